@@ -11,8 +11,8 @@ import (
 func PageNotFound(w http.ResponseWriter, r *http.Request) {
 	controllers.LuaPage(w, r, httprouter.Params{
 		{
-			"filepath",
-			"404",
+			Key:   "filepath",
+			Value: "404",
 		},
 	})
 }
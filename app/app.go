@@ -32,18 +32,12 @@ func Start() {
 	// Wait for all tasks
 	wait.Add(10)
 
-	// Load application logger
-	loadAppLogger()
-
-	// Load application config
-	loadAppConfig()
+	// Load logger, config files and database connection
+	Setup()
 
 	// Run logger renew service
 	go util.RenewLogger()
 
-	loadLUAConfig()
-	connectDatabase()
-
 	// Execute our tasks
 	go func(wait *sync.WaitGroup) {
 
@@ -62,6 +56,18 @@ func Start() {
 	// Create application cache
 	createCache()
 
+	// Create session storage
+	createSessionStorage()
+
+	// Create failed login tracker
+	createLoginTracker()
+
+	// Create token service
+	createTokenService()
+
+	// Create mail queue
+	createMailQueue()
+
 	// Load local config files
 	overwriteConfigFile()
 
@@ -80,10 +86,27 @@ func Start() {
 	// Execute migrations
 	executeMigrations()
 
+	// Compile extension hooks
+	loadHooks()
+
 	// Execute the init lua file
 	executeInitFile()
 }
 
+// Setup loads the application logger, the config files and connects to the database
+func Setup() {
+	// Load application logger
+	loadAppLogger()
+
+	// Load application config
+	loadAppConfig()
+
+	loadLUAConfig()
+	connectDatabase()
+	createAuditTable()
+	checkPasswordColumn()
+}
+
 func loadServerMonsters(wg *sync.WaitGroup) {
 	// Load server monsters
 	if err := util.LoadServerMonsters(util.Config.Configuration.Datapack); err != nil {
@@ -164,16 +187,16 @@ func loadConfigMap() {
 
 func loadMap() {
 	// Map holder
-	m := models.Map{}
+	m := &models.Map{}
 
 	// Get map mod time
-	fileInformation, err := os.Stat(filepath.Join(util.Config.Configuration.Datapack, "data", "world", lua.Config.GetGlobal("mapName").String()+".otbm"))
+	fileInformation, err := os.Stat(mapFilePath())
 	if err != nil {
 		util.Logger.Logger.Fatalf("Cannot get map file information: %v", err)
 	}
 
 	// Check if map is encoded
-	err = database.DB.Get(&m, "SELECT id, name, data, created_at, updated_at, last_modtime FROM castro_map WHERE name = ? ORDER BY id DESC", lua.Config.GetGlobal("mapName").String())
+	err = database.DB.Get(m, "SELECT id, name, data, created_at, updated_at, last_modtime FROM castro_map WHERE name = ? ORDER BY id DESC", lua.Config.GetGlobal("mapName").String())
 
 	// Unexpected error
 	if err != nil && err != sql.ErrNoRows {
@@ -187,61 +210,21 @@ func loadMap() {
 		util.Logger.Logger.Info("Encoding map. This process can take several minutes")
 
 		// Encode map
-		mapData, err := util.EncodeMap(
-			filepath.Join(util.Config.Configuration.Datapack, "data", "world", lua.Config.GetGlobal("mapName").String()+".otbm"),
-		)
-
-		if err != nil {
+		if m, err = EncodeMap(); err != nil {
 			util.Logger.Logger.Fatalf("Cannot encode map file: %v", err)
 		}
 
-		// Update map struct
-		m.Name = lua.Config.GetGlobal("mapName").String()
-		m.Data = mapData
-		m.Created_at = time.Now()
-		m.Updated_at = time.Now()
-		m.Last_modtime = fileInformation.ModTime()
-
-		// Save map
-		if _, err := database.DB.Exec(
-			"INSERT INTO castro_map (name, data, created_at, updated_at, last_modtime) VALUES (?, ?, ?, ?, ?)",
-			m.Name,
-			m.Data,
-			m.Created_at,
-			m.Updated_at,
-			m.Last_modtime,
-		); err != nil {
-			util.Logger.Logger.Fatalf("Cannot save encoded map file: %v", err)
-		}
-	}
-
-	// Check if map is old
-	if !fileInformation.ModTime().Round(time.Second).Equal(m.Last_modtime) {
+	} else if !fileInformation.ModTime().Round(time.Second).Equal(m.Last_modtime) {
 
+		// Map is old
 		fmt.Println(">> Encoded map is outdated. Generating new map data")
 		util.Logger.Logger.Info("Encoded map is outdated. Generating new map data")
 
 		// Encode map
-		mapData, err := util.EncodeMap(
-			filepath.Join(util.Config.Configuration.Datapack, "data", "world", lua.Config.GetGlobal("mapName").String()+".otbm"),
-		)
-
-		if err != nil {
+		if m, err = EncodeMap(); err != nil {
 			util.Logger.Logger.Fatalf("Cannot encode map file: %v", err)
 		}
 
-		// Update map struct
-		m.Name = lua.Config.GetGlobal("mapName").String()
-		m.Data = mapData
-		m.Created_at = time.Now()
-		m.Updated_at = time.Now()
-		m.Last_modtime = fileInformation.ModTime()
-
-		// Save map
-		if _, err := database.DB.Exec("UPDATE castro_map SET data = ?, created_at = ?, updated_at = ? WHERE name = ?", m.Data, m.Created_at, m.Updated_at, m.Name); err != nil {
-			util.Logger.Logger.Fatalf("Cannot save encoded map file: %v", err)
-		}
-
 		// Log messages
 		util.Logger.Logger.Info("New map data saved to database")
 	}
@@ -257,48 +240,91 @@ func loadMap() {
 	util.OTBMap.Load(castroMap)
 }
 
-func executeMigrations() {
-	// Create migration state
-	state := glua.NewState()
+// EncodeMap encodes the server map file and saves the result on the castro_map table
+func EncodeMap() (*models.Map, error) {
+	// Get map mod time
+	fileInformation, err := os.Stat(mapFilePath())
+	if err != nil {
+		return nil, err
+	}
 
-	// Set database metatable
-	lua.SetDatabaseMetaTable(state)
+	// Encode map
+	mapData, err := util.EncodeMap(mapFilePath())
+	if err != nil {
+		return nil, err
+	}
 
-	// Close state
-	defer state.Close()
+	// Create map struct
+	m := &models.Map{
+		Name:         lua.Config.GetGlobal("mapName").String(),
+		Data:         mapData,
+		Created_at:   time.Now(),
+		Updated_at:   time.Now(),
+		Last_modtime: fileInformation.ModTime(),
+	}
 
-	// Walk migrations directory
-	if err := filepath.Walk("migrations", func(path string, info os.FileInfo, err error) error {
+	// Check if map is already saved
+	exists := false
+	if err := database.DB.Get(&exists, "SELECT EXISTS (SELECT 1 FROM castro_map WHERE name = ?)", m.Name); err != nil {
+		return nil, err
+	}
 
-		// Check if lua file
-		if !strings.HasSuffix(path, ".lua") {
-			return nil
-		}
+	if exists {
 
-		// Do lua file
-		if err := state.DoFile(path); err != nil {
-			return err
-		}
+		// Update map
+		_, err = database.DB.Exec(
+			"UPDATE castro_map SET data = ?, updated_at = ?, last_modtime = ? WHERE name = ?",
+			m.Data,
+			m.Updated_at,
+			m.Last_modtime,
+			m.Name,
+		)
 
-		// Call migration function
-		if err := state.CallByParam(
-			glua.P{
-				Fn:      state.GetGlobal("migration"),
-				NRet:    0,
-				Protect: !util.Config.Configuration.IsDev(),
-			},
-		); err != nil {
-			return err
-		}
+		return m, err
+	}
 
-		// Pop state
-		state.Pop(-1)
+	// Save map
+	_, err = database.DB.Exec(
+		"INSERT INTO castro_map (name, data, created_at, updated_at, last_modtime) VALUES (?, ?, ?, ?, ?)",
+		m.Name,
+		m.Data,
+		m.Created_at,
+		m.Updated_at,
+		m.Last_modtime,
+	)
 
-		return nil
+	return m, err
+}
 
-	}); err != nil {
+// mapFilePath returns the path of the server map file
+func mapFilePath() string {
+	return filepath.Join(util.Config.Configuration.Datapack, "data", "world", lua.Config.GetGlobal("mapName").String()+".otbm")
+}
+
+func executeMigrations() {
+	// Get core and extension migration directories
+	sources, err := lua.MigrationSources()
+	if err != nil {
+		util.Logger.Logger.Fatalf("Cannot get migration directories: %v", err)
+	}
+
+	// Apply pending migrations
+	applied, err := lua.ApplyMigrations(sources)
+	if err != nil {
 		util.Logger.Logger.Fatalf("Cannot run migration files: %v", err)
 	}
+
+	// Log applied migrations
+	for _, m := range applied {
+		util.Logger.Logger.Infof("Applied migration %v", m)
+	}
+}
+
+func loadHooks() {
+	// Compile extension hook handlers
+	if err := lua.Hooks.Load(); err != nil {
+		util.Logger.Logger.Errorf("Cannot load extension hooks: %v", err)
+	}
 }
 
 func executeInitFile() {
@@ -397,6 +423,11 @@ func loadAppConfig() {
 	if err := util.LoadConfig("config.toml"); err != nil {
 		util.Logger.Logger.Fatalf("Cannot read configuration file: %v", err)
 	}
+
+	// X-Forwarded-For used to be trusted from any address
+	if ssl := util.Config.Configuration.SSL; ssl.Proxy && len(ssl.TrustedProxies) == 0 {
+		util.Logger.Logger.Warn("SSL.Proxy is enabled without SSL.TrustedProxies, X-Forwarded-For is only read from loopback addresses. Add your proxy address to SSL.TrustedProxies")
+	}
 }
 
 func loadLUAConfig() {
@@ -416,6 +447,55 @@ func createCache() {
 	)
 }
 
+func createSessionStorage() {
+	var err error
+
+	// Create the configured session storage
+	if util.Sessions, err = util.NewSessionStorage(util.Config.Configuration.Session.Store); err != nil {
+		util.Logger.Logger.Fatalf("Cannot create session storage: %v", err)
+	}
+
+	// Run expired session purge service
+	purge := util.Config.Configuration.Session.Purge.Duration
+	if purge <= 0 {
+		purge = time.Minute * 30
+	}
+
+	go util.PurgeSessions(purge)
+}
+
+func createLoginTracker() {
+	// Create failed login table
+	if err := util.CreateLoginTable(); err != nil {
+		util.Logger.Logger.Fatalf("Cannot create failed login table: %v", err)
+	}
+
+	// Run old failed login purge service
+	go util.PurgeLoginAttempts(time.Minute * 10)
+}
+
+func createTokenService() {
+	// Create issued tokens table
+	if err := util.CreateTokenTable(); err != nil {
+		util.Logger.Logger.Fatalf("Cannot create token table: %v", err)
+	}
+
+	// Run expired token purge service
+	go util.PurgeTokens(time.Minute * 10)
+}
+
+func createMailQueue() {
+	// Create mail queue table
+	if err := util.CreateMailQueueTable(); err != nil {
+		util.Logger.Logger.Fatalf("Cannot create mail queue table: %v", err)
+	}
+
+	// Run mail queue workers
+	if util.Config.Configuration.Mail.Enabled {
+		go util.RunMailQueue(time.Second * 30)
+	}
+}
+
 func loadWidgetList(wg *sync.WaitGroup) {
 	// Load widget list
 	if err := util.Widgets.Load("widgets/"); err != nil {
@@ -458,6 +538,11 @@ func appTemplates(wg *sync.WaitGroup) {
 	// Load template hooks
 	util.Template.LoadTemplateHooks()
 
+	// Load mail templates
+	if err := util.MailTemplate.Load(filepath.Join(util.Config.Configuration.Template, "mail")); err != nil {
+		util.Logger.Logger.Errorf("Cannot load mail templates: %v", err)
+	}
+
 	// Tell the wait group we are done
 	wg.Done()
 }
@@ -482,9 +567,44 @@ func widgetTemplates(wg *sync.WaitGroup) {
 	wg.Done()
 }
 
+func createAuditTable() {
+	// Create audit log table
+	if err := models.CreateAuditTable(); err != nil {
+		util.Logger.Logger.Fatalf("Cannot create audit log table: %v", err)
+	}
+}
+
+func checkPasswordColumn() {
+	// Check there is room for the configured password hashes
+	if err := util.CheckPasswordColumn(); err != nil {
+		util.Logger.Logger.Errorf("Cannot use the configured password scheme, new passwords are hashed with sha1: %v", err)
+	}
+}
+
 func connectDatabase() {
 	var err error
 
+	// Connect to the SQLite database
+	if util.Config.Configuration.Database.Driver == database.SQLite {
+		if database.DB, err = database.OpenSQLite(util.Config.Configuration.Database.File); err != nil {
+			util.Logger.Logger.Fatalf("Cannot open SQLite database: %v", err)
+		}
+
+		// Create missing server and castro tables
+		for _, dir := range []string{filepath.Join(database.InstallDirectory(database.SQLite), "tfs"), database.InstallDirectory(database.SQLite)} {
+			tables, err := database.InstallTables(database.DB, dir)
+			if err != nil {
+				util.Logger.Logger.Fatalf("Cannot create SQLite tables: %v", err)
+			}
+
+			for _, table := range tables {
+				util.Logger.Logger.Infof("Created SQLite table %v", table)
+			}
+		}
+
+		return
+	}
+
 	// Connect to the MySQL database
 	if database.DB, err = database.Open(lua.Config.GetGlobal("mysqlUser").String(), 
 		lua.Config.GetGlobal("mysqlPass").String(), 
@@ -528,7 +648,12 @@ func templateFuncs() template.FuncMap {
 			}
 			return template.URL("http://" + u)
 		},
-		"queryResults": func(m map[string]interface{}) []interface{} {
+		"queryResults": func(v interface{}) []interface{} {
+			// Sequences are already converted to slices
+			if s, ok := v.([]interface{}); ok {
+				return s
+			}
+			m, _ := v.(map[string]interface{})
 			n := len(m)
 			r := []interface{}{}
 			for i := 0; i < n; i++ {
@@ -576,12 +701,22 @@ func templateFuncs() template.FuncMap {
 		"widgetList": func() []*util.Widget {
 			return util.Widgets.List
 		},
-		"captchaKey": func() string {
-			return util.Config.Configuration.Captcha.Public
+		"captchaKey": func() (string, error) {
+			provider, err := util.GetCaptchaProvider()
+			if err != nil {
+				return "", err
+			}
+			return provider.Key()
 		},
 		"captchaEnabled": func() bool {
 			return util.Config.Configuration.Captcha.Enabled
 		},
+		"captchaProvider": func() string {
+			if util.Config.Configuration.Captcha.Provider == "" {
+				return util.CaptchaProviderRecaptcha
+			}
+			return util.Config.Configuration.Captcha.Provider
+		},
 		"eq": func(a, b interface{}) bool {
 			return a == b
 		},
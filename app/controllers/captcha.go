@@ -0,0 +1,31 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/raggaer/castro/app/util"
+)
+
+// CaptchaImage serves the image of an image captcha challenge
+func CaptchaImage(w http.ResponseWriter, req *http.Request, ps httprouter.Params) {
+	// Render challenge image
+	buff, exists, err := util.CaptchaImage(ps.ByName("id"))
+
+	if err != nil {
+		util.Logger.Logger.Errorf("Cannot render captcha image: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if !exists {
+		w.WriteHeader(404)
+		return
+	}
+
+	// Images must not be cached since the challenge can only be used once
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+
+	w.Write(buff.Bytes())
+}
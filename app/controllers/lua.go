@@ -1,11 +1,17 @@
 package controllers
 
 import (
+	"context"
 	"net/http"
+	"path"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/raggaer/castro/app/database"
 	"github.com/raggaer/castro/app/lua"
+	"github.com/raggaer/castro/app/models"
 	"github.com/raggaer/castro/app/util"
 )
 
@@ -64,6 +70,9 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 			util.Logger.Logger.Errorf("Cannot reload extension subtopic %v: %v", ps.ByName("page"), err)
 		}
 
+		// Reload extension capabilities
+		lua.Sandboxes.Reset()
+
 		// Reload extension static list
 		if err := util.ExtensionStatic.Load("extensions"); err != nil {
 
@@ -97,15 +106,18 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		if err := util.Widgets.LoadExtensions(); err != nil {
 			util.Logger.Logger.Errorf("Cannot load extension widget list: %v", err)
 		}
+
+		// Remove pooled states so the new config values are used
+		lua.Pool.Clear()
 	}
 
 	// Get session
-	session, ok := r.Context().Value("session").(map[string]interface{})
+	session, ok := r.Context().Value("session").(*util.Session)
 
 	if !ok {
 		// Set error header
 		w.WriteHeader(500)
-		util.Logger.Logger.Error("Cannot get session as map")
+		util.Logger.Logger.Error("Cannot get session")
 
 		return
 	}
@@ -128,8 +140,31 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		pageName = "index"
 	}
 
+	// Staff accounts must enable two-factor before using the admin pages
+	if requireTwoFactor(w, r, session, pageName) {
+		return
+	}
+
+	// Retrieve compiled proto
+	protoPath := filepath.Join("pages", pageName, r.Method+".lua")
+	if !lua.CompiledPageList.Exists(protoPath) {
+		protoPath = filepath.Join("pages", "404", r.Method+".lua")
+	}
+	proto, err := lua.CompiledPageList.Get(protoPath)
+	if err != nil {
+		w.WriteHeader(404)
+		util.Logger.Logger.Errorf("Cannot find lua proto, subtopic source (%s) %v", pageName, err)
+		return
+	}
+
+	// Extension pages use states restricted to the extension capabilities
+	pool := lua.Sandboxes.Pool(lua.CompiledPageList.Extension(protoPath))
+
 	// Get state from the pool
-	s := lua.NewState()
+	s := pool.Get()
+
+	// Return state to the pool
+	defer pool.Put(s)
 
 	// Create HTTP metatable
 	lua.SetHTTPMetaTable(s)
@@ -143,30 +178,101 @@ func LuaPage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	// Set language user data
 	lua.SetI18nUserData(s, language)
 
-	// Retrieve compiled proto
-	protoPath := filepath.Join("pages", pageName, r.Method+".lua")
-	if !lua.CompiledPageList.Exists(protoPath) {
-		protoPath = filepath.Join("pages", "404", r.Method+".lua")
-	}
-	proto, err := lua.CompiledPageList.Get(protoPath)
-	if err != nil {
-		w.WriteHeader(404)
-		util.Logger.Logger.Errorf("Cannot find lua proto, subtopic source (%s) %v", pageName, err)
-		return
-	}
+	// Get page execution timeout
+	timeout := lua.ExecutionTimeout(
+		filepath.ToSlash(filepath.Dir(protoPath)),
+		lua.CompiledPageList.Extension(protoPath),
+	)
+
+	// Create page execution context. The context is cancelled if the client disconnects
+	ctx, cancel := lua.NewExecutionContext(r.Context(), timeout)
+	defer cancel()
+
+	// Set state execution context
+	s.SetContext(ctx)
 
 	// Execute compiled file
 	if err := lua.DoCompiledFile(
 		s,
 		proto,
 	); err != nil {
-		w.WriteHeader(404)
-		util.Logger.Logger.Errorf("Cannot get %v subtopic source (%s) %v", pageName, err)
+		logPageExecutionError(ctx, w, 404, pageName, timeout, err, "Cannot get %v subtopic source: %v")
 		return
 	}
 
-	if err := lua.ExecuteControllerPage(s, r.Method); err != nil {
+	if err := lua.ExecuteControllerPage(ctx, s, r.Method); err != nil {
+		logPageExecutionError(ctx, w, 500, pageName, timeout, err, "Cannot execute subtopic %v: %v")
+	}
+}
+
+// requireTwoFactor redirects staff accounts without two-factor away from the
+// admin pages. Returns true if the request was redirected
+func requireTwoFactor(w http.ResponseWriter, r *http.Request, session *util.Session, pageName string) bool {
+	if !util.Config.Configuration.TwoFactor.RequireStaff {
+		return false
+	}
+
+	// Check for admin pages. The name is cleaned first so other spellings of
+	// the same page are checked too
+	pageName = strings.Replace(pageName, "\\", "/", -1)
+	pageName = strings.ToLower(strings.Trim(path.Clean("/"+pageName), "/"))
+	if pageName != "admin" && !strings.HasPrefix(pageName, "admin/") {
+		return false
+	}
+
+	// Get logged account
+	accountName := session.LoggedAccount()
+	if accountName == "" {
+		return false
+	}
+
+	var id int64
+	if err := database.DB.Get(&id, "SELECT id FROM accounts WHERE name = ?", accountName); err != nil {
+		w.WriteHeader(500)
+		util.Logger.Logger.Errorf("Cannot get logged account: %v", err)
+		return true
+	}
+
+	required, err := models.TwoFactorRequired(id)
+	if err != nil {
 		w.WriteHeader(500)
-		util.Logger.Logger.Errorf("Cannot execute subtopic %v: %v", pageName, err)
+		util.Logger.Logger.Errorf("Cannot check two-factor policy: %v", err)
+		return true
+	}
+
+	if !required {
+		return false
+	}
+
+	// Tell the account why it was redirected
+	session.Data["validationError"] = "Staff accounts must enable two-factor authentication before using the admin panel"
+	if err := session.Save(); err != nil {
+		util.Logger.Logger.Errorf("Cannot save session: %v", err)
+	}
+
+	http.Redirect(w, r, "/subtopic/account/twofa/enable", http.StatusFound)
+
+	return true
+}
+
+// logPageExecutionError logs a subtopic execution error taking into account the execution context
+func logPageExecutionError(ctx context.Context, w http.ResponseWriter, status int, pageName string, timeout time.Duration, err error, format string) {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+
+		// Set error header
+		w.WriteHeader(503)
+		util.Logger.Logger.Errorf("Subtopic %v exceeded its execution time of %v", pageName, timeout)
+
+	case context.Canceled:
+
+		// Client is gone so there is no need to write a response
+		util.Logger.Logger.Infof("Client disconnected while executing subtopic %v", pageName)
+
+	default:
+
+		// Set error header
+		w.WriteHeader(status)
+		util.Logger.Logger.Errorf(format, pageName, err)
 	}
 }
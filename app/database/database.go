@@ -8,13 +8,28 @@ import (
 	"github.com/jmoiron/sqlx"
 )
 
+const (
+	// MySQL dialect name. Also used as the driver name
+	MySQL = "mysql"
+
+	// SQLite dialect name
+	SQLite = "sqlite"
+)
+
 // DB holds the main database handle
 var DB *sqlx.DB
 
+// Handle is implemented by database handles and transactions
+type Handle interface {
+	sqlx.Queryer
+	sqlx.Execer
+	DriverName() string
+}
+
 // Open creates a new connection to a MySQL database with the given credentials
 func Open(username, password, host, port, db, params string) (*sqlx.DB, error) {
 	// Connect to the given database
-	databaseHandle, err := sqlx.Connect("mysql", fmt.Sprintf(
+	databaseHandle, err := sqlx.Connect(MySQL, fmt.Sprintf(
 		"%v:%v@(%v:%v)/%v?charset=utf8&parseTime=True&loc=Local"+params,
 		username,
 		password,
@@ -26,3 +41,12 @@ func Open(username, password, host, port, db, params string) (*sqlx.DB, error) {
 	// Return database handler
 	return databaseHandle, err
 }
+
+// Dialect returns the SQL dialect of the given handle
+func Dialect(h Handle) string {
+	if h.DriverName() == sqliteDriverName {
+		return SQLite
+	}
+
+	return MySQL
+}
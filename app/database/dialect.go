@@ -0,0 +1,288 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// identifierRegexp valid table and column names
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// InstallDirectory returns the directory that holds the install scripts of the given dialect
+func InstallDirectory(dialect string) string {
+	return filepath.Join("install", dialect)
+}
+
+// Statement adapts a MySQL query to the dialect of the given handle. SQLite
+// locks the whole database on writes so row locking clauses are removed, and
+// INSERT IGNORE is written as INSERT OR IGNORE
+func Statement(h Handle, query string) string {
+	if Dialect(h) != SQLite {
+		return query
+	}
+
+	if lead := strings.TrimLeft(query, " \t\r\n"); len(lead) > len("INSERT IGNORE ") && strings.EqualFold(lead[:len("INSERT IGNORE ")], "INSERT IGNORE ") {
+		return "INSERT OR IGNORE " + lead[len("INSERT IGNORE "):]
+	}
+
+	trimmed := strings.TrimRight(query, " \t\r\n;")
+	if strings.HasSuffix(strings.ToUpper(trimmed), " FOR UPDATE") {
+		return trimmed[:len(trimmed)-len(" FOR UPDATE")]
+	}
+
+	return query
+}
+
+// TableExists checks if the given table exists
+func TableExists(h Handle, table string) (bool, error) {
+	exists := false
+
+	if Dialect(h) == SQLite {
+		err := sqlx.Get(h, &exists, "SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", table)
+		return exists, err
+	}
+
+	err := sqlx.Get(h, &exists, "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?)", table)
+	return exists, err
+}
+
+// Columns returns the column names of the given table
+func Columns(h Handle, table string) ([]string, error) {
+	columns := []string{}
+
+	if Dialect(h) == SQLite {
+
+		// Get table information
+		rows, err := h.Queryx("SELECT name FROM pragma_table_info(?)", table)
+		if err != nil {
+			return nil, err
+		}
+
+		// Close rows
+		defer rows.Close()
+
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return nil, err
+			}
+			columns = append(columns, name)
+		}
+
+		return columns, rows.Err()
+	}
+
+	err := sqlx.Select(h, &columns, "SELECT COLUMN_NAME FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = ?", table)
+	return columns, err
+}
+
+// InstallTables runs the install scripts of the given directory whose table does not exist.
+// Each script is named after the table it creates. Returns the list of created tables
+func InstallTables(h Handle, dir string) ([]string, error) {
+	created := []string{}
+
+	// Get all sql files
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Loop files
+	for _, f := range files {
+
+		// Check if sql file
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".sql") {
+			continue
+		}
+
+		table := strings.TrimSuffix(f.Name(), ".sql")
+
+		// Check if table exists
+		exists, err := TableExists(h, table)
+		if err != nil {
+			return created, err
+		}
+
+		if exists {
+			continue
+		}
+
+		// Read file
+		buff, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return created, err
+		}
+
+		// Execute script
+		if _, err := h.Exec(string(buff)); err != nil {
+			return created, err
+		}
+
+		created = append(created, table)
+	}
+
+	return created, nil
+}
+
+// InstallTable runs the install script of the given table for the dialect of the
+// handle. Used by the tables created at startup, their scripts must not fail if
+// the table already exists
+func InstallTable(h Handle, table string) error {
+	buff, err := ioutil.ReadFile(filepath.Join(InstallDirectory(Dialect(h)), table+".sql"))
+	if err != nil {
+		return err
+	}
+
+	_, err = h.Exec(string(buff))
+	return err
+}
+
+// DropColumn removes the given column of a table. The SQLite version bundled
+// with Castro can not drop columns so the table is created again without the
+// column, copied and renamed. SQLite handles must have foreign keys disabled,
+// see MigrationHandle
+func DropColumn(h Handle, table, column string) error {
+	if !identifierRegexp.MatchString(table) || !identifierRegexp.MatchString(column) {
+		return fmt.Errorf("invalid table or column name %q.%q", table, column)
+	}
+
+	if Dialect(h) != SQLite {
+		_, err := h.Exec("ALTER TABLE `" + table + "` DROP COLUMN `" + column + "`")
+		return err
+	}
+
+	// Dropping the table would delete the rows that reference it
+	foreignKeys := false
+	if err := sqlx.Get(h, &foreignKeys, "PRAGMA foreign_keys"); err != nil {
+		return err
+	}
+
+	if foreignKeys {
+		return errors.New("SQLite columns can only be dropped inside migrations")
+	}
+
+	// Get table and index definitions
+	schema := ""
+	if err := sqlx.Get(h, &schema, "SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table); err != nil {
+		return err
+	}
+
+	indexes := []string{}
+	if err := sqlx.Select(h, &indexes, "SELECT sql FROM sqlite_master WHERE type IN ('index', 'trigger') AND tbl_name = ? AND sql IS NOT NULL", table); err != nil {
+		return err
+	}
+
+	start, end := strings.Index(schema, "("), strings.LastIndex(schema, ")")
+	if start == -1 || end < start {
+		return fmt.Errorf("cannot parse the definition of %v", table)
+	}
+
+	definitions := []string{}
+	columns := []string{}
+	found := false
+
+	for _, definition := range splitDefinitions(schema[start+1 : end]) {
+		name := strings.Trim(strings.Fields(definition)[0], "`\"[]")
+
+		switch strings.ToUpper(name) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			if definitionUses(definition, column) {
+				return fmt.Errorf("column %v is used by the constraint %v", column, definition)
+			}
+			definitions = append(definitions, definition)
+			continue
+		}
+
+		if strings.EqualFold(name, column) {
+			found = true
+			continue
+		}
+
+		definitions = append(definitions, definition)
+		columns = append(columns, "`"+name+"`")
+	}
+
+	if !found {
+		return fmt.Errorf("table %v has no column %v", table, column)
+	}
+
+	rebuilt := table + "_castro_rebuild"
+	list := strings.Join(columns, ", ")
+
+	for _, query := range []string{
+		"CREATE TABLE `" + rebuilt + "` (" + strings.Join(definitions, ", ") + ")",
+		"INSERT INTO `" + rebuilt + "` (" + list + ") SELECT " + list + " FROM `" + table + "`",
+		"DROP TABLE `" + table + "`",
+		"ALTER TABLE `" + rebuilt + "` RENAME TO `" + table + "`",
+	} {
+		if _, err := h.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	// Indexes and triggers on the dropped column are removed with it
+	for _, index := range indexes {
+		if definitionUses(index, column) {
+			continue
+		}
+
+		if _, err := h.Exec(index); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// splitDefinitions splits the column and constraint definitions of a CREATE
+// TABLE statement
+func splitDefinitions(body string) []string {
+	list := []string{}
+	depth := 0
+	quote := rune(0)
+	last := 0
+
+	for i, r := range body {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"' || r == '`':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			list = append(list, strings.TrimSpace(body[last:i]))
+			last = i + 1
+		}
+	}
+
+	if rest := strings.TrimSpace(body[last:]); rest != "" {
+		list = append(list, rest)
+	}
+
+	return list
+}
+
+// definitionUses checks if the given definition mentions the given column
+func definitionUses(definition, column string) bool {
+	for _, field := range strings.FieldsFunc(definition, func(r rune) bool {
+		return !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+	}) {
+		if strings.EqualFold(field, column) {
+			return true
+		}
+	}
+
+	return false
+}
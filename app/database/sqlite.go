@@ -0,0 +1,83 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+)
+
+// sqliteDriverName name of the SQLite driver with the MySQL compatibility functions
+const sqliteDriverName = "castro_sqlite3"
+
+func init() {
+	// Register SQLite driver with the MySQL functions used by Castro
+	sql.Register(sqliteDriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			if err := conn.RegisterFunc("now", sqliteNow, false); err != nil {
+				return err
+			}
+
+			return conn.RegisterFunc("unix_timestamp", sqliteUnixTimestamp, false)
+		},
+	})
+}
+
+// OpenSQLite opens the given SQLite database file. The file is created if it does not exist
+func OpenSQLite(path string) (*sqlx.DB, error) {
+	return sqlx.Connect(sqliteDriverName, sqliteDSN(path, true))
+}
+
+// sqliteDSN returns the connection string of the given SQLite database file.
+// Every connection must use the same journal mode or the driver would try to change it
+func sqliteDSN(path string, foreignKeys bool) string {
+	fk := "0"
+	if foreignKeys {
+		fk = "1"
+	}
+
+	return "file:" + path + "?_foreign_keys=" + fk + "&_busy_timeout=5000&_journal_mode=WAL"
+}
+
+// MigrationHandle returns the handle used to run migrations and a function
+// that releases it. SQLite migrations use their own connection with foreign
+// keys disabled, otherwise rebuilding a table would delete the rows that
+// reference it
+func MigrationHandle(h *sqlx.DB) (*sqlx.DB, func(), error) {
+	if Dialect(h) != SQLite {
+		return h, func() {}, nil
+	}
+
+	// Get database file
+	file := ""
+	if err := h.Get(&file, "SELECT file FROM pragma_database_list WHERE name = 'main'"); err != nil {
+		return nil, nil, err
+	}
+
+	// In memory databases can not be opened twice
+	if file == "" {
+		return h, func() {}, nil
+	}
+
+	conn, err := sqlx.Connect(sqliteDriverName, sqliteDSN(file, false))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn.SetMaxOpenConns(1)
+
+	return conn, func() {
+		conn.Close()
+	}, nil
+}
+
+// sqliteNow implements the MySQL NOW function
+func sqliteNow() string {
+	return time.Now().Format("2006-01-02 15:04:05")
+}
+
+// sqliteUnixTimestamp implements the MySQL UNIX_TIMESTAMP function
+func sqliteUnixTimestamp() int64 {
+	return time.Now().Unix()
+}
@@ -0,0 +1,167 @@
+package lua
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/raggaer/castro/app/database"
+	"github.com/raggaer/castro/app/models"
+	"github.com/raggaer/castro/app/util"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// SetAuditMetaTable sets the audit metatable on the given lua state
+func SetAuditMetaTable(luaState *lua.LState) {
+	// Create and set audit metatable
+	auditMetaTable := luaState.NewTypeMetatable(AuditMetaTableName)
+	luaState.SetGlobal(AuditMetaTableName, auditMetaTable)
+
+	// Set all audit metatable functions
+	luaState.SetFuncs(auditMetaTable, auditMethods)
+}
+
+// auditActor returns the logged account and the client address of the state.
+// States without a session or a request return empty values
+func auditActor(L *lua.LState) (int64, string, string) {
+	id, name, ip := int64(0), "", ""
+
+	// Get logged account
+	if session, ok := stateUserData(L, SessionMetaTable, SessionInstanceName).(*util.Session); ok {
+		name = session.LoggedAccount()
+	}
+
+	if name != "" {
+		if err := database.DB.Get(&id, "SELECT id FROM accounts WHERE name = ?", name); err != nil {
+			L.RaiseError("Cannot get account by name: %v", err)
+		}
+	}
+
+	// Get client address
+	if req, ok := stateUserData(L, HTTPMetaTableName, HTTPRequestName).(*http.Request); ok {
+		ip = util.ClientIP(req)
+	}
+
+	return id, name, ip
+}
+
+// tableToAuditFilter converts the given lua table to an audit filter
+func tableToAuditFilter(tbl *lua.LTable) models.AuditFilter {
+	f := models.AuditFilter{}
+
+	if tbl == nil {
+		return f
+	}
+
+	f.Account = lua.LVAsString(tbl.RawGetString("account"))
+	f.Action = lua.LVAsString(tbl.RawGetString("action"))
+	f.Target = lua.LVAsString(tbl.RawGetString("target"))
+	f.From = int64(lua.LVAsNumber(tbl.RawGetString("from")))
+	f.To = int64(lua.LVAsNumber(tbl.RawGetString("to")))
+	f.Limit = int(lua.LVAsNumber(tbl.RawGetString("limit")))
+	f.Offset = int(lua.LVAsNumber(tbl.RawGetString("offset")))
+
+	return f
+}
+
+// decodeAuditValue decodes a JSON audit value to a lua value
+func decodeAuditValue(s string) lua.LValue {
+	if s == "" {
+		return lua.LNil
+	}
+
+	var v interface{}
+
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return lua.LString(s)
+	}
+
+	return GoToValue(v)
+}
+
+// WriteAuditEntry records a privileged action done by the logged account
+func WriteAuditEntry(L *lua.LState) int {
+	// Get action and target. Numeric targets are saved as strings
+	action := L.CheckString(2)
+	target := lua.LVAsString(L.Get(3))
+
+	// Get actor
+	id, name, ip := auditActor(L)
+
+	// Write entry
+	if err := models.WriteAuditEntry(id, name, ip, action, target, ValueToGo(L.Get(4)), ValueToGo(L.Get(5))); err != nil {
+		L.RaiseError("Cannot write audit entry: %v", err)
+	}
+
+	return 0
+}
+
+// GetAuditLog returns the audit entries that match the given filter
+func GetAuditLog(L *lua.LState) int {
+	// Get entries
+	entries, err := models.GetAuditLog(tableToAuditFilter(L.OptTable(2, nil)))
+
+	if err != nil {
+		L.RaiseError("Cannot get audit log: %v", err)
+		return 0
+	}
+
+	// Data holder
+	t := &lua.LTable{}
+
+	for _, entry := range entries {
+		e := StructToTable(entry)
+
+		// Set decoded values
+		e.RawSetString("Before", decodeAuditValue(entry.Old_value))
+		e.RawSetString("After", decodeAuditValue(entry.New_value))
+
+		t.Append(e)
+	}
+
+	L.Push(t)
+
+	return 1
+}
+
+// CountAuditLog returns the number of audit entries that match the given filter
+func CountAuditLog(L *lua.LState) int {
+	// Count entries
+	count, err := models.CountAuditLog(tableToAuditFilter(L.OptTable(2, nil)))
+
+	if err != nil {
+		L.RaiseError("Cannot count audit log: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LNumber(count))
+
+	return 1
+}
+
+// ExportAuditLog returns every audit entry that matches the given filter as csv or json
+func ExportAuditLog(L *lua.LState) int {
+	// Get filter without limit
+	f := tableToAuditFilter(L.OptTable(2, nil))
+	f.Limit = -1
+
+	// Get entries
+	entries, err := models.GetAuditLog(f)
+
+	if err != nil {
+		L.RaiseError("Cannot get audit log: %v", err)
+		return 0
+	}
+
+	// Export entries
+	buff := &bytes.Buffer{}
+
+	if err := models.ExportAuditLog(buff, entries, L.OptString(3, "csv")); err != nil {
+		L.RaiseError("Cannot export audit log: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LString(buff.String()))
+
+	return 1
+}
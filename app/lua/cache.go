@@ -37,18 +37,8 @@ func GetCacheValue(L *lua.LState) int {
 		return 1
 	}
 
-	// Switch cache value type
-	switch v.(type) {
-
-	case string:
-		L.Push(lua.LString(v.(string)))
-	case float64:
-		L.Push(lua.LNumber(v.(float64)))
-	case bool:
-		L.Push(lua.LBool(v.(bool)))
-	case map[string]interface{}:
-		L.Push(MapToTable(v.(map[string]interface{})))
-	}
+	// Push value as lua value
+	L.Push(GoToValue(v))
 
 	return 1
 }
@@ -127,8 +117,8 @@ func SetCacheValue(L *lua.LState) int {
 
 	case lua.LTTable:
 
-		// Convert table to map
-		m := TableToMap(val.(*lua.LTable))
+		// Convert table to map or slice
+		m := ValueToGo(val)
 
 		// Set cache value
 		util.Cache.Set(key.String(), m, dur)
@@ -1,6 +1,9 @@
 package lua
 
 import (
+	"fmt"
+	"net/url"
+
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 )
@@ -25,20 +28,29 @@ func IsEnabled(L *lua.LState) int {
 	return 1
 }
 
-// VerifyCaptcha checks if the given captcha response is valid
+// VerifyCaptcha checks if the given captcha response is valid. The response
+// can be a form values table or the reCAPTCHA answer string
 func VerifyCaptcha(L *lua.LState) int {
 	// Get captcha response
 	answer := L.Get(2)
 
-	// Check for valid response type
-	if answer.Type() != lua.LTString {
+	// Form values holder
+	form := url.Values{}
 
-		L.ArgError(1, "Invalid captcha response format. Expected string")
+	switch answer.Type() {
+	case lua.LTString:
+		form.Set("g-recaptcha-response", answer.String())
+	case lua.LTTable:
+		for k, v := range TableToMap(answer.(*lua.LTable)) {
+			form.Set(k, fmt.Sprint(v))
+		}
+	default:
+		L.ArgError(1, "Invalid captcha response format. Expected string or table")
 		return 0
 	}
 
 	// Verify captcha answer
-	check, err := util.VerifyCaptcha(answer.String())
+	check, err := util.VerifyCaptcha(form)
 
 	if err != nil {
 
@@ -51,3 +63,23 @@ func VerifyCaptcha(L *lua.LState) int {
 
 	return 1
 }
+
+// GetCaptchaKey returns the reCAPTCHA public key or a new challenge
+// identifier for the image captcha
+func GetCaptchaKey(L *lua.LState) int {
+	provider, err := util.GetCaptchaProvider()
+	if err != nil {
+		L.RaiseError("Cannot get captcha provider: %v", err)
+		return 0
+	}
+
+	key, err := provider.Key()
+	if err != nil {
+		L.RaiseError("Cannot get captcha key: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LString(key))
+
+	return 1
+}
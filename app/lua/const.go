@@ -37,9 +37,15 @@ const (
 	// EventMetaTableName the name of the current event metatable
 	EventMetaTableName = "event"
 
+	// HooksMetaTableName the name of the extension hooks metatable
+	HooksMetaTableName = "hooks"
+
 	// EventsMetaTableName the name of the widget metatable
 	EventsMetaTableName = "events"
 
+	// EventsRunningFieldName the name of the field that holds if the state started a background event
+	EventsRunningFieldName = "__running"
+
 	// WidgetMetaTableName the name of the widget metatable
 	WidgetMetaTableName = "widgets"
 
@@ -94,6 +100,21 @@ const (
 	// SessionInstanceName the field name of the session instance
 	SessionInstanceName = "__s"
 
+	// RolesMetaTableName the name of the roles metatable
+	RolesMetaTableName = "roles"
+
+	// LoginMetaTableName the name of the failed login metatable
+	LoginMetaTableName = "login"
+
+	// TwoFactorMetaTableName the name of the two-factor metatable
+	TwoFactorMetaTableName = "twofa"
+
+	// TokenMetaTableName the name of the token metatable
+	TokenMetaTableName = "token"
+
+	// AuditMetaTableName the name of the audit metatable
+	AuditMetaTableName = "audit"
+
 	// DatabaseMetaTableName the name of the database metatable
 	DatabaseMetaTableName = "db"
 
@@ -129,4 +150,10 @@ const (
 
 	// HTTPMetaTableBodyName the field name of the http body
 	HTTPMetaTableBodyName = "body"
+
+	// SandboxRegistryName the registry field that holds the extension sandbox of a state
+	SandboxRegistryName = "__sandbox"
+
+	// SandboxLoadedName the registry field that holds the modules required by a sandboxed state
+	SandboxLoadedName = "__sandboxLoaded"
 )
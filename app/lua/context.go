@@ -0,0 +1,62 @@
+package lua
+
+import (
+	"context"
+	"time"
+
+	"github.com/raggaer/castro/app/util"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// defaultExecutionTimeout is the execution time given to pages and widgets when no timeout is configured
+const defaultExecutionTimeout = time.Second * 10
+
+// ExecutionTimeout returns the execution timeout of the given page or widget. The timeout is
+// looked up by name first, then by extension identifier and finally the default timeout is used
+func ExecutionTimeout(name, extension string) time.Duration {
+	// Get lua configuration
+	cfg := util.Config.Configuration.Lua
+
+	// Check for page or widget timeout
+	if d, ok := cfg.Timeouts[name]; ok {
+		return d.Duration
+	}
+
+	// Check for extension timeout
+	if extension != "" {
+		if d, ok := cfg.Timeouts[extension]; ok {
+			return d.Duration
+		}
+	}
+
+	// Use configured default timeout
+	if cfg.Timeout.Duration > 0 {
+		return cfg.Timeout.Duration
+	}
+
+	return defaultExecutionTimeout
+}
+
+// NewExecutionContext returns a context that is cancelled when the parent context is done or
+// when the timeout passes. A timeout of zero disables the deadline
+func NewExecutionContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(parent)
+	}
+
+	return context.WithTimeout(parent, timeout)
+}
+
+// stateContext returns the context of the given state or a background context if the state has none
+func stateContext(L *glua.LState) context.Context {
+	if ctx := L.Context(); ctx != nil {
+		return ctx
+	}
+
+	return context.Background()
+}
+
+// hasBackgroundEvents checks if the given state started a background event
+func hasBackgroundEvents(state *glua.LState) bool {
+	return glua.LVAsBool(state.GetField(state.GetTypeMetatable(EventsMetaTableName), EventsRunningFieldName))
+}
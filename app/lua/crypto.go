@@ -10,6 +10,7 @@ import (
 	"fmt"
 
 	"github.com/dchest/uniuri"
+	"github.com/raggaer/castro/app/util"
 	"github.com/skip2/go-qrcode"
 	"github.com/yuin/gopher-lua"
 )
@@ -130,6 +131,42 @@ func Md5Hash(L *lua.LState) int {
 	return 1
 }
 
+// HashPassword hashes the given account password with the configured scheme
+func HashPassword(L *lua.LState) int {
+	// Get password
+	password := L.CheckString(2)
+
+	// Hash password
+	hash, err := util.HashPassword(password)
+
+	if err != nil {
+		L.RaiseError("Cannot hash password: %v", err)
+		return 0
+	}
+
+	// Push hash
+	L.Push(lua.LString(hash))
+
+	return 1
+}
+
+// VerifyPassword checks an account password against a stored hash of any
+// known scheme. The second value tells if the hash should be replaced
+func VerifyPassword(L *lua.LState) int {
+	// Get password and stored hash
+	password := L.CheckString(2)
+	hash := L.CheckString(3)
+
+	// Verify password
+	valid, rehash := util.VerifyPassword(password, hash)
+
+	// Push results
+	L.Push(lua.LBool(valid))
+	L.Push(lua.LBool(rehash))
+
+	return 2
+}
+
 // RandomString generates a random string with the given length
 func RandomString(L *lua.LState) int {
 	// Get length
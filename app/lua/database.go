@@ -1,14 +1,22 @@
 package lua
 
 import (
+	"context"
 	"database/sql"
 	"strings"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/raggaer/castro/app/database"
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 )
 
+// queryer is implemented by the database handle and by transactions
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error)
+}
+
 // SetDatabaseMetaTable sets the database metatable of the given state
 func SetDatabaseMetaTable(luaState *lua.LState) {
 	// Create and set the MySQL metatable
@@ -20,11 +28,216 @@ func SetDatabaseMetaTable(luaState *lua.LState) {
 
 	// Set transaction field status
 	luaState.SetField(mysqlMetaTable, DatabaseTransactionStatusFieldName, lua.LBool(false))
+	luaState.SetField(mysqlMetaTable, DatabaseTransactionFieldName, lua.LNil)
+}
+
+// stateTransaction returns the running transaction of the given state
+func stateTransaction(L *lua.LState) *sqlx.Tx {
+	// Get database metatable
+	meta := L.GetTypeMetatable(DatabaseMetaTableName)
+	if meta == lua.LNil {
+		return nil
+	}
+
+	// Get transaction user data
+	data, ok := L.GetField(meta, DatabaseTransactionFieldName).(*lua.LUserData)
+	if !ok {
+		return nil
+	}
+
+	tx, ok := data.Value.(*sqlx.Tx)
+	if !ok {
+		return nil
+	}
+
+	return tx
+}
+
+// setStateTransaction sets the running transaction of the given state
+func setStateTransaction(L *lua.LState, tx *sqlx.Tx) {
+	// Get database metatable
+	meta := L.GetTypeMetatable(DatabaseMetaTableName)
+
+	if tx == nil {
+		L.SetField(meta, DatabaseTransactionFieldName, lua.LNil)
+		L.SetField(meta, DatabaseTransactionStatusFieldName, lua.LBool(false))
+		return
+	}
+
+	// Create transaction user data
+	data := L.NewUserData()
+	data.Value = tx
+
+	L.SetField(meta, DatabaseTransactionFieldName, data)
+	L.SetField(meta, DatabaseTransactionStatusFieldName, lua.LBool(true))
+}
+
+// stateQueryer returns the running transaction of the given state or the database handle
+func stateQueryer(L *lua.LState) queryer {
+	if tx := stateTransaction(L); tx != nil {
+		return tx
+	}
+
+	return database.DB
+}
+
+// rollbackStateTransaction reverts the running transaction of the given state if any.
+// Used when a state is returned to the pool without finishing its transaction
+func rollbackStateTransaction(L *lua.LState) {
+	// Get running transaction
+	tx := stateTransaction(L)
+	if tx == nil {
+		return
+	}
+
+	// Remove transaction from the state
+	setStateTransaction(L, nil)
+
+	// Rollback transaction
+	if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+		util.Logger.Logger.Errorf("Cannot rollback unfinished transaction: %v", err)
+	}
+}
+
+// BeginTransaction starts a transaction. All the state queries run inside the transaction
+// until commit or rollback are called
+func BeginTransaction(L *lua.LState) int {
+	// Check for running transaction
+	if stateTransaction(L) != nil {
+		L.RaiseError("Cannot begin transaction: a transaction is already running")
+		return 0
+	}
+
+	// Start transaction
+	tx, err := database.DB.BeginTxx(stateContext(L), nil)
+	if err != nil {
+		L.RaiseError("Cannot begin transaction: %v", err)
+		return 0
+	}
+
+	// Save transaction
+	setStateTransaction(L, tx)
+
+	return 0
+}
+
+// CommitTransaction commits the running transaction
+func CommitTransaction(L *lua.LState) int {
+	// Get running transaction
+	tx := stateTransaction(L)
+	if tx == nil {
+		L.RaiseError("Cannot commit transaction: there is no running transaction")
+		return 0
+	}
+
+	// Remove transaction from the state
+	setStateTransaction(L, nil)
+
+	// Commit transaction
+	if err := tx.Commit(); err != nil {
+		L.RaiseError("Cannot commit transaction: %v", err)
+	}
+
+	return 0
+}
+
+// RollbackTransaction reverts the running transaction
+func RollbackTransaction(L *lua.LState) int {
+	// Get running transaction
+	tx := stateTransaction(L)
+	if tx == nil {
+		L.RaiseError("Cannot rollback transaction: there is no running transaction")
+		return 0
+	}
+
+	// Remove transaction from the state
+	setStateTransaction(L, nil)
+
+	// Rollback transaction
+	if err := tx.Rollback(); err != nil {
+		L.RaiseError("Cannot rollback transaction: %v", err)
+	}
+
+	return 0
+}
+
+// Transaction runs the given function inside a transaction. The transaction is committed
+// if the function succeeds or reverted if the function raises an error
+func Transaction(L *lua.LState) int {
+	// Get function
+	fn := L.CheckFunction(2)
+
+	// Function arguments
+	args := []lua.LValue{}
+	for i := 3; i <= L.GetTop(); i++ {
+		args = append(args, L.Get(i))
+	}
+
+	// Run inside the current transaction
+	if stateTransaction(L) != nil {
+		top := L.GetTop()
+		L.Push(fn)
+		for _, arg := range args {
+			L.Push(arg)
+		}
+		L.Call(len(args), lua.MultRet)
+		return L.GetTop() - top
+	}
+
+	// Start transaction
+	tx, err := database.DB.BeginTxx(stateContext(L), nil)
+	if err != nil {
+		L.RaiseError("Cannot begin transaction: %v", err)
+		return 0
+	}
+
+	// Save transaction
+	setStateTransaction(L, tx)
+
+	// Call function
+	top := L.GetTop()
+	L.Push(fn)
+	for _, arg := range args {
+		L.Push(arg)
+	}
+
+	if err := L.PCall(len(args), lua.MultRet, nil); err != nil {
+
+		// Remove transaction from the state
+		setStateTransaction(L, nil)
+
+		// Rollback transaction
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			util.Logger.Logger.Errorf("Cannot rollback transaction: %v", rErr)
+		}
+
+		// Raise the function error
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			L.Error(apiErr.Object, 0)
+		}
+		L.RaiseError("%v", err)
+		return 0
+	}
+
+	// The function can finish the transaction by itself
+	if stateTransaction(L) == tx {
+
+		// Remove transaction from the state
+		setStateTransaction(L, nil)
+
+		// Commit transaction
+		if err := tx.Commit(); err != nil {
+			L.RaiseError("Cannot commit transaction: %v", err)
+			return 0
+		}
+	}
+
+	return L.GetTop() - top
 }
 
 // Wrapper around database.DB.Exec
 func executeQueryHelper(L *lua.LState, query string, args ...interface{}) (sql.Result, error) {
-	return database.DB.Exec(query, args)
+	return stateQueryer(L).ExecContext(stateContext(L), database.Statement(database.DB, query), args...)
 }
 
 // Execute executes a query without returning the result
@@ -40,25 +253,16 @@ func Execute(L *lua.LState) int {
 		return 0
 	}
 
-	// Count number of params
-	n := strings.Count(query.String(), "?")
-
-	args := []interface{}{}
-
-	// Get all arguments matching the number of params
-	for i := 0; i < n; i++ {
-
-		// Append argument to slice
-		args = append(args, L.Get(3+i).String())
-	}
+	// Get query arguments
+	q, args, _ := queryArguments(L, query.String(), 3)
 
 	// Log query on development mode
 	if util.Config.Configuration.IsDev() || util.Config.Configuration.IsLog() {
-		util.Logger.Logger.Infof("execute: "+strings.Replace(query.String(), "?", "%v", -1), args...)
+		util.Logger.Logger.Infof("execute: %v", queryString(q, args))
 	}
 
 	// Execute query using database or transaction
-	result, err := database.DB.Exec(query.String(), args...)
+	result, err := stateQueryer(L).ExecContext(stateContext(L), database.Statement(database.DB, q), args...)
 
 	if err != nil {
 		L.RaiseError("Cannot execute query: %v", err)
@@ -66,7 +270,7 @@ func Execute(L *lua.LState) int {
 	}
 
 	// Check if query is INSERT
-	if !strings.HasPrefix(query.String(), "INSERT") {
+	if !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(q)), "INSERT") {
 		return 0
 	}
 
@@ -84,6 +288,26 @@ func Execute(L *lua.LState) int {
 	return 1
 }
 
+// DropColumn removes the given column of a table. SQLite tables are rebuilt
+// without the column so it can only be used inside migrations there
+func DropColumn(L *lua.LState) int {
+	// Get table and column names
+	table := L.CheckString(2)
+	column := L.CheckString(3)
+
+	// Use the running transaction if any
+	var h database.Handle = database.DB
+	if tx := stateTransaction(L); tx != nil {
+		h = tx
+	}
+
+	if err := database.DropColumn(h, table, column); err != nil {
+		L.RaiseError("Cannot drop column %v.%v: %v", table, column, err)
+	}
+
+	return 0
+}
+
 // SingleQuery performs and ad-hoc query returning the first result as a table
 func SingleQuery(L *lua.LState) int {
 	// Get query
@@ -97,40 +321,28 @@ func SingleQuery(L *lua.LState) int {
 		return 0
 	}
 
-	// Count number of params
-	n := strings.Count(query.String(), "?")
-
-	args := []interface{}{}
-
-	// Get all arguments matching the number of params
-	for i := 0; i < n; i++ {
-
-		// Append argument to slice
-		args = append(args, L.Get(3+i).String())
-	}
+	// Get query arguments
+	q, args, next := queryArguments(L, query.String(), 3)
 
 	// Check if user wants to use cache
-	cache := L.ToBool(3 + n)
+	cache := L.ToBool(next)
 
 	// Save cache variable
 	saveToCache := false
-	cacheKey := query.String()
+	cacheKey := ""
 
 	if cache {
 
 		// Build cache key as the full query with arguments inside
-		for _, arg := range args {
-
-			// Replace "?" with argument
-			cacheKey = strings.Replace(cacheKey, "?", arg.(string), 1)
-		}
+		cacheKey = queryString(q, args)
 
 		// Try to load from cache
 		q, found := util.Cache.Get(cacheKey)
 
 		if found {
 
-			results := q.(*lua.LTable)
+			// Convert cached rows to a new lua sequence
+			results := SliceToTable(q.([]interface{}))
 
 			// Set cache status
 			L.Push(lua.LBool(true))
@@ -159,11 +371,11 @@ func SingleQuery(L *lua.LState) int {
 
 	// Log query on development mode
 	if util.Config.Configuration.IsDev() || util.Config.Configuration.IsLog() {
-		util.Logger.Logger.Infof("query: "+strings.Replace(query.String(), "?", "%v", -1), args...)
+		util.Logger.Logger.Infof("query: %v", queryString(q, args))
 	}
 
 	// Run query
-	rows, err := database.DB.Queryx(query.String(), args...)
+	rows, err := stateQueryer(L).QueryxContext(stateContext(L), database.Statement(database.DB, q), args...)
 
 	if err != nil {
 		L.RaiseError("Cannot execute query: %v", err)
@@ -173,8 +385,9 @@ func SingleQuery(L *lua.LState) int {
 	// Close rows
 	defer rows.Close()
 
-	// Result holder
+	// Result holders
 	results := L.NewTable()
+	list := []interface{}{}
 
 	// Loop rows
 	for rows.Next() {
@@ -190,11 +403,12 @@ func SingleQuery(L *lua.LState) int {
 
 		// Append to lua table
 		results.Append(MapToTable(result))
+		list = append(list, result)
 	}
 
-	// If user wants to use cache save table
-	if saveToCache {
-		util.Cache.Add(cacheKey, results, util.Config.Configuration.Cache.Default.Duration)
+	// If user wants to use cache save rows. Results of a running transaction are not saved
+	if saveToCache && stateTransaction(L) == nil {
+		util.Cache.Add(cacheKey, list, util.Config.Configuration.Cache.Default.Duration)
 	}
 
 	// If there are no results return nil
@@ -229,40 +443,28 @@ func Query(L *lua.LState) int {
 		return 0
 	}
 
-	// Count number of params
-	n := strings.Count(query.String(), "?")
-
-	args := []interface{}{}
-
-	// Get all arguments matching the number of params
-	for i := 0; i < n; i++ {
-
-		// Append argument to slice
-		args = append(args, L.Get(3+i).String())
-	}
+	// Get query arguments
+	q, args, next := queryArguments(L, query.String(), 3)
 
 	// Check if user wants to use cache
-	cache := L.ToBool(3 + n)
+	cache := L.ToBool(next)
 
 	// Save cache variable
 	saveToCache := false
-	cacheKey := query.String()
+	cacheKey := ""
 
 	if cache {
 
 		// Build cache key as the full query with arguments inside
-		for _, arg := range args {
-
-			// Replace "?" with argument
-			cacheKey = strings.Replace(cacheKey, "?", arg.(string), 1)
-		}
+		cacheKey = queryString(q, args)
 
 		// Try to load from cache
 		q, found := util.Cache.Get(cacheKey)
 
 		if found {
 
-			results := q.(*lua.LTable)
+			// Convert cached rows to a new lua sequence
+			results := SliceToTable(q.([]interface{}))
 
 			// If there are no results return nil
 			if results.Len() == 0 {
@@ -288,11 +490,11 @@ func Query(L *lua.LState) int {
 
 	// Log query on development mode
 	if util.Config.Configuration.IsDev() || util.Config.Configuration.IsLog() {
-		util.Logger.Logger.Infof("query: "+strings.Replace(query.String(), "?", "%v", -1), args...)
+		util.Logger.Logger.Infof("query: %v", queryString(q, args))
 	}
 
 	// Run query
-	rows, err := database.DB.Queryx(query.String(), args...)
+	rows, err := stateQueryer(L).QueryxContext(stateContext(L), database.Statement(database.DB, q), args...)
 
 	if err != nil {
 		L.RaiseError("Cannot execute query: %v", err)
@@ -302,8 +504,9 @@ func Query(L *lua.LState) int {
 	// Close rows
 	defer rows.Close()
 
-	// Result holder
+	// Result holders
 	results := L.NewTable()
+	list := []interface{}{}
 
 	// Loop rows
 	for rows.Next() {
@@ -319,11 +522,12 @@ func Query(L *lua.LState) int {
 
 		// Append to lua table
 		results.Append(MapToTable(result))
+		list = append(list, result)
 	}
 
-	// If user wants to use cache save table
-	if saveToCache {
-		util.Cache.Add(cacheKey, results, util.Config.Configuration.Cache.Default.Duration)
+	// If user wants to use cache save rows. Results of a running transaction are not saved
+	if saveToCache && stateTransaction(L) == nil {
+		util.Cache.Add(cacheKey, list, util.Config.Configuration.Cache.Default.Duration)
 	}
 
 	// If there are no results return nil
@@ -1,6 +1,10 @@
 package lua
 
 import (
+	"context"
+	"time"
+
+	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 )
 
@@ -12,6 +16,9 @@ func SetEventsMetaTable(luaState *lua.LState) {
 
 	// Set all events metatable functions
 	luaState.SetFuncs(eventMetaTable, eventsMethods)
+
+	// Set running field status
+	luaState.SetField(eventMetaTable, EventsRunningFieldName, lua.LBool(false))
 }
 
 // BackgroundEvent executes a background event
@@ -19,39 +26,80 @@ func BackgroundEvent(L *lua.LState) int {
 	// Get function
 	f := L.ToFunction(2)
 
+	// Get the extension running the event
+	extension := ""
+	sb := stateSandbox(L)
+	if sb != nil {
+		extension = sb.id
+	}
+
+	// Event timeout. Default event timeout or the extension timeout
+	timeout := util.Config.Configuration.Lua.EventTimeout.Duration
+	if timeout <= 0 {
+		timeout = ExecutionTimeout("", extension)
+	}
+
+	// Get optional timeout value
+	if t := L.Get(3); t.Type() == lua.LTString {
+
+		// Parse timeout
+		d, err := time.ParseDuration(t.String())
+
+		if err != nil {
+			L.ArgError(3, "Invalid timeout format. Unexpected format")
+			return 0
+		}
+
+		timeout = d
+	}
+
+	// Sandboxed extensions can not run events without a deadline
+	if sb != nil && timeout <= 0 {
+		timeout = defaultExecutionTimeout
+	}
+
 	// Create new thread
-	thread, _ := L.NewThread()
+	thread, cancelThread := L.NewThread()
 
-	// Infinite loop
-	go func() {
+	// Create event execution context. Events are not tied to the state context
+	ctx, cancel := NewExecutionContext(context.Background(), timeout)
 
-		for {
+	// Set thread execution context
+	thread.SetContext(ctx)
 
-			// Resume function using  a new state thread
-			status, err, _ := L.Resume(thread, f)
+	// Mark state as running a background event so it is not reused
+	L.SetField(L.GetTypeMetatable(EventsMetaTableName), EventsRunningFieldName, lua.LBool(true))
 
-			if status == lua.ResumeError {
-				break
-			}
+	go func() {
 
-			// Check if event finished execution
-			if status == lua.ResumeOK {
-				break
-			}
+		// Release thread resources
+		defer thread.Close()
+		defer cancel()
 
-			if err != nil {
+		if cancelThread != nil {
+			defer cancelThread()
+		}
+
+		// Revert unfinished transactions
+		defer rollbackStateTransaction(thread)
 
-				// Weird case
-				if err.Error() == "nil" {
-					break
-				}
+		// Execute event function
+		if err := thread.CallByParam(
+			lua.P{
+				Fn:      f,
+				NRet:    0,
+				Protect: true,
+			},
+		); err != nil {
 
-				L.RaiseError("Running event returned an error: %v", err)
-				break
+			// Check if event timed out
+			if ctx.Err() == context.DeadlineExceeded {
+				util.Logger.Logger.Errorf("Background event exceeded its execution time of %v", timeout)
+				return
 			}
-		}
 
-		thread.Close()
+			util.Logger.Logger.Errorf("Running event returned an error: %v", err)
+		}
 	}()
 
 	return 0
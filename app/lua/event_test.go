@@ -0,0 +1,18 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBackgroundEventTimeoutArgument(t *testing.T) {
+	setTestConfig()
+
+	L := NewState()
+	defer L.Close()
+
+	err := L.DoString(`events:new(function() end, "soon")`)
+	if err == nil || !strings.Contains(err.Error(), "#3") {
+		t.Errorf("expected an error on the timeout argument, got %v", err)
+	}
+}
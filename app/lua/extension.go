@@ -1,6 +1,17 @@
 package lua
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/raggaer/castro/app/database"
+	"github.com/raggaer/castro/app/models"
 	"github.com/raggaer/castro/app/util"
 	lua "github.com/yuin/gopher-lua"
 )
@@ -11,8 +22,23 @@ func SetExtensionMetaTable(luaState *lua.LState) {
 	extMetaTable := luaState.NewTypeMetatable(ExtensionMetaTableName)
 	luaState.SetGlobal(ExtensionMetaTableName, extMetaTable)
 
-	// Set all mail metatable functions
-	luaState.SetFuncs(extMetaTable, extensionMethods)
+	// Set all extension metatable functions. The function list is not a package
+	// variable since reloading extensions creates new states
+	luaState.SetFuncs(extMetaTable, map[string]lua.LGFunction{
+		"reload":          ReloadExtensions,
+		"migrate":         MigrateExtension,
+		"rollback":        RollbackExtension,
+		"manifest":        GetExtensionManifest,
+		"install":         InstallExtension,
+		"upgrade":         UpgradeExtension,
+		"uninstall":       UninstallExtension,
+		"installArchive":  InstallExtensionArchive,
+		"registry":        GetExtensionRegistry,
+		"installRegistry": InstallRegistryExtension,
+		"config":          GetExtensionConfig,
+		"settings":        GetExtensionSettings,
+		"saveSettings":    SaveExtensionSettings,
+	})
 }
 
 // ReloadExtensions reloads all extensions
@@ -32,5 +58,819 @@ func ReloadExtensions(L *lua.LState) int {
 		L.RaiseError("Cannot reload extension widget list: %v", err)
 	}
 
+	// Reload extension hooks
+	if err := Hooks.Load(); err != nil {
+		L.RaiseError("Cannot reload extension hooks: %v", err)
+	}
+
+	// Reload extension capabilities
+	Sandboxes.Reset()
+
 	return 0
 }
+
+// MigrateExtension applies the pending migrations of the given extension
+func MigrateExtension(L *lua.LState) int {
+	// Get extension id
+	id := L.ToString(2)
+
+	// Apply extension migrations
+	applied, err := ApplyMigrations(map[string]string{
+		id: filepath.Join("extensions", id, "migrations"),
+	})
+	if err != nil {
+		L.RaiseError("Cannot apply %v extension migrations: %v", id, err)
+		return 0
+	}
+
+	// Push number of applied migrations
+	L.Push(lua.LNumber(len(applied)))
+
+	return 1
+}
+
+// RollbackExtension reverts the applied migrations of the given extension
+func RollbackExtension(L *lua.LState) int {
+	// Get extension id
+	id := L.ToString(2)
+
+	// Revert extension migrations
+	reverted, err := RollbackMigrations(id, filepath.Join("extensions", id, "migrations"), L.OptInt(3, 0))
+	if err != nil {
+		L.RaiseError("Cannot rollback %v extension migrations: %v", id, err)
+		return 0
+	}
+
+	// Push number of reverted migrations
+	L.Push(lua.LNumber(len(reverted)))
+
+	return 1
+}
+
+// GetExtensionManifest returns the manifest of the given extension with its
+// installed version. Returns nil and the error message if the manifest is not valid
+func GetExtensionManifest(L *lua.LState) int {
+	// Get extension id
+	id := L.CheckString(2)
+
+	manifest, err := util.LoadExtensionManifest(id)
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	// Get installed extensions
+	installed, err := installedExtensions()
+	if err != nil {
+		L.RaiseError("Cannot get installed extensions: %v", err)
+		return 0
+	}
+
+	dependencies := map[string]interface{}{}
+	for id, constraint := range manifest.Dependencies {
+		dependencies[id] = constraint
+	}
+
+	t := L.NewTable()
+	t.RawSetString("id", lua.LString(manifest.ID))
+	t.RawSetString("name", lua.LString(manifest.Name))
+	t.RawSetString("author", lua.LString(manifest.Author))
+	t.RawSetString("version", lua.LString(manifest.Version))
+	t.RawSetString("description", lua.LString(manifest.Description))
+	t.RawSetString("type", lua.LString(manifest.Type))
+	t.RawSetString("url", lua.LString(manifest.URL))
+	t.RawSetString("castro", lua.LString(manifest.Castro))
+	t.RawSetString("dependencies", MapToTable(dependencies))
+	t.RawSetString("configurable", lua.LBool(len(manifest.Config) > 0))
+
+	capabilities := L.NewTable()
+	for _, capability := range manifest.Capabilities {
+		capabilities.Append(lua.LString(capability))
+	}
+
+	t.RawSetString("capabilities", capabilities)
+
+	if version, ok := installed[manifest.ID]; ok {
+		t.RawSetString("installed", lua.LTrue)
+		t.RawSetString("installedVersion", lua.LString(version.String()))
+		t.RawSetString("upgradable", lua.LBool(manifest.SemanticVersion().Compare(version) > 0))
+
+		sb, err := Sandboxes.Get(manifest.ID)
+		t.RawSetString("unrestricted", lua.LBool(err == nil && sb == nil))
+	}
+
+	if err := manifest.CheckCastroVersion(); err != nil {
+		t.RawSetString("incompatible", lua.LString(err.Error()))
+	}
+
+	L.Push(t)
+
+	return 1
+}
+
+// InstallExtension installs the given extension and its missing dependencies.
+// The extension only runs unrestricted if the third argument is true, its
+// dependencies are always sandboxed. Returns the list of installed extensions
+// and the message of the install script
+func InstallExtension(L *lua.LState) int {
+	// Get extension id
+	id := L.CheckString(2)
+
+	// Get unrestricted access grant
+	unrestricted := ""
+	if L.OptBool(3, false) {
+		unrestricted = id
+	}
+
+	// Resolve install order
+	list, err := resolveExtensionInstall(id)
+	if err != nil {
+		L.RaiseError("Cannot install %v: %v", id, err)
+		return 0
+	}
+
+	message, err := installExtensions(L, list, unrestricted)
+	if err != nil {
+		L.RaiseError("Cannot install %v: %v", id, err)
+		return 0
+	}
+
+	// Data holder
+	t := L.NewTable()
+	for _, manifest := range list {
+		e := L.NewTable()
+		e.RawSetString("id", lua.LString(manifest.ID))
+		e.RawSetString("version", lua.LString(manifest.Version))
+		t.Append(e)
+	}
+
+	L.Push(t)
+	L.Push(lua.LString(message))
+
+	return 2
+}
+
+// UpgradeExtension upgrades the given extension to the version of its manifest.
+// Returns the previous version, the new version and the message of the upgrade script
+func UpgradeExtension(L *lua.LState) int {
+	// Get extension id
+	id := L.CheckString(2)
+
+	from, to, message, err := upgradeExtension(L, id)
+	if err != nil {
+		L.RaiseError("Cannot upgrade %v: %v", id, err)
+		return 0
+	}
+
+	L.Push(lua.LString(from))
+	L.Push(lua.LString(to))
+	L.Push(lua.LString(message))
+
+	return 3
+}
+
+// UninstallExtension removes the given extension and reverts its migrations.
+// Returns the message of the uninstall script
+func UninstallExtension(L *lua.LState) int {
+	// Get extension id
+	id := L.CheckString(2)
+
+	message, err := uninstallExtension(L, id)
+	if err != nil {
+		L.RaiseError("Cannot uninstall %v: %v", id, err)
+		return 0
+	}
+
+	L.Push(lua.LString(message))
+
+	return 1
+}
+
+// installedExtensions returns the version of every installed extension
+func installedExtensions() (map[string]util.Version, error) {
+	rows := []struct {
+		ID      string
+		Version string
+	}{}
+
+	if err := database.DB.Select(&rows, "SELECT id, COALESCE(version, '') AS version FROM castro_extensions"); err != nil {
+		return nil, err
+	}
+
+	installed := map[string]util.Version{}
+	for _, row := range rows {
+		// Versions saved by older castro versions may not be valid
+		v, _ := util.ParseVersion(row.Version)
+		installed[row.ID] = v
+	}
+
+	return installed, nil
+}
+
+// checkDependency checks if the given version satisfies a dependency range
+func checkDependency(parent, id, constraint string, version util.Version) error {
+	c, err := util.ParseVersionConstraint(constraint)
+	if err != nil {
+		return err
+	}
+
+	if !c.Check(version) {
+		return fmt.Errorf("%v requires %v %v, found %v", parent, id, constraint, version.String())
+	}
+
+	return nil
+}
+
+// resolveExtensionInstall returns the manifests of the given extension and its missing
+// dependencies in install order
+func resolveExtensionInstall(id string) ([]*util.ExtensionManifest, error) {
+	installed, err := installedExtensions()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := installed[id]; ok {
+		return nil, errors.New("extension is already installed")
+	}
+
+	list := []*util.ExtensionManifest{}
+	resolved := map[string]*util.ExtensionManifest{}
+	visiting := map[string]bool{}
+
+	var visit func(id, parent, constraint string) error
+	visit = func(id, parent, constraint string) error {
+		// Installed dependencies only need a valid version
+		if version, ok := installed[id]; ok {
+			return checkDependency(parent, id, constraint, version)
+		}
+
+		if visiting[id] {
+			return fmt.Errorf("circular dependency between %v and %v", parent, id)
+		}
+
+		if manifest, ok := resolved[id]; ok {
+			return checkDependency(parent, id, constraint, manifest.SemanticVersion())
+		}
+
+		manifest, err := util.LoadExtensionManifest(id)
+		if err != nil {
+			if parent != "" {
+				return fmt.Errorf("dependency %v of %v is not available: %v", id, parent, err)
+			}
+			return err
+		}
+
+		if parent != "" {
+			if err := checkDependency(parent, id, constraint, manifest.SemanticVersion()); err != nil {
+				return err
+			}
+		}
+
+		if err := manifest.CheckCastroVersion(); err != nil {
+			return err
+		}
+
+		// Dependencies are installed first
+		visiting[id] = true
+		for _, dep := range manifest.DependencyList() {
+			if err := visit(dep, id, manifest.Dependencies[dep]); err != nil {
+				return err
+			}
+		}
+		visiting[id] = false
+
+		resolved[id] = manifest
+		list = append(list, manifest)
+
+		return nil
+	}
+
+	if err := visit(id, "", ""); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// installedDependents returns the installed extensions that depend on the given extension
+func installedDependents(id string) (map[string]*util.ExtensionManifest, error) {
+	installed, err := installedExtensions()
+	if err != nil {
+		return nil, err
+	}
+
+	dependents := map[string]*util.ExtensionManifest{}
+
+	for other := range installed {
+		if other == id {
+			continue
+		}
+
+		manifest, err := util.LoadExtensionManifest(other)
+		if err != nil {
+			util.Logger.Logger.Errorf("Cannot check %v dependencies: %v", other, err)
+			continue
+		}
+
+		if _, ok := manifest.Dependencies[id]; ok {
+			dependents[other] = manifest
+		}
+	}
+
+	return dependents, nil
+}
+
+// runExtensionTransaction runs the given function with a transaction set as the
+// state transaction. The transaction is reverted if the function fails
+func runExtensionTransaction(L *lua.LState, f func(tx *sqlx.Tx) error) error {
+	if stateTransaction(L) != nil {
+		return errors.New("extensions can not be changed inside a transaction")
+	}
+
+	// Start transaction
+	tx, err := database.DB.BeginTxx(stateContext(L), nil)
+	if err != nil {
+		return err
+	}
+
+	setStateTransaction(L, tx)
+
+	err = f(tx)
+
+	// Lifecycle scripts can not finish the transaction by themselves
+	if stateTransaction(L) != tx {
+		err = errors.New("lifecycle scripts can not commit or rollback the transaction")
+	}
+
+	setStateTransaction(L, nil)
+
+	if err != nil {
+		if rErr := tx.Rollback(); rErr != nil {
+			util.Logger.Logger.Errorf("Cannot rollback extension transaction: %v", rErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// runExtensionScript calls a function of an extension lifecycle script. Scripts
+// that do not exist are skipped. The function can return false and a message to
+// abort the operation. Sandboxed extensions only get their capabilities
+func runExtensionScript(L *lua.LState, sb *sandbox, id, script, name string, args ...lua.LValue) (string, error) {
+	path := filepath.Join("extensions", id, script)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return "", nil
+	}
+
+	proto, err := CompileLua(path)
+	if err != nil {
+		return "", err
+	}
+
+	if sb != nil {
+		defer sb.enter(L)()
+	}
+
+	env, err := runIsolated(L, proto, sb)
+	if err != nil {
+		return "", err
+	}
+
+	fn, ok := env.RawGetString(name).(*lua.LFunction)
+	if !ok {
+		return "", fmt.Errorf("function %v is missing on %v", name, path)
+	}
+
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 2, Protect: true}, args...); err != nil {
+		return "", err
+	}
+
+	success, message := L.Get(-2), L.Get(-1)
+	L.Pop(2)
+
+	if success == lua.LFalse {
+		if message == lua.LNil {
+			return "", fmt.Errorf("%v function explicitly returned false but did not provide any message", name)
+		}
+		return "", errors.New(message.String())
+	}
+
+	if message == lua.LNil {
+		return "", nil
+	}
+
+	return message.String(), nil
+}
+
+// revertExtensionMigrations reverts the given number of applied migrations of every extension
+func revertExtensionMigrations(applied map[string]int) {
+	for id, n := range applied {
+		if n == 0 {
+			continue
+		}
+
+		if _, err := RollbackMigrations(id, filepath.Join("extensions", id, "migrations"), n); err != nil {
+			util.Logger.Logger.Errorf("Cannot revert %v extension migrations: %v", id, err)
+		}
+	}
+}
+
+// installExtensions installs the given extensions in order. Migrations are applied
+// first and reverted if any install step fails. The unrestricted extension runs
+// without a sandbox. Returns the message of the install script of the last extension
+func installExtensions(L *lua.LState, list []*util.ExtensionManifest, unrestricted string) (string, error) {
+	applied := map[string]int{}
+
+	if unrestricted != "" {
+		defer Sandboxes.grant(unrestricted)()
+	}
+
+	// Apply migrations
+	for _, manifest := range list {
+		migrations, err := ApplyMigrations(map[string]string{
+			manifest.ID: filepath.Join("extensions", manifest.ID, "migrations"),
+		})
+
+		applied[manifest.ID] = len(migrations)
+
+		if err != nil {
+			revertExtensionMigrations(applied)
+			return "", fmt.Errorf("cannot apply %v migrations: %v", manifest.ID, err)
+		}
+	}
+
+	message := ""
+
+	err := runExtensionTransaction(L, func(tx *sqlx.Tx) error {
+		for _, manifest := range list {
+			sb, err := Sandboxes.manifestSandbox(manifest)
+			if err != nil {
+				return err
+			}
+
+			msg, err := runExtensionScript(L, sb, manifest.ID, manifest.Scripts.Install, "install")
+			if err != nil {
+				return fmt.Errorf("%v install script failed: %v", manifest.ID, err)
+			}
+
+			message = msg
+
+			now := time.Now().Unix()
+
+			if _, err := tx.Exec(
+				"INSERT INTO castro_extensions (name, id, version, description, author, type, installed, capabilities, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, 1, ?, ?, ?)",
+				manifest.Name,
+				manifest.ID,
+				manifest.Version,
+				manifest.Description,
+				manifest.Author,
+				manifest.Type,
+				manifest.CapabilityGrant(manifest.ID == unrestricted),
+				now,
+				now,
+			); err != nil {
+				return err
+			}
+
+			if err := insertExtensionResources(tx, manifest, true); err != nil {
+				return err
+			}
+
+			for permission, description := range manifest.Permissions {
+				if _, err := tx.Exec("INSERT INTO castro_permissions (name, description, extension_id) VALUES (?, ?, ?)", permission, description, manifest.ID); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		revertExtensionMigrations(applied)
+		return "", err
+	}
+
+	// Use the granted capabilities
+	Sandboxes.Reset()
+
+	// Compile the new hooks
+	if err := Hooks.Load(); err != nil {
+		util.Logger.Logger.Errorf("Cannot reload extension hooks: %v", err)
+	}
+
+	return message, nil
+}
+
+// ownPermission checks if the given permission belongs to the given extension.
+// Role permissions outside the extension prefix are never removed
+func ownPermission(id, permission string) bool {
+	return strings.HasPrefix(permission, id+".") && permission != models.AllPermissions && !models.IsCorePermission(permission)
+}
+
+// insertExtensionResources saves the hooks, template hooks, pages and widgets of the given extension
+func insertExtensionResources(tx *sqlx.Tx, manifest *util.ExtensionManifest, enabled bool) error {
+	for hook, handler := range manifest.Hooks {
+		if _, err := tx.Exec("INSERT INTO castro_extension_hooks (extension_id, type, script, priority, enabled) VALUES (?, ?, ?, ?, ?)", manifest.ID, hook, handler.Script, handler.Priority, enabled); err != nil {
+			return err
+		}
+	}
+
+	for hook, template := range manifest.TemplateHooks {
+		if _, err := tx.Exec("INSERT INTO castro_extension_templatehooks (extension_id, type, template, enabled) VALUES (?, ?, ?, ?)", manifest.ID, hook, template, enabled); err != nil {
+			return err
+		}
+	}
+
+	for _, extType := range []string{"pages", "widgets"} {
+		if _, err := os.Stat(filepath.Join("extensions", manifest.ID, extType)); err != nil {
+			continue
+		}
+
+		var exists bool
+		if err := tx.Get(&exists, "SELECT EXISTS (SELECT 1 FROM castro_extension_"+extType+" WHERE extension_id = ?)", manifest.ID); err != nil {
+			return err
+		}
+
+		if exists {
+			continue
+		}
+
+		if _, err := tx.Exec("INSERT INTO castro_extension_"+extType+" (extension_id, enabled) VALUES (?, ?)", manifest.ID, enabled); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// upgradeExtension upgrades the given extension to the version of its manifest
+func upgradeExtension(L *lua.LState, id string) (string, string, string, error) {
+	manifest, err := util.LoadExtensionManifest(id)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	installed, err := installedExtensions()
+	if err != nil {
+		return "", "", "", err
+	}
+
+	from, ok := installed[id]
+	if !ok {
+		return "", "", "", errors.New("extension is not installed")
+	}
+
+	to := manifest.SemanticVersion()
+
+	if to.Compare(from) <= 0 {
+		return "", "", "", fmt.Errorf("installed version %v is up to date", from.String())
+	}
+
+	if err := manifest.CheckCastroVersion(); err != nil {
+		return "", "", "", err
+	}
+
+	// Dependencies must be installed
+	for _, dep := range manifest.DependencyList() {
+		version, ok := installed[dep]
+		if !ok {
+			return "", "", "", fmt.Errorf("dependency %v is not installed", dep)
+		}
+
+		if err := checkDependency(id, dep, manifest.Dependencies[dep], version); err != nil {
+			return "", "", "", err
+		}
+	}
+
+	// Installed extensions must accept the new version
+	dependents, err := installedDependents(id)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	for other, dependent := range dependents {
+		if err := checkDependency(other, id, dependent.Dependencies[id], to); err != nil {
+			return "", "", "", err
+		}
+	}
+
+	// Apply new migrations
+	migrations, err := ApplyMigrations(map[string]string{
+		id: filepath.Join("extensions", id, "migrations"),
+	})
+	applied := map[string]int{id: len(migrations)}
+
+	if err != nil {
+		revertExtensionMigrations(applied)
+		return "", "", "", fmt.Errorf("cannot apply migrations: %v", err)
+	}
+
+	// Unrestricted extensions keep the grant of the administrator
+	sb, err := Sandboxes.Get(id)
+	if err == nil && sb != nil {
+		sb, err = Sandboxes.manifestSandbox(manifest)
+	}
+
+	if err != nil {
+		revertExtensionMigrations(applied)
+		return "", "", "", err
+	}
+
+	message := ""
+
+	err = runExtensionTransaction(L, func(tx *sqlx.Tx) error {
+		msg, err := runExtensionScript(L, sb, id, manifest.Scripts.Upgrade, "upgrade", lua.LString(from.String()), lua.LString(to.String()))
+		if err != nil {
+			return fmt.Errorf("upgrade script failed: %v", err)
+		}
+
+		message = msg
+
+		if _, err := tx.Exec(
+			"UPDATE castro_extensions SET name = ?, version = ?, description = ?, author = ?, type = ?, capabilities = ?, updated_at = ? WHERE id = ?",
+			manifest.Name,
+			manifest.Version,
+			manifest.Description,
+			manifest.Author,
+			manifest.Type,
+			manifest.CapabilityGrant(sb == nil),
+			time.Now().Unix(),
+			id,
+		); err != nil {
+			return err
+		}
+
+		// Keep hooks disabled if they were disabled
+		var disabled bool
+		if err := tx.Get(&disabled, "SELECT EXISTS (SELECT 1 FROM castro_extension_hooks WHERE extension_id = ? AND enabled = 0)", id); err != nil {
+			return err
+		}
+
+		// Replace hooks and template hooks
+		for _, table := range []string{"castro_extension_hooks", "castro_extension_templatehooks"} {
+			if _, err := tx.Exec("DELETE FROM "+table+" WHERE extension_id = ?", id); err != nil {
+				return err
+			}
+		}
+
+		if err := insertExtensionResources(tx, manifest, !disabled); err != nil {
+			return err
+		}
+
+		// Remove settings that are no longer in the config schema
+		if err := removeStaleExtensionConfig(tx, manifest); err != nil {
+			return err
+		}
+
+		// Replace permissions keeping the role permissions that still exist
+		current := []string{}
+		if err := tx.Select(&current, "SELECT name FROM castro_permissions WHERE extension_id = ?", id); err != nil {
+			return err
+		}
+
+		for _, permission := range current {
+			if _, ok := manifest.Permissions[permission]; ok || !ownPermission(id, permission) {
+				continue
+			}
+
+			if _, err := tx.Exec("DELETE FROM castro_role_permissions WHERE permission = ?", permission); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Exec("DELETE FROM castro_permissions WHERE extension_id = ?", id); err != nil {
+			return err
+		}
+
+		for permission, description := range manifest.Permissions {
+			if _, err := tx.Exec("INSERT INTO castro_permissions (name, description, extension_id) VALUES (?, ?, ?)", permission, description, id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		revertExtensionMigrations(applied)
+		return "", "", "", err
+	}
+
+	// Use the granted capabilities
+	Sandboxes.Reset()
+
+	// Compile the new hooks
+	if err := Hooks.Load(); err != nil {
+		util.Logger.Logger.Errorf("Cannot reload extension hooks: %v", err)
+	}
+
+	return from.String(), to.String(), message, nil
+}
+
+// uninstallExtension removes the given extension. The extension data is only
+// removed if the uninstall script succeeds
+func uninstallExtension(L *lua.LState, id string) (string, error) {
+	installed, err := installedExtensions()
+	if err != nil {
+		return "", err
+	}
+
+	if _, ok := installed[id]; !ok {
+		return "", errors.New("extension is not installed")
+	}
+
+	// Installed extensions can not lose their dependencies
+	dependents, err := installedDependents(id)
+	if err != nil {
+		return "", err
+	}
+
+	if len(dependents) > 0 {
+		names := []string{}
+		for other := range dependents {
+			names = append(names, other)
+		}
+
+		sort.Strings(names)
+
+		return "", fmt.Errorf("%v depends on it", strings.Join(names, ", "))
+	}
+
+	// Use the default script name if the manifest is gone
+	script := "uninstall.lua"
+	if manifest, err := util.LoadExtensionManifest(id); err == nil {
+		script = manifest.Scripts.Uninstall
+	}
+
+	// Run the uninstall script with the granted capabilities
+	sb, err := Sandboxes.Get(id)
+	if err != nil {
+		return "", err
+	}
+
+	message := ""
+
+	err = runExtensionTransaction(L, func(tx *sqlx.Tx) error {
+		msg, err := runExtensionScript(L, sb, id, script, "uninstall")
+		if err != nil {
+			return fmt.Errorf("uninstall script failed: %v", err)
+		}
+
+		message = msg
+
+		// Remove the extension permissions from every role
+		permissions := []string{}
+		if err := tx.Select(&permissions, "SELECT name FROM castro_permissions WHERE extension_id = ?", id); err != nil {
+			return err
+		}
+
+		for _, permission := range permissions {
+			if !ownPermission(id, permission) {
+				continue
+			}
+
+			if _, err := tx.Exec("DELETE FROM castro_role_permissions WHERE permission = ?", permission); err != nil {
+				return err
+			}
+		}
+
+		for _, query := range []string{
+			"DELETE FROM castro_permissions WHERE extension_id = ?",
+			"DELETE FROM castro_extension_hooks WHERE extension_id = ?",
+			"DELETE FROM castro_extension_templatehooks WHERE extension_id = ?",
+			"DELETE FROM castro_extension_pages WHERE extension_id = ?",
+			"DELETE FROM castro_extension_widgets WHERE extension_id = ?",
+			"DELETE FROM castro_extension_config WHERE extension_id = ?",
+			"DELETE FROM castro_extensions WHERE id = ?",
+		} {
+			if _, err := tx.Exec(query, id); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	// Forget the extension capabilities
+	Sandboxes.Reset()
+
+	// Remove the extension hooks
+	if err := Hooks.Load(); err != nil {
+		util.Logger.Logger.Errorf("Cannot reload extension hooks: %v", err)
+	}
+
+	// Revert extension migrations
+	if _, err := RollbackMigrations(id, filepath.Join("extensions", id, "migrations"), 0); err != nil {
+		return message, fmt.Errorf("extension was removed but its migrations could not be reverted: %v", err)
+	}
+
+	return message, nil
+}
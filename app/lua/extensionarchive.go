@@ -0,0 +1,275 @@
+package lua
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/raggaer/castro/app/util"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// InstallExtensionArchive installs or upgrades an extension from a zip archive.
+// The archive can be a form file, a file path or an http(s) URL. Returns the
+// install result and the message of the lifecycle script
+func InstallExtensionArchive(L *lua.LState) int {
+	// Get archive contents
+	data, err := extensionArchiveData(L, L.Get(2))
+	if err != nil {
+		L.RaiseError("Cannot read extension archive: %v", err)
+		return 0
+	}
+
+	result, message, err := installExtensionArchive(L, data, L.OptString(3, ""), L.OptString(4, ""), "")
+	if err != nil {
+		L.RaiseError("Cannot install extension archive: %v", err)
+		return 0
+	}
+
+	L.Push(result)
+	L.Push(lua.LString(message))
+
+	return 2
+}
+
+// GetExtensionRegistry returns the extensions of the local registry with
+// their installed version
+func GetExtensionRegistry(L *lua.LState) int {
+	entries, err := util.LoadExtensionRegistry()
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	// Get installed extensions
+	installed, err := installedExtensions()
+	if err != nil {
+		L.RaiseError("Cannot get installed extensions: %v", err)
+		return 0
+	}
+
+	// Data holder
+	t := L.NewTable()
+
+	for _, entry := range entries {
+		e := L.NewTable()
+		e.RawSetString("id", lua.LString(entry.ID))
+		e.RawSetString("name", lua.LString(entry.Name))
+		e.RawSetString("version", lua.LString(entry.Version))
+		e.RawSetString("description", lua.LString(entry.Description))
+		e.RawSetString("signed", lua.LBool(entry.Signature != ""))
+
+		if version, ok := installed[entry.ID]; ok {
+			e.RawSetString("installed", lua.LTrue)
+			e.RawSetString("installedVersion", lua.LString(version.String()))
+
+			if v, err := util.ParseVersion(entry.Version); err == nil {
+				e.RawSetString("upgradable", lua.LBool(v.Compare(version) > 0))
+			}
+		}
+
+		t.Append(e)
+	}
+
+	L.Push(t)
+
+	return 1
+}
+
+// InstallRegistryExtension installs or upgrades the given extension from the
+// local registry. Returns the same values as installArchive
+func InstallRegistryExtension(L *lua.LState) int {
+	// Get extension id
+	id := L.CheckString(2)
+
+	entry, err := util.FindExtensionRegistryEntry(id)
+	if err != nil {
+		L.RaiseError("Cannot install %v: %v", id, err)
+		return 0
+	}
+
+	data, err := entry.ReadArchive()
+	if err != nil {
+		L.RaiseError("Cannot read %v archive: %v", id, err)
+		return 0
+	}
+
+	result, message, err := installExtensionArchive(L, data, entry.SHA256, entry.Signature, id)
+	if err != nil {
+		L.RaiseError("Cannot install %v: %v", id, err)
+		return 0
+	}
+
+	L.Push(result)
+	L.Push(lua.LString(message))
+
+	return 2
+}
+
+// extensionArchiveData returns the contents of the given archive source
+func extensionArchiveData(L *lua.LState, source lua.LValue) ([]byte, error) {
+	switch source.Type() {
+	case lua.LTTable:
+		u, ok := L.GetField(source, "__file").(*lua.LUserData)
+		if !ok {
+			return nil, fmt.Errorf("invalid form file")
+		}
+
+		file, ok := u.Value.(*formFileUserData)
+		if !ok {
+			return nil, fmt.Errorf("invalid form file")
+		}
+
+		if int64(len(file.File)) > util.MaxExtensionArchiveSize() {
+			return nil, fmt.Errorf("archive is bigger than %d bytes", util.MaxExtensionArchiveSize())
+		}
+
+		return file.File, nil
+	case lua.LTString:
+		src := source.String()
+
+		if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+			return util.DownloadExtensionArchive(src)
+		}
+
+		return util.ReadExtensionArchive(src)
+	}
+
+	return nil, fmt.Errorf("expected a form file, path or URL, got %v", source.Type().String())
+}
+
+// installExtensionArchive verifies and unpacks the given archive into the
+// extensions folder and runs the install or upgrade flow. An existing
+// extension folder is only replaced by a newer version and restored if the
+// install fails. Unless empty, the archive must hold the expected extension
+func installExtensionArchive(L *lua.LState, data []byte, checksum, signature, expected string) (*lua.LTable, string, error) {
+	if expected != "" {
+		manifest, err := util.ReadExtensionArchiveManifest(data)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if manifest.ID != expected {
+			return nil, "", fmt.Errorf("archive holds the %v extension", manifest.ID)
+		}
+	}
+
+	if err := util.VerifyExtensionArchive(data, checksum, signature); err != nil {
+		return nil, "", err
+	}
+
+	// Unpack next to the other extensions so the folder can be renamed
+	staging, err := ioutil.TempDir("extensions", ".archive-")
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer os.RemoveAll(staging)
+
+	manifest, err := util.ExtractExtensionArchive(data, filepath.Join(staging, "files"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	installed, err := installedExtensions()
+	if err != nil {
+		return nil, "", err
+	}
+
+	id := manifest.ID
+	if !util.ValidExtensionID(id) {
+		return nil, "", fmt.Errorf("invalid extension id %q", id)
+	}
+
+	target := filepath.Join("extensions", id)
+	backup := ""
+	from, upgrade := installed[id]
+
+	if _, err := os.Stat(target); err == nil {
+		// Get the version of the existing folder
+		current := from
+		if !upgrade {
+			m, err := util.LoadExtensionManifest(id)
+			if err != nil {
+				return nil, "", fmt.Errorf("%v already exists", target)
+			}
+			current = m.SemanticVersion()
+		}
+
+		if manifest.SemanticVersion().Compare(current) <= 0 {
+			return nil, "", fmt.Errorf("%v version %v is already present", id, current.String())
+		}
+
+		backup = filepath.Join(staging, "previous")
+		if err := os.Rename(target, backup); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := os.Rename(filepath.Join(staging, "files"), target); err != nil {
+		if backup != "" {
+			os.Rename(backup, target)
+		}
+		return nil, "", err
+	}
+
+	result := L.NewTable()
+	result.RawSetString("id", lua.LString(id))
+	result.RawSetString("version", lua.LString(manifest.Version))
+
+	if upgrade {
+		_, _, message, err := upgradeExtension(L, id)
+		if err == nil {
+			result.RawSetString("from", lua.LString(from.String()))
+			return result, message, nil
+		}
+
+		restoreExtensionFolder(target, backup)
+		return nil, "", err
+	}
+
+	list, err := resolveExtensionInstall(id)
+	if err != nil {
+		restoreExtensionFolder(target, backup)
+		return nil, "", err
+	}
+
+	message, err := installExtensions(L, list, "")
+	if err != nil {
+		restoreExtensionFolder(target, backup)
+		return nil, "", err
+	}
+
+	// Installed extensions including missing dependencies
+	installedList := L.NewTable()
+	for _, m := range list {
+		e := L.NewTable()
+		e.RawSetString("id", lua.LString(m.ID))
+		e.RawSetString("version", lua.LString(m.Version))
+		installedList.Append(e)
+	}
+
+	result.RawSetString("installed", installedList)
+
+	return result, message, nil
+}
+
+// restoreExtensionFolder removes an unpacked extension folder and moves back
+// the previous one
+func restoreExtensionFolder(target, backup string) {
+	if err := os.RemoveAll(target); err != nil {
+		util.Logger.Logger.Errorf("Cannot remove %v: %v", target, err)
+		return
+	}
+
+	if backup == "" {
+		return
+	}
+
+	if err := os.Rename(backup, target); err != nil {
+		util.Logger.Logger.Errorf("Cannot restore %v: %v", target, err)
+	}
+}
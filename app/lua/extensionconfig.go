@@ -0,0 +1,286 @@
+package lua
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/raggaer/castro/app/database"
+	"github.com/raggaer/castro/app/util"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// extensionConfigRow holds a castro_extension_config row
+type extensionConfigRow struct {
+	Name  string
+	Value string
+}
+
+// GetExtensionConfig returns the settings of the given extension using the
+// defaults of its manifest for the fields that were never saved. Sandboxed
+// extensions can only read their own settings and can omit the id
+func GetExtensionConfig(L *lua.LState) int {
+	id := L.OptString(2, "")
+
+	if sb := stateSandbox(L); sb != nil {
+		if id != "" && id != sb.id {
+			L.RaiseError("Extension %v can not read the %v settings", sb.id, id)
+			return 0
+		}
+		id = sb.id
+	}
+
+	if id == "" {
+		L.ArgError(2, "Extension id expected")
+		return 0
+	}
+
+	manifest, err := util.LoadExtensionManifest(id)
+	if err != nil {
+		L.RaiseError("Cannot get %v settings: %v", id, err)
+		return 0
+	}
+
+	values, _, err := extensionConfigValues(manifest)
+	if err != nil {
+		L.RaiseError("Cannot get %v settings: %v", id, err)
+		return 0
+	}
+
+	L.Push(MapToTable(values))
+
+	return 1
+}
+
+// GetExtensionSettings returns the config schema of the given extension with
+// the current values. Secret values are never returned, the set field tells if
+// they were saved
+func GetExtensionSettings(L *lua.LState) int {
+	// Get extension id
+	id := L.CheckString(2)
+
+	manifest, err := util.LoadExtensionManifest(id)
+	if err != nil {
+		L.RaiseError("Cannot get %v settings: %v", id, err)
+		return 0
+	}
+
+	values, set, err := extensionConfigValues(manifest)
+	if err != nil {
+		L.RaiseError("Cannot get %v settings: %v", id, err)
+		return 0
+	}
+
+	// Data holder
+	t := L.NewTable()
+
+	for _, field := range manifest.Config {
+		f := L.NewTable()
+		f.RawSetString("name", lua.LString(field.Name))
+		f.RawSetString("label", lua.LString(field.Label))
+		f.RawSetString("description", lua.LString(field.Description))
+		f.RawSetString("type", lua.LString(field.Type))
+		f.RawSetString("required", lua.LBool(field.Required))
+		f.RawSetString("secret", lua.LBool(field.Secret))
+		f.RawSetString("set", lua.LBool(set[field.Name]))
+
+		if field.Label == "" {
+			f.RawSetString("label", lua.LString(field.Name))
+		}
+
+		// Limits are strings so zero limits are also rendered
+		if field.Min != nil {
+			f.RawSetString("min", lua.LString(strconv.FormatFloat(*field.Min, 'f', -1, 64)))
+		}
+
+		if field.Max != nil {
+			f.RawSetString("max", lua.LString(strconv.FormatFloat(*field.Max, 'f', -1, 64)))
+		}
+
+		if field.MaxLength > 0 {
+			f.RawSetString("maxLength", lua.LNumber(field.MaxLength))
+		}
+
+		options := L.NewTable()
+		for _, option := range field.Options {
+			options.Append(lua.LString(option))
+		}
+
+		f.RawSetString("options", options)
+
+		if !field.Secret {
+			f.RawSetString("value", GoToValue(values[field.Name]))
+		}
+
+		t.Append(f)
+	}
+
+	L.Push(t)
+
+	return 1
+}
+
+// SaveExtensionSettings validates and saves the given form values of an
+// extension. Missing boolean fields are saved as false and empty secret fields
+// keep their value. Returns the list of changed fields, or nil and a table with
+// the error of every invalid field
+func SaveExtensionSettings(L *lua.LState) int {
+	// Get extension id
+	id := L.CheckString(2)
+
+	// Get form values
+	form := L.CheckTable(3)
+
+	manifest, err := util.LoadExtensionManifest(id)
+	if err != nil {
+		L.RaiseError("Cannot save %v settings: %v", id, err)
+		return 0
+	}
+
+	current, set, err := extensionConfigValues(manifest)
+	if err != nil {
+		L.RaiseError("Cannot save %v settings: %v", id, err)
+		return 0
+	}
+
+	values := map[string]interface{}{}
+	problems := L.NewTable()
+	invalid := false
+
+	for i := range manifest.Config {
+		field := &manifest.Config[i]
+		raw := form.RawGetString(field.Name)
+
+		// Keep saved secrets when the field is left empty
+		if field.Secret && set[field.Name] && (raw == lua.LNil || raw.String() == "") {
+			continue
+		}
+
+		if raw == lua.LNil {
+			raw = lua.LString("")
+		}
+
+		v, err := field.Parse(raw.String())
+		if err != nil {
+			problems.RawSetString(field.Name, lua.LString(err.Error()))
+			invalid = true
+			continue
+		}
+
+		if set[field.Name] && v == current[field.Name] {
+			continue
+		}
+
+		values[field.Name] = v
+	}
+
+	if invalid {
+		L.Push(lua.LNil)
+		L.Push(problems)
+		return 2
+	}
+
+	if err := runExtensionTransaction(L, func(tx *sqlx.Tx) error {
+		return saveExtensionConfig(tx, id, values)
+	}); err != nil {
+		L.RaiseError("Cannot save %v settings: %v", id, err)
+		return 0
+	}
+
+	// Changed field names
+	changed := L.NewTable()
+	for _, field := range manifest.Config {
+		if _, ok := values[field.Name]; ok {
+			changed.Append(lua.LString(field.Name))
+		}
+	}
+
+	L.Push(changed)
+
+	return 1
+}
+
+// extensionConfigValues returns the settings of the given extension and which
+// of them were saved. Saved values that are no longer valid use the default value
+func extensionConfigValues(manifest *util.ExtensionManifest) (map[string]interface{}, map[string]bool, error) {
+	rows := []extensionConfigRow{}
+
+	if err := database.DB.Select(&rows, "SELECT name, value FROM castro_extension_config WHERE extension_id = ?", manifest.ID); err != nil {
+		return nil, nil, err
+	}
+
+	values := map[string]interface{}{}
+	set := map[string]bool{}
+
+	for i := range manifest.Config {
+		values[manifest.Config[i].Name] = manifest.Config[i].DefaultValue()
+	}
+
+	for _, row := range rows {
+		field, ok := manifest.ConfigField(row.Name)
+		if !ok {
+			continue
+		}
+
+		var raw interface{}
+		if err := json.Unmarshal([]byte(row.Value), &raw); err != nil {
+			util.Logger.Logger.Errorf("Cannot decode %v setting %v: %v", manifest.ID, row.Name, err)
+			continue
+		}
+
+		v, err := field.Check(raw)
+		if err != nil {
+			util.Logger.Logger.Errorf("Invalid %v setting %v: %v", manifest.ID, row.Name, err)
+			continue
+		}
+
+		values[row.Name] = v
+		set[row.Name] = true
+	}
+
+	return values, set, nil
+}
+
+// saveExtensionConfig replaces the given settings of an extension
+func saveExtensionConfig(tx *sqlx.Tx, id string, values map[string]interface{}) error {
+	now := time.Now().Unix()
+
+	for name, value := range values {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("DELETE FROM castro_extension_config WHERE extension_id = ? AND name = ?", id, name); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("INSERT INTO castro_extension_config (extension_id, name, value, updated_at) VALUES (?, ?, ?, ?)", id, name, string(data), now); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeStaleExtensionConfig removes the saved settings that are no longer part
+// of the config schema of the given extension
+func removeStaleExtensionConfig(tx *sqlx.Tx, manifest *util.ExtensionManifest) error {
+	names := []string{}
+	if err := tx.Select(&names, "SELECT name FROM castro_extension_config WHERE extension_id = ?", manifest.ID); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if _, ok := manifest.ConfigField(name); ok {
+			continue
+		}
+
+		if _, err := tx.Exec("DELETE FROM castro_extension_config WHERE extension_id = ? AND name = ?", manifest.ID, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
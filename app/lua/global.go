@@ -25,7 +25,13 @@ func SetGlobalLuaValue(L *lua.LState) int {
 	key := L.ToString(2)
 
 	// Get content table
-	content := TableToMap(L.ToTable(3))
+	tbl := L.ToTable(3)
+
+	// Convert sequences to slices and any other table to map
+	var content interface{} = TableToMap(tbl)
+	if tbl != nil && IsSequence(tbl) {
+		content = TableToSlice(tbl)
+	}
 
 	// Create buffer
 	buff := bytes.NewBuffer([]byte{})
@@ -91,19 +97,25 @@ func GetGlobalLuaValue(L *lua.LState) int {
 		return 0
 	}
 
-	// Create buffer
-	buff := bytes.NewBuffer(b)
-
-	// Create decoder
-	decoder := gob.NewDecoder(buff)
-
 	// Decoded value placeholder
 	result := map[string]interface{}{}
 
-	// Decode value
-	if err := decoder.Decode(&result); err != nil {
-		L.RaiseError("Cannot decode lua table: %v", err)
-		return 0
+	// Decode value as map
+	if err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(&result); err != nil {
+
+		// Decoded sequence placeholder
+		sequence := []interface{}{}
+
+		// Decode value as slice
+		if err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(&sequence); err != nil {
+			L.RaiseError("Cannot decode lua table: %v", err)
+			return 0
+		}
+
+		// Push result as lua sequence
+		L.Push(SliceToTable(sequence))
+
+		return 1
 	}
 
 	// Push result as lua table
@@ -0,0 +1,495 @@
+package lua
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/raggaer/castro/app/models"
+	"github.com/raggaer/castro/app/util"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// HookField describes a field of a hook event payload
+type HookField struct {
+	Name string
+	Type glua.LValueType
+}
+
+// HookEvent describes an event that extensions can listen to
+type HookEvent struct {
+	Name        string
+	Cancellable bool
+	Fields      []HookField
+}
+
+// hookHandler is an extension handler compiled into a function proto
+type hookHandler struct {
+	Extension string
+	Event     string
+	Path      string
+	Priority  int
+	modTime   time.Time
+	proto     *glua.FunctionProto
+}
+
+// hookBus dispatches events to the extension hook handlers
+type hookBus struct {
+	rw       sync.RWMutex
+	events   map[string]*HookEvent
+	handlers map[string][]*hookHandler
+}
+
+// hookEventInstance is the value received by the handlers of a dispatched event
+type hookEventInstance struct {
+	Event     *HookEvent
+	Payload   *glua.LTable
+	Cancelled bool
+	Reason    string
+}
+
+// sandboxedHookEvent is the event received by sandboxed handlers. Payload
+// fields are read without their credentials
+type sandboxedHookEvent struct {
+	*hookEventInstance
+	sb *sandbox
+}
+
+var (
+	// Hooks is the application event bus for extension hooks
+	Hooks = &hookBus{
+		events:   map[string]*HookEvent{},
+		handlers: map[string][]*hookHandler{},
+	}
+
+	// hookEventMethods methods of the event received by the handlers
+	hookEventMethods = map[string]glua.LGFunction{
+		"cancel":      CancelHookEvent,
+		"isCancelled": IsHookEventCancelled,
+	}
+)
+
+func init() {
+	account := HookField{Name: "account", Type: glua.LTTable}
+
+	for _, event := range []*HookEvent{
+		{Name: "onStartup"},
+		{Name: "onNewArticle", Fields: []HookField{{Name: "article", Type: glua.LTTable}, account}},
+		{Name: "onEditArticle", Fields: []HookField{{Name: "article", Type: glua.LTTable}, account}},
+		{Name: "onLogin", Cancellable: true, Fields: []HookField{account}},
+		{Name: "onLogout", Fields: []HookField{account}},
+		{Name: "onRegister", Fields: []HookField{{Name: "id", Type: glua.LTNumber}, {Name: "name", Type: glua.LTString}, {Name: "email", Type: glua.LTString}}},
+		{Name: "onCharacterCreate", Fields: []HookField{account, {Name: "name", Type: glua.LTString}, {Name: "vocation", Type: glua.LTString}, {Name: "town", Type: glua.LTString}}},
+		{Name: "onCheckout", Cancellable: true, Fields: []HookField{account, {Name: "character", Type: glua.LTString}, {Name: "offers", Type: glua.LTTable}, {Name: "price", Type: glua.LTNumber}}},
+		{Name: "onPaymentReceived", Fields: []HookField{{Name: "provider", Type: glua.LTString}, {Name: "account", Type: glua.LTString}, {Name: "points", Type: glua.LTNumber}, {Name: "reference", Type: glua.LTString}}},
+		{Name: "onHouseBid", Cancellable: true, Fields: []HookField{account, {Name: "character", Type: glua.LTString}, {Name: "house", Type: glua.LTNumber}, {Name: "bid", Type: glua.LTNumber}}},
+	} {
+		Hooks.Register(event)
+	}
+}
+
+// Register adds an event that extensions can listen to
+func (h *hookBus) Register(event *HookEvent) {
+	h.rw.Lock()
+	defer h.rw.Unlock()
+
+	h.events[event.Name] = event
+}
+
+// Event returns the given event description
+func (h *hookBus) Event(name string) (*HookEvent, bool) {
+	h.rw.RLock()
+	defer h.rw.RUnlock()
+
+	event, ok := h.events[name]
+	return event, ok
+}
+
+// Events returns the name of all the registered events sorted by name
+func (h *hookBus) Events() []string {
+	h.rw.RLock()
+	defer h.rw.RUnlock()
+
+	names := []string{}
+	for name := range h.events {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Load compiles the handlers of all the enabled extension hooks. Handlers that
+// fail to compile or listen to unknown events are skipped
+func (h *hookBus) Load() error {
+	// Get enabled hooks
+	hooks, err := models.GetExtensionHooks()
+
+	if err != nil {
+		return err
+	}
+
+	handlers := map[string][]*hookHandler{}
+
+	for _, hook := range hooks {
+		if _, ok := h.Event(hook.Type); !ok {
+			util.Logger.Logger.Errorf("Extension %v listens to unknown hook %v", hook.Extension_id, hook.Type)
+			continue
+		}
+
+		handler := &hookHandler{
+			Extension: hook.Extension_id,
+			Event:     hook.Type,
+			Path:      filepath.Join("extensions", hook.Extension_id, hook.Script),
+			Priority:  hook.Priority,
+		}
+
+		if err := handler.compile(); err != nil {
+			util.Logger.Logger.Errorf("Cannot compile %v hook of extension %v: %v", hook.Type, hook.Extension_id, err)
+			continue
+		}
+
+		handlers[hook.Type] = append(handlers[hook.Type], handler)
+	}
+
+	// Higher priority handlers run first
+	for _, list := range handlers {
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Priority > list[j].Priority
+		})
+	}
+
+	h.rw.Lock()
+	defer h.rw.Unlock()
+
+	h.handlers = handlers
+
+	return nil
+}
+
+// Handlers returns the handlers of the given event in execution order
+func (h *hookBus) Handlers(event string) []*hookHandler {
+	h.rw.RLock()
+	defer h.rw.RUnlock()
+
+	return append([]*hookHandler{}, h.handlers[event]...)
+}
+
+// compile compiles the handler script
+func (h *hookHandler) compile() error {
+	info, err := os.Stat(h.Path)
+	if err != nil {
+		return err
+	}
+
+	proto, err := CompileLua(h.Path)
+	if err != nil {
+		return err
+	}
+
+	h.proto = proto
+	h.modTime = info.ModTime()
+
+	return nil
+}
+
+// Proto returns the compiled handler script. On development mode the script
+// is compiled again when the file changes
+func (h *hookHandler) Proto() (*glua.FunctionProto, error) {
+	if !util.Config.Configuration.IsDev() {
+		return h.proto, nil
+	}
+
+	Hooks.rw.Lock()
+	defer Hooks.rw.Unlock()
+
+	info, err := os.Stat(h.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.ModTime().Equal(h.modTime) {
+		if err := h.compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return h.proto, nil
+}
+
+// Dispatch runs the handlers of the given event on the given state. Every handler
+// script runs on its own environment so handlers do not overwrite each other globals.
+// Handlers that fail are logged and skipped
+func (h *hookBus) Dispatch(L *glua.LState, name string, payload *glua.LTable) (*hookEventInstance, map[string]glua.LValue, error) {
+	event, ok := h.Event(name)
+	if !ok {
+		return nil, nil, fmt.Errorf("Unknown hook event %v", name)
+	}
+
+	// Check payload field types
+	for _, field := range event.Fields {
+		if v := payload.RawGetString(field.Name); v.Type() != field.Type {
+			return nil, nil, fmt.Errorf("Invalid %v field of %v event. Expected %v got %v", field.Name, name, field.Type, v.Type())
+		}
+	}
+
+	instance := &hookEventInstance{
+		Event:   event,
+		Payload: payload,
+	}
+
+	results := map[string]glua.LValue{}
+
+	for _, handler := range h.Handlers(name) {
+		if instance.Cancelled {
+			break
+		}
+
+		ret, err := handler.call(L, instance)
+		if err != nil {
+			util.Logger.Logger.Errorf("Cannot run %v hook of extension %v: %v", name, handler.Extension, err)
+			continue
+		}
+
+		if ret != glua.LNil {
+			results[handler.Extension] = ret
+		}
+	}
+
+	return instance, results, nil
+}
+
+// call runs the handler script and calls its event function with the given event
+func (h *hookHandler) call(L *glua.LState, instance *hookEventInstance) (glua.LValue, error) {
+	proto, err := h.Proto()
+	if err != nil {
+		return glua.LNil, err
+	}
+
+	// Restrict sandboxed extensions to their capabilities
+	sb, err := Sandboxes.Get(h.Extension)
+	if err != nil {
+		return glua.LNil, err
+	}
+
+	// Create event user data
+	event := L.NewUserData()
+	event.Value = instance
+	L.SetMetatable(event, L.GetTypeMetatable(EventMetaTableName))
+
+	if sb != nil {
+		defer sb.enter(L)()
+		event.Value = &sandboxedHookEvent{hookEventInstance: instance, sb: sb}
+	}
+
+	// Run handler script
+	env, err := runIsolated(L, proto, sb)
+	if err != nil {
+		return glua.LNil, err
+	}
+
+	// Get handler function
+	fn, ok := env.RawGetString(h.Event).(*glua.LFunction)
+	if !ok {
+		return glua.LNil, fmt.Errorf("Function %v is missing on %v", h.Event, h.Path)
+	}
+
+	if err := L.CallByParam(glua.P{Fn: fn, NRet: 1, Protect: true}, event); err != nil {
+		return glua.LNil, err
+	}
+
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	return ret, nil
+}
+
+// runIsolated runs the given script on its own environment and returns it. The
+// environment falls back to the state globals or to the globals granted by the
+// given sandbox
+func runIsolated(L *glua.LState, proto *glua.FunctionProto, sb *sandbox) (*glua.LTable, error) {
+	globals := L.G.Global
+	if sb != nil {
+		globals = sb.env(L)
+	}
+
+	env := L.NewTable()
+	meta := L.NewTable()
+	meta.RawSetString("__index", globals)
+	L.SetMetatable(env, meta)
+
+	script := L.NewFunctionFromProto(proto)
+	script.Env = env
+
+	if err := L.CallByParam(glua.P{Fn: script, NRet: 0, Protect: true}); err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+// SetHooksMetaTable sets the hooks metatable of the given state
+func SetHooksMetaTable(luaState *glua.LState) {
+	// Create and set the hooks metatable
+	hooksMetaTable := luaState.NewTypeMetatable(HooksMetaTableName)
+	luaState.SetGlobal(HooksMetaTableName, hooksMetaTable)
+
+	// Set all hooks metatable functions
+	luaState.SetFuncs(hooksMetaTable, hooksMethods)
+
+	// Create the metatable of the dispatched events
+	eventMetaTable := luaState.NewTypeMetatable(EventMetaTableName)
+	luaState.SetField(eventMetaTable, "__index", luaState.NewFunction(getHookEventField))
+	luaState.SetField(eventMetaTable, "__newindex", luaState.NewFunction(setHookEventField))
+}
+
+// checkHookEvent returns the event instance of the first argument and the
+// sandbox of the handler that received it
+func checkHookEvent(L *glua.LState) (*hookEventInstance, *sandbox) {
+	data := L.CheckUserData(1)
+
+	switch instance := data.Value.(type) {
+	case *hookEventInstance:
+		return instance, nil
+	case *sandboxedHookEvent:
+		return instance.hookEventInstance, instance.sb
+	}
+
+	L.ArgError(1, "Invalid hook event")
+	return nil, nil
+}
+
+// getHookEventField returns an event method or a payload field
+func getHookEventField(L *glua.LState) int {
+	instance, sb := checkHookEvent(L)
+	key := L.CheckString(2)
+
+	if method, ok := hookEventMethods[key]; ok {
+		L.Push(L.NewFunction(method))
+		return 1
+	}
+
+	switch key {
+	case "name":
+		L.Push(glua.LString(instance.Event.Name))
+	case "cancellable":
+		L.Push(glua.LBool(instance.Event.Cancellable))
+	default:
+		value := instance.Payload.RawGetString(key)
+
+		// Sandboxed handlers do not get the account credentials
+		if sb != nil {
+			if sb.hidesField(key) {
+				value = glua.LNil
+			}
+			value = sb.hideCredentials(L, value)
+		}
+
+		L.Push(value)
+	}
+
+	return 1
+}
+
+// setHookEventField sets a payload field so the next handlers can see it
+func setHookEventField(L *glua.LState) int {
+	instance, _ := checkHookEvent(L)
+
+	instance.Payload.RawSetString(L.CheckString(2), L.Get(3))
+
+	return 0
+}
+
+// CancelHookEvent cancels the event so the remaining handlers do not run
+func CancelHookEvent(L *glua.LState) int {
+	instance, _ := checkHookEvent(L)
+
+	if !instance.Event.Cancellable {
+		L.RaiseError("Event %v cannot be cancelled", instance.Event.Name)
+		return 0
+	}
+
+	instance.Cancelled = true
+	instance.Reason = L.OptString(2, "")
+
+	return 0
+}
+
+// IsHookEventCancelled checks if a previous handler cancelled the event
+func IsHookEventCancelled(L *glua.LState) int {
+	instance, _ := checkHookEvent(L)
+
+	L.Push(glua.LBool(instance.Cancelled))
+
+	return 1
+}
+
+// DispatchHook runs the handlers of an event. Returns a table with the cancel status
+// and the value returned by every extension handler
+func DispatchHook(L *glua.LState) int {
+	// Get event name
+	name := L.CheckString(2)
+
+	// Get optional payload
+	payload := L.OptTable(3, L.NewTable())
+
+	instance, results, err := Hooks.Dispatch(L, name, payload)
+	if err != nil {
+		L.RaiseError("Cannot dispatch hook: %v", err)
+		return 0
+	}
+
+	// Data holder
+	t := L.NewTable()
+	t.RawSetString("cancelled", glua.LBool(instance.Cancelled))
+	t.RawSetString("reason", glua.LString(instance.Reason))
+	t.RawSetString("data", instance.Payload)
+
+	resultTable := L.NewTable()
+	for extension, value := range results {
+		resultTable.RawSetString(extension, value)
+	}
+	t.RawSetString("results", resultTable)
+
+	L.Push(t)
+
+	return 1
+}
+
+// GetHookList returns the name of all the events
+func GetHookList(L *glua.LState) int {
+	L.Push(StringSliceToTable(Hooks.Events()))
+
+	return 1
+}
+
+// GetHookFields returns the payload field names of the given event in order
+func GetHookFields(L *glua.LState) int {
+	event, ok := Hooks.Event(L.CheckString(2))
+	if !ok {
+		L.ArgError(1, "Unknown hook event")
+		return 0
+	}
+
+	fields := []string{}
+	for _, field := range event.Fields {
+		fields = append(fields, field.Name)
+	}
+
+	L.Push(StringSliceToTable(fields))
+
+	return 1
+}
+
+// ReloadHooks compiles the extension hook handlers again
+func ReloadHooks(L *glua.LState) int {
+	if err := Hooks.Load(); err != nil {
+		L.RaiseError("Cannot reload extension hooks: %v", err)
+	}
+
+	return 0
+}
@@ -0,0 +1,150 @@
+package lua
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raggaer/castro/app/util"
+	"github.com/sirupsen/logrus"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// setTestConfig sets an empty configuration and logger
+func setTestConfig() {
+	util.Config = &util.ConfigurationFile{Configuration: &util.Configuration{}}
+	util.Logger.Logger = logrus.New()
+}
+
+// testHookHandler compiles the given script on the given folder as a handler
+// of the given extension
+func testHookHandler(t *testing.T, dir, extension, event, script string) *hookHandler {
+	path := filepath.Join(dir, "handler.lua")
+	if err := ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := &hookHandler{Extension: extension, Event: event, Path: path}
+	if err := handler.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	return handler
+}
+
+func TestHookHandlerCredentials(t *testing.T) {
+	setTestConfig()
+
+	script := `
+	function onLogin(event)
+		return {
+			password = event.account.Password,
+			secret = event.account.Secret,
+			email = event.account.Email,
+			name = event.account.Name,
+			nested = event.account.Nested.Password,
+		}
+	end
+	`
+
+	dir, err := ioutil.TempDir("", "castro-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler := testHookHandler(t, dir, "hooktest", "onLogin", script)
+
+	tests := []struct {
+		name         string
+		capabilities []string
+		sandboxed    bool
+		credentials  bool
+		email        bool
+	}{
+		{name: "unrestricted", credentials: true, email: true},
+		{name: "sandboxed", sandboxed: true},
+		{name: "db.read", capabilities: []string{"db.read"}, sandboxed: true, email: true},
+	}
+
+	for _, test := range tests {
+		var sb *sandbox
+		if test.sandboxed {
+			var err error
+			if sb, err = newSandbox("hooktest", test.capabilities); err != nil {
+				t.Fatal(err)
+			}
+		}
+		Sandboxes.sandboxes["hooktest"] = sb
+
+		L := NewState()
+
+		account := L.NewTable()
+		account.RawSetString("Name", glua.LString("admin"))
+		account.RawSetString("Password", glua.LString("hash"))
+		account.RawSetString("Secret", glua.LString("seed"))
+		account.RawSetString("Email", glua.LString("admin@example.com"))
+		nested := L.NewTable()
+		nested.RawSetString("Password", glua.LString("hash"))
+		account.RawSetString("Nested", nested)
+
+		payload := L.NewTable()
+		payload.RawSetString("account", account)
+
+		event, _ := Hooks.Event("onLogin")
+		ret, err := handler.call(L, &hookEventInstance{Event: event, Payload: payload})
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+			L.Close()
+			continue
+		}
+
+		result := ret.(*glua.LTable)
+
+		if result.RawGetString("name").String() != "admin" {
+			t.Errorf("%v: account name is %v", test.name, result.RawGetString("name"))
+		}
+
+		for _, field := range []string{"password", "secret", "nested"} {
+			if hidden := result.RawGetString(field) == glua.LNil; hidden == test.credentials {
+				t.Errorf("%v: %v hidden = %v", test.name, field, hidden)
+			}
+		}
+
+		if hidden := result.RawGetString("email") == glua.LNil; hidden == test.email {
+			t.Errorf("%v: email hidden = %v", test.name, hidden)
+		}
+
+		// The payload of the next handlers is left untouched
+		if account.RawGetString("Password") == glua.LNil {
+			t.Errorf("%v: payload account changed", test.name)
+		}
+
+		L.Close()
+	}
+
+	delete(Sandboxes.sandboxes, "hooktest")
+}
+
+func TestSandboxHooksDispatch(t *testing.T) {
+	setTestConfig()
+
+	sb, err := newSandbox("hooktest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	L := NewState()
+	defer L.Close()
+
+	sb.apply(L)
+
+	if err := L.DoString(`return hooks.dispatch`); err != nil {
+		t.Fatal(err)
+	}
+
+	if fn := L.Get(-1); fn != glua.LNil {
+		t.Errorf("hooks:dispatch is available to sandboxed extensions: %v", fn)
+	}
+}
@@ -3,7 +3,6 @@ package lua
 import (
 	"bytes"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"net/url"
 	"time"
@@ -20,6 +19,11 @@ func SetHTTPMetaTable(luaState *glua.LState) {
 
 	// Set all HTTP metatable functions
 	luaState.SetFuncs(httpMetaTable, httpMethods)
+
+	// Restrict the functions of sandboxed extensions
+	if sb := stateSandbox(luaState); sb != nil {
+		sb.restrictHTTP(luaState, httpMetaTable)
+	}
 }
 
 // SetRegularHTTPMetaTable sets the event http metatable removing some http methods
@@ -111,6 +115,7 @@ func SetCookie(L *glua.LState) int {
 		Expires:  time.Unix(L.ToInt64(4), 0),
 		Secure:   util.Config.Configuration.IsSSL(),
 		HttpOnly: true,
+		SameSite: util.Config.Configuration.Cookies.SameSiteMode(),
 	}
 
 	// Set HTTP cookie
@@ -222,7 +227,7 @@ func RenderTemplate(L *glua.LState) int {
 	req, w := getRequestAndResponseWriter(L)
 
 	// Get session
-	session := getSessionData(L)
+	session := getSession(L)
 
 	templateName := L.ToString(2)
 
@@ -438,25 +443,61 @@ func GetHeader(L *glua.LState) int {
 	return 1
 }
 
-// GetRemoteAddress returns the request remote address
+// GetRemoteAddress returns the request client address
 func GetRemoteAddress(L *glua.LState) int {
 	// Get request
 	req, _ := getRequestAndResponseWriter(L)
 
-	// Get and split address
-	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	// Push client address. Trusted proxies are resolved to the real client
+	L.Push(glua.LString(util.ClientIP(req)))
 
-	if err != nil {
-		L.RaiseError("Cannot split host and port: %v", err)
-		return 0
+	return 1
+}
+
+// getContentSecurityPolicy returns the policy of the current response
+func getContentSecurityPolicy(L *glua.LState) *util.ContentSecurityPolicy {
+	// Get request
+	req, _ := getRequestAndResponseWriter(L)
+
+	// Get policy
+	csp, ok := req.Context().Value("csp").(*util.ContentSecurityPolicy)
+
+	if !ok {
+		L.RaiseError("Cannot get content security policy")
+		return nil
 	}
 
-	// Push remote address
-	L.Push(glua.LString(host))
+	return csp
+}
+
+// GetNonce returns the script nonce of the current response
+func GetNonce(L *glua.LState) int {
+	// Get policy
+	csp := getContentSecurityPolicy(L)
+
+	// Push nonce
+	L.Push(glua.LString(csp.Nonce))
 
 	return 1
 }
 
+// AllowSource adds a source to the Content-Security-Policy of the current response
+func AllowSource(L *glua.LState) int {
+	// Get directive and source
+	directive := L.CheckString(2)
+	source := L.CheckString(3)
+
+	// Get policy
+	csp := getContentSecurityPolicy(L)
+
+	// Add source
+	if err := csp.Allow(directive, source); err != nil {
+		L.RaiseError("Cannot allow source: %v", err)
+	}
+
+	return 0
+}
+
 // CreateRequestClient creates a HTTP client
 func CreateRequestClient(L *glua.LState) int {
 	// Get data table
@@ -1,12 +1,21 @@
 package lua
 
 import (
+	"encoding/json"
 	"io/ioutil"
+	"sync"
 
-	"github.com/clbanning/mxj"
+	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
 )
 
+// jsonArrayField field that held top-level JSON arrays before they were
+// unmarshaled as sequences
+const jsonArrayField = "object"
+
+// jsonArrayFieldWarning logs the deprecated field warning only once
+var jsonArrayFieldWarning sync.Once
+
 // SetJSONMetaTable sets the json metatable of the given state
 func SetJSONMetaTable(luaState *lua.LState) {
 	// Create and set the json metatable
@@ -28,11 +37,8 @@ func MarshalJSON(L *lua.LState) int {
 		return 0
 	}
 
-	// Convert table to map
-	r := mxj.Map(TableToMap(L.ToTable(2)))
-
-	// Marshal converted table
-	buff, err := r.Json()
+	// Marshal converted table. Sequences are marshaled as arrays
+	buff, err := json.Marshal(ValueToGo(tbl))
 
 	if err != nil {
 		L.RaiseError("Cannot marshal the given table: %v", err)
@@ -56,16 +62,17 @@ func UnmarshalJSON(L *lua.LState) int {
 		return 0
 	}
 
-	// Unmarshal string
-	result, err := mxj.NewMapJson([]byte(src.String()))
+	// Result placeholder
+	var result interface{}
 
-	if err != nil {
+	// Unmarshal string
+	if err := json.Unmarshal([]byte(src.String()), &result); err != nil {
 		L.RaiseError("Cannot unmarshal the given string: %v", err)
 		return 0
 	}
 
 	// Push result as table
-	L.Push(MapToTable(result))
+	L.Push(unmarshaledToValue(L, result))
 
 	return 1
 }
@@ -84,16 +91,55 @@ func UnmarshalJSONFile(L *lua.LState) int {
 	// Read whole file
 	file, err := ioutil.ReadFile(src.String())
 
-	// Unmarshal string
-	result, err := mxj.NewMapJson(file)
-
 	if err != nil {
+		L.RaiseError("Cannot read the given file: %v", err)
+		return 0
+	}
+
+	// Result placeholder
+	var result interface{}
+
+	// Unmarshal file contents
+	if err := json.Unmarshal(file, &result); err != nil {
 		L.RaiseError("Cannot unmarshal the given file: %v", err)
 		return 0
 	}
 
 	// Push result as table
-	L.Push(MapToTable(result))
+	L.Push(unmarshaledToValue(L, result))
+
+	return 1
+}
+
+// unmarshaledToValue converts an unmarshaled JSON value to a lua value. Top-level
+// arrays used to be wrapped in an object field, the field is still resolved to
+// the array itself so old scripts keep working
+func unmarshaledToValue(L *lua.LState, result interface{}) lua.LValue {
+	value := GoToValue(result)
+
+	if _, ok := result.([]interface{}); !ok {
+		return value
+	}
+
+	meta := L.NewTable()
+	meta.RawSetString("__index", L.NewFunction(jsonArrayIndex))
+	L.SetMetatable(value, meta)
+
+	return value
+}
+
+// jsonArrayIndex resolves the deprecated object field of unmarshaled arrays
+func jsonArrayIndex(L *lua.LState) int {
+	if key, ok := L.Get(2).(lua.LString); !ok || string(key) != jsonArrayField {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	jsonArrayFieldWarning.Do(func() {
+		util.Logger.Logger.Warnf("%v The object field of unmarshaled JSON arrays is deprecated, use the returned table instead", L.Where(1))
+	})
+
+	L.Push(L.CheckTable(1))
 
 	return 1
 }
@@ -0,0 +1,133 @@
+package lua
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/raggaer/castro/app/util"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// SetLoginMetaTable sets the login metatable on the given lua state
+func SetLoginMetaTable(luaState *lua.LState) {
+	// Create and set login metatable
+	loginMetaTable := luaState.NewTypeMetatable(LoginMetaTableName)
+	luaState.SetGlobal(LoginMetaTableName, loginMetaTable)
+
+	// Set all login metatable functions
+	luaState.SetFuncs(loginMetaTable, loginMethods)
+}
+
+// loginArguments returns the account name and client address arguments. The
+// address defaults to the address of the current request
+func loginArguments(L *lua.LState) (string, string) {
+	account := L.OptString(2, "")
+
+	if ip := L.OptString(3, ""); ip != "" {
+		return account, ip
+	}
+
+	if req, ok := stateUserData(L, HTTPMetaTableName, HTTPRequestName).(*http.Request); ok {
+		return account, util.ClientIP(req)
+	}
+
+	return account, ""
+}
+
+// loginStatusToTable converts a failed login status to a lua table
+func loginStatusToTable(status *util.LoginStatus) *lua.LTable {
+	t := &lua.LTable{}
+
+	t.RawSetString("failures", lua.LNumber(status.Failures))
+	t.RawSetString("captcha", lua.LBool(status.Captcha))
+	t.RawSetString("locked", lua.LBool(status.Locked))
+	t.RawSetString("retryAfter", lua.LNumber(math.Ceil(status.RetryAfter.Seconds())))
+
+	if status.Locked {
+		t.RawSetString("lockedUntil", lua.LNumber(status.LockedUntil.Unix()))
+	}
+
+	if status.UnlockToken != "" {
+		t.RawSetString("unlockToken", lua.LString(status.UnlockToken))
+	}
+
+	return t
+}
+
+// CheckLogin returns the failed login status of an account and client address
+func CheckLogin(L *lua.LState) int {
+	// Get status
+	status, err := util.CheckLogin(loginArguments(L))
+
+	if err != nil {
+		L.RaiseError("Cannot check failed logins: %v", err)
+		return 0
+	}
+
+	L.Push(loginStatusToTable(status))
+
+	return 1
+}
+
+// AttemptLogin checks the failed login status of an account and client address
+// and counts the attempt in the same step
+func AttemptLogin(L *lua.LState) int {
+	// Check and count attempt
+	status, err := util.AttemptLogin(loginArguments(L))
+
+	if err != nil {
+		L.RaiseError("Cannot count login attempt: %v", err)
+		return 0
+	}
+
+	t := loginStatusToTable(status)
+	t.RawSetString("allowed", lua.LBool(status.Allowed))
+
+	L.Push(t)
+
+	return 1
+}
+
+// FailLogin records a failed login of an account and client address
+func FailLogin(L *lua.LState) int {
+	// Record failure
+	status, err := util.FailLogin(loginArguments(L))
+
+	if err != nil {
+		L.RaiseError("Cannot record failed login: %v", err)
+		return 0
+	}
+
+	L.Push(loginStatusToTable(status))
+
+	return 1
+}
+
+// ResetLogin removes the failed logins of an account
+func ResetLogin(L *lua.LState) int {
+	if err := util.ResetLogin(L.CheckString(2)); err != nil {
+		L.RaiseError("Cannot reset failed logins: %v", err)
+	}
+
+	return 0
+}
+
+// UnlockLogin unlocks the account of the given unlock token
+func UnlockLogin(L *lua.LState) int {
+	// Unlock account
+	account, err := util.UnlockLogin(L.CheckString(2))
+
+	if err != nil {
+		L.RaiseError("Cannot unlock account: %v", err)
+		return 0
+	}
+
+	if account == "" {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	L.Push(lua.LString(account))
+
+	return 1
+}
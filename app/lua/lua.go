@@ -2,6 +2,7 @@ package lua
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -18,14 +19,17 @@ import (
 
 // luaStatePool struct used for lua state pooling
 type luaStatePool struct {
-	m     sync.Mutex
-	saved []*glua.LState
+	m         sync.Mutex
+	saved     []*glua.LState
+	snapshots map[*glua.LState]*stateSnapshot
+	sandbox   *sandbox
 }
 
 var (
 	// Pool saves all lua state pointers to create a sync.Pool
 	Pool = &luaStatePool{
-		saved: make([]*glua.LState, 0, 10),
+		saved:     make([]*glua.LState, 0, 10),
+		snapshots: map[*glua.LState]*stateSnapshot{},
 	}
 
 	globalFuncList = map[string]func(l *glua.LState) int{
@@ -36,13 +40,15 @@ var (
 		"ternary": Ternary,
 	}
 	cryptoMethods = map[string]glua.LGFunction{
-		"sha1":         Sha1Hash,
-		"sha256":       Sha256Hash,
-		"hmacsha256":   HmacSha256,
-		"md5":          Md5Hash,
-		"randomString": RandomString,
-		"qr":           GenerateQRCode,
-		"qrKey":        GenerateAuthSecretKey,
+		"sha1":           Sha1Hash,
+		"sha256":         Sha256Hash,
+		"hmacsha256":     HmacSha256,
+		"md5":            Md5Hash,
+		"hashPassword":   HashPassword,
+		"verifyPassword": VerifyPassword,
+		"randomString":   RandomString,
+		"qr":             GenerateQRCode,
+		"qrKey":          GenerateAuthSecretKey,
 	}
 	base64Methods = map[string]glua.LGFunction{
 		"encode": Base64Encode,
@@ -52,6 +58,11 @@ var (
 		"query":       Query,
 		"execute":     Execute,
 		"singleQuery": SingleQuery,
+		"dropColumn":  DropColumn,
+		"transaction": Transaction,
+		"begin":       BeginTransaction,
+		"commit":      CommitTransaction,
+		"rollback":    RollbackTransaction,
 	}
 	configMethods = map[string]glua.LGFunction{
 		"get":       GetConfigLuaValue,
@@ -69,6 +80,8 @@ var (
 		"postForm":           PostFormRequest,
 		"getHeader":          GetHeader,
 		"getRemoteAddress":   GetRemoteAddress,
+		"nonce":              GetNonce,
+		"allowSource":        AllowSource,
 		"curl":               CreateRequestClient,
 		"formFile":           GetFormFile,
 		"parseMultiPartForm": ParseMultiPartForm,
@@ -94,16 +107,23 @@ var (
 	sessionMethods = map[string]glua.LGFunction{
 		"isLogged":      IsLogged,
 		"isAdmin":       IsAdmin,
+		"can":           Can,
+		"permissions":   GetPermissions,
 		"getFlash":      GetFlash,
 		"setFlash":      SetFlash,
 		"set":           SetSessionData,
 		"get":           GetSessionData,
 		"destroy":       DestroySession,
 		"loggedAccount": GetLoggedAccount,
+		"list":          ListSessions,
+		"revoke":        RevokeSession,
+		"revokeOthers":  RevokeOtherSessions,
+		"revokeAccount": RevokeAccountSessions,
 	}
 	captchaMethods = map[string]glua.LGFunction{
 		"isEnabled": IsEnabled,
 		"verify":    VerifyCaptcha,
+		"key":       GetCaptchaKey,
 	}
 	mapMethods = map[string]glua.LGFunction{
 		"houseList":  HouseList,
@@ -187,6 +207,12 @@ var (
 	eventsMethods = map[string]glua.LGFunction{
 		"new": BackgroundEvent,
 	}
+	hooksMethods = map[string]glua.LGFunction{
+		"dispatch": DispatchHook,
+		"list":     GetHookList,
+		"fields":   GetHookFields,
+		"reload":   ReloadHooks,
+	}
 	paypalMethods = map[string]glua.LGFunction{
 		"createPayment":      CreatePaypalPayment,
 		"paymentInformation": GetPaypalPayment,
@@ -209,6 +235,41 @@ var (
 		"createDirectory": CreateDirectory,
 		"unzip":           UnzipFile,
 	}
+	rolesMethods = map[string]glua.LGFunction{
+		"list":        GetRoleList,
+		"permissions": GetPermissionList,
+		"account":     GetAccountRoles,
+		"save":        SaveRole,
+		"delete":      DeleteRole,
+		"assign":      AssignRole,
+		"remove":      RemoveRole,
+	}
+	twofaMethods = map[string]glua.LGFunction{
+		"generateCodes":  GenerateRecoveryCodes,
+		"useCode":        UseRecoveryCode,
+		"remainingCodes": RemainingRecoveryCodes,
+		"disable":        DisableTwoFactor,
+		"required":       TwoFactorRequired,
+	}
+	tokenMethods = map[string]glua.LGFunction{
+		"issue":   IssueToken,
+		"verify":  VerifyToken,
+		"consume": ConsumeToken,
+		"revoke":  RevokeTokens,
+	}
+	loginMethods = map[string]glua.LGFunction{
+		"check":   CheckLogin,
+		"attempt": AttemptLogin,
+		"fail":    FailLogin,
+		"reset":   ResetLogin,
+		"unlock":  UnlockLogin,
+	}
+	auditMethods = map[string]glua.LGFunction{
+		"log":    WriteAuditEntry,
+		"list":   GetAuditLog,
+		"count":  CountAuditLog,
+		"export": ExportAuditLog,
+	}
 	envMethods = map[string]glua.LGFunction{
 		"set": SetEnvVariable,
 		"get": GetEnvVariable,
@@ -235,9 +296,6 @@ var (
 	outfitMethods = map[string]glua.LGFunction{
 		"generate": GenerateOutfit,
 	}
-	extensionMethods = map[string]glua.LGFunction{
-		"reload": ReloadExtensions,
-	}
 	i18nMethods = map[string]glua.LGFunction{
 		"get": GetLanguageIndex,
 	}
@@ -316,12 +374,12 @@ func OverwriteConfigFile() error {
 
 // Get retrieves a lua state from the pool if no states are available we create one
 func (p *luaStatePool) Get() *glua.LState {
-	// Lock and unlock our mutex to prevent data race
+	// Lock our mutex to prevent data race
 	p.m.Lock()
-	defer p.m.Unlock()
 
 	// If no states available create one
 	if (len(p.saved)) == 0 {
+		p.m.Unlock()
 		return p.New()
 	}
 
@@ -329,6 +387,8 @@ func (p *luaStatePool) Get() *glua.LState {
 	x := p.saved[len(p.saved)-1]
 	p.saved = p.saved[0 : len(p.saved)-1]
 
+	p.m.Unlock()
+
 	return x
 }
 
@@ -364,6 +424,9 @@ func GetApplicationState(luaState *glua.LState) {
 	// Create events metatable
 	SetEventsMetaTable(luaState)
 
+	// Create hooks metatable
+	SetHooksMetaTable(luaState)
+
 	// Create storage metatable
 	SetStorageMetaTable(luaState)
 
@@ -394,6 +457,21 @@ func GetApplicationState(luaState *glua.LState) {
 	// Create session metatable
 	SetSessionMetaTable(luaState)
 
+	// Create roles metatable
+	SetRolesMetaTable(luaState)
+
+	// Create audit metatable
+	SetAuditMetaTable(luaState)
+
+	// Create login metatable
+	SetLoginMetaTable(luaState)
+
+	// Create two-factor metatable
+	SetTwoFactorMetaTable(luaState)
+
+	// Create token metatable
+	SetTokenMetaTable(luaState)
+
 	// Create database metatable
 	SetDatabaseMetaTable(luaState)
 
@@ -415,6 +493,9 @@ func GetApplicationState(luaState *glua.LState) {
 	// Create json metatable
 	SetJSONMetaTable(luaState)
 
+	// Create extension metatable
+	SetExtensionMetaTable(luaState)
+
 	// Loop global functions map
 	for funcName, luaFunc := range globalFuncList {
 
@@ -518,6 +599,17 @@ func SetConfigGlobal(L *glua.LState) {
 	// Set SSL value
 	L.SetField(tbl, "SSL", StructToTable(&util.Config.Configuration.SSL))
 
+	// Set Login value
+	L.SetField(tbl, "Login", StructToTable(&util.Config.Configuration.Login))
+
+	// Set TwoFactor value
+	L.SetField(tbl, "TwoFactor", StructToTable(&util.Config.Configuration.TwoFactor))
+
+	// Set EmailVerification value
+	emailVerification := StructToTable(&util.Config.Configuration.EmailVerification)
+	emailVerification.RawSetString("Expire", glua.LString(util.Config.Configuration.EmailVerification.Expire.String))
+	L.SetField(tbl, "EmailVerification", emailVerification)
+
 	// Set global value
 	L.SetGlobal("app", tbl)
 
@@ -537,6 +629,26 @@ func (p *luaStatePool) Put(state *glua.LState) {
 	p.m.Lock()
 	defer p.m.Unlock()
 
+	// Revert unfinished transactions
+	rollbackStateTransaction(state)
+
+	// Remove state execution context
+	state.RemoveContext()
+
+	// Get state snapshot
+	snapshot, ok := p.snapshots[state]
+
+	// Close states that do not belong to the pool, that exceed the pool size or
+	// that share their globals with a running background event
+	if !ok || hasBackgroundEvents(state) || (util.Config.Configuration.Lua.PoolSize > 0 && len(p.saved) >= util.Config.Configuration.Lua.PoolSize) {
+		delete(p.snapshots, state)
+		state.Close()
+		return
+	}
+
+	// Reset state globals and metatables
+	snapshot.restore(state)
+
 	// Append to the pool
 	p.saved = append(p.saved, state)
 }
@@ -544,27 +656,58 @@ func (p *luaStatePool) Put(state *glua.LState) {
 // New creates and returns a lua state
 func (p *luaStatePool) New() *glua.LState {
 	// Create a new lua state
-	state := glua.NewState(
-		glua.Options{
-			IncludeGoStackTrace: util.Config.Configuration.IsDev(),
-		},
-	)
+	state := NewState()
 
-	// Set castro metatables
-	GetApplicationState(state)
+	// Restrict extension states
+	if p.sandbox != nil {
+		p.sandbox.apply(state)
+	}
+
+	// Save the state initial globals
+	snapshot := newStateSnapshot(state)
+
+	// Lock and unlock our mutex to prevent data race
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	// Save state snapshot
+	p.snapshots[state] = snapshot
 
 	// Return the lua state
 	return state
 }
 
+// Clear closes all the saved states. States that are in use will be closed when they are returned to the pool
+func (p *luaStatePool) Clear() {
+	// Lock and unlock our mutex to prevent data race
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	// Close all saved states
+	for _, state := range p.saved {
+		state.Close()
+	}
+
+	// Reset pool
+	p.saved = make([]*glua.LState, 0, 10)
+	p.snapshots = map[*glua.LState]*stateSnapshot{}
+}
+
+// stateOptions returns the lua state options from the configuration file
+func stateOptions() glua.Options {
+	return glua.Options{
+		IncludeGoStackTrace: util.Config.Configuration.IsDev(),
+		CallStackSize:       util.Config.Configuration.Lua.CallStackSize,
+		RegistrySize:        util.Config.Configuration.Lua.RegistrySize,
+		RegistryMaxSize:     util.Config.Configuration.Lua.RegistryMaxSize,
+		MinimizeStackMemory: util.Config.Configuration.Lua.MinimizeStackMemory,
+	}
+}
+
 // NewState creates and returns a new lua state
 func NewState() *glua.LState {
 	// Create a new lua state
-	state := glua.NewState(
-		glua.Options{
-			IncludeGoStackTrace: util.Config.Configuration.IsDev(),
-		},
-	)
+	state := glua.NewState(stateOptions())
 
 	// Set castro metatables
 	GetApplicationState(state)
@@ -583,8 +726,12 @@ func ExecuteFile(luaState *glua.LState, path string) error {
 	return nil
 }
 
-// ExecuteControllerPage executes the given subtopic using call by param
-func ExecuteControllerPage(luaState *glua.LState, method string) error {
+// ExecuteControllerPage executes the given subtopic using call by param. The execution
+// is interrupted once the given context is done
+func ExecuteControllerPage(ctx context.Context, luaState *glua.LState, method string) error {
+	// Set state execution context
+	luaState.SetContext(ctx)
+
 	// Call file function
 	if err := luaState.CallByParam(
 		glua.P{
@@ -0,0 +1,160 @@
+package lua
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raggaer/castro/app/database"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// testPool returns an empty state pool
+func testPool() *luaStatePool {
+	return &luaStatePool{
+		saved:     make([]*glua.LState, 0, 10),
+		snapshots: map[*glua.LState]*stateSnapshot{},
+	}
+}
+
+// setTestDatabase opens a SQLite database on the given folder as the
+// application database. Returns a function that restores the previous one
+func setTestDatabase(t *testing.T, dir string) func() {
+	db, err := database.OpenSQLite(filepath.Join(dir, "castro.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := database.DB
+	database.DB = db
+
+	return func() {
+		db.Close()
+		database.DB = previous
+	}
+}
+
+func TestPoolPutRestoresState(t *testing.T) {
+	setTestConfig()
+
+	p := testPool()
+	defer p.Clear()
+
+	state := p.Get()
+
+	if err := state.DoString(`
+	leaked = "value"
+	string.leaked = "value"
+	cache.leaked = "value"
+	app.Version = "changed"
+	setmetatable(app, {__index = function() return "leaked" end})
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	state.G.Registry.RawSetString("leaked", glua.LString("value"))
+	state.Push(glua.LString("stack"))
+
+	p.Put(state)
+
+	if reused := p.Get(); reused != state {
+		t.Fatal("expected the returned state to be reused")
+	}
+
+	if err := state.DoString(`
+	return leaked, string.leaked, cache.leaked, app.Version, app.missing
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	leaks := map[int]string{1: "global", 2: "library field", 3: "metatable field", 5: "added metatable"}
+	for i, name := range leaks {
+		if v := state.Get(i); v != glua.LNil {
+			t.Errorf("%v leaked into the next request: %v", name, v)
+		}
+	}
+
+	if v := state.Get(4); v.String() == "changed" {
+		t.Error("app table change leaked into the next request")
+	}
+
+	if v := state.G.Registry.RawGetString("leaked"); v != glua.LNil {
+		t.Errorf("registry change leaked into the next request: %v", v)
+	}
+
+	if top := state.GetTop(); top != 5 {
+		t.Errorf("stack was not cleaned, %d values left", top-5)
+	}
+}
+
+func TestPoolPutClosesEventStates(t *testing.T) {
+	setTestConfig()
+
+	p := testPool()
+	defer p.Clear()
+
+	state := p.Get()
+
+	if err := state.DoString(`events:new(function() end)`); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Put(state)
+
+	if len(p.saved) != 0 {
+		t.Error("state running a background event was saved on the pool")
+	}
+
+	// States created outside the pool are closed too
+	p.Put(NewState())
+
+	if len(p.saved) != 0 {
+		t.Error("state created outside the pool was saved on the pool")
+	}
+}
+
+func TestPoolPutRollsBackTransaction(t *testing.T) {
+	setTestConfig()
+
+	dir, err := ioutil.TempDir("", "castro-pool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer setTestDatabase(t, dir)()
+
+	db := database.DB
+
+	if _, err := db.Exec("CREATE TABLE pool_test (id INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := testPool()
+	defer p.Clear()
+
+	state := p.Get()
+
+	if err := state.DoString(`
+	db:begin()
+	db:execute("INSERT INTO pool_test (id) VALUES (?)", 1)
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Put(state)
+
+	count := 0
+	if err := db.Get(&count, "SELECT COUNT(*) FROM pool_test"); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 0 {
+		t.Errorf("unfinished transaction was committed, %d rows", count)
+	}
+
+	if stateTransaction(state) != nil {
+		t.Error("transaction was left on the pooled state")
+	}
+}
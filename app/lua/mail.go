@@ -3,7 +3,6 @@ package lua
 import (
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
-	"gopkg.in/gomail.v2"
 )
 
 // SetMailMetaTable sets the mail metatable of the given state
@@ -16,7 +15,8 @@ func SetMailMetaTable(luaState *lua.LState) {
 	luaState.SetFuncs(mailMetaTable, mailMethods)
 }
 
-// SendMail sends a mail to the given direction
+// SendMail queues a mail to the given direction. The body can be given as
+// html and plain text strings or rendered from a mail template
 func SendMail(L *lua.LState) int {
 	// Get information table
 	tbl := L.Get(2)
@@ -39,48 +39,44 @@ func SendMail(L *lua.LState) int {
 		return 0
 	}
 
-	// Get subject
-	subject, ok := info["subject"].(string)
+	// Get optional fields
+	subject, _ := info["subject"].(string)
+	html, _ := info["body"].(string)
+	text, _ := info["text"].(string)
+	lang, _ := info["lang"].(string)
+
+	// Render mail template
+	if name, ok := info["template"].(string); ok {
+		data, ok := info["data"].(map[string]interface{})
+		if !ok {
+			data = map[string]interface{}{}
+		}
+
+		var err error
+		if html, text, err = util.RenderMail(name, lang, data); err != nil {
+			L.RaiseError("Cannot render mail template: %v", err)
+			return 0
+		}
+
+		// Use the subject of the language file
+		if subject == "" {
+			subject, _ = util.MailSubject(name, lang)
+		}
+	}
 
-	if !ok {
+	if subject == "" {
 		L.ArgError(1, "Missing 'subject' table field")
 		return 0
 	}
 
-	// Get email body
-	body, ok := info["body"].(string)
-
-	if !ok {
+	if html == "" && text == "" {
 		L.ArgError(1, "Missing 'body' table field")
 		return 0
 	}
 
-	// Create new gomail object
-	m := gomail.NewMessage()
-
-	// Set from header
-	m.SetHeader("From", util.Config.Configuration.Mail.Username)
-
-	// Set to header
-	m.SetHeader("To", to)
-
-	// Set subject
-	m.SetHeader("Subject", subject)
-
-	// Set body
-	m.SetBody("text/html", body)
-
-	// Create dialer
-	d := gomail.NewPlainDialer(
-		util.Config.Configuration.Mail.Server,
-		util.Config.Configuration.Mail.Port,
-		util.Config.Configuration.Mail.Username,
-		util.Config.Configuration.Mail.Password,
-	)
-
-	// Send email
-	if err := d.DialAndSend(m); err != nil {
-		L.RaiseError("Cannot send email: %v", err)
+	// Queue email
+	if err := util.QueueMail(to, subject, html, text); err != nil {
+		L.RaiseError("Cannot queue email: %v", err)
 		return 0
 	}
 
@@ -0,0 +1,533 @@
+package lua
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/raggaer/castro/app/database"
+	"github.com/raggaer/castro/app/util"
+	glua "github.com/yuin/gopher-lua"
+)
+
+// Migration holds a numbered migration file
+type Migration struct {
+	Extension string
+	Version   int64
+	Name      string
+	Path      string
+	Checksum  string
+}
+
+// MigrationStatus holds the state of a migration
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool
+	Missing   bool
+}
+
+// migrationRecord holds a castro_migrations row
+type migrationRecord struct {
+	Extension_id string
+	Version      int64
+	Name         string
+	Checksum     string
+	Applied_at   int64
+}
+
+// migrationMutex prevents running migrations concurrently
+var migrationMutex = &sync.Mutex{}
+
+// String returns the migration identifier
+func (m *Migration) String() string {
+	if m.Extension == "" {
+		return fmt.Sprintf("%d_%s", m.Version, m.Name)
+	}
+
+	return fmt.Sprintf("%s/%d_%s", m.Extension, m.Version, m.Name)
+}
+
+// MigrationSources returns the migration directory of the core and of every
+// installed extension. Core migrations use an empty extension id
+func MigrationSources() (map[string]string, error) {
+	sources := map[string]string{
+		"": "migrations",
+	}
+
+	// Get installed extensions
+	rows, err := database.DB.Queryx("SELECT id FROM castro_extensions WHERE installed = 1")
+	if err != nil {
+		return nil, err
+	}
+
+	// Close rows
+	defer rows.Close()
+
+	// Loop rows
+	for rows.Next() {
+		var id string
+
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+
+		sources[id] = filepath.Join("extensions", id, "migrations")
+	}
+
+	return sources, rows.Err()
+}
+
+// LoadMigrations reads all the migration files of the given directory sorted by
+// version. Migration files must be named as <version>_<name>.lua
+func LoadMigrations(extension, dir string) ([]*Migration, error) {
+	// Read directory files
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Migration{}, nil
+		}
+		return nil, err
+	}
+
+	// Migration list holder
+	migrations := []*Migration{}
+	versions := map[int64]string{}
+
+	// Loop directory files
+	for _, f := range files {
+
+		// Check if lua file
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".lua") {
+			continue
+		}
+
+		// Parse migration version
+		name := strings.TrimSuffix(f.Name(), ".lua")
+		parts := strings.SplitN(name, "_", 2)
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil || len(parts) != 2 || version <= 0 {
+			return nil, fmt.Errorf("Invalid migration file name %v, expected <version>_<name>.lua", filepath.Join(dir, f.Name()))
+		}
+
+		// Check for duplicated versions
+		if other, ok := versions[version]; ok {
+			return nil, fmt.Errorf("Migrations %v and %v share the same version", other, f.Name())
+		}
+		versions[version] = f.Name()
+
+		// Read file contents to calculate the checksum
+		buff, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		checksum := sha256.Sum256(buff)
+
+		migrations = append(migrations, &Migration{
+			Extension: extension,
+			Version:   version,
+			Name:      parts[1],
+			Path:      filepath.Join(dir, f.Name()),
+			Checksum:  hex.EncodeToString(checksum[:]),
+		})
+	}
+
+	// Sort migrations by version
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// MigrationStatusList returns the status of every migration of the given sources
+func MigrationStatusList(sources map[string]string) ([]*MigrationStatus, error) {
+	// Create migration table
+	if err := createMigrationTable(); err != nil {
+		return nil, err
+	}
+
+	list := []*MigrationStatus{}
+
+	// Loop sources in a predictable order
+	for _, extension := range sortedMigrationSources(sources) {
+
+		// Load migration files
+		migrations, err := LoadMigrations(extension, sources[extension])
+		if err != nil {
+			return nil, err
+		}
+
+		// Load applied migrations
+		records, err := appliedMigrations(extension)
+		if err != nil {
+			return nil, err
+		}
+
+		// Loop migration files
+		for _, m := range migrations {
+			status := &MigrationStatus{
+				Migration: *m,
+			}
+
+			if record, ok := records[m.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = time.Unix(record.Applied_at, 0)
+				status.Modified = record.Checksum != m.Checksum
+				delete(records, m.Version)
+			}
+
+			list = append(list, status)
+		}
+
+		// Applied migrations without a file
+		for _, record := range records {
+			list = append(list, &MigrationStatus{
+				Migration: Migration{
+					Extension: extension,
+					Version:   record.Version,
+					Name:      record.Name,
+					Checksum:  record.Checksum,
+				},
+				Applied:   true,
+				AppliedAt: time.Unix(record.Applied_at, 0),
+				Missing:   true,
+			})
+		}
+	}
+
+	return list, nil
+}
+
+// ApplyMigrations runs the up function of every pending migration of the given sources.
+// Core migrations run first, each migration runs only once
+func ApplyMigrations(sources map[string]string) ([]*Migration, error) {
+	// Lock migrations
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	// Get migrations status
+	list, err := MigrationStatusList(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	// Applied migrations holder
+	applied := []*Migration{}
+
+	// Check for modified migrations before running anything
+	for _, status := range list {
+		if status.Modified {
+			return applied, fmt.Errorf("Migration %v was modified after being applied", status.String())
+		}
+	}
+
+	// Get migration handle
+	h, release, err := database.MigrationHandle(database.DB)
+	if err != nil {
+		return applied, err
+	}
+
+	defer release()
+
+	// Loop pending migrations
+	for _, status := range list {
+		if status.Applied {
+			continue
+		}
+
+		m := status.Migration
+
+		// Run migration and save its record
+		if err := m.run(h, "up", func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(
+				"INSERT INTO castro_migrations (extension_id, version, name, checksum, applied_at) VALUES (?, ?, ?, ?, ?)",
+				m.Extension,
+				m.Version,
+				m.Name,
+				m.Checksum,
+				time.Now().Unix(),
+			)
+			return err
+		}); err != nil {
+			return applied, fmt.Errorf("Cannot apply migration %v: %v", m.String(), err)
+		}
+
+		applied = append(applied, &m)
+	}
+
+	return applied, nil
+}
+
+// RollbackMigrations runs the down function of the last applied migrations of the given
+// source directory. If steps is lower or equal to zero all migrations are reverted
+func RollbackMigrations(extension, dir string, steps int) ([]*Migration, error) {
+	// Lock migrations
+	migrationMutex.Lock()
+	defer migrationMutex.Unlock()
+
+	// Create migration table
+	if err := createMigrationTable(); err != nil {
+		return nil, err
+	}
+
+	// Load migration files
+	migrations, err := LoadMigrations(extension, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load applied migrations
+	records, err := appliedMigrations(extension)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort applied versions from newest to oldest
+	versions := []int64{}
+	for version := range records {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+
+	if steps > 0 && steps < len(versions) {
+		versions = versions[:steps]
+	}
+
+	// Reverted migrations holder
+	reverted := []*Migration{}
+
+	// Get migration handle
+	h, release, err := database.MigrationHandle(database.DB)
+	if err != nil {
+		return reverted, err
+	}
+
+	defer release()
+
+	// Loop versions
+	for _, version := range versions {
+
+		// Find migration file
+		var m *Migration
+		for _, migration := range migrations {
+			if migration.Version == version {
+				m = migration
+				break
+			}
+		}
+
+		if m == nil {
+			return reverted, fmt.Errorf("Cannot rollback migration %v: file not found", records[version].Name)
+		}
+
+		// Run migration and remove its record
+		if err := m.run(h, "down", func(tx *sqlx.Tx) error {
+			_, err := tx.Exec(
+				"DELETE FROM castro_migrations WHERE extension_id = ? AND version = ?",
+				m.Extension,
+				m.Version,
+			)
+			return err
+		}); err != nil {
+			return reverted, fmt.Errorf("Cannot rollback migration %v: %v", m.String(), err)
+		}
+
+		reverted = append(reverted, m)
+	}
+
+	return reverted, nil
+}
+
+// run executes the given migration function on a new lua state. The function
+// and the record change run in one transaction. MySQL commits schema changes
+// right away so only the data changes are reverted there if anything fails
+func (m *Migration) run(h *sqlx.DB, name string, record func(tx *sqlx.Tx) error) error {
+	// Create migration state
+	state := glua.NewState()
+
+	// Close state
+	defer state.Close()
+
+	// Set database metatable. Migrations run inside their own transaction so
+	// they can not finish it
+	SetDatabaseMetaTable(state)
+
+	for _, method := range []string{"begin", "commit", "rollback", "transaction"} {
+		state.SetField(state.GetTypeMetatable(DatabaseMetaTableName), method, state.NewFunction(func(L *glua.LState) int {
+			L.RaiseError("migrations can not commit or rollback the transaction")
+			return 0
+		}))
+	}
+
+	// Restrict sandboxed extension migrations to their capabilities
+	if m.Extension != "" {
+		sb, err := m.sandbox()
+		if err != nil {
+			return err
+		}
+
+		if sb != nil {
+			sb.apply(state)
+		}
+	}
+
+	// Do lua file
+	if err := state.DoFile(m.Path); err != nil {
+		return err
+	}
+
+	// Get migration function
+	fn := state.GetGlobal(name)
+	if fn.Type() != glua.LTFunction {
+		return fmt.Errorf("%v function not found", name)
+	}
+
+	// Start transaction
+	tx, err := h.Beginx()
+	if err != nil {
+		return err
+	}
+
+	setStateTransaction(state, tx)
+
+	// Call migration function
+	err = state.CallByParam(
+		glua.P{
+			Fn:      fn,
+			NRet:    0,
+			Protect: true,
+		},
+	)
+
+	// Migrations can not finish the transaction by themselves
+	if err == nil && stateTransaction(state) != tx {
+		err = errors.New("migrations can not commit or rollback the transaction")
+	}
+
+	setStateTransaction(state, nil)
+
+	if err == nil {
+		err = record(tx)
+	}
+
+	// Rebuilt SQLite tables should keep their references
+	if err == nil && database.Dialect(tx) == database.SQLite {
+		if fkErr := checkForeignKeys(tx); fkErr != nil {
+			util.Logger.Logger.Errorf("Migration %v: %v", m.String(), fkErr)
+		}
+	}
+
+	if err != nil {
+		if rErr := tx.Rollback(); rErr != nil && rErr != sql.ErrTxDone {
+			util.Logger.Logger.Errorf("Cannot rollback migration %v: %v", m.String(), rErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// checkForeignKeys returns an error if any row references a missing row
+func checkForeignKeys(tx *sqlx.Tx) error {
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if rows.Next() {
+		var (
+			table, parent string
+			rowid         sql.NullInt64
+			fkid          int64
+		)
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return err
+		}
+		return fmt.Errorf("table %v has rows that reference missing %v rows", table, parent)
+	}
+
+	return rows.Err()
+}
+
+// sandbox returns the sandbox of the migration extension. Unrestricted
+// extensions keep their grant, otherwise the manifest is used since migrations
+// run before the capabilities are saved
+func (m *Migration) sandbox() (*sandbox, error) {
+	sb, err := Sandboxes.Get(m.Extension)
+	if err != nil || sb == nil {
+		return sb, err
+	}
+
+	manifest, err := util.LoadExtensionManifest(m.Extension)
+	if err != nil {
+		return sb, nil
+	}
+
+	return Sandboxes.manifestSandbox(manifest)
+}
+
+// appliedMigrations returns the applied migrations of the given source by version
+func appliedMigrations(extension string) (map[int64]*migrationRecord, error) {
+	records := []*migrationRecord{}
+
+	// Get migration rows
+	if err := database.DB.Select(
+		&records,
+		"SELECT extension_id, version, name, checksum, applied_at FROM castro_migrations WHERE extension_id = ?",
+		extension,
+	); err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	list := map[int64]*migrationRecord{}
+
+	for _, record := range records {
+		list[record.Version] = record
+	}
+
+	return list, nil
+}
+
+// createMigrationTable creates the castro_migrations table if needed
+func createMigrationTable() error {
+	// Read table file
+	buff, err := ioutil.ReadFile(filepath.Join(database.InstallDirectory(database.Dialect(database.DB)), "castro_migrations.sql"))
+	if err != nil {
+		return err
+	}
+
+	// Execute table query
+	_, err = database.DB.Exec(string(buff))
+
+	return err
+}
+
+// sortedMigrationSources returns the source keys with the core source first
+func sortedMigrationSources(sources map[string]string) []string {
+	keys := []string{}
+
+	for key := range sources {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
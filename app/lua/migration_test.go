@@ -0,0 +1,150 @@
+package lua
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/raggaer/castro/app/database"
+)
+
+// writeTestFiles writes the given files on the given folder
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, body := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		versions []int64
+		fail     string
+	}{
+		{name: "sorted", files: map[string]string{"0010_b.lua": "", "0002_a.lua": "", "notes.txt": ""}, versions: []int64{2, 10}},
+		{name: "empty", files: map[string]string{}, versions: []int64{}},
+		{name: "no name", files: map[string]string{"0001.lua": ""}, fail: "Invalid migration file name"},
+		{name: "no version", files: map[string]string{"first_a.lua": ""}, fail: "Invalid migration file name"},
+		{name: "zero version", files: map[string]string{"0_a.lua": ""}, fail: "Invalid migration file name"},
+		{name: "same version", files: map[string]string{"1_a.lua": "", "01_b.lua": ""}, fail: "share the same version"},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "castro-migrations")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writeTestFiles(t, dir, test.files)
+
+		migrations, err := LoadMigrations("news", dir)
+		os.RemoveAll(dir)
+
+		if test.fail != "" {
+			if err == nil || !strings.Contains(err.Error(), test.fail) {
+				t.Errorf("%v: expected a %q error, got %v", test.name, test.fail, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		versions := []int64{}
+		for _, m := range migrations {
+			versions = append(versions, m.Version)
+			if m.Extension != "news" || m.Checksum == "" {
+				t.Errorf("%v: unexpected migration %+v", test.name, m)
+			}
+		}
+
+		if len(versions) != len(test.versions) {
+			t.Errorf("%v: versions %v, expected %v", test.name, versions, test.versions)
+			continue
+		}
+		for i := range versions {
+			if versions[i] != test.versions[i] {
+				t.Errorf("%v: versions %v, expected %v", test.name, versions, test.versions)
+				break
+			}
+		}
+	}
+
+	// Missing folders have no migrations
+	if migrations, err := LoadMigrations("", filepath.Join(os.TempDir(), "castro-missing-migrations")); err != nil || len(migrations) != 0 {
+		t.Errorf("expected no migrations for a missing folder, got %v %v", migrations, err)
+	}
+}
+
+func TestMigrationRun(t *testing.T) {
+	setTestConfig()
+
+	dir, err := ioutil.TempDir("", "castro-migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer setTestDatabase(t, dir)()
+
+	if _, err := database.DB.Exec("CREATE TABLE migration_test (id INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFiles(t, dir, map[string]string{
+		"1_valid.lua":  `function up() db:execute("INSERT INTO migration_test (id) VALUES (1)") end`,
+		"2_fails.lua":  `function up() db:execute("INSERT INTO migration_test (id) VALUES (2)") error("failed") end`,
+		"3_commit.lua": `function up() db:execute("INSERT INTO migration_test (id) VALUES (3)") db:commit() end`,
+		"4_down.lua":   `function down() end`,
+	})
+
+	migrations, err := LoadMigrations("", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		fail string
+		rows int
+	}{
+		{rows: 1},
+		{fail: "failed", rows: 1},
+		{fail: "can not commit or rollback", rows: 1},
+		{fail: "up function not found", rows: 1},
+	}
+
+	for i, test := range tests {
+		recorded := false
+
+		err := migrations[i].run(database.DB, "up", func(tx *sqlx.Tx) error {
+			recorded = true
+			return nil
+		})
+
+		if test.fail == "" && err != nil {
+			t.Errorf("%v: unexpected error: %v", migrations[i], err)
+		}
+		if test.fail != "" && (err == nil || !strings.Contains(err.Error(), test.fail)) {
+			t.Errorf("%v: expected a %q error, got %v", migrations[i], test.fail, err)
+		}
+		if recorded != (test.fail == "") {
+			t.Errorf("%v: recorded = %v", migrations[i], recorded)
+		}
+
+		rows := 0
+		if err := database.DB.Get(&rows, "SELECT COUNT(*) FROM migration_test"); err != nil {
+			t.Fatal(err)
+		}
+		if rows != test.rows {
+			t.Errorf("%v: %d rows, expected %d", migrations[i], rows, test.rows)
+		}
+	}
+}
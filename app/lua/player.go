@@ -65,6 +65,11 @@ func createPlayerMetaTable(player *models.Player, luaState *lua.LState) *lua.LTa
 	// Set all player metatable functions
 	luaState.SetFuncs(playerMetaTable, playerMethods)
 
+	// Guard database changes of sandboxed extensions
+	if sb := stateSandbox(luaState); sb != nil {
+		sb.restrictPlayer(luaState, playerMetaTable)
+	}
+
 	// Set all player public fields
 	MergeTableFields(StructToTable(player), playerMetaTable)
 
@@ -151,7 +156,7 @@ func SetPlayerBankBalance(L *lua.LState) int {
 
 	// Update bank balance
 	if err := player.SetBalance(newBalance); err != nil {
-		L.RaiseError("Cannot update player balance: %v")
+		L.RaiseError("Cannot update player balance: %v", err)
 		return 0
 	}
 
@@ -413,15 +418,10 @@ func SetPlayerCustomField(L *lua.LState) int {
 	// Get field value
 	fieldValue := L.Get(3)
 
-	// Retrieve current schema
-	schema := Config.GetGlobal("mysqlDatabase").String()
-
-	// Column name placeholder
-	nameList := []models.PlayerColumn{}
-
 	// Get all player column names
-	if err := database.DB.Select(&nameList, "SELECT COLUMN_NAME AS name FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_NAME = ? AND TABLE_SCHEMA = ?", "players", schema); err != nil {
-		L.RaiseError("Cannot get list of column names from information_schema: %v", err)
+	nameList, err := database.Columns(database.DB, "players")
+	if err != nil {
+		L.RaiseError("Cannot get list of player column names: %v", err)
 		return 0
 	}
 
@@ -429,7 +429,7 @@ func SetPlayerCustomField(L *lua.LState) int {
 	for _, column := range nameList {
 
 		// Check for valid column name
-		if column.Name == fieldName {
+		if column == fieldName {
 
 			// Set custom field
 			if _, err := database.DB.Exec("UPDATE players SET "+html.EscapeString(fieldName)+" = ? WHERE id = ?", fieldValue.String(), player.ID); err != nil {
@@ -463,15 +463,10 @@ func GetPlayerCustomField(L *lua.LState) int {
 	// Field placeholder
 	fieldValue := ""
 
-	// Retrieve current schema
-	schema := Config.GetGlobal("mysqlDatabase").String()
-
-	// Column name placeholder
-	nameList := []models.PlayerColumn{}
-
 	// Get all player column names
-	if err := database.DB.Select(&nameList, "SELECT COLUMN_NAME AS name FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_NAME = ? AND TABLE_SCHEMA = ?", "players", schema); err != nil {
-		L.RaiseError("Cannot get list of column names from information_schema: %v", err)
+	nameList, err := database.Columns(database.DB, "players")
+	if err != nil {
+		L.RaiseError("Cannot get list of player column names: %v", err)
 		return 0
 	}
 
@@ -479,7 +474,7 @@ func GetPlayerCustomField(L *lua.LState) int {
 	for _, column := range nameList {
 
 		// Check for valid column name
-		if column.Name == fieldName {
+		if column == fieldName {
 
 			// Retrieve custom field
 			if err := database.DB.Get(&fieldValue, "SELECT "+html.EscapeString(fieldName)+" FROM players WHERE id = ?", player.ID); err != nil {
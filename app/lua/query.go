@@ -0,0 +1,205 @@
+package lua
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	glua "github.com/yuin/gopher-lua"
+)
+
+// queryPlaceholder holds the position of a query parameter
+type queryPlaceholder struct {
+	Start int
+	End   int
+	Name  string
+}
+
+// parseQueryPlaceholders returns the list of positional (?) and named (:name) parameters
+// of the given query. Parameters found inside quoted strings or comments are ignored
+func parseQueryPlaceholders(query string) []queryPlaceholder {
+	placeholders := []queryPlaceholder{}
+
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; c {
+
+		// Skip quoted strings and identifiers
+		case '\'', '"', '`':
+			for i++; i < len(query); i++ {
+				if query[i] == '\\' && c != '`' {
+					i++
+					continue
+				}
+				if query[i] == c {
+					// Doubled quotes are escaped quotes
+					if i+1 < len(query) && query[i+1] == c {
+						i++
+						continue
+					}
+					break
+				}
+			}
+
+		// Skip line comments
+		case '-', '#':
+			if c == '-' && !strings.HasPrefix(query[i:], "--") {
+				continue
+			}
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+
+		// Skip block comments
+		case '/':
+			if !strings.HasPrefix(query[i:], "/*") {
+				continue
+			}
+			end := strings.Index(query[i+2:], "*/")
+			if end == -1 {
+				i = len(query)
+				continue
+			}
+			i += end + 3
+
+		case '?':
+			placeholders = append(placeholders, queryPlaceholder{
+				Start: i,
+				End:   i + 1,
+			})
+
+		case ':':
+			// Ignore casts (::) and assignments (:=)
+			if i > 0 && query[i-1] == ':' {
+				continue
+			}
+
+			// Read parameter name
+			end := i + 1
+			for end < len(query) && isQueryNameChar(query[end], end == i+1) {
+				end++
+			}
+
+			if end == i+1 {
+				continue
+			}
+
+			placeholders = append(placeholders, queryPlaceholder{
+				Start: i,
+				End:   end,
+				Name:  query[i+1 : end],
+			})
+
+			i = end - 1
+		}
+	}
+
+	return placeholders
+}
+
+// isQueryNameChar checks if the given character can be part of a named parameter
+func isQueryNameChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+
+	return !first && c >= '0' && c <= '9'
+}
+
+// queryArguments reads the query arguments of the given state starting at the given stack index.
+// If the argument at the index is a table the query named parameters are read from the table,
+// otherwise every positional parameter is read from the stack. Returns the query using only
+// positional parameters, the arguments and the stack index after the last argument
+func queryArguments(L *glua.LState, query string, index int) (string, []interface{}, int) {
+	// Get query placeholders
+	placeholders := parseQueryPlaceholders(query)
+
+	args := []interface{}{}
+
+	// Check for named parameters
+	if tbl, ok := L.Get(index).(*glua.LTable); ok {
+
+		// Query builder
+		buff := &strings.Builder{}
+		last := 0
+
+		for _, p := range placeholders {
+			if p.Name == "" {
+				L.ArgError(index, "Cannot mix positional and named parameters")
+				return query, nil, index
+			}
+
+			buff.WriteString(query[last:p.Start])
+			buff.WriteString("?")
+			last = p.End
+
+			args = append(args, queryArgument(tbl.RawGetString(p.Name)))
+		}
+
+		buff.WriteString(query[last:])
+
+		return buff.String(), args, index + 1
+	}
+
+	// Get positional parameters
+	for _, p := range placeholders {
+		if p.Name != "" {
+			continue
+		}
+
+		args = append(args, queryArgument(L.Get(index)))
+		index++
+	}
+
+	return query, args, index
+}
+
+// queryArgument converts a lua value to a query argument preserving its type
+func queryArgument(value glua.LValue) interface{} {
+	switch v := value.(type) {
+	case *glua.LNilType:
+		return nil
+	case glua.LBool:
+		return bool(v)
+	case glua.LNumber:
+		// Send integers as integers
+		if f := float64(v); f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f)
+		}
+		return float64(v)
+	case glua.LString:
+		return string(v)
+	default:
+		return v.String()
+	}
+}
+
+// queryString returns the query with the arguments in place of its positional parameters.
+// Only used for logging and cache keys
+func queryString(query string, args []interface{}) string {
+	buff := &strings.Builder{}
+	last := 0
+	n := 0
+
+	for _, p := range parseQueryPlaceholders(query) {
+		if p.Name != "" || n >= len(args) {
+			continue
+		}
+
+		buff.WriteString(query[last:p.Start])
+
+		if s, ok := args[n].(string); ok {
+			buff.WriteString(fmt.Sprintf("%q", s))
+		} else if args[n] == nil {
+			buff.WriteString("NULL")
+		} else {
+			buff.WriteString(fmt.Sprintf("%v", args[n]))
+		}
+
+		last = p.End
+		n++
+	}
+
+	buff.WriteString(query[last:])
+
+	return buff.String()
+}
@@ -0,0 +1,56 @@
+package lua
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseQueryPlaceholders(t *testing.T) {
+	tests := []struct {
+		query string
+		names []string
+	}{
+		{query: "SELECT * FROM accounts WHERE id = ?", names: []string{""}},
+		{query: "SELECT * FROM accounts WHERE id = ? AND name = ?", names: []string{"", ""}},
+		{query: "SELECT * FROM accounts WHERE id = :id AND name = :name_2", names: []string{"id", "name_2"}},
+		{query: "SELECT ':id', \"?\", `a?` FROM accounts WHERE id = ?", names: []string{""}},
+		{query: "SELECT 'it''s ?' FROM accounts WHERE id = :id", names: []string{"id"}},
+		{query: "SELECT 'a\\' ?' FROM accounts WHERE id = ?", names: []string{""}},
+		{query: "SELECT 1 -- ?\nFROM accounts WHERE id = ?", names: []string{""}},
+		{query: "SELECT 1 # :id\nFROM accounts WHERE id = :id", names: []string{"id"}},
+		{query: "SELECT 1 /* ? :id */ FROM accounts WHERE id = ?", names: []string{""}},
+		{query: "SELECT 1 /* ? unterminated", names: []string{}},
+		{query: "SELECT id::text, @a := 1 FROM accounts WHERE id = :1", names: []string{}},
+		{query: "SELECT 5-3 FROM accounts WHERE id = ?", names: []string{""}},
+	}
+
+	for _, test := range tests {
+		names := []string{}
+		for _, p := range parseQueryPlaceholders(test.query) {
+			names = append(names, p.Name)
+		}
+
+		if !reflect.DeepEqual(names, test.names) {
+			t.Errorf("parseQueryPlaceholders(%q) = %q, expected %q", test.query, names, test.names)
+		}
+	}
+}
+
+func TestQueryString(t *testing.T) {
+	tests := []struct {
+		query    string
+		args     []interface{}
+		expected string
+	}{
+		{query: "SELECT * FROM a WHERE id = ?", args: []interface{}{int64(1)}, expected: "SELECT * FROM a WHERE id = 1"},
+		{query: "SELECT * FROM a WHERE name = ? AND b = ?", args: []interface{}{"x", nil}, expected: `SELECT * FROM a WHERE name = "x" AND b = NULL`},
+		{query: "SELECT '?' FROM a WHERE id = ?", args: []interface{}{int64(2)}, expected: "SELECT '?' FROM a WHERE id = 2"},
+		{query: "SELECT * FROM a WHERE id = ? AND b = ?", args: []interface{}{int64(3)}, expected: "SELECT * FROM a WHERE id = 3 AND b = ?"},
+	}
+
+	for _, test := range tests {
+		if s := queryString(test.query, test.args); s != test.expected {
+			t.Errorf("queryString(%q) = %q, expected %q", test.query, s, test.expected)
+		}
+	}
+}
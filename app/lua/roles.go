@@ -0,0 +1,196 @@
+package lua
+
+import (
+	"github.com/raggaer/castro/app/models"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// SetRolesMetaTable sets the roles metatable on the given lua state
+func SetRolesMetaTable(luaState *lua.LState) {
+	// Create and set roles metatable
+	rolesMetaTable := luaState.NewTypeMetatable(RolesMetaTableName)
+	luaState.SetGlobal(RolesMetaTableName, rolesMetaTable)
+
+	// Set all roles metatable functions
+	luaState.SetFuncs(rolesMetaTable, rolesMethods)
+}
+
+// GetRoleList returns all the roles with their permissions
+func GetRoleList(L *lua.LState) int {
+	// Get role list
+	roles, err := models.GetRoleList()
+
+	if err != nil {
+		L.RaiseError("Cannot get role list: %v", err)
+		return 0
+	}
+
+	// Data holder
+	t := &lua.LTable{}
+
+	for _, role := range roles {
+		t.Append(StructToTable(role))
+	}
+
+	L.Push(t)
+
+	return 1
+}
+
+// GetPermissionList returns all the assignable permissions
+func GetPermissionList(L *lua.LState) int {
+	// Get permission list
+	permissions, err := models.GetPermissionList()
+
+	if err != nil {
+		L.RaiseError("Cannot get permission list: %v", err)
+		return 0
+	}
+
+	// Data holder
+	t := &lua.LTable{}
+
+	for i := range permissions {
+		t.Append(StructToTable(&permissions[i]))
+	}
+
+	L.Push(t)
+
+	return 1
+}
+
+// GetAccountRoles returns the role names of the given account
+func GetAccountRoles(L *lua.LState) int {
+	// Get account id
+	id := L.CheckInt64(2)
+
+	// Get account roles
+	roles, err := models.GetAccountRoles(id)
+
+	if err != nil {
+		L.RaiseError("Cannot get account roles: %v", err)
+		return 0
+	}
+
+	L.Push(StringSliceToTable(roles))
+
+	return 1
+}
+
+// SaveRole creates or updates a role
+func SaveRole(L *lua.LState) int {
+	// Get role name and description
+	name := L.CheckString(2)
+	description := L.OptString(3, "")
+
+	// Get role permissions
+	permissions := []string{}
+
+	if tbl := L.OptTable(4, nil); tbl != nil {
+		tbl.ForEach(func(_ lua.LValue, v lua.LValue) {
+			if v.Type() == lua.LTString {
+				permissions = append(permissions, v.String())
+			}
+		})
+	}
+
+	// The logged account can only give the permissions it holds
+	required := permissions
+	if name == models.AdministratorRole {
+		required = append(required, models.AllPermissions)
+	}
+
+	if !checkGrantedPermissions(L, required) {
+		return 0
+	}
+
+	// Save role
+	if err := models.SaveRole(name, description, permissions); err != nil {
+		L.RaiseError("Cannot save role: %v", err)
+	}
+
+	return 0
+}
+
+// DeleteRole removes a role. The administrator role cannot be removed
+func DeleteRole(L *lua.LState) int {
+	// Get role name
+	name := L.CheckString(2)
+
+	if name == models.AdministratorRole {
+		L.ArgError(1, "The administrator role cannot be removed")
+		return 0
+	}
+
+	// Delete role
+	if err := models.DeleteRole(name); err != nil {
+		L.RaiseError("Cannot delete role: %v", err)
+	}
+
+	return 0
+}
+
+// AssignRole gives a role to the given account
+func AssignRole(L *lua.LState) int {
+	// Get account id and role name
+	id := L.CheckInt64(2)
+	role := L.CheckString(3)
+
+	// The logged account can only give the roles it could create
+	if !checkGrantedRole(L, role) {
+		return 0
+	}
+
+	// Assign role
+	if err := models.AssignRole(id, role); err != nil {
+		L.RaiseError("Cannot assign role: %v", err)
+	}
+
+	return 0
+}
+
+// RemoveRole takes a role from the given account
+func RemoveRole(L *lua.LState) int {
+	// Get account id and role name
+	id := L.CheckInt64(2)
+	role := L.CheckString(3)
+
+	// The logged account can only take the roles it could give
+	if !checkGrantedRole(L, role) {
+		return 0
+	}
+
+	// Remove role
+	if err := models.RemoveRole(id, role); err != nil {
+		L.RaiseError("Cannot remove role: %v", err)
+	}
+
+	return 0
+}
+
+// checkGrantedRole raises an error unless the logged account holds every
+// permission of the given role. The administrator role needs every permission
+func checkGrantedRole(L *lua.LState, role string) bool {
+	permissions, err := models.GetRolePermissions(role)
+	if err != nil {
+		L.RaiseError("Cannot get role permissions: %v", err)
+		return false
+	}
+
+	if role == models.AdministratorRole {
+		permissions = append(permissions, models.AllPermissions)
+	}
+
+	return checkGrantedPermissions(L, permissions)
+}
+
+// checkGrantedPermissions raises an error unless the logged account holds the
+// given permissions
+func checkGrantedPermissions(L *lua.LState, permissions []string) bool {
+	if permission := models.UngrantedPermission(loggedAccountPermissions(L), permissions); permission != "" {
+		L.RaiseError("The logged account does not have the %v permission", permission)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,806 @@
+package lua
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/kardianos/osext"
+	"github.com/raggaer/castro/app/database"
+	"github.com/raggaer/castro/app/util"
+	glua "github.com/yuin/gopher-lua"
+)
+
+var (
+	// Sandboxes holds the granted capabilities and the state pools of the
+	// sandboxed extensions
+	Sandboxes = &sandboxList{
+		sandboxes: map[string]*sandbox{},
+		pools:     map[string]*luaStatePool{},
+		granted:   map[string]bool{},
+	}
+
+	// sandboxGlobals globals that extensions can always use
+	sandboxGlobals = map[string]bool{
+		"_G":                   true,
+		"_VERSION":             true,
+		"assert":               true,
+		"error":                true,
+		"getmetatable":         true,
+		"ipairs":               true,
+		"next":                 true,
+		"pairs":                true,
+		"pcall":                true,
+		"print":                true,
+		"rawequal":             true,
+		"rawget":               true,
+		"rawset":               true,
+		"select":               true,
+		"setmetatable":         true,
+		"tonumber":             true,
+		"tostring":             true,
+		"type":                 true,
+		"unpack":               true,
+		"xpcall":               true,
+		"string":               true,
+		"table":                true,
+		"math":                 true,
+		"coroutine":            true,
+		"sleep":                true,
+		"try":                  true,
+		"ternary":              true,
+		"serverPath":           true,
+		"logFile":              true,
+		I18nMetaTableName:      true,
+		OutfitMetaTableName:    true,
+		LogMetaTableName:       true,
+		URLMetaTableName:       true,
+		TimeMetaTableName:      true,
+		DebugMetaTableName:     true,
+		CaptchaMetaTableName:   true,
+		CryptoMetaTableName:    true,
+		Base64MetaTableName:    true,
+		ValidatorMetaTableName: true,
+		EventsMetaTableName:    true,
+		WidgetMetaTableName:    true,
+	}
+
+	// sandboxTables globals that extensions can use with some of their functions
+	// restricted. Returning false removes the global
+	sandboxTables = map[string]func(sb *sandbox, L *glua.LState, t *glua.LTable) bool{
+		DatabaseMetaTableName: (*sandbox).restrictDatabase,
+		HTTPMetaTableName:     (*sandbox).restrictHTTP,
+		FileMetaTableName:     (*sandbox).restrictFile,
+		ImageMetaTableName:    (*sandbox).restrictImage,
+		MailMetaTableName:     (*sandbox).restrictMail,
+		HooksMetaTableName:    (*sandbox).restrictHooks,
+		SessionMetaTable:      (*sandbox).restrictSession,
+		StorageMetaTableName:  (*sandbox).restrictStorage,
+		CacheMetaTableName:    (*sandbox).restrictCache,
+		GlobalMetaTableName:   (*sandbox).restrictGlobal,
+		MapMetaTableName:      (*sandbox).restrictMap,
+		JSONMetaTableName:     (*sandbox).restrictUnmarshalFile,
+		XMLMetaTableName:      (*sandbox).restrictUnmarshalFile,
+	}
+
+	// sandboxAppFields fields of the app global that extensions can read
+	sandboxAppFields = []string{"Version", "BuildDate", "CheckUpdates", "URL", "Port", "Mode", "Custom", "Shop"}
+
+	// sandboxCredentialFields account and payload fields hidden from sandboxed
+	// extensions. Email is shown with the db.read capability
+	sandboxCredentialFields = map[string]bool{
+		"password": true,
+		"secret":   true,
+		"email":    true,
+	}
+
+	// sandboxModuleRegexp valid module names for require
+	sandboxModuleRegexp = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+)
+
+// sandbox restricts the code of an extension to its granted capabilities
+type sandbox struct {
+	id   string
+	caps *util.ExtensionCapabilities
+}
+
+// sandboxList holds the sandboxes of the extensions. A nil sandbox means the
+// extension runs without restrictions
+type sandboxList struct {
+	rw        sync.Mutex
+	sandboxes map[string]*sandbox
+	pools     map[string]*luaStatePool
+
+	// granted extensions being installed with unrestricted access
+	granted map[string]bool
+}
+
+// newSandbox creates the sandbox of the given extension. The extension folder
+// is always accessible
+func newSandbox(id string, capabilities []string) (*sandbox, error) {
+	caps, err := util.ParseExtensionCapabilities(capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	caps.Paths = append(caps.Paths, filepath.Join("extensions", id))
+
+	return &sandbox{
+		id:   id,
+		caps: caps,
+	}, nil
+}
+
+// manifestSandbox returns the sandbox described by the given manifest. Only
+// extensions the administrator is installing with unrestricted access run
+// without a sandbox
+func (s *sandboxList) manifestSandbox(manifest *util.ExtensionManifest) (*sandbox, error) {
+	s.rw.Lock()
+	unrestricted := s.granted[manifest.ID]
+	s.rw.Unlock()
+
+	if unrestricted {
+		return nil, nil
+	}
+
+	return newSandbox(manifest.ID, manifest.Capabilities)
+}
+
+// grant runs the given extension unrestricted while it is installed. Returns
+// a function that removes the grant
+func (s *sandboxList) grant(id string) func() {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	s.granted[id] = true
+
+	return func() {
+		s.rw.Lock()
+		defer s.rw.Unlock()
+
+		delete(s.granted, id)
+	}
+}
+
+// Get returns the sandbox of the given extension using the capabilities granted
+// when it was installed. Only extensions the administrator installed with
+// unrestricted access return a nil sandbox
+func (s *sandboxList) Get(id string) (*sandbox, error) {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	return s.get(id)
+}
+
+func (s *sandboxList) get(id string) (*sandbox, error) {
+	if sb, ok := s.sandboxes[id]; ok {
+		return sb, nil
+	}
+
+	var raw sql.NullString
+	capabilities := []string{}
+
+	err := database.DB.Get(&raw, "SELECT capabilities FROM castro_extensions WHERE id = ?", id)
+
+	switch {
+	case err == sql.ErrNoRows:
+		// Unknown extensions get no capabilities
+	case err != nil:
+		return nil, err
+	case !raw.Valid:
+		s.sandboxes[id] = nil
+		return nil, nil
+	default:
+		if err := json.Unmarshal([]byte(raw.String), &capabilities); err != nil {
+			return nil, fmt.Errorf("Cannot parse %v capabilities: %v", id, err)
+		}
+	}
+
+	sb, err := newSandbox(id, capabilities)
+	if err != nil {
+		return nil, err
+	}
+
+	s.sandboxes[id] = sb
+
+	return sb, nil
+}
+
+// Pool returns the state pool used by the pages of the given extension. Pages
+// outside extensions and unrestricted extensions use the application pool
+func (s *sandboxList) Pool(id string) *luaStatePool {
+	if id == "" {
+		return Pool
+	}
+
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	sb, err := s.get(id)
+	if err != nil {
+		util.Logger.Logger.Errorf("Cannot get %v extension capabilities: %v", id, err)
+		sb = &sandbox{id: id, caps: &util.ExtensionCapabilities{}}
+	}
+
+	if sb == nil {
+		return Pool
+	}
+
+	if p, ok := s.pools[id]; ok {
+		return p
+	}
+
+	p := &luaStatePool{
+		saved:     make([]*glua.LState, 0, 10),
+		snapshots: map[*glua.LState]*stateSnapshot{},
+		sandbox:   sb,
+	}
+	s.pools[id] = p
+
+	return p
+}
+
+// Reset forgets the granted capabilities and closes the sandboxed states
+func (s *sandboxList) Reset() {
+	s.rw.Lock()
+	defer s.rw.Unlock()
+
+	for _, p := range s.pools {
+		p.Clear()
+	}
+
+	s.sandboxes = map[string]*sandbox{}
+	s.pools = map[string]*luaStatePool{}
+}
+
+// NewExtensionState creates a lua state for the code of the given extension
+func NewExtensionState(id string) *glua.LState {
+	state := NewState()
+
+	if id == "" {
+		return state
+	}
+
+	sb, err := Sandboxes.Get(id)
+	if err != nil {
+		util.Logger.Logger.Errorf("Cannot get %v extension capabilities: %v", id, err)
+		sb = &sandbox{id: id, caps: &util.ExtensionCapabilities{}}
+	}
+
+	if sb != nil {
+		sb.apply(state)
+	}
+
+	return state
+}
+
+// stateSandbox returns the sandbox of the given state or nil
+func stateSandbox(L *glua.LState) *sandbox {
+	data, ok := L.G.Registry.RawGetString(SandboxRegistryName).(*glua.LUserData)
+	if !ok {
+		return nil
+	}
+
+	sb, _ := data.Value.(*sandbox)
+
+	return sb
+}
+
+// enter marks the given state as running code of the extension. Returns a
+// function that restores the previous sandbox
+func (sb *sandbox) enter(L *glua.LState) func() {
+	previous := L.G.Registry.RawGetString(SandboxRegistryName)
+
+	data := L.NewUserData()
+	data.Value = sb
+	L.G.Registry.RawSetString(SandboxRegistryName, data)
+
+	return func() {
+		L.G.Registry.RawSetString(SandboxRegistryName, previous)
+	}
+}
+
+// apply restricts the globals of the given state
+func (sb *sandbox) apply(L *glua.LState) {
+	data := L.NewUserData()
+	data.Value = sb
+
+	L.G.Registry.RawSetString(SandboxRegistryName, data)
+	L.G.Registry.RawSetString(SandboxLoadedName, L.NewTable())
+
+	sb.globals(L, L.G.Global, true)
+}
+
+// env returns a restricted copy of the globals of the given state for the
+// scripts of the extension
+func (sb *sandbox) env(L *glua.LState) *glua.LTable {
+	return sb.globals(L, L.G.Global, false)
+}
+
+// globals removes or restricts the globals an extension can not use. Unless
+// inPlace is set the source table is left untouched and a copy is returned
+func (sb *sandbox) globals(L *glua.LState, src *glua.LTable, inPlace bool) *glua.LTable {
+	dst := src
+	if !inPlace {
+		dst = L.NewTable()
+	}
+
+	// Gather names first since the source can be changed
+	names := []string{}
+	src.ForEach(func(key glua.LValue, value glua.LValue) {
+		if name, ok := key.(glua.LString); ok {
+			names = append(names, string(name))
+		} else if inPlace {
+			src.RawSet(key, glua.LNil)
+		}
+	})
+
+	loaded, ok := L.G.Registry.RawGetString(SandboxLoadedName).(*glua.LTable)
+	if !ok || !inPlace {
+		loaded = L.NewTable()
+	}
+
+	for _, name := range names {
+		value := src.RawGetString(name)
+
+		switch {
+		case name == "_G":
+			value = dst
+		case name == "os":
+			value = sb.os(L, value)
+		case name == "require":
+			value = sb.require(L, dst, loaded)
+		case name == "load" || name == "loadstring":
+			value = sb.load(L, dst, value)
+		case name == "Player" || name == "Guild":
+			if !sb.caps.DBRead {
+				value = glua.LNil
+			}
+		case name == "app":
+			value = sb.app(L, value)
+		case name == ExtensionMetaTableName:
+			value = sb.extension(L, value)
+		case sandboxTables[name] != nil:
+			t, ok := value.(*glua.LTable)
+			if !ok {
+				value = glua.LNil
+				break
+			}
+			if !inPlace {
+				t = copyTable(L, t)
+			}
+			if !sandboxTables[name](sb, L, t) {
+				value = glua.LNil
+				break
+			}
+			value = t
+		case sandboxGlobals[name]:
+			if t, ok := value.(*glua.LTable); ok && !inPlace {
+				value = copyTable(L, t)
+			}
+		default:
+			value = glua.LNil
+		}
+
+		dst.RawSetString(name, value)
+	}
+
+	return dst
+}
+
+// copyTable returns a shallow copy of the given table
+func copyTable(L *glua.LState, t *glua.LTable) *glua.LTable {
+	c := L.NewTable()
+	t.ForEach(func(key glua.LValue, value glua.LValue) {
+		c.RawSet(key, value)
+	})
+	return c
+}
+
+// deny raises the error of a missing capability
+func (sb *sandbox) deny(L *glua.LState, format string, args ...interface{}) {
+	L.RaiseError("Extension %v %v", sb.id, fmt.Sprintf(format, args...))
+}
+
+// guard wraps a function of the given table so it only runs if check succeeds
+func (sb *sandbox) guard(L *glua.LState, t *glua.LTable, name string, check func(L *glua.LState) error) {
+	fn, ok := t.RawGetString(name).(*glua.LFunction)
+	if !ok {
+		return
+	}
+
+	if !fn.IsG {
+		t.RawSetString(name, glua.LNil)
+		return
+	}
+
+	t.RawSetString(name, L.NewFunction(func(L *glua.LState) int {
+		if err := check(L); err != nil {
+			sb.deny(L, "%v", err)
+			return 0
+		}
+		return fn.GFunction(L)
+	}))
+}
+
+// wrapResult wraps a function of the given table so the table it returns can be restricted
+func (sb *sandbox) wrapResult(L *glua.LState, t *glua.LTable, name string, restrict func(L *glua.LState, result *glua.LTable)) {
+	fn, ok := t.RawGetString(name).(*glua.LFunction)
+	if !ok || !fn.IsG {
+		t.RawSetString(name, glua.LNil)
+		return
+	}
+
+	t.RawSetString(name, L.NewFunction(func(L *glua.LState) int {
+		n := fn.GFunction(L)
+		if n > 0 {
+			if result, ok := L.Get(-n).(*glua.LTable); ok {
+				restrict(L, result)
+			}
+		}
+		return n
+	}))
+}
+
+// pathArg returns a check of the path on the given argument
+func (sb *sandbox) pathArg(positions ...int) func(L *glua.LState) error {
+	return func(L *glua.LState) error {
+		for _, i := range positions {
+			if p := L.ToString(i); !sb.caps.CanAccess(p) {
+				return fmt.Errorf("needs the %v:%v capability", util.CapabilityFile, p)
+			}
+		}
+		return nil
+	}
+}
+
+// urlArg returns a check of the URL on the given argument
+func (sb *sandbox) urlArg(position int) func(L *glua.LState) error {
+	return func(L *glua.LState) error {
+		return sb.checkURL(L.ToString(position))
+	}
+}
+
+// checkURL checks if the given URL can be requested
+func (sb *sandbox) checkURL(rawurl string) error {
+	if !sb.caps.CanRequest(rawurl) {
+		return fmt.Errorf("needs the %v capability to request %v", util.CapabilityHTTPOutbound, rawurl)
+	}
+	return nil
+}
+
+// checkQuery checks the statement of the second argument
+func (sb *sandbox) checkQuery(L *glua.LState) error {
+	read, tables, err := util.SQLStatement(L.CheckString(2))
+	if err != nil {
+		return fmt.Errorf("cannot run the query: %v", err)
+	}
+
+	if read && !sb.caps.DBRead {
+		return fmt.Errorf("needs the %v capability", util.CapabilityDBRead)
+	}
+
+	for _, table := range tables {
+		if !sb.caps.CanWrite(table) {
+			return fmt.Errorf("needs the %v:%v capability", util.CapabilityDBWrite, table)
+		}
+	}
+
+	return nil
+}
+
+// restrictDatabase checks every statement against the granted tables
+func (sb *sandbox) restrictDatabase(L *glua.LState, t *glua.LTable) bool {
+	if !sb.caps.DBRead && len(sb.caps.DBWrite) == 0 {
+		return false
+	}
+
+	for _, name := range []string{"query", "singleQuery", "execute"} {
+		sb.guard(L, t, name, sb.checkQuery)
+	}
+
+	sb.guard(L, t, "dropColumn", func(L *glua.LState) error {
+		return sb.tableWrite(L.CheckString(2))(L)
+	})
+
+	return true
+}
+
+// restrictHTTP checks outbound requests and file access of the http functions
+func (sb *sandbox) restrictHTTP(L *glua.LState, t *glua.LTable) bool {
+	sb.guard(L, t, "get", sb.urlArg(2))
+	sb.guard(L, t, "postForm", sb.urlArg(2))
+	sb.guard(L, t, "curl", func(L *glua.LState) error {
+		return sb.checkURL(L.CheckTable(2).RawGetString("url").String())
+	})
+	sb.guard(L, t, "serveFile", sb.pathArg(2))
+	sb.wrapResult(L, t, "formFile", func(L *glua.LState, file *glua.LTable) {
+		for _, name := range []string{"saveFile", "saveFileAsPNG", "SaveFileAsJPEG"} {
+			sb.guard(L, file, name, sb.pathArg(2))
+		}
+	})
+
+	return true
+}
+
+// restrictFile limits the file functions to the granted paths
+func (sb *sandbox) restrictFile(L *glua.LState, t *glua.LTable) bool {
+	for name := range fileMethods {
+		if name == "unzip" {
+			sb.guard(L, t, name, sb.pathArg(2, 3))
+			continue
+		}
+		sb.guard(L, t, name, sb.pathArg(2))
+	}
+
+	return true
+}
+
+// restrictImage limits the saved images to the granted paths
+func (sb *sandbox) restrictImage(L *glua.LState, t *glua.LTable) bool {
+	sb.wrapResult(L, t, "new", func(L *glua.LState, img *glua.LTable) {
+		sb.guard(L, img, "save", sb.pathArg(2))
+	})
+
+	return true
+}
+
+// restrictMail removes the mail functions without the mail capability
+func (sb *sandbox) restrictMail(L *glua.LState, t *glua.LTable) bool {
+	return sb.caps.Mail
+}
+
+// restrictHooks removes the hook reload and dispatch functions. Events are only
+// dispatched by core pages
+func (sb *sandbox) restrictHooks(L *glua.LState, t *glua.LTable) bool {
+	t.RawSetString("reload", glua.LNil)
+	t.RawSetString("dispatch", glua.LNil)
+	return true
+}
+
+// restrictSession removes the functions that change other accounts and hides
+// the credentials of the logged account
+func (sb *sandbox) restrictSession(L *glua.LState, t *glua.LTable) bool {
+	t.RawSetString("revokeAccount", glua.LNil)
+
+	fn, ok := t.RawGetString("loggedAccount").(*glua.LFunction)
+	if !ok || !fn.IsG {
+		t.RawSetString("loggedAccount", glua.LNil)
+		return true
+	}
+
+	t.RawSetString("loggedAccount", L.NewFunction(func(L *glua.LState) int {
+		n := fn.GFunction(L)
+		if n > 0 {
+			L.Replace(-n, sb.hideCredentials(L, L.Get(-n)))
+		}
+		return n
+	}))
+
+	return true
+}
+
+// hideCredentials returns a copy of the given value without the credential
+// fields. Nested tables are copied too
+func (sb *sandbox) hideCredentials(L *glua.LState, value glua.LValue) glua.LValue {
+	return sb.copyWithoutCredentials(L, value, map[*glua.LTable]*glua.LTable{})
+}
+
+// hidesField checks if the given account or payload field is hidden
+func (sb *sandbox) hidesField(name string) bool {
+	name = strings.ToLower(name)
+	return sandboxCredentialFields[name] && !(name == "email" && sb.caps.DBRead)
+}
+
+// copyWithoutCredentials copies the given value. Copied tables are reused so
+// cycles end
+func (sb *sandbox) copyWithoutCredentials(L *glua.LState, value glua.LValue, copies map[*glua.LTable]*glua.LTable) glua.LValue {
+	tbl, ok := value.(*glua.LTable)
+	if !ok {
+		return value
+	}
+
+	if c, ok := copies[tbl]; ok {
+		return c
+	}
+
+	c := L.NewTable()
+	copies[tbl] = c
+
+	tbl.ForEach(func(key, v glua.LValue) {
+		if name, ok := key.(glua.LString); ok && sb.hidesField(string(name)) {
+			return
+		}
+
+		c.RawSet(key, sb.copyWithoutCredentials(L, v, copies))
+	})
+
+	return c
+}
+
+// restrictCache keeps the cache keys of the extension apart from the keys of
+// the application and other extensions
+func (sb *sandbox) restrictCache(L *glua.LState, t *glua.LTable) bool {
+	for _, name := range []string{"get", "set", "delete"} {
+		sb.guard(L, t, name, func(L *glua.LState) error {
+			if key, ok := L.Get(2).(glua.LString); ok {
+				L.Replace(2, glua.LString(sb.cacheKey(string(key))))
+			}
+			return nil
+		})
+	}
+	return true
+}
+
+// cacheKey returns the application cache key of the given extension key
+func (sb *sandbox) cacheKey(key string) string {
+	return "ext:" + sb.id + ":" + key
+}
+
+// restrictStorage requires db.read to use player storage values and guards their changes
+func (sb *sandbox) restrictStorage(L *glua.LState, t *glua.LTable) bool {
+	sb.guard(L, t, "set", sb.tableWrite("player_storage"))
+	return sb.caps.DBRead
+}
+
+// restrictGlobal requires db.read to use the global values and guards their changes
+func (sb *sandbox) restrictGlobal(L *glua.LState, t *glua.LTable) bool {
+	sb.guard(L, t, "set", sb.tableWrite("castro_global"))
+	sb.guard(L, t, "delete", sb.tableWrite("castro_global"))
+	return sb.caps.DBRead
+}
+
+// restrictMap guards the saved map data
+func (sb *sandbox) restrictMap(L *glua.LState, t *glua.LTable) bool {
+	sb.guard(L, t, "encode", sb.tableWrite("castro_map"))
+	return true
+}
+
+// restrictUnmarshalFile limits the decoded files to the granted paths
+func (sb *sandbox) restrictUnmarshalFile(L *glua.LState, t *glua.LTable) bool {
+	sb.guard(L, t, "unmarshalFile", sb.pathArg(2))
+	return true
+}
+
+// os returns the time functions of the os library
+func (sb *sandbox) os(L *glua.LState, value glua.LValue) glua.LValue {
+	lib, ok := value.(*glua.LTable)
+	if !ok {
+		return glua.LNil
+	}
+
+	t := L.NewTable()
+	for _, name := range []string{"time", "clock", "date", "difftime"} {
+		t.RawSetString(name, lib.RawGetString(name))
+	}
+
+	return t
+}
+
+// app returns the app fields that do not hold secrets
+func (sb *sandbox) app(L *glua.LState, value glua.LValue) glua.LValue {
+	app, ok := value.(*glua.LTable)
+	if !ok {
+		return glua.LNil
+	}
+
+	t := L.NewTable()
+	for _, name := range sandboxAppFields {
+		t.RawSetString(name, app.RawGetString(name))
+	}
+
+	return t
+}
+
+// extension returns the extension functions that only read the extension settings
+func (sb *sandbox) extension(L *glua.LState, value glua.LValue) glua.LValue {
+	ext, ok := value.(*glua.LTable)
+	if !ok {
+		return glua.LNil
+	}
+
+	t := L.NewTable()
+	t.RawSetString("config", ext.RawGetString("config"))
+
+	return t
+}
+
+// restrictPlayer guards the player functions that change the database
+func (sb *sandbox) restrictPlayer(L *glua.LState, t *glua.LTable) {
+	sb.guard(L, t, "setBankBalance", sb.tableWrite("players"))
+	sb.guard(L, t, "setCustomField", sb.tableWrite("players"))
+	sb.guard(L, t, "setStorageValue", sb.tableWrite("player_storage"))
+}
+
+// tableWrite returns a check for changes to the given table
+func (sb *sandbox) tableWrite(table string) func(L *glua.LState) error {
+	return func(L *glua.LState) error {
+		if !sb.caps.CanWrite(table) {
+			return fmt.Errorf("needs the %v:%v capability", util.CapabilityDBWrite, table)
+		}
+		return nil
+	}
+}
+
+// load returns a load function that compiles chunks with the given environment
+func (sb *sandbox) load(L *glua.LState, env *glua.LTable, value glua.LValue) glua.LValue {
+	fn, ok := value.(*glua.LFunction)
+	if !ok || !fn.IsG {
+		return glua.LNil
+	}
+
+	return L.NewFunction(func(L *glua.LState) int {
+		n := fn.GFunction(L)
+		if n > 0 {
+			if chunk, ok := L.Get(-n).(*glua.LFunction); ok {
+				chunk.Env = env
+			}
+		}
+		return n
+	})
+}
+
+// require returns a require function that loads engine modules with the given
+// environment
+func (sb *sandbox) require(L *glua.LState, env, loaded *glua.LTable) glua.LValue {
+	return L.NewFunction(func(L *glua.LState) int {
+		name := L.CheckString(1)
+
+		if v := loaded.RawGetString(name); v != glua.LNil {
+			L.Push(v)
+			return 1
+		}
+
+		if !sandboxModuleRegexp.MatchString(name) {
+			L.ArgError(1, "Invalid module name")
+			return 0
+		}
+
+		proto, err := sandboxModule(name)
+		if err != nil {
+			L.RaiseError("Cannot load module %v: %v", name, err)
+			return 0
+		}
+
+		module := L.NewFunctionFromProto(proto)
+		module.Env = env
+
+		L.Push(module)
+		L.Push(glua.LString(name))
+		L.Call(1, 1)
+
+		ret := L.Get(-1)
+		L.Pop(1)
+
+		if ret == glua.LNil {
+			ret = glua.LTrue
+		}
+
+		loaded.RawSetString(name, ret)
+		L.Push(ret)
+
+		return 1
+	})
+}
+
+// sandboxModule compiles the given engine module
+func sandboxModule(name string) (*glua.FunctionProto, error) {
+	f, err := osext.ExecutableFolder()
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(f, "engine", filepath.FromSlash(strings.Replace(name, ".", "/", -1))+".lua")
+
+	proto, err := CompileLua(path)
+	if err != nil {
+		return nil, errors.New("module not found")
+	}
+
+	return proto, nil
+}
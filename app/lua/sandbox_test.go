@@ -0,0 +1,53 @@
+package lua
+
+import (
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/raggaer/castro/app/util"
+	glua "github.com/yuin/gopher-lua"
+)
+
+func TestSandboxCache(t *testing.T) {
+	setTestConfig()
+	util.Cache = cache.New(time.Minute, time.Minute)
+	util.Cache.Set("captcha_1", "answer", time.Minute)
+
+	sb, err := newSandbox("news", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	L := NewState()
+	defer L.Close()
+
+	sb.apply(L)
+
+	if err := L.DoString(`
+	cache:set("key", "value")
+	return cache:get("captcha_1"), cache:get("key")
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := L.Get(-2); v != glua.LNil {
+		t.Errorf("extension read an application cache key: %v", v)
+	}
+
+	if v := L.Get(-1); v.String() != "value" {
+		t.Errorf("extension cache key = %v, expected value", v)
+	}
+
+	if v, found := util.Cache.Get("ext:news:key"); !found || v != "value" {
+		t.Errorf("extension key stored as %v %v, expected ext:news:key", v, found)
+	}
+
+	if err := L.DoString(`cache:delete("captcha_1")`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := util.Cache.Get("captcha_1"); !found {
+		t.Error("extension deleted an application cache key")
+	}
+}
@@ -1,8 +1,11 @@
 package lua
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 
+	"github.com/raggaer/castro/app/database"
 	"github.com/raggaer/castro/app/models"
 	"github.com/raggaer/castro/app/util"
 	"github.com/yuin/gopher-lua"
@@ -19,18 +22,18 @@ func SetSessionMetaTable(luaState *lua.LState) {
 }
 
 // SetSessionMetaTableUserData sets the session metatable user data
-func SetSessionMetaTableUserData(luaState *lua.LState, sessionData map[string]interface{}) {
+func SetSessionMetaTableUserData(luaState *lua.LState, session *util.Session) {
 	// Get session metatable
 	jwtMetaTable := luaState.GetTypeMetatable(SessionMetaTable)
 
 	// Set session field
 	sess := luaState.NewUserData()
-	sess.Value = sessionData
+	sess.Value = session
 	luaState.SetField(jwtMetaTable, SessionInstanceName, sess)
 }
 
-// getSessionData gets the user data struct from the session metatable and returns the session pointer
-func getSessionData(L *lua.LState) map[string]interface{} {
+// getSession gets the user data struct from the session metatable and returns the session pointer
+func getSession(L *lua.LState) *util.Session {
 	// Get metatable
 	meta := L.GetTypeMetatable(SessionMetaTable)
 
@@ -38,31 +41,47 @@ func getSessionData(L *lua.LState) map[string]interface{} {
 	data := L.GetField(meta, SessionInstanceName).(*lua.LUserData)
 
 	// Return session struct
-	return data.Value.(map[string]interface{})
+	return data.Value.(*util.Session)
 }
 
-// updateSessionData saves a new cookie with the encoded map
+// getSessionData returns the data map of the current session
+func getSessionData(L *lua.LState) map[string]interface{} {
+	return getSession(L).Data
+}
+
+// updateSessionData saves the session and sets the session cookie
 func updateSessionData(L *lua.LState) {
 	// Get response writer from state
 	_, w := getRequestAndResponseWriter(L)
 
 	// Get session
-	session := getSessionData(L)
+	session := getSession(L)
 
-	// Encode session map
-	encoded, err := util.SessionStore.Encode(util.Config.Configuration.Cookies.Name, session)
-
-	if err != nil {
-		util.Logger.Logger.Fatalf("Cannot encode cookie value: %v", err)
+	// Save session. The session id changes when an account logs in
+	if err := session.Save(); err != nil {
+		L.RaiseError("Cannot save session: %v", err)
+		return
 	}
 
 	// Create cookie
-	c := util.SessionCookie(encoded)
+	c, err := session.Cookie()
+
+	if err != nil {
+		L.RaiseError("Cannot encode cookie value: %v", err)
+		return
+	}
 
 	// Set cookie
 	http.SetCookie(w, c)
 }
 
+// sessionHandle returns the public identifier of a session. Session ids are
+// never exposed to lua
+func sessionHandle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
 // GetLoggedAccount gets the user account if any
 func GetLoggedAccount(L *lua.LState) int {
 	// Get session data from the user data field
@@ -159,13 +178,59 @@ func IsAdmin(L *lua.LState) int {
 	return 1
 }
 
-// DestroySession removes the session data from the database
+// loggedAccountPermissions returns the permissions of the logged account. Returns
+// an empty list if there is no logged account
+func loggedAccountPermissions(L *lua.LState) []string {
+	// Get logged account name
+	accountName := getSession(L).LoggedAccount()
+
+	if accountName == "" {
+		return []string{}
+	}
+
+	// Get account id
+	var id int64
+	if err := database.DB.Get(&id, "SELECT id FROM accounts WHERE name = ?", accountName); err != nil {
+		L.RaiseError("Cannot get account by name: %v", err)
+		return nil
+	}
+
+	// Get account permissions
+	permissions, err := models.GetAccountPermissions(id)
+
+	if err != nil {
+		L.RaiseError("Cannot get account permissions: %v", err)
+		return nil
+	}
+
+	return permissions
+}
+
+// Can checks if the logged account has the given permission
+func Can(L *lua.LState) int {
+	// Get permission name
+	permission := L.CheckString(2)
+
+	// Push permission status
+	L.Push(lua.LBool(models.HasPermission(loggedAccountPermissions(L), permission)))
+
+	return 1
+}
+
+// GetPermissions returns the permissions of the logged account
+func GetPermissions(L *lua.LState) int {
+	L.Push(StringSliceToTable(loggedAccountPermissions(L)))
+
+	return 1
+}
+
+// DestroySession removes the session data and gives the session a new id
 func DestroySession(L *lua.LState) int {
-	// Get session data from the user data field
-	session := getSessionData(L)
+	// Get session
+	session := getSession(L)
 
 	// Loop map
-	for key := range session {
+	for key := range session.Data {
 
 		// Omit issuer element
 		if key == "issuer" || key == "csrf-token" {
@@ -173,7 +238,13 @@ func DestroySession(L *lua.LState) int {
 		}
 
 		// Delete each element
-		delete(session, key)
+		delete(session.Data, key)
+	}
+
+	// Remove the old session from the storage
+	if err := session.Regenerate(); err != nil {
+		L.RaiseError("Cannot regenerate session: %v", err)
+		return 0
 	}
 
 	// Update session data
@@ -182,6 +253,162 @@ func DestroySession(L *lua.LState) int {
 	return 0
 }
 
+// ListSessions returns the sessions of the logged account
+func ListSessions(L *lua.LState) int {
+	// Get session
+	session := getSession(L)
+
+	// Check if user is logged
+	account := session.LoggedAccount()
+
+	if account == "" {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	// Get account sessions
+	list, err := util.Sessions.List(account)
+
+	if err != nil {
+		L.RaiseError("Cannot get session list: %v", err)
+		return 0
+	}
+
+	// Result holder
+	results := L.NewTable()
+
+	for _, s := range list {
+		t := L.NewTable()
+
+		t.RawSetString("id", lua.LString(sessionHandle(s.ID)))
+		t.RawSetString("ip", lua.LString(s.IP))
+		t.RawSetString("userAgent", lua.LString(s.UserAgent))
+		t.RawSetString("createdAt", lua.LNumber(s.CreatedAt.Unix()))
+		t.RawSetString("lastSeen", lua.LNumber(s.LastSeen.Unix()))
+		t.RawSetString("current", lua.LBool(s.ID == session.ID))
+
+		results.Append(t)
+	}
+
+	L.Push(results)
+
+	return 1
+}
+
+// RevokeSession removes the given session of the logged account
+func RevokeSession(L *lua.LState) int {
+	// Get session identifier
+	handle := L.CheckString(2)
+
+	// Get session
+	session := getSession(L)
+
+	// Check if user is logged
+	account := session.LoggedAccount()
+
+	if account == "" {
+		L.Push(lua.LBool(false))
+		return 1
+	}
+
+	// Get account sessions
+	list, err := util.Sessions.List(account)
+
+	if err != nil {
+		L.RaiseError("Cannot get session list: %v", err)
+		return 0
+	}
+
+	for _, s := range list {
+		if sessionHandle(s.ID) != handle {
+			continue
+		}
+
+		// Remove session
+		if err := util.Sessions.Delete(s.ID); err != nil {
+			L.RaiseError("Cannot revoke session: %v", err)
+			return 0
+		}
+
+		L.Push(lua.LBool(true))
+		return 1
+	}
+
+	L.Push(lua.LBool(false))
+
+	return 1
+}
+
+// RevokeOtherSessions removes all the sessions of the logged account except the current one
+func RevokeOtherSessions(L *lua.LState) int {
+	// Get session
+	session := getSession(L)
+
+	// Check if user is logged
+	account := session.LoggedAccount()
+
+	if account == "" {
+		L.Push(lua.LNumber(0))
+		return 1
+	}
+
+	// Get account sessions
+	list, err := util.Sessions.List(account)
+
+	if err != nil {
+		L.RaiseError("Cannot get session list: %v", err)
+		return 0
+	}
+
+	// Revoked sessions counter
+	revoked := 0
+
+	for _, s := range list {
+		if s.ID == session.ID {
+			continue
+		}
+
+		// Remove session
+		if err := util.Sessions.Delete(s.ID); err != nil {
+			L.RaiseError("Cannot revoke session: %v", err)
+			return 0
+		}
+
+		revoked++
+	}
+
+	L.Push(lua.LNumber(revoked))
+
+	return 1
+}
+
+// RevokeAccountSessions removes all the sessions of the given account
+func RevokeAccountSessions(L *lua.LState) int {
+	// Get account name
+	account := L.CheckString(2)
+
+	// Get account sessions
+	list, err := util.Sessions.List(account)
+
+	if err != nil {
+		L.RaiseError("Cannot get session list: %v", err)
+		return 0
+	}
+
+	for _, s := range list {
+
+		// Remove session
+		if err := util.Sessions.Delete(s.ID); err != nil {
+			L.RaiseError("Cannot revoke session: %v", err)
+			return 0
+		}
+	}
+
+	L.Push(lua.LNumber(len(list)))
+
+	return 1
+}
+
 // SetSessionData saves an item to the session map
 func SetSessionData(L *lua.LState) int {
 	// Get key
@@ -219,11 +446,8 @@ func SetSessionData(L *lua.LState) int {
 
 	case *lua.LTable:
 
-		// Convert table to map
-		m := TableToMap(val.(*lua.LTable))
-
-		// Assign element as map
-		session[key.String()] = m
+		// Convert table to map or slice
+		session[key.String()] = ValueToGo(lv)
 	}
 
 	// Update session data
@@ -269,13 +493,10 @@ func GetSessionData(L *lua.LState) int {
 
 		// Push element as boolean
 		L.Push(lua.LBool(val.(bool)))
-	case map[string]interface{}:
-
-		// Convert map to lua table
-		tble := MapToTable(val.(map[string]interface{}))
+	case map[string]interface{}, []interface{}:
 
 		// Push element as table
-		L.Push(tble)
+		L.Push(GoToValue(val))
 	default:
 		L.Push(lua.LNil)
 	}
@@ -343,13 +564,10 @@ func GetFlash(L *lua.LState) int {
 
 		// Push element as boolean
 		L.Push(lua.LBool(val.(bool)))
-	case map[string]interface{}:
-
-		// Convert map to lua table
-		tble := MapToTable(val.(map[string]interface{}))
+	case map[string]interface{}, []interface{}:
 
 		// Push element as table
-		L.Push(tble)
+		L.Push(GoToValue(val))
 	default:
 		L.Push(lua.LNil)
 		return 1
@@ -402,11 +620,8 @@ func SetFlash(L *lua.LState) int {
 
 	case *lua.LTable:
 
-		// Convert table to map
-		m := TableToMap(val.(*lua.LTable))
-
-		// Assign element as map
-		session[key.String()] = m
+		// Convert table to map or slice
+		session[key.String()] = ValueToGo(lv)
 	}
 
 	// Update session data
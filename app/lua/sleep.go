@@ -16,8 +16,25 @@ func ThreadSleep(L *lua.LState) int {
 		return 0
 	}
 
-	// Sleep goroutine
-	time.Sleep(duration)
+	// Get state context
+	ctx := L.Context()
+
+	// Sleep goroutine if the state has no context
+	if ctx == nil {
+		time.Sleep(duration)
+		return 0
+	}
+
+	// Create sleep timer
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	// Wait for the timer or the state context
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		L.RaiseError(ctx.Err().Error())
+	}
 
 	return 0
 }
@@ -0,0 +1,94 @@
+package lua
+
+import (
+	glua "github.com/yuin/gopher-lua"
+)
+
+// stateSnapshot holds the contents of the global and registry tables of a lua state
+// so the state can be restored to its original form once a request is done
+type stateSnapshot struct {
+	tables     map[*glua.LTable]map[glua.LValue]glua.LValue
+	metatables map[*glua.LTable]glua.LValue
+}
+
+// newStateSnapshot saves the current globals and metatables of the given state
+func newStateSnapshot(state *glua.LState) *stateSnapshot {
+	s := &stateSnapshot{
+		tables:     map[*glua.LTable]map[glua.LValue]glua.LValue{},
+		metatables: map[*glua.LTable]glua.LValue{},
+	}
+
+	// Save global table and every table reachable from it (metatables, libraries, app table)
+	s.save(state.G.Global)
+
+	// Save registry table and all the registered type metatables
+	s.save(state.G.Registry)
+
+	return s
+}
+
+// save copies the fields of the given table and of every table found as a key,
+// value or metatable. Tables that were already saved are skipped so cycles end
+func (s *stateSnapshot) save(tbl *glua.LTable) {
+	// Skip already saved tables
+	if _, ok := s.tables[tbl]; ok {
+		return
+	}
+
+	// Fields holder
+	fields := map[glua.LValue]glua.LValue{}
+	s.tables[tbl] = fields
+	s.metatables[tbl] = tbl.Metatable
+
+	// Loop table fields
+	tbl.ForEach(func(key glua.LValue, value glua.LValue) {
+		fields[key] = value
+	})
+
+	// Save nested tables
+	for key, value := range fields {
+		if t, ok := key.(*glua.LTable); ok {
+			s.save(t)
+		}
+		if t, ok := value.(*glua.LTable); ok {
+			s.save(t)
+		}
+	}
+
+	if t, ok := tbl.Metatable.(*glua.LTable); ok {
+		s.save(t)
+	}
+}
+
+// restore removes any field created after the snapshot was taken and
+// sets back the original value of every saved field
+func (s *stateSnapshot) restore(state *glua.LState) {
+	// Clean the state stack
+	state.SetTop(0)
+
+	// Loop saved tables
+	for tbl, fields := range s.tables {
+
+		// Gather keys that were not present when the snapshot was taken
+		keys := []glua.LValue{}
+
+		tbl.ForEach(func(key glua.LValue, value glua.LValue) {
+			if _, ok := fields[key]; !ok {
+				keys = append(keys, key)
+			}
+		})
+
+		// Remove new keys
+		for _, key := range keys {
+			tbl.RawSet(key, glua.LNil)
+		}
+
+		// Restore original values
+		for key, value := range fields {
+			tbl.RawSet(key, value)
+		}
+
+		// Restore original metatable
+		tbl.Metatable = s.metatables[tbl]
+	}
+}
@@ -16,27 +16,33 @@ import (
 var (
 	// WidgetList list of widget states
 	WidgetList = &stateList{
-		List: make(map[string][]*glua.LState),
-		Type: "widget",
+		List:       make(map[string][]*glua.LState),
+		snapshots:  make(map[*glua.LState]*stateSnapshot),
+		extensions: make(map[string]string),
+		Type:       "widget",
 	}
 
 	// CompiledPageList list of compiled subtopic states
 	CompiledPageList = &compiledStateList{
-		List: make(map[string]*glua.FunctionProto),
-		Type: "page",
+		List:       make(map[string]*glua.FunctionProto),
+		extensions: make(map[string]string),
+		Type:       "page",
 	}
 )
 
 type compiledStateList struct {
-	rw   sync.Mutex
-	List map[string]*glua.FunctionProto
-	Type string
+	rw         sync.Mutex
+	List       map[string]*glua.FunctionProto
+	extensions map[string]string
+	Type       string
 }
 
 type stateList struct {
-	rw   sync.Mutex
-	List map[string][]*glua.LState
-	Type string
+	rw         sync.Mutex
+	List       map[string][]*glua.LState
+	snapshots  map[*glua.LState]*stateSnapshot
+	extensions map[string]string
+	Type       string
 }
 
 // Exists checks if a proto path exists
@@ -76,6 +82,7 @@ func (s *compiledStateList) CompileFiles(dir string) error {
 		return err
 	}
 	s.List = files
+	s.extensions = make(map[string]string)
 	return nil
 }
 
@@ -125,6 +132,7 @@ func (s *compiledStateList) CompileExtensions(extType string) error {
 
 				// Add to the list
 				s.List[path] = proto
+				s.extensions[path] = extensionID
 			}
 			return nil
 		})
@@ -135,6 +143,11 @@ func (s *compiledStateList) CompileExtensions(extType string) error {
 	return nil
 }
 
+// Extension returns the identifier of the extension that owns the given proto path
+func (s *compiledStateList) Extension(path string) string {
+	return s.extensions[strings.ToLower(path)]
+}
+
 // Get retrieves a compiled lua function proto
 func (s *compiledStateList) Get(path string) (*glua.FunctionProto, error) {
 	path = strings.ToLower(path)
@@ -154,6 +167,8 @@ func (s *stateList) Load(dir string) error {
 
 	// Set list
 	s.List = make(map[string][]*glua.LState)
+	s.snapshots = make(map[*glua.LState]*stateSnapshot)
+	s.extensions = make(map[string]string)
 
 	// Get subtopic list
 	subtopicList, err := util.GetLuaFiles(dir)
@@ -166,15 +181,15 @@ func (s *stateList) Load(dir string) error {
 	for _, subtopic := range subtopicList {
 
 		// Create state
-		state := glua.NewState()
-
-		// Set castro metatables
-		GetApplicationState(state)
+		state := NewState()
 
 		if err := state.DoFile(subtopic); err != nil {
 			return err
 		}
 
+		// Save state snapshot
+		s.snapshots[state] = newStateSnapshot(state)
+
 		// Set lowercase path
 		path := strings.ToLower(subtopic)
 
@@ -193,6 +208,7 @@ func (s *stateList) LoadExtensions() error {
 
 	// Set list
 	s.List = make(map[string][]*glua.LState)
+	s.extensions = make(map[string]string)
 
 	// Set extension type
 	extType := s.Type + "s"
@@ -237,11 +253,8 @@ func (s *stateList) LoadExtensions() error {
 		// Loop subtopic list
 		for _, subtopic := range subtopicList {
 
-			// Create state
-			state := glua.NewState()
-
-			// Set castro metatables
-			GetApplicationState(state)
+			// Create state restricted to the extension capabilities
+			state := NewExtensionState(extensionID)
 
 			if err := state.DoFile(subtopic); err != nil {
 				if extType == "widgets" {
@@ -255,11 +268,15 @@ func (s *stateList) LoadExtensions() error {
 				return fmt.Errorf("extension: %v %v", extensionID, err.Error())
 			}
 
+			// Save state snapshot
+			s.snapshots[state] = newStateSnapshot(state)
+
 			// Set lowercase path
 			path := strings.ToLower(strings.Replace(subtopic, dir, extType, -1))
 
 			// Add state to the pool
 			s.List[path] = append(s.List[path], state)
+			s.extensions[path] = extensionID
 		}
 	}
 
@@ -278,15 +295,15 @@ func (s *stateList) Get(path string) (*glua.LState, error) {
 	if len(s.List[path]) == 0 {
 
 		// Create new state
-		state := glua.NewState()
-
-		// Set castro metatables
-		GetApplicationState(state)
+		state := NewExtensionState(s.extensions[path])
 
 		if err := state.DoFile(path); err != nil {
 			return nil, err
 		}
 
+		// Save state snapshot
+		s.snapshots[state] = newStateSnapshot(state)
+
 		return state, nil
 	}
 
@@ -306,8 +323,23 @@ func (s *stateList) Put(state *glua.LState, path string) {
 	s.rw.Lock()
 	defer s.rw.Unlock()
 
-	// Remove database transaction status
-	state.SetField(state.GetTypeMetatable(DatabaseMetaTableName), DatabaseTransactionStatusFieldName, glua.LBool(false))
+	// Revert unfinished transactions
+	rollbackStateTransaction(state)
+
+	// Remove state execution context
+	state.RemoveContext()
+
+	// States that share their globals with a running background event are not reused
+	if hasBackgroundEvents(state) {
+		delete(s.snapshots, state)
+		state.Close()
+		return
+	}
+
+	// Reset state globals and metatables
+	if snapshot, ok := s.snapshots[state]; ok {
+		snapshot.restore(state)
+	}
 
 	// Save state
 	s.List[path] = append(s.List[path], state)
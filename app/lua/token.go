@@ -0,0 +1,128 @@
+package lua
+
+import (
+	"time"
+
+	"github.com/raggaer/castro/app/util"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// SetTokenMetaTable sets the token metatable on the given lua state
+func SetTokenMetaTable(luaState *lua.LState) {
+	// Create and set token metatable
+	tokenMetaTable := luaState.NewTypeMetatable(TokenMetaTableName)
+	luaState.SetGlobal(TokenMetaTableName, tokenMetaTable)
+
+	// Set all token metatable functions
+	luaState.SetFuncs(tokenMetaTable, tokenMethods)
+}
+
+// tokenToTable converts a token to a lua table
+func tokenToTable(t *util.Token) *lua.LTable {
+	tbl := &lua.LTable{}
+
+	tbl.RawSetString("purpose", lua.LString(t.Purpose))
+	tbl.RawSetString("subject", lua.LString(t.Subject))
+	tbl.RawSetString("data", lua.LString(t.Data))
+	tbl.RawSetString("expiresAt", lua.LNumber(t.ExpiresAt))
+
+	return tbl
+}
+
+// IssueToken creates a signed single-use token. The lifetime can be a number
+// of seconds or a duration string and defaults to one hour
+func IssueToken(L *lua.LState) int {
+	// Get purpose and subject
+	purpose := L.CheckString(2)
+	subject := lua.LVAsString(L.CheckAny(3))
+
+	// Get lifetime
+	ttl := time.Hour
+
+	switch v := L.Get(4).(type) {
+	case lua.LNumber:
+		ttl = time.Duration(v) * time.Second
+	case lua.LString:
+		if v != "" {
+			d, err := time.ParseDuration(string(v))
+			if err != nil {
+				L.ArgError(4, "Invalid token lifetime")
+				return 0
+			}
+			ttl = d
+		}
+	}
+
+	// Issue token
+	token, err := util.IssueToken(purpose, subject, L.OptString(5, ""), ttl)
+
+	if err != nil {
+		L.RaiseError("Cannot issue token: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LString(token))
+
+	return 1
+}
+
+// VerifyToken checks a token without using it. Returns nil if the token is not valid
+func VerifyToken(L *lua.LState) int {
+	// Get token and purpose
+	value := L.CheckString(2)
+	purpose := L.CheckString(3)
+
+	// Verify token
+	t, err := util.VerifyToken(value, purpose)
+
+	if err == util.ErrInvalidToken {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	if err != nil {
+		L.RaiseError("Cannot verify token: %v", err)
+		return 0
+	}
+
+	L.Push(tokenToTable(t))
+
+	return 1
+}
+
+// ConsumeToken checks a token and marks it as used. Returns nil if the token is not valid
+func ConsumeToken(L *lua.LState) int {
+	// Get token and purpose
+	value := L.CheckString(2)
+	purpose := L.CheckString(3)
+
+	// Consume token
+	t, err := util.ConsumeToken(value, purpose)
+
+	if err == util.ErrInvalidToken {
+		L.Push(lua.LNil)
+		return 1
+	}
+
+	if err != nil {
+		L.RaiseError("Cannot consume token: %v", err)
+		return 0
+	}
+
+	L.Push(tokenToTable(t))
+
+	return 1
+}
+
+// RevokeTokens removes every unused token of the given purpose and subject
+func RevokeTokens(L *lua.LState) int {
+	// Get purpose and subject
+	purpose := L.CheckString(2)
+	subject := lua.LVAsString(L.CheckAny(3))
+
+	if err := util.RevokeTokens(purpose, subject); err != nil {
+		L.RaiseError("Cannot revoke tokens: %v", err)
+	}
+
+	return 0
+}
@@ -0,0 +1,18 @@
+package lua
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIssueTokenLifetimeArgument(t *testing.T) {
+	setTestConfig()
+
+	L := NewState()
+	defer L.Close()
+
+	err := L.DoString(`token:issue("verify", 1, "soon")`)
+	if err == nil || !strings.Contains(err.Error(), "#4") {
+		t.Errorf("expected an error on the lifetime argument, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package lua
+
+import (
+	"github.com/raggaer/castro/app/database"
+	"github.com/raggaer/castro/app/models"
+	"github.com/raggaer/castro/app/util"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// SetTwoFactorMetaTable sets the twofa metatable on the given lua state
+func SetTwoFactorMetaTable(luaState *lua.LState) {
+	// Create and set twofa metatable
+	twofaMetaTable := luaState.NewTypeMetatable(TwoFactorMetaTableName)
+	luaState.SetGlobal(TwoFactorMetaTableName, twofaMetaTable)
+
+	// Set all twofa metatable functions
+	luaState.SetFuncs(twofaMetaTable, twofaMethods)
+}
+
+// GenerateRecoveryCodes replaces the recovery codes of the given account and
+// returns the new codes
+func GenerateRecoveryCodes(L *lua.LState) int {
+	// Get account id
+	id := L.CheckInt64(2)
+
+	// Get number of codes
+	count := util.Config.Configuration.TwoFactor.RecoveryCodes
+	if count == 0 {
+		count = 10
+	}
+
+	// Generate codes
+	codes, err := models.GenerateRecoveryCodes(id, count)
+
+	if err != nil {
+		L.RaiseError("Cannot generate recovery codes: %v", err)
+		return 0
+	}
+
+	L.Push(StringSliceToTable(codes))
+
+	return 1
+}
+
+// UseRecoveryCode marks the given recovery code of an account as used
+func UseRecoveryCode(L *lua.LState) int {
+	// Get account id and code
+	id := L.CheckInt64(2)
+	code := L.CheckString(3)
+
+	// Use code
+	valid, err := models.UseRecoveryCode(id, code)
+
+	if err != nil {
+		L.RaiseError("Cannot use recovery code: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LBool(valid))
+
+	return 1
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes of an account
+func RemainingRecoveryCodes(L *lua.LState) int {
+	// Get account id
+	id := L.CheckInt64(2)
+
+	// Count codes
+	n, err := models.RemainingRecoveryCodes(id)
+
+	if err != nil {
+		L.RaiseError("Cannot count recovery codes: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LNumber(n))
+
+	return 1
+}
+
+// DisableTwoFactor removes the two-factor secret and recovery codes of an account
+func DisableTwoFactor(L *lua.LState) int {
+	// Get account id
+	id := L.CheckInt64(2)
+
+	if err := models.DisableTwoFactor(id); err != nil {
+		L.RaiseError("Cannot disable two-factor: %v", err)
+	}
+
+	return 0
+}
+
+// TwoFactorRequired checks if the logged account must enable two-factor before
+// using the admin pages
+func TwoFactorRequired(L *lua.LState) int {
+	// Get logged account name
+	accountName := getSession(L).LoggedAccount()
+
+	if accountName == "" || !util.Config.Configuration.TwoFactor.RequireStaff {
+		L.Push(lua.LBool(false))
+		return 1
+	}
+
+	// Get account id
+	var id int64
+	if err := database.DB.Get(&id, "SELECT id FROM accounts WHERE name = ?", accountName); err != nil {
+		L.RaiseError("Cannot get account by name: %v", err)
+		return 0
+	}
+
+	required, err := models.TwoFactorRequired(id)
+
+	if err != nil {
+		L.RaiseError("Cannot check two-factor policy: %v", err)
+		return 0
+	}
+
+	L.Push(lua.LBool(required))
+
+	return 1
+}
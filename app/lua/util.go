@@ -24,89 +24,80 @@ func MapToTable(m map[string]interface{}) *lua.LTable {
 
 	// Loop map
 	for key, element := range m {
+		resultTable.RawSetString(key, GoToValue(element))
+	}
 
-		switch element.(type) {
-		case float64:
-			resultTable.RawSetString(key, lua.LNumber(element.(float64)))
-		case int64:
-			resultTable.RawSetString(key, lua.LNumber(element.(int64)))
-		case string:
-			resultTable.RawSetString(key, lua.LString(element.(string)))
-		case bool:
-			resultTable.RawSetString(key, lua.LBool(element.(bool)))
-		case []byte:
-			resultTable.RawSetString(key, lua.LString(string(element.([]byte))))
-		case map[string]interface{}:
-
-			// Get table from map
-			tble := MapToTable(element.(map[string]interface{}))
-
-			resultTable.RawSetString(key, tble)
-
-		case time.Time:
-			resultTable.RawSetString(key, lua.LNumber(element.(time.Time).Unix()))
-
-		case []map[string]interface{}:
-
-			// Create slice table
-			sliceTable := &lua.LTable{}
-
-			// Loop element
-			for _, s := range element.([]map[string]interface{}) {
-
-				// Get table from map
-				tble := MapToTable(s)
-
-				sliceTable.Append(tble)
-			}
-
-			// Set slice table
-			resultTable.RawSetString(key, sliceTable)
-
-		case []interface{}:
-
-			// Create slice table
-			sliceTable := &lua.LTable{}
-
-			// Loop interface slice
-			for _, s := range element.([]interface{}) {
-
-				// Switch interface type
-				switch s.(type) {
-				case map[string]interface{}:
-
-					// Convert map to table
-					t := MapToTable(s.(map[string]interface{}))
-
-					// Append result
-					sliceTable.Append(t)
-
-				case float64:
+	return resultTable
+}
 
-					// Append result as number
-					sliceTable.Append(lua.LNumber(s.(float64)))
+// SliceToTable converts a Go slice to a lua sequence
+func SliceToTable(s []interface{}) *lua.LTable {
+	// Main table pointer
+	resultTable := &lua.LTable{}
 
-				case string:
+	// Loop slice keeping the element positions
+	for i, element := range s {
+		resultTable.RawSetInt(i+1, GoToValue(element))
+	}
 
-					// Append result as string
-					sliceTable.Append(lua.LString(s.(string)))
+	return resultTable
+}
 
-				case bool:
+// GoToValue converts a Go value to a lua value. Slices are converted to lua sequences
+// and maps to lua tables, unsupported values are converted to nil
+func GoToValue(element interface{}) lua.LValue {
+	switch v := element.(type) {
+	case nil:
+		return lua.LNil
+	case lua.LValue:
+		return v
+	case float64:
+		return lua.LNumber(v)
+	case float32:
+		return lua.LNumber(v)
+	case int:
+		return lua.LNumber(v)
+	case int32:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case uint32:
+		return lua.LNumber(v)
+	case uint64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case bool:
+		return lua.LBool(v)
+	case []byte:
+		return lua.LString(string(v))
+	case time.Time:
+		return lua.LNumber(v.Unix())
+	case map[string]interface{}:
+		return MapToTable(v)
+	case []interface{}:
+		return SliceToTable(v)
+	case []map[string]interface{}:
+
+		// Create slice table
+		sliceTable := &lua.LTable{}
+
+		// Loop element
+		for _, m := range v {
+			sliceTable.Append(MapToTable(m))
+		}
 
-					// Append result as bool
-					sliceTable.Append(lua.LBool(s.(bool)))
-				}
-			}
+		return sliceTable
 
-			// Append to main table
-			resultTable.RawSetString(key, sliceTable)
-		}
+	case []string:
+		return StringSliceToTable(v)
+	default:
+		return lua.LNil
 	}
-
-	return resultTable
 }
 
-// TableToMap converts a LUA table to a Go map[string]interface{}
+// TableToMap converts a LUA table to a Go map[string]interface{}. Nested
+// sequences are converted to []interface{} keeping their order
 func TableToMap(table *lua.LTable) map[string]interface{} {
 	// Check for valid table
 	if table == nil {
@@ -119,56 +110,60 @@ func TableToMap(table *lua.LTable) map[string]interface{} {
 	// Loop lua table
 	table.ForEach(func(i lua.LValue, v lua.LValue) {
 
-		// Get string index
-		index := i.String()
-
-		// Switch value type
-		switch lv := v.(type) {
-		case *lua.LTable:
-
-			// Get current table
-			tbl := v.(*lua.LTable)
-
-			// Check for lua table
-			if tbl.MaxN() == 0 {
-
-				// Convert table to map
-				n := TableToMap(tbl)
-				m[index] = n
-
-			} else {
-
-				// Data holder
-				ret := make([]interface{}, 0, tbl.MaxN())
+		// Convert value skipping unsupported types
+		if value := ValueToGo(v); value != nil {
+			m[fmt.Sprint(ValueToGo(i))] = value
+		}
+	})
+	return m
+}
 
-				// Loop table
-				tbl.ForEach(func(i lua.LValue, v lua.LValue) {
+// TableToSlice converts a lua sequence to a Go []interface{}. The fields
+// that are not part of the sequence are ignored
+func TableToSlice(table *lua.LTable) []interface{} {
+	// Data holder
+	ret := []interface{}{}
 
-					// Append to array
-					ret = append(ret, ValueToGo(v))
-				})
+	// Check for valid table
+	if table == nil {
+		return ret
+	}
 
-				// Set array
-				m[index] = ret
-			}
+	// Loop sequence until the first nil element
+	for i := 1; table.RawGetInt(i) != lua.LNil; i++ {
+		ret = append(ret, ValueToGo(table.RawGetInt(i)))
+	}
 
-		case lua.LNumber:
+	return ret
+}
 
-			// Convert to number to float64
-			m[index] = float64(lv)
+// IsSequence checks if all the keys of the given table are consecutive
+// integers starting at one. Empty tables are not sequences
+func IsSequence(table *lua.LTable) bool {
+	count := 0
+	sequence := true
 
-		case lua.LBool:
+	// Loop table keys
+	table.ForEach(func(key lua.LValue, _ lua.LValue) {
+		count++
 
-			// Convert value to boolean
-			m[index] = bool(lv)
+		if n, ok := key.(lua.LNumber); !ok || float64(n) != float64(int(n)) || n < 1 {
+			sequence = false
+		}
+	})
 
-		case lua.LString:
+	if !sequence || count == 0 {
+		return false
+	}
 
-			// Convert value to string
-			m[index] = string(lv)
+	// Integer keys must have no gaps
+	for i := 1; i <= count; i++ {
+		if table.RawGetInt(i) == lua.LNil {
+			return false
 		}
-	})
-	return m
+	}
+
+	return true
 }
 
 // URLValuesToTable converts a map[string][]string to a lua table
@@ -242,7 +237,8 @@ func TableToURLValues(t *lua.LTable) url.Values {
 	return m
 }
 
-// ValueToGo converts a lua value to a go type
+// ValueToGo converts a lua value to a go type. Sequences are converted to
+// []interface{} and any other table to map[string]interface{}
 func ValueToGo(lv lua.LValue) interface{} {
 	switch v := lv.(type) {
 	case *lua.LNilType:
@@ -254,23 +250,11 @@ func ValueToGo(lv lua.LValue) interface{} {
 	case lua.LNumber:
 		return float64(v)
 	case *lua.LTable:
-		maxn := v.MaxN()
-		if maxn == 0 { // table
-			ret := make(map[string]interface{})
-			v.ForEach(func(key, value lua.LValue) {
-				keystr := fmt.Sprint(ValueToGo(key))
-				ret[keystr] = ValueToGo(value)
-			})
-			return ret
-		}
-
-		ret := make([]interface{}, 0, maxn)
-
-		for i := 1; i <= maxn; i++ {
-			ret = append(ret, ValueToGo(v.RawGetInt(i)))
+		if IsSequence(v) {
+			return TableToSlice(v)
 		}
 
-		return ret
+		return TableToMap(v)
 
 	default:
 		return nil
@@ -468,3 +452,19 @@ func TableToStruct(table *lua.LTable, dst interface{}) {
 		}
 	}
 }
+
+// stateUserData returns the value of the given metatable user data field or nil
+// if the state does not have it
+func stateUserData(L *lua.LState, metatable, field string) interface{} {
+	meta, ok := L.GetTypeMetatable(metatable).(*lua.LTable)
+	if !ok {
+		return nil
+	}
+
+	data, ok := meta.RawGetString(field).(*lua.LUserData)
+	if !ok {
+		return nil
+	}
+
+	return data.Value
+}
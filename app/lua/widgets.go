@@ -1,6 +1,7 @@
 package lua
 
 import (
+	"context"
 	"errors"
 	"html/template"
 	"net/http"
@@ -58,7 +59,7 @@ func RenderWidgetTemplate(L *lua.LState) int {
 	return 0
 }
 
-func compileWidgetList(req *http.Request, w http.ResponseWriter, sess map[string]interface{}) (map[string]template.HTML, error) {
+func compileWidgetList(req *http.Request, w http.ResponseWriter, sess *util.Session) (map[string]template.HTML, error) {
 	// Data holder
 	results := map[string]template.HTML{}
 
@@ -93,9 +94,26 @@ func compileWidgetList(req *http.Request, w http.ResponseWriter, sess map[string
 		// Set session user data
 		SetSessionMetaTableUserData(state, sess)
 
+		// Get widget execution timeout
+		timeout := ExecutionTimeout(filepath.ToSlash(filepath.Join("widgets", widget.Name)), widget.Extension)
+
+		// Create widget execution context
+		ctx, cancel := NewExecutionContext(req.Context(), timeout)
+
 		// Call widget function
-		if err := ExecuteControllerPage(state, "widget"); err != nil {
+		err = widget.Execute(ctx, state)
+
+		// Release context resources
+		cancel()
+
+		if err != nil {
 			WidgetList.Put(state, filepath.Join("widgets", widget.Name, widget.Name+".lua"))
+
+			// Log widget timeout
+			if ctx.Err() == context.DeadlineExceeded {
+				util.Logger.Logger.Errorf("Widget %v exceeded its execution time of %v", widget.Name, timeout)
+			}
+
 			return nil, err
 		}
 
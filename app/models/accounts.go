@@ -23,6 +23,9 @@ type CastroAccount struct {
 	AccountID int64
 	Points    int
 	Admin     bool
+
+	// Email_verified is set once the account confirms its email address
+	Email_verified bool
 }
 
 // GetAccountByName gets an account and its castro account by the account name
@@ -37,9 +40,16 @@ func GetAccountByName(name string) (Account, CastroAccount, error) {
 	}
 
 	// Get castro account from database
-	if err := database.DB.Get(&castroAccount, "SELECT id, points, admin FROM castro_accounts WHERE account_id = ?", account.ID); err != nil {
+	if err := database.DB.Get(&castroAccount, "SELECT id, points, email_verified FROM castro_accounts WHERE account_id = ?", account.ID); err != nil {
+		return account, castroAccount, err
+	}
+
+	// Accounts with the administrator permissions are admins
+	admin, err := AccountCan(account.ID, AllPermissions)
+	if err != nil {
 		return account, castroAccount, err
 	}
+	castroAccount.Admin = admin
 
 	return account, castroAccount, nil
 }
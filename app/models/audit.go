@@ -0,0 +1,205 @@
+package models
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raggaer/castro/app/database"
+)
+
+const (
+	// defaultAuditLimit number of audit entries returned when the filter has no limit
+	defaultAuditLimit = 50
+)
+
+// AuditEntry struct used for the admin audit log
+type AuditEntry struct {
+	ID         int64
+	Account_id int64
+	Account    string
+	Ip         string
+	Action     string
+	Target     string
+	Old_value  string
+	New_value  string
+	Created_at int64
+}
+
+// AuditFilter struct used to search the audit log. Empty fields are ignored
+type AuditFilter struct {
+	Account string
+	Action  string
+	Target  string
+	From    int64
+	To      int64
+	Limit   int
+	Offset  int
+}
+
+// CreateAuditTable creates the audit log table if it does not exist
+func CreateAuditTable() error {
+	return database.InstallTable(database.DB, "castro_audit_log")
+}
+
+// WriteAuditEntry records a privileged action. The old and new values are
+// saved as JSON, nil values are saved as an empty string
+func WriteAuditEntry(accountID int64, account, ip, action, target string, before, after interface{}) error {
+	oldValue, err := encodeAuditValue(before)
+	if err != nil {
+		return err
+	}
+
+	newValue, err := encodeAuditValue(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.DB.Exec(
+		"INSERT INTO castro_audit_log (account_id, account, ip, action, target, old_value, new_value, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		accountID,
+		account,
+		ip,
+		action,
+		target,
+		oldValue,
+		newValue,
+		time.Now().Unix(),
+	)
+
+	return err
+}
+
+// encodeAuditValue encodes an audit value as JSON
+func encodeAuditValue(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+
+	buff, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buff), nil
+}
+
+// where returns the WHERE clause and arguments of the filter. Actions ending
+// in .* match every action with the same prefix
+func (f AuditFilter) where() (string, []interface{}) {
+	conditions := []string{}
+	args := []interface{}{}
+
+	if f.Account != "" {
+		conditions = append(conditions, "account = ?")
+		args = append(args, f.Account)
+	}
+
+	if strings.HasSuffix(f.Action, ".*") {
+		conditions = append(conditions, "action LIKE ?")
+		args = append(args, strings.TrimSuffix(f.Action, "*")+"%")
+	} else if f.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, f.Action)
+	}
+
+	if f.Target != "" {
+		conditions = append(conditions, "target = ?")
+		args = append(args, f.Target)
+	}
+
+	if f.From > 0 {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.From)
+	}
+
+	if f.To > 0 {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, f.To)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// GetAuditLog returns the audit entries that match the filter, newest first
+func GetAuditLog(f AuditFilter) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+
+	where, args := f.where()
+
+	// Limit -1 returns every entry
+	limit := ""
+	if f.Limit == 0 {
+		f.Limit = defaultAuditLimit
+	}
+	if f.Limit > 0 {
+		limit = " LIMIT ? OFFSET ?"
+		args = append(args, f.Limit, f.Offset)
+	}
+
+	if err := database.DB.Select(
+		&entries,
+		"SELECT id, account_id, account, ip, action, target, old_value, new_value, created_at FROM castro_audit_log"+where+" ORDER BY id DESC"+limit,
+		args...,
+	); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// CountAuditLog returns the number of audit entries that match the filter
+func CountAuditLog(f AuditFilter) (int, error) {
+	count := 0
+
+	where, args := f.where()
+
+	if err := database.DB.Get(&count, "SELECT COUNT(*) FROM castro_audit_log"+where, args...); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// ExportAuditLog writes the given entries as csv or json
+func ExportAuditLog(w io.Writer, entries []*AuditEntry, format string) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(entries)
+	case "csv":
+		writer := csv.NewWriter(w)
+
+		if err := writer.Write([]string{"id", "date", "account_id", "account", "ip", "action", "target", "old_value", "new_value"}); err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			if err := writer.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				time.Unix(e.Created_at, 0).UTC().Format(time.RFC3339),
+				strconv.FormatInt(e.Account_id, 10),
+				e.Account,
+				e.Ip,
+				e.Action,
+				e.Target,
+				e.Old_value,
+				e.New_value,
+			}); err != nil {
+				return err
+			}
+		}
+
+		writer.Flush()
+		return writer.Error()
+	}
+
+	return fmt.Errorf("Unknown audit export format %v", format)
+}
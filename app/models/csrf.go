@@ -1,9 +1,48 @@
 package models
 
-import "time"
+import (
+	"crypto/subtle"
+	"time"
+
+	"github.com/dchest/uniuri"
+)
 
 // CsrfToken struct used for the application XSRF tokens
 type CsrfToken struct {
-	Token string
-	At    time.Time
+	Token    string
+	Previous string
+	At       time.Time
+}
+
+// NewCsrfToken creates and returns a new CsrfToken
+func NewCsrfToken() *CsrfToken {
+	return &CsrfToken{
+		Token: uniuri.NewLen(32),
+		At:    time.Now(),
+	}
+}
+
+// Valid checks if the given value matches the token. The previous token is
+// still accepted so forms rendered before a rotation keep working
+func (c *CsrfToken) Valid(value string) bool {
+	if value == "" {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(value), []byte(c.Token)) == 1 {
+		return true
+	}
+	return c.Previous != "" && subtle.ConstantTimeCompare([]byte(value), []byte(c.Previous)) == 1
+}
+
+// Expired checks if the token is older than the given lifetime. A lifetime of
+// zero means the token never expires
+func (c *CsrfToken) Expired(lifetime time.Duration) bool {
+	return lifetime > 0 && time.Since(c.At) > lifetime
+}
+
+// Rotate replaces the token with a new one keeping the old value as previous
+func (c *CsrfToken) Rotate() {
+	c.Previous = c.Token
+	c.Token = uniuri.NewLen(32)
+	c.At = time.Now()
 }
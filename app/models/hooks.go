@@ -0,0 +1,24 @@
+package models
+
+import (
+	"github.com/raggaer/castro/app/database"
+)
+
+// ExtensionHook struct used for extension lua hooks
+type ExtensionHook struct {
+	Extension_id string
+	Type         string
+	Script       string
+	Priority     int
+}
+
+// GetExtensionHooks returns all the enabled extension lua hooks
+func GetExtensionHooks() ([]ExtensionHook, error) {
+	hooks := []ExtensionHook{}
+
+	if err := database.DB.Select(&hooks, "SELECT extension_id, type, script, priority FROM castro_extension_hooks WHERE enabled = 1"); err != nil {
+		return nil, err
+	}
+
+	return hooks, nil
+}
@@ -0,0 +1,242 @@
+package models
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/raggaer/castro/app/database"
+)
+
+const (
+	// AdministratorRole name of the role that holds every permission
+	AdministratorRole = "administrator"
+
+	// AllPermissions permission that grants every other permission
+	AllPermissions = "*"
+)
+
+// Permission struct used for the assignable permissions
+type Permission struct {
+	Name         string
+	Description  string
+	Extension_id string
+}
+
+// Role struct used for account roles
+type Role struct {
+	Name        string
+	Description string
+	Permissions []string
+}
+
+// CorePermissions list of the permissions used by the castro pages
+var CorePermissions = []Permission{
+	{Name: "articles.edit", Description: "Create, edit and delete articles"},
+	{Name: "shop.manage", Description: "Manage shop categories, offers and discount codes"},
+	{Name: "bans.manage", Description: "Ban and unban accounts"},
+	{Name: "extensions.install", Description: "Browse, install and uninstall extensions"},
+	{Name: "roles.manage", Description: "Manage roles and assign them to accounts"},
+	{Name: "audit.view", Description: "View and export the audit log"},
+}
+
+// IsCorePermission checks if the given permission is used by the castro pages
+func IsCorePermission(name string) bool {
+	for _, permission := range CorePermissions {
+		if permission.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetPermissionList returns the core permissions and the permissions declared by
+// the installed extensions sorted by name
+func GetPermissionList() ([]Permission, error) {
+	list := []Permission{}
+
+	// Get extension permissions
+	if err := database.DB.Select(&list, "SELECT name, description, extension_id FROM castro_permissions"); err != nil {
+		return nil, err
+	}
+
+	list = append(list, CorePermissions...)
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+
+	return list, nil
+}
+
+// GetAccountPermissions returns the permissions of all the roles of the given account
+func GetAccountPermissions(accountID int64) ([]string, error) {
+	permissions := []string{}
+
+	// Get account role permissions
+	if err := database.DB.Select(
+		&permissions,
+		"SELECT DISTINCT p.permission FROM castro_account_roles a INNER JOIN castro_role_permissions p ON p.role = a.role WHERE a.account_id = ? ORDER BY p.permission",
+		accountID,
+	); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// GetAccountRoles returns the role names of the given account
+func GetAccountRoles(accountID int64) ([]string, error) {
+	roles := []string{}
+
+	if err := database.DB.Select(&roles, "SELECT role FROM castro_account_roles WHERE account_id = ? ORDER BY role", accountID); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// GetRolePermissions returns the permissions of the given role
+func GetRolePermissions(role string) ([]string, error) {
+	permissions := []string{}
+
+	if err := database.DB.Select(&permissions, "SELECT permission FROM castro_role_permissions WHERE role = ? ORDER BY permission", role); err != nil {
+		return nil, err
+	}
+
+	return permissions, nil
+}
+
+// GetRoleList returns all the roles with their permissions
+func GetRoleList() ([]*Role, error) {
+	roles := []*Role{}
+
+	// Get roles
+	if err := database.DB.Select(&roles, "SELECT name, description FROM castro_roles ORDER BY name"); err != nil {
+		return nil, err
+	}
+
+	// Get role permissions
+	rows := []struct {
+		Role       string
+		Permission string
+	}{}
+
+	if err := database.DB.Select(&rows, "SELECT role, permission FROM castro_role_permissions ORDER BY permission"); err != nil {
+		return nil, err
+	}
+
+	for _, role := range roles {
+		role.Permissions = []string{}
+
+		for _, row := range rows {
+			if row.Role == role.Name {
+				role.Permissions = append(role.Permissions, row.Permission)
+			}
+		}
+	}
+
+	return roles, nil
+}
+
+// HasPermission checks if the given permission list grants a permission. A
+// permission ending in .* grants every permission with the same prefix
+func HasPermission(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission || p == AllPermissions {
+			return true
+		}
+
+		if strings.HasSuffix(p, ".*") && strings.HasPrefix(permission, strings.TrimSuffix(p, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UngrantedPermission returns the first of the given permissions that the
+// account permissions do not grant or an empty string
+func UngrantedPermission(account, permissions []string) string {
+	for _, permission := range permissions {
+		if !HasPermission(account, permission) {
+			return permission
+		}
+	}
+
+	return ""
+}
+
+// AccountCan checks if the given account has a permission
+func AccountCan(accountID int64, permission string) (bool, error) {
+	permissions, err := GetAccountPermissions(accountID)
+	if err != nil {
+		return false, err
+	}
+
+	return HasPermission(permissions, permission), nil
+}
+
+// SaveRole creates or updates a role and replaces its permissions
+func SaveRole(name, description string, permissions []string) error {
+	// Begin transaction
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Save role
+	if _, err := tx.Exec("REPLACE INTO castro_roles (name, description) VALUES (?, ?)", name, description); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Replace role permissions
+	if _, err := tx.Exec("DELETE FROM castro_role_permissions WHERE role = ?", name); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, permission := range permissions {
+		if _, err := tx.Exec("INSERT INTO castro_role_permissions (role, permission) VALUES (?, ?)", name, permission); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DeleteRole removes a role and its account assignments
+func DeleteRole(name string) error {
+	// Begin transaction
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM castro_account_roles WHERE role = ?",
+		"DELETE FROM castro_role_permissions WHERE role = ?",
+		"DELETE FROM castro_roles WHERE name = ?",
+	} {
+		if _, err := tx.Exec(query, name); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AssignRole gives a role to the given account
+func AssignRole(accountID int64, role string) error {
+	_, err := database.DB.Exec("REPLACE INTO castro_account_roles (account_id, role) VALUES (?, ?)", accountID, role)
+	return err
+}
+
+// RemoveRole takes a role from the given account
+func RemoveRole(accountID int64, role string) error {
+	_, err := database.DB.Exec("DELETE FROM castro_account_roles WHERE account_id = ? AND role = ?", accountID, role)
+	return err
+}
@@ -0,0 +1,122 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/raggaer/castro/app/database"
+)
+
+// recoveryCodeChars characters used by the recovery codes
+var recoveryCodeChars = []byte("abcdefghjkmnpqrstuvwxyz23456789")
+
+// hashRecoveryCode returns the stored form of a recovery code. Dashes, spaces
+// and case are ignored
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateRecoveryCodes replaces the recovery codes of the given account with
+// count new codes. The codes are only returned here, the database holds hashes
+func GenerateRecoveryCodes(accountID int64, count int) ([]string, error) {
+	codes := make([]string, count)
+
+	// Begin transaction
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove old codes
+	if _, err := tx.Exec("DELETE FROM castro_twofa_recovery WHERE account_id = ?", accountID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for i := range codes {
+		code := uniuri.NewLenChars(10, recoveryCodeChars)
+		codes[i] = code[:5] + "-" + code[5:]
+
+		if _, err := tx.Exec("INSERT INTO castro_twofa_recovery (account_id, code) VALUES (?, ?)", accountID, hashRecoveryCode(code)); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+// UseRecoveryCode marks the given recovery code as used. Returns false if the
+// code does not exist or was already used
+func UseRecoveryCode(accountID int64, code string) (bool, error) {
+	result, err := database.DB.Exec(
+		"UPDATE castro_twofa_recovery SET used_at = ? WHERE account_id = ? AND code = ? AND used_at = 0",
+		time.Now().Unix(),
+		accountID,
+		hashRecoveryCode(code),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+
+	return n == 1, err
+}
+
+// RemainingRecoveryCodes returns the number of unused recovery codes of the given account
+func RemainingRecoveryCodes(accountID int64) (int, error) {
+	n := 0
+	err := database.DB.Get(&n, "SELECT COUNT(*) FROM castro_twofa_recovery WHERE account_id = ? AND used_at = 0", accountID)
+
+	return n, err
+}
+
+// DisableTwoFactor removes the two-factor secret and the recovery codes of the given account
+func DisableTwoFactor(accountID int64) error {
+	// Begin transaction
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE accounts SET secret = NULL WHERE id = ?", accountID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM castro_twofa_recovery WHERE account_id = ?", accountID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TwoFactorRequired checks if the given account holds any permission and has
+// no two-factor secret
+func TwoFactorRequired(accountID int64) (bool, error) {
+	// Get account secret
+	secret := ""
+	if err := database.DB.Get(&secret, "SELECT COALESCE(secret, '') FROM accounts WHERE id = ?", accountID); err != nil {
+		return false, err
+	}
+
+	if secret != "" {
+		return false, nil
+	}
+
+	// Get account permissions
+	permissions, err := GetAccountPermissions(accountID)
+	if err != nil {
+		return false, err
+	}
+
+	return len(permissions) > 0, nil
+}
@@ -0,0 +1,329 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CapabilityDBRead allows SELECT queries
+	CapabilityDBRead = "db.read"
+
+	// CapabilityDBWrite allows changes to the listed tables
+	CapabilityDBWrite = "db.write"
+
+	// CapabilityHTTPOutbound allows requests to the listed hosts
+	CapabilityHTTPOutbound = "http.outbound"
+
+	// CapabilityFile allows access to the listed paths
+	CapabilityFile = "file"
+
+	// CapabilityMail allows sending mails
+	CapabilityMail = "mail"
+)
+
+// ExtensionCapabilities holds the capabilities granted to an extension
+type ExtensionCapabilities struct {
+	DBRead  bool
+	DBWrite []string
+	Hosts   []string
+	Paths   []string
+	Mail    bool
+}
+
+// ParseExtensionCapabilities parses a capability list such as
+// ["db.read", "db.write:news,news_comments", "http.outbound:api.example.com", "file:public/news", "mail"]
+func ParseExtensionCapabilities(list []string) (*ExtensionCapabilities, error) {
+	c := &ExtensionCapabilities{}
+
+	for _, capability := range list {
+		name, args := capability, []string{}
+
+		if i := strings.Index(capability, ":"); i != -1 {
+			name = capability[:i]
+			for _, arg := range strings.Split(capability[i+1:], ",") {
+				if arg = strings.TrimSpace(arg); arg != "" {
+					args = append(args, arg)
+				}
+			}
+		}
+
+		switch strings.TrimSpace(name) {
+		case CapabilityDBRead:
+			c.DBRead = true
+		case CapabilityMail:
+			c.Mail = true
+		case CapabilityDBWrite:
+			if len(args) == 0 {
+				return nil, fmt.Errorf("capability %q needs a table list", capability)
+			}
+			c.DBWrite = append(c.DBWrite, args...)
+		case CapabilityHTTPOutbound:
+			if len(args) == 0 {
+				return nil, fmt.Errorf("capability %q needs a host list", capability)
+			}
+			c.Hosts = append(c.Hosts, args...)
+		case CapabilityFile:
+			if len(args) == 0 {
+				return nil, fmt.Errorf("capability %q needs a path list", capability)
+			}
+			for _, p := range args {
+				if filepath.IsAbs(p) || strings.HasPrefix(filepath.Clean(p), "..") {
+					return nil, fmt.Errorf("capability %q path %q must be inside the castro folder", capability, p)
+				}
+				c.Paths = append(c.Paths, filepath.Clean(p))
+			}
+		default:
+			return nil, fmt.Errorf("unknown capability %q", capability)
+		}
+	}
+
+	return c, nil
+}
+
+// CanWrite checks if the given table can be changed
+func (c *ExtensionCapabilities) CanWrite(table string) bool {
+	for _, t := range c.DBWrite {
+		if t == "*" || strings.EqualFold(t, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanRequest checks if the host of the given URL can be requested. Hosts can
+// use a leading wildcard such as *.example.com
+func (c *ExtensionCapabilities) CanRequest(rawurl string) bool {
+	u, err := url.Parse(rawurl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+
+	for _, h := range c.Hosts {
+		h = strings.ToLower(h)
+
+		switch {
+		case h == "*" || h == host:
+			return true
+		case strings.HasPrefix(h, "*.") && strings.HasSuffix(host, h[1:]):
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanAccess checks if the given path is inside one of the granted paths
+func (c *ExtensionCapabilities) CanAccess(path string) bool {
+	if filepath.IsAbs(path) {
+		return false
+	}
+
+	path = filepath.Clean(path)
+
+	for _, p := range c.Paths {
+		if path == p || strings.HasPrefix(path, p+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// List returns the capabilities in manifest form
+func (c *ExtensionCapabilities) List() []string {
+	list := []string{}
+
+	if c.DBRead {
+		list = append(list, CapabilityDBRead)
+	}
+	if len(c.DBWrite) > 0 {
+		list = append(list, CapabilityDBWrite+":"+strings.Join(c.DBWrite, ","))
+	}
+	if len(c.Hosts) > 0 {
+		list = append(list, CapabilityHTTPOutbound+":"+strings.Join(c.Hosts, ","))
+	}
+	if len(c.Paths) > 0 {
+		list = append(list, CapabilityFile+":"+strings.Join(c.Paths, ","))
+	}
+	if c.Mail {
+		list = append(list, CapabilityMail)
+	}
+
+	return list
+}
+
+// SQLStatement returns if the given single statement reads data and the tables
+// it changes. Statements with sub queries read data too. Statements changing
+// several tables at once, comments and multiple statements are rejected
+func SQLStatement(query string) (bool, []string, error) {
+	q := strings.TrimSpace(query)
+	q = strings.TrimSpace(strings.TrimSuffix(q, ";"))
+
+	if strings.Contains(q, ";") || strings.Contains(q, "--") || strings.Contains(q, "/*") || strings.Contains(q, "#") {
+		return false, nil, errors.New("multiple statements and comments are not allowed")
+	}
+
+	fields := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ", ",", " , ").Replace(q))
+	if len(fields) == 0 {
+		return false, nil, errors.New("empty statement")
+	}
+
+	upper := make([]string, len(fields))
+	reads := false
+	for i, f := range fields {
+		upper[i] = strings.ToUpper(f)
+		reads = reads || upper[i] == "SELECT"
+	}
+
+	// next returns the index of the first field that is not a modifier
+	next := func(i int, modifiers ...string) int {
+		for i < len(upper) {
+			skip := false
+			for _, m := range modifiers {
+				if upper[i] == m {
+					skip = true
+					break
+				}
+			}
+			if !skip {
+				break
+			}
+			i++
+		}
+		return i
+	}
+
+	// single returns the table at the given index making sure it is only
+	// followed by an optional alias and one of the end keywords
+	single := func(i int, end ...string) (bool, []string, error) {
+		if i >= len(fields) {
+			return false, nil, fmt.Errorf("cannot find the table of %q", query)
+		}
+
+		isEnd := func(j int) bool {
+			if j >= len(upper) {
+				return true
+			}
+			for _, e := range end {
+				if upper[j] == e {
+					return true
+				}
+			}
+			return false
+		}
+
+		j := i + 1
+		if !isEnd(j) {
+			if upper[j] == "AS" {
+				j++
+			}
+			if j >= len(upper) || !sqlIdentifier(fields[j]) {
+				return false, nil, errors.New("statements can only change a single table")
+			}
+			j++
+		}
+
+		if !isEnd(j) {
+			return false, nil, errors.New("statements can only change a single table")
+		}
+
+		return reads, []string{sqlTableName(fields[i])}, nil
+	}
+
+	// topLevel rejects the given keywords outside of sub queries starting at
+	// the given index
+	topLevel := func(i int, keywords ...string) error {
+		depth := 0
+		for _, f := range upper[i:] {
+			switch f {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			}
+			if depth > 0 {
+				continue
+			}
+			for _, k := range keywords {
+				if f == k {
+					return errors.New("statements can only change a single table")
+				}
+			}
+		}
+		return nil
+	}
+
+	switch upper[0] {
+	case "SELECT", "SHOW", "EXPLAIN", "DESCRIBE":
+		if strings.Contains(strings.ToUpper(q), "OUTFILE") || strings.Contains(strings.ToUpper(q), "DUMPFILE") {
+			return false, nil, errors.New("SELECT INTO files is not allowed")
+		}
+		return true, nil, nil
+	case "INSERT", "REPLACE":
+		return single(next(1, "LOW_PRIORITY", "DELAYED", "HIGH_PRIORITY", "IGNORE", "OR", "REPLACE", "ABORT", "FAIL", "ROLLBACK", "INTO"), "(", "VALUES", "VALUE", "SET", "SELECT")
+	case "UPDATE":
+		if err := topLevel(1, "FROM", "USING", "JOIN", "STRAIGHT_JOIN"); err != nil {
+			return false, nil, err
+		}
+		return single(next(1, "LOW_PRIORITY", "IGNORE", "OR", "REPLACE", "ABORT", "FAIL", "ROLLBACK"), "SET")
+	case "DELETE":
+		// Multi table deletes list their targets before FROM
+		i := next(1, "LOW_PRIORITY", "QUICK", "IGNORE")
+		if i >= len(upper) || upper[i] != "FROM" {
+			return false, nil, errors.New("statements can only change a single table")
+		}
+		if err := topLevel(i+1, "FROM", "USING", "JOIN", "STRAIGHT_JOIN"); err != nil {
+			return false, nil, err
+		}
+		return single(i+1, "WHERE", "ORDER", "LIMIT")
+	case "CREATE", "ALTER", "DROP", "TRUNCATE":
+		i := next(1, "TEMPORARY", "UNIQUE")
+		if i < len(upper) && upper[i] == "INDEX" {
+			// CREATE INDEX name ON table
+			for j := i; j < len(upper)-1; j++ {
+				if upper[j] == "ON" {
+					return reads, []string{sqlTableName(fields[j+1])}, nil
+				}
+			}
+			return false, nil, fmt.Errorf("cannot find the table of %q", query)
+		}
+		if upper[0] != "TRUNCATE" && (i >= len(upper) || upper[i] != "TABLE") {
+			return false, nil, fmt.Errorf("%v statements can only change tables", upper[0])
+		}
+		i = next(i, "TABLE", "IF", "NOT", "EXISTS")
+		if upper[0] == "ALTER" || upper[0] == "CREATE" {
+			if i >= len(fields) {
+				return false, nil, fmt.Errorf("cannot find the table of %q", query)
+			}
+			return reads, []string{sqlTableName(fields[i])}, nil
+		}
+		return single(i)
+	}
+
+	return false, nil, fmt.Errorf("%v statements are not allowed", upper[0])
+}
+
+// sqlTableName removes the quotes of a table name
+func sqlTableName(name string) string {
+	return strings.Trim(name, "`\"[]")
+}
+
+// sqlIdentifier checks if the given field can be a table alias
+func sqlIdentifier(name string) bool {
+	name = sqlTableName(name)
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '_' || r == '$' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSQLStatement(t *testing.T) {
+	tests := []struct {
+		query  string
+		read   bool
+		tables []string
+		fail   bool
+	}{
+		{query: "SELECT * FROM accounts", read: true},
+		{query: "SELECT * FROM accounts INTO OUTFILE '/tmp/a'", fail: true},
+		{query: "INSERT INTO news (title) VALUES (?)", tables: []string{"news"}},
+		{query: "INSERT IGNORE INTO `news` SET title = ?", tables: []string{"news"}},
+		{query: "INSERT INTO news SELECT * FROM accounts", read: true, tables: []string{"news"}},
+		{query: "UPDATE news SET title = ? WHERE id = ?", tables: []string{"news"}},
+		{query: "UPDATE news AS n SET n.title = ?", tables: []string{"news"}},
+		{query: "UPDATE news SET views = (SELECT COUNT(*) FROM accounts)", read: true, tables: []string{"news"}},
+		{query: "UPDATE news, accounts SET accounts.premdays = 0", fail: true},
+		{query: "UPDATE news n JOIN accounts a ON a.id = n.id SET a.premdays = 0", fail: true},
+		{query: "UPDATE news STRAIGHT_JOIN accounts SET accounts.premdays = 0", fail: true},
+		{query: "UPDATE news SET title = a.name FROM accounts a", fail: true},
+		{query: "DELETE FROM news WHERE id = ?", tables: []string{"news"}},
+		{query: "DELETE LOW_PRIORITY FROM news", tables: []string{"news"}},
+		{query: "DELETE FROM news WHERE id IN (SELECT id FROM accounts)", read: true, tables: []string{"news"}},
+		{query: "DELETE news FROM accounts AS news WHERE 1=1", fail: true},
+		{query: "DELETE FROM news USING news JOIN accounts WHERE 1=1", fail: true},
+		{query: "DELETE FROM news, accounts USING news JOIN accounts", fail: true},
+		{query: "DELETE FROM news AS n JOIN accounts", fail: true},
+		{query: "CREATE TABLE IF NOT EXISTS news (id INT)", tables: []string{"news"}},
+		{query: "CREATE TABLE news AS SELECT * FROM accounts", read: true, tables: []string{"news"}},
+		{query: "CREATE INDEX idx ON news (id)", tables: []string{"news"}},
+		{query: "DROP TABLE news", tables: []string{"news"}},
+		{query: "DROP TABLE news, accounts", fail: true},
+		{query: "DROP DATABASE castro", fail: true},
+		{query: "SELECT 1; DROP TABLE accounts", fail: true},
+		{query: "SELECT 1 -- comment", fail: true},
+		{query: "GRANT ALL ON *.* TO x", fail: true},
+		{query: "", fail: true},
+	}
+
+	for _, test := range tests {
+		read, tables, err := SQLStatement(test.query)
+		if test.fail {
+			if err == nil {
+				t.Errorf("SQLStatement(%q) expected an error, got %v %v", test.query, read, tables)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SQLStatement(%q) unexpected error: %v", test.query, err)
+			continue
+		}
+		if read != test.read || !reflect.DeepEqual(tables, test.tables) {
+			t.Errorf("SQLStatement(%q) = %v %v, expected %v %v", test.query, read, tables, test.read, test.tables)
+		}
+	}
+}
+
+func TestExtensionCapabilities(t *testing.T) {
+	c, err := ParseExtensionCapabilities([]string{"db.read", "db.write:news", "http.outbound:*.example.com", "file:public/news"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !c.CanWrite("NEWS") || c.CanWrite("accounts") {
+		t.Error("unexpected db.write check")
+	}
+	if !c.CanRequest("https://api.example.com/x") || c.CanRequest("https://example.org") || c.CanRequest("file:///etc/passwd") {
+		t.Error("unexpected http.outbound check")
+	}
+	if !c.CanAccess("public/news/a.png") || c.CanAccess("public/newsletter") || c.CanAccess("public/news/../../config.toml") {
+		t.Error("unexpected file check")
+	}
+
+	for _, list := range [][]string{{"db.write"}, {"file:../"}, {"file:/etc"}, {"unknown"}} {
+		if _, err := ParseExtensionCapabilities(list); err == nil {
+			t.Errorf("ParseExtensionCapabilities(%v) expected an error", list)
+		}
+	}
+}
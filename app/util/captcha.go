@@ -1,14 +1,46 @@
 package util
 
 import (
-	"gopkg.in/square/go-jose.v1/json"
+	"bytes"
+	"fmt"
+	"image/color"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dchest/uniuri"
+	"github.com/joseluis2g/goimage"
+	"gopkg.in/square/go-jose.v1/json"
 )
 
 const captchaURL = "https://www.google.com/recaptcha/api/siteverify"
 
+const (
+	// CaptchaProviderRecaptcha uses the google reCAPTCHA service
+	CaptchaProviderRecaptcha = "recaptcha"
+
+	// CaptchaProviderImage uses a self-hosted image captcha
+	CaptchaProviderImage = "image"
+)
+
+var (
+	// captchaProviders holds the registered captcha providers
+	captchaProviders = map[string]CaptchaProvider{
+		CaptchaProviderRecaptcha: &recaptchaProvider{},
+		CaptchaProviderImage:     &imageCaptchaProvider{},
+	}
+
+	// captchaRW protects the captcha providers map
+	captchaRW = &sync.RWMutex{}
+
+	// captchaChars characters used by the image captcha
+	captchaChars = []byte("ABCDEFGHJKLMNPRSTUVWXYZ23456789")
+)
+
 type captchaResponse struct {
 	Success  bool   `json:"success"`
 	Hostname string `json:"hostname"`
@@ -16,13 +48,87 @@ type captchaResponse struct {
 
 // CaptchaConfig struct used for the TOML configuration file
 type CaptchaConfig struct {
-	Enabled bool
-	Public  string
-	Secret  string
+	Enabled  bool
+	Provider string
+	Public   string
+	Secret   string
+	Length   int
+	Expire   StringDuration
+}
+
+// CaptchaProvider represents a captcha service
+type CaptchaProvider interface {
+	// Key returns the value of the captchaKey template function
+	Key() (string, error)
+
+	// Verify checks the answer of the given form values
+	Verify(form url.Values) (bool, error)
+}
+
+// RegisterCaptchaProvider adds a captcha provider that can be used on the
+// Captcha.Provider config option
+func RegisterCaptchaProvider(name string, provider CaptchaProvider) {
+	// Lock mutex
+	captchaRW.Lock()
+	defer captchaRW.Unlock()
+
+	captchaProviders[name] = provider
+}
+
+// GetCaptchaProvider returns the configured captcha provider
+func GetCaptchaProvider() (CaptchaProvider, error) {
+	// Lock mutex
+	captchaRW.RLock()
+	defer captchaRW.RUnlock()
+
+	name := captchaConfig().Provider
+
+	provider, ok := captchaProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown captcha provider %v", name)
+	}
+
+	return provider, nil
+}
+
+// captchaConfig returns the captcha options with the defaults applied
+func captchaConfig() CaptchaConfig {
+	c := Config.Configuration.Captcha
+
+	if c.Provider == "" {
+		c.Provider = CaptchaProviderRecaptcha
+	}
+	if c.Length == 0 {
+		c.Length = 5
+	}
+	if c.Expire.Duration == 0 {
+		c.Expire = StringDuration{String: "10m", Duration: time.Minute * 10}
+	}
+
+	return c
+}
+
+// VerifyCaptcha checks the captcha answer of the given form values using the
+// configured provider
+func VerifyCaptcha(form url.Values) (bool, error) {
+	provider, err := GetCaptchaProvider()
+	if err != nil {
+		return false, err
+	}
+
+	return provider.Verify(form)
+}
+
+// recaptchaProvider checks the answers with the google reCAPTCHA service
+type recaptchaProvider struct{}
+
+// Key returns the reCAPTCHA public key
+func (r *recaptchaProvider) Key() (string, error) {
+	return Config.Configuration.Captcha.Public, nil
 }
 
-// VerifyCaptcha checks if the given captcha answer is valid
-func VerifyCaptcha(answer string) (bool, error) {
+// Verify checks the g-recaptcha-response form value
+func (r *recaptchaProvider) Verify(form url.Values) (bool, error) {
 	// Post form to google service
 	resp, err := http.PostForm(captchaURL,
 		url.Values{
@@ -30,7 +136,7 @@ func VerifyCaptcha(answer string) (bool, error) {
 				Config.Configuration.Captcha.Secret,
 			},
 			"response": {
-				answer,
+				form.Get("g-recaptcha-response"),
 			},
 		},
 	)
@@ -65,3 +171,125 @@ func VerifyCaptcha(answer string) (bool, error) {
 	// Return success status
 	return captchaResp.Success, nil
 }
+
+// imageCaptchaProvider renders the challenges as images. The answers are
+// kept on the application cache and can only be used once
+type imageCaptchaProvider struct{}
+
+// imageCaptcha holds the answer of an image challenge and its image once it
+// is rendered
+type imageCaptcha struct {
+	Answer string
+	Image  []byte
+}
+
+// imageCaptchaMutex makes reading and removing a challenge a single step
+var imageCaptchaMutex = &sync.Mutex{}
+
+// Key creates a new challenge and returns its identifier
+func (i *imageCaptchaProvider) Key() (string, error) {
+	c := captchaConfig()
+
+	id := uniuri.NewLen(32)
+
+	// Save challenge answer
+	Cache.Set("captcha_"+id, &imageCaptcha{Answer: uniuri.NewLenChars(c.Length, captchaChars)}, c.Expire.Duration)
+
+	return id, nil
+}
+
+// Verify checks the captcha-answer form value against the challenge of the
+// captcha-id form value. The challenge is removed even if the answer is wrong
+func (i *imageCaptchaProvider) Verify(form url.Values) (bool, error) {
+	key := "captcha_" + form.Get("captcha-id")
+
+	imageCaptchaMutex.Lock()
+	value, ok := Cache.Get(key)
+	if ok {
+		Cache.Delete(key)
+	}
+	imageCaptchaMutex.Unlock()
+
+	challenge, ok := value.(*imageCaptcha)
+	if !ok {
+		return false, nil
+	}
+
+	return strings.EqualFold(strings.TrimSpace(form.Get("captcha-answer")), challenge.Answer), nil
+}
+
+// CaptchaImage returns the image of the given challenge. Every challenge is
+// rendered once so reloading it does not show new noise. Returns false if the
+// challenge does not exist
+func CaptchaImage(id string) (*bytes.Buffer, bool, error) {
+	key := "captcha_" + id
+
+	imageCaptchaMutex.Lock()
+	defer imageCaptchaMutex.Unlock()
+
+	value, ok := Cache.Get(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	challenge, ok := value.(*imageCaptcha)
+	if !ok {
+		return nil, false, nil
+	}
+
+	if challenge.Image == nil {
+		image, err := renderCaptchaImage(challenge.Answer)
+		if err != nil {
+			return nil, false, err
+		}
+		challenge.Image = image
+	}
+
+	return bytes.NewBuffer(append([]byte{}, challenge.Image...)), true, nil
+}
+
+// renderCaptchaImage draws the given answer with noise and strike lines
+func renderCaptchaImage(text string) ([]byte, error) {
+	width, height := 30*len(text)+20, 50
+
+	img := goimage.NewImage(width, height)
+
+	// Set background
+	img.AddUniformImage(width, height, color.RGBA{245, 245, 245, 255}, 0, 0)
+
+	// Draw noise
+	for n := 0; n < width; n++ {
+		img.AddUniformImage(
+			rand.Intn(3)+1,
+			rand.Intn(3)+1,
+			color.RGBA{uint8(rand.Intn(200)), uint8(rand.Intn(200)), uint8(rand.Intn(200)), 255},
+			rand.Intn(width),
+			rand.Intn(height),
+		)
+	}
+
+	// Draw every character with a random color and offset
+	for n, r := range text {
+		if err := img.WriteText(
+			string(r),
+			color.RGBA{uint8(rand.Intn(120)), uint8(rand.Intn(120)), uint8(rand.Intn(120)), 255},
+			float64(26+rand.Intn(8)),
+			10+n*30+rand.Intn(6),
+			32+rand.Intn(10),
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	// Draw strike lines
+	for n := 0; n < 2; n++ {
+		img.AddUniformImage(width-20, 2, color.RGBA{uint8(rand.Intn(120)), uint8(rand.Intn(120)), uint8(rand.Intn(120)), 255}, 10, 15+rand.Intn(20))
+	}
+
+	buff := &bytes.Buffer{}
+	if err := img.Encode(buff); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
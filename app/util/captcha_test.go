@@ -0,0 +1,103 @@
+package util
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+func TestImageCaptchaVerify(t *testing.T) {
+	Config = &ConfigurationFile{Configuration: &Configuration{}}
+	Cache = cache.New(time.Minute, time.Minute)
+
+	provider := &imageCaptchaProvider{}
+
+	tests := []struct {
+		name   string
+		answer func(answer string) string
+		valid  bool
+	}{
+		{name: "valid", answer: func(answer string) string { return answer }, valid: true},
+		{name: "case", answer: func(answer string) string { return " " + strings.ToLower(answer) + " " }, valid: true},
+		{name: "wrong", answer: func(answer string) string { return answer + "x" }},
+	}
+
+	for _, test := range tests {
+		id, err := provider.Key()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		value, _ := Cache.Get("captcha_" + id)
+		form := url.Values{"captcha-id": {id}, "captcha-answer": {test.answer(value.(*imageCaptcha).Answer)}}
+
+		if valid, err := provider.Verify(form); err != nil || valid != test.valid {
+			t.Errorf("%v: Verify = %v %v, expected %v", test.name, valid, err, test.valid)
+		}
+
+		// Challenges can only be answered once
+		if valid, _ := provider.Verify(form); valid {
+			t.Errorf("%v: challenge answered twice", test.name)
+		}
+	}
+
+	// Concurrent answers of the same challenge are accepted once
+	id, _ := provider.Key()
+	value, _ := Cache.Get("captcha_" + id)
+	form := url.Values{"captcha-id": {id}, "captcha-answer": {value.(*imageCaptcha).Answer}}
+
+	accepted := 0
+	m := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+
+	for n := 0; n < 20; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if valid, _ := provider.Verify(form); valid {
+				m.Lock()
+				accepted++
+				m.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if accepted != 1 {
+		t.Errorf("challenge accepted %d times", accepted)
+	}
+}
+
+func TestCaptchaImage(t *testing.T) {
+	Config = &ConfigurationFile{Configuration: &Configuration{}}
+	Cache = cache.New(time.Minute, time.Minute)
+
+	id, err := (&imageCaptchaProvider{}).Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, exists, err := CaptchaImage(id)
+	if err != nil || !exists {
+		t.Fatalf("CaptchaImage = %v %v", exists, err)
+	}
+
+	second, _, err := CaptchaImage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Error("challenge image was drawn again")
+	}
+
+	if _, exists, _ := CaptchaImage("missing"); exists {
+		t.Error("image of a missing challenge was drawn")
+	}
+}
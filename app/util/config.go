@@ -1,14 +1,19 @@
 package util
 
 import (
+	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/raggaer/castro/app/database"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // StaticConfig struct used for the static asset handler options
@@ -23,6 +28,13 @@ type CookieConfig struct {
 	MaxAge   int
 	HashKey  string
 	BlockKey string
+	SameSite string
+}
+
+// SessionConfig struct used for the session storage options
+type SessionConfig struct {
+	Store string
+	Purge StringDuration
 }
 
 // MapWatchConfig map watcher goroutine configuration options
@@ -36,10 +48,14 @@ type ShopConfig struct {
 	Enabled bool
 }
 
-// PluginConfig struct used for the plugin listener
+// PluginConfig struct used for the plugin listener and the extension archive installer
 type PluginConfig struct {
-	Enabled bool
-	Origin  string
+	Enabled          bool
+	Origin           string
+	Registry         string
+	TrustedKeys      []string
+	RequireSignature bool
+	MaxArchiveSize   int64
 }
 
 // RateLimiterConfig struct used for the rate limiting configuration options
@@ -47,6 +63,68 @@ type RateLimiterConfig struct {
 	Enabled bool
 	Number  int64
 	Time    StringDuration
+	Routes  []RateLimitRoute
+}
+
+// LoginConfig struct used for the failed login protection options. Zero values
+// use the defaults
+type LoginConfig struct {
+	CaptchaAfter int
+	LockAfter    int
+	Backoff      StringDuration
+	MaxBackoff   StringDuration
+	Lockout      StringDuration
+	Window       StringDuration
+	UnlockMail   bool
+}
+
+// PasswordConfig struct used for the account password hashing options. Zero
+// values use the defaults
+type PasswordConfig struct {
+	Scheme        string
+	BcryptCost    int
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+}
+
+// TwoFactorConfig struct used for the two-factor authentication options
+type TwoFactorConfig struct {
+	RequireStaff  bool
+	RecoveryCodes int
+}
+
+// EmailVerificationConfig struct used for the account email verification options
+type EmailVerificationConfig struct {
+	Enabled              bool
+	RequireForCharacters bool
+	Expire               StringDuration
+}
+
+// RateLimitRoute struct used for the per-route rate limiting rules
+type RateLimitRoute struct {
+	Path    string
+	Methods []string
+	Number  int64
+	Time    StringDuration
+}
+
+// LuaConfig struct used for the lua state pool configuration options
+type LuaConfig struct {
+	PoolSize            int
+	CallStackSize       int
+	RegistrySize        int
+	RegistryMaxSize     int
+	MinimizeStackMemory bool
+	Timeout             StringDuration
+	EventTimeout        StringDuration
+	Timeouts            map[string]StringDuration
+}
+
+// DatabaseConfig struct used for the database configuration options
+type DatabaseConfig struct {
+	Driver string
+	File   string
 }
 
 // CacheConfig struct used for the cache configuration options
@@ -57,20 +135,27 @@ type CacheConfig struct {
 
 // SSLConfig struct used for the ssl configuration options
 type SSLConfig struct {
-	Enabled bool
-	Auto    bool
-	Proxy   bool
-	Cert    string
-	Key     string
+	Enabled        bool
+	Auto           bool
+	Proxy          bool
+	TrustedProxies []string
+	Cert           string
+	Key            string
 }
 
 // MailConfig struct used for the mail configuration options
 type MailConfig struct {
-	Enabled  bool
-	Server   string
-	Port     int
-	Username string
-	Password string
+	Enabled     bool
+	Transport   string
+	Directory   string
+	From        string
+	Server      string
+	Port        int
+	Username    string
+	Password    string
+	Workers     int
+	MaxAttempts int
+	RetryDelay  StringDuration
 }
 
 // PaygolConfig struct used for the paygol configuration options
@@ -125,6 +210,7 @@ type SecurityConfig struct {
 	ContentType       string
 	ReferrerPolicy    string
 	CrossDomainPolicy string
+	CSRFExpire        StringDuration
 	CSP               ContentSecurityPolicyConfig
 }
 
@@ -136,36 +222,44 @@ type ConfigTown struct {
 
 // Configuration struct used for the main Castro config file TOML file
 type Configuration struct {
-	CheckUpdates bool
-	LoadMap      bool
-	MapHouseFile string
-	Towns        []ConfigTown
-	Template     string
-	Mode         string
-	Port         int
-	URL          string
-	Datapack     string
-	MapWatch     MapWatchConfig
-	Security     SecurityConfig
-	Plugin       PluginConfig
-	Mail         MailConfig
-	Captcha      CaptchaConfig
-	SSL          SSLConfig
-	PayPal       PayPalConfig
-	PayGol       PaygolConfig
-	Fortumo      FortumoConfig
-	Shop         ShopConfig
-	Cookies      CookieConfig
-	Cache        CacheConfig
-	RateLimit    RateLimiterConfig
-	Static       StaticConfig
-	Custom       map[string]interface{}
+	CheckUpdates      bool
+	LoadMap           bool
+	MapHouseFile      string
+	Towns             []ConfigTown
+	Template          string
+	Mode              string
+	Port              int
+	URL               string
+	Datapack          string
+	MapWatch          MapWatchConfig
+	Security          SecurityConfig
+	Plugin            PluginConfig
+	Mail              MailConfig
+	Captcha           CaptchaConfig
+	SSL               SSLConfig
+	PayPal            PayPalConfig
+	PayGol            PaygolConfig
+	Fortumo           FortumoConfig
+	Shop              ShopConfig
+	Cookies           CookieConfig
+	Session           SessionConfig
+	Cache             CacheConfig
+	RateLimit         RateLimiterConfig
+	Login             LoginConfig
+	Password          PasswordConfig
+	TwoFactor         TwoFactorConfig
+	EmailVerification EmailVerificationConfig
+	Lua               LuaConfig
+	Database          DatabaseConfig
+	Static            StaticConfig
+	Custom            map[string]interface{}
 }
 
 // ConfigurationFile struct used to store a configuration pointer
 type ConfigurationFile struct {
 	rw            sync.RWMutex
 	Configuration *Configuration
+	proxies       []*net.IPNet
 }
 
 // StringDuration struct used to convert strings to time duration during config encoding or vice-versa
@@ -220,60 +314,239 @@ func LoadConfig(path string) error {
 		return err
 	}
 
+	// Invalid networks are reported when validating the config file
+	Config.proxies, _ = trustedProxyNetworks(Config.Configuration.SSL)
+
 	return nil
 }
 
-// IsDev checks if castro is running on development mode
-func (c Configuration) IsDev() bool {
-	return c.Mode == "dev"
+// TrustedProxies returns the trusted proxy networks parsed when the
+// configuration file was loaded
+func (c *ConfigurationFile) TrustedProxies() []*net.IPNet {
+	c.rw.RLock()
+	defer c.rw.RUnlock()
+
+	return c.proxies
 }
 
-// IsLog checks if castro is running on log mode
-func (c Configuration) IsLog() bool {
-	return c.Mode == "log"
+// ValidateConfig decodes the given config file and returns the list of problems found
+func ValidateConfig(path string) ([]string, error) {
+	c := &Configuration{}
+
+	// Decode the given file
+	meta, err := toml.DecodeFile(path, c)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []string{}
+
+	// Check for unknown fields
+	for _, key := range meta.Undecoded() {
+		problems = append(problems, fmt.Sprintf("Unknown field %v", key.String()))
+	}
+
+	return append(problems, c.Validate()...), nil
 }
 
-// CSP returns a valid Content-Security-Policy header value
-func (c Configuration) CSP() string {
-	// Set default-src field
-	buff := getCSPField("default-src", c.Security.CSP.Default, nil)
+// Validate checks the configuration values and returns the list of problems found
+func (c Configuration) Validate() []string {
+	problems := []string{}
+
+	// Check running mode
+	if c.Mode != "dev" && c.Mode != "prod" && c.Mode != "log" {
+		problems = append(problems, fmt.Sprintf("Mode must be dev, prod or log, got %q", c.Mode))
+	}
 
-	// Set frame-src field
-	buff += getCSPField("frame-src", c.Security.CSP.Frame.Default, c.Security.CSP.Frame.SRC)
+	// Check database driver
+	switch c.Database.Driver {
+	case "", "mysql":
+	case "sqlite":
+		if c.Database.File == "" {
+			problems = append(problems, "Database.File is needed when using the sqlite driver")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("Database.Driver must be mysql or sqlite, got %q", c.Database.Driver))
+	}
 
-	// Set script-src field
-	buff += getCSPField("script-src", c.Security.CSP.Script.Default, c.Security.CSP.Script.SRC)
+	// Check session store
+	switch c.Session.Store {
+	case "", DatabaseSessionStore, MemorySessionStore:
+	default:
+		problems = append(problems, fmt.Sprintf("Session.Store must be database or memory, got %q", c.Session.Store))
+	}
 
-	// Set font-src field
-	buff += getCSPField("font-src", c.Security.CSP.Font.Default, c.Security.CSP.Font.SRC)
+	// Check HTTP server fields
+	if c.Port <= 0 || c.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("Port %v is not a valid port", c.Port))
+	}
+	if c.URL == "" {
+		problems = append(problems, "URL cannot be empty")
+	}
 
-	// Set connect-src field
-	buff += getCSPField("connect-src", c.Security.CSP.Connect.Default, c.Security.CSP.Connect.SRC)
+	// Check datapack path
+	if _, err := os.Stat(filepath.Join(c.Datapack, "config.lua")); err != nil {
+		problems = append(problems, fmt.Sprintf("Datapack %q does not contain a config.lua file", c.Datapack))
+	}
 
-	// Set style-src field
-	buff += getCSPField("style-src", c.Security.CSP.Style.Default, c.Security.CSP.Style.SRC)
+	// Check template directory
+	if info, err := os.Stat(c.Template); err != nil || !info.IsDir() {
+		problems = append(problems, fmt.Sprintf("Template directory %q does not exist", c.Template))
+	}
 
-	// Set img-src field
-	buff += getCSPField("img-src", c.Security.CSP.Image.Default, c.Security.CSP.Image.SRC)
+	// Check map fields
+	if !c.LoadMap && c.MapHouseFile == "" {
+		problems = append(problems, "MapHouseFile is needed when LoadMap is disabled")
+	}
+	if c.MapWatch.Enabled && c.MapWatch.Check.Duration <= 0 {
+		problems = append(problems, "MapWatch.Check must be greater than zero")
+	}
 
-	return buff
-}
+	// Check cookie keys
+	if len(c.Cookies.HashKey) < 32 {
+		problems = append(problems, "Cookies.HashKey must be at least 32 characters long")
+	}
+	if l := len(c.Cookies.BlockKey); l != 16 && l != 24 && l != 32 {
+		problems = append(problems, "Cookies.BlockKey must be 16, 24 or 32 characters long")
+	}
+	switch strings.ToLower(c.Cookies.SameSite) {
+	case "", "lax", "strict":
+	case "none":
+		if !c.IsSSL() {
+			problems = append(problems, "Cookies.SameSite none requires SSL")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("Cookies.SameSite %q must be lax, strict or none", c.Cookies.SameSite))
+	}
+
+	// Check SSL certificate files
+	if c.SSL.Enabled && !c.SSL.Auto {
+		for _, f := range []string{c.SSL.Cert, c.SSL.Key} {
+			if _, err := os.Stat(f); err != nil {
+				problems = append(problems, fmt.Sprintf("SSL file %q does not exist", f))
+			}
+		}
+	}
+
+	// Check rate limiter
+	if c.RateLimit.Enabled && (c.RateLimit.Number <= 0 || c.RateLimit.Time.Duration <= 0) {
+		problems = append(problems, "RateLimit.Number and RateLimit.Time must be greater than zero")
+	}
+	for _, r := range c.RateLimit.Routes {
+		if !strings.HasPrefix(r.Path, "/") {
+			problems = append(problems, fmt.Sprintf("RateLimit route path %q must start with /", r.Path))
+		}
+		if r.Number <= 0 || r.Time.Duration <= 0 {
+			problems = append(problems, fmt.Sprintf("RateLimit route %q Number and Time must be greater than zero", r.Path))
+		}
+	}
+
+	// Check failed login protection
+	if c.Login.CaptchaAfter < 0 || c.Login.LockAfter < 0 {
+		problems = append(problems, "Login.CaptchaAfter and Login.LockAfter can not be negative")
+	}
+
+	// Check two-factor options
+	if c.TwoFactor.RecoveryCodes < 0 {
+		problems = append(problems, "TwoFactor.RecoveryCodes can not be negative")
+	}
+
+	// Check email verification
+	if c.EmailVerification.Enabled && !c.Mail.Enabled {
+		problems = append(problems, "EmailVerification needs Mail to be enabled")
+	}
+
+	// Check password hashing
+	switch c.Password.Scheme {
+	case "", PasswordSHA1, PasswordBcrypt, PasswordArgon2id:
+	default:
+		problems = append(problems, fmt.Sprintf("Password.Scheme %q must be sha1, bcrypt or argon2id", c.Password.Scheme))
+	}
+	if c.Password.BcryptCost != 0 && (c.Password.BcryptCost < bcrypt.MinCost || c.Password.BcryptCost > bcrypt.MaxCost) {
+		problems = append(problems, fmt.Sprintf("Password.BcryptCost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+
+	// Check trusted proxies
+	if _, err := ParseTrustedProxies(c.SSL.TrustedProxies); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	// Check mail transport
+	switch c.Mail.Transport {
+	case "", MailTransportSMTP:
+		if c.Mail.Enabled && (c.Mail.Server == "" || c.Mail.Port <= 0) {
+			problems = append(problems, "Mail.Server and Mail.Port are needed when mail is enabled")
+		}
+	case MailTransportFile:
+	default:
+		problems = append(problems, fmt.Sprintf("Mail.Transport %q must be smtp or file", c.Mail.Transport))
+	}
+	if c.Mail.Workers < 0 || c.Mail.MaxAttempts < 0 {
+		problems = append(problems, "Mail.Workers and Mail.MaxAttempts can not be negative")
+	}
 
-func getCSPField(name string, def []string, src []string) string {
-	// Data holder
-	buff := name
+	// Check captcha provider
+	switch c.Captcha.Provider {
+	case "", CaptchaProviderRecaptcha:
+		if c.Captcha.Enabled && (c.Captcha.Public == "" || c.Captcha.Secret == "") {
+			problems = append(problems, "Captcha.Public and Captcha.Secret are needed when captcha is enabled")
+		}
+	default:
+		captchaRW.RLock()
+		if _, ok := captchaProviders[c.Captcha.Provider]; !ok {
+			problems = append(problems, fmt.Sprintf("Captcha.Provider %q is not a known captcha provider", c.Captcha.Provider))
+		}
+		captchaRW.RUnlock()
+	}
+	if c.Captcha.Length < 0 || c.Captcha.Length > 10 {
+		problems = append(problems, "Captcha.Length can not be negative or greater than 10")
+	}
 
-	// Loop default values
-	for _, d := range def {
-		buff += " '" + d + "' "
+	// Check extension archive options
+	if _, err := ParseTrustedKeys(c.Plugin.TrustedKeys); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.Plugin.RequireSignature && len(c.Plugin.TrustedKeys) == 0 {
+		problems = append(problems, "Plugin.RequireSignature needs at least one Plugin.TrustedKeys entry")
+	}
+	if c.Plugin.MaxArchiveSize < 0 {
+		problems = append(problems, "Plugin.MaxArchiveSize can not be negative")
+	}
+	if c.Plugin.Registry != "" {
+		if info, err := os.Stat(c.Plugin.Registry); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("Plugin.Registry directory %q does not exist", c.Plugin.Registry))
+		}
+	}
+
+	// Check static directory
+	if c.Static.Enabled {
+		if info, err := os.Stat(c.Static.Directory); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("Static directory %q does not exist", c.Static.Directory))
+		}
 	}
 
-	// Loop src values
-	for _, s := range src {
-		buff += " " + s + " "
+	// Check payment gateways
+	if c.PayPal.Enabled && (c.PayPal.PublicKey == "" || c.PayPal.SecretKey == "") {
+		problems = append(problems, "PayPal.PublicKey and PayPal.SecretKey are needed when PayPal is enabled")
+	}
+	if c.PayGol.Enabled && c.PayGol.Secret == "" {
+		problems = append(problems, "PayGol.Secret is needed when PayGol is enabled")
+	}
+	if c.Fortumo.Enabled && c.Fortumo.Secret == "" {
+		problems = append(problems, "Fortumo.Secret is needed when Fortumo is enabled")
 	}
 
-	return buff + ";"
+	return problems
+}
+
+// IsDev checks if castro is running on development mode
+func (c Configuration) IsDev() bool {
+	return c.Mode == "dev"
+}
+
+// IsLog checks if castro is running on log mode
+func (c Configuration) IsLog() bool {
+	return c.Mode == "log"
 }
 
 // IsSSL returns if the server is behind SSL
@@ -288,6 +561,18 @@ func (c Configuration) IsSSL() bool {
 	return false
 }
 
+// SameSiteMode returns the SameSite attribute used for the cookies. Defaults to lax
+func (c CookieConfig) SameSiteMode() http.SameSite {
+	switch strings.ToLower(c.SameSite) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
 // EncodeConfig encodes the given io writer
 func EncodeConfig(configFile io.Writer, c *Configuration) error {
 	// Lock mutex
@@ -375,7 +660,7 @@ func LoadExternalConfigFiles() ([]string, error) {
 	// Installed extensions placeholder
 	var installed []string
 	// Get installed extensions
-	if err := database.DB.Select(&installed, "SELECT id FROM castro_extensions WHERE installed = b'1'"); err != nil {
+	if err := database.DB.Select(&installed, "SELECT id FROM castro_extensions WHERE installed = 1"); err != nil {
 		return nil, err
 	}
 
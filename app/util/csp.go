@@ -0,0 +1,192 @@
+package util
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// cspAliases maps the short directive names to the header names
+var cspAliases = map[string]string{
+	"default": "default-src",
+	"frame":   "frame-src",
+	"script":  "script-src",
+	"font":    "font-src",
+	"connect": "connect-src",
+	"style":   "style-src",
+	"image":   "img-src",
+	"img":     "img-src",
+}
+
+// cspDirectives directives that can be changed for a single response
+var cspDirectives = map[string]bool{
+	"default-src":  true,
+	"frame-src":    true,
+	"script-src":   true,
+	"font-src":     true,
+	"connect-src":  true,
+	"style-src":    true,
+	"img-src":      true,
+	"media-src":    true,
+	"object-src":   true,
+	"worker-src":   true,
+	"manifest-src": true,
+	"child-src":    true,
+	"form-action":  true,
+}
+
+// cspScriptDirectives directives that control which scripts run. Their sources
+// must name a single host
+var cspScriptDirectives = map[string]bool{
+	"default-src": true,
+	"script-src":  true,
+	"worker-src":  true,
+	"object-src":  true,
+	"child-src":   true,
+}
+
+var (
+	// cspSchemeRegexp valid scheme sources such as https: or data:
+	cspSchemeRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.\-]*:$`)
+
+	// cspHostRegexp valid host sources such as https://*.example.com:8080/path
+	cspHostRegexp = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.\-]*://)?(\*|(\*\.)?[A-Za-z0-9\-]+(\.[A-Za-z0-9\-]+)*)(:([0-9]+|\*))?(/[A-Za-z0-9\-._~!$&()*+=:@%/]*)?$`)
+)
+
+// cspDirective holds the keywords and sources of a policy directive
+type cspDirective struct {
+	Name     string
+	Keywords []string
+	Sources  []string
+}
+
+// ContentSecurityPolicy holds the Content-Security-Policy of a single response
+type ContentSecurityPolicy struct {
+	rw         sync.Mutex
+	Nonce      string
+	directives []*cspDirective
+}
+
+// NewContentSecurityPolicy creates the policy of a response from the given
+// configuration with a new random nonce
+func NewContentSecurityPolicy(c SecurityConfig) (*ContentSecurityPolicy, error) {
+	// Create random nonce
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	csp := &ContentSecurityPolicy{
+		Nonce: base64.StdEncoding.EncodeToString(b),
+	}
+
+	csp.directive("default-src").Keywords = append([]string{}, c.CSP.Default...)
+	csp.addType("frame-src", c.CSP.Frame)
+	csp.addType("script-src", c.CSP.Script)
+	csp.addType("font-src", c.CSP.Font)
+	csp.addType("connect-src", c.CSP.Connect)
+	csp.addType("style-src", c.CSP.Style)
+	csp.addType("img-src", c.CSP.Image)
+
+	// Allow scripts with the response nonce
+	if c.NonceEnabled {
+		script := csp.directive("script-src")
+		script.Keywords = append(script.Keywords, "nonce-"+csp.Nonce)
+	}
+
+	return csp, nil
+}
+
+// addType copies the values of a configuration policy type
+func (c *ContentSecurityPolicy) addType(name string, t ContentSecurityPolicyType) {
+	d := c.directive(name)
+	d.Keywords = append(d.Keywords, t.Default...)
+	d.Sources = append(d.Sources, t.SRC...)
+}
+
+// directive returns the directive of the given name creating it if needed
+func (c *ContentSecurityPolicy) directive(name string) *cspDirective {
+	for _, d := range c.directives {
+		if d.Name == name {
+			return d
+		}
+	}
+
+	d := &cspDirective{
+		Name: name,
+	}
+	c.directives = append(c.directives, d)
+
+	return d
+}
+
+// Allow adds a source to the given directive. Directives can use the header
+// name (script-src) or the short name (script). Sources must be a host, a
+// scheme or the 'self' keyword, other keywords can only be set on the configuration.
+// Script directives only accept 'self' and hosts without wildcards
+func (c *ContentSecurityPolicy) Allow(directive, source string) error {
+	// Get header name
+	name := strings.ToLower(strings.TrimSpace(directive))
+	if alias, ok := cspAliases[name]; ok {
+		name = alias
+	}
+
+	if !cspDirectives[name] {
+		return fmt.Errorf("unknown Content-Security-Policy directive %q", directive)
+	}
+
+	if source != "'self'" && !cspSchemeRegexp.MatchString(source) && !cspHostRegexp.MatchString(source) {
+		return fmt.Errorf("invalid Content-Security-Policy source %q", source)
+	}
+
+	// Scheme and wildcard sources would let any site run scripts
+	if cspScriptDirectives[name] && (cspSchemeRegexp.MatchString(source) || strings.Contains(source, "*")) {
+		return fmt.Errorf("Content-Security-Policy source %q is not allowed on %v", source, name)
+	}
+
+	c.rw.Lock()
+	defer c.rw.Unlock()
+
+	d := c.directive(name)
+
+	// Skip duplicated sources
+	for _, s := range d.Sources {
+		if s == source {
+			return nil
+		}
+	}
+
+	d.Sources = append(d.Sources, source)
+
+	return nil
+}
+
+// String returns a valid Content-Security-Policy header value
+func (c *ContentSecurityPolicy) String() string {
+	c.rw.Lock()
+	defer c.rw.Unlock()
+
+	fields := []string{}
+
+	for _, d := range c.directives {
+		values := []string{d.Name}
+
+		for _, k := range d.Keywords {
+			values = append(values, "'"+k+"'")
+		}
+
+		values = append(values, d.Sources...)
+
+		// Skip empty directives
+		if len(values) == 1 {
+			continue
+		}
+
+		fields = append(fields, strings.Join(values, " "))
+	}
+
+	return strings.Join(fields, "; ")
+}
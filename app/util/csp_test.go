@@ -0,0 +1,49 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestContentSecurityPolicyAllow(t *testing.T) {
+	tests := []struct {
+		directive string
+		source    string
+		fail      bool
+	}{
+		{directive: "frame", source: "https://www.paypal.com"},
+		{directive: "script-src", source: "https://cdn.example.com:8080/js/"},
+		{directive: "script", source: "'self'"},
+		{directive: "script-src", source: "https://*.example.com:8080/js/", fail: true},
+		{directive: "script", source: "*", fail: true},
+		{directive: "script", source: "https://*", fail: true},
+		{directive: "script", source: "https:", fail: true},
+		{directive: "script", source: "data:", fail: true},
+		{directive: "default", source: "data:", fail: true},
+		{directive: "worker-src", source: "blob:", fail: true},
+		{directive: "script", source: "'unsafe-eval'", fail: true},
+		{directive: "connect", source: "https://*.example.com"},
+		{directive: "img", source: "data:"},
+		{directive: "style", source: "'self'"},
+		{directive: "connect", source: "api.example.com"},
+		{directive: "script", source: "'unsafe-inline'", fail: true},
+		{directive: "script", source: "https://a.com; script-src *", fail: true},
+		{directive: "script", source: "https://a.com 'unsafe-eval'", fail: true},
+		{directive: "script", source: "https://a.com/x,y", fail: true},
+		{directive: "script", source: "", fail: true},
+		{directive: "report-uri", source: "https://a.com", fail: true},
+		{directive: "script; default-src", source: "https://a.com", fail: true},
+	}
+
+	for _, test := range tests {
+		csp := &ContentSecurityPolicy{}
+		err := csp.Allow(test.directive, test.source)
+		if test.fail != (err != nil) {
+			t.Errorf("Allow(%q, %q) error = %v", test.directive, test.source, err)
+			continue
+		}
+		if !test.fail && !strings.HasSuffix(csp.String(), " "+test.source) {
+			t.Errorf("Allow(%q, %q) policy = %q", test.directive, test.source, csp.String())
+		}
+	}
+}
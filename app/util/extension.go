@@ -0,0 +1,272 @@
+package util
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/raggaer/castro/app/models"
+)
+
+// ExtensionManifestName name of the manifest file of every extension
+const ExtensionManifestName = "extension.json"
+
+// extensionIDRegexp valid extension identifiers. Identifiers are folder names
+// so they must start with a letter or number
+var extensionIDRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.\-]*$`)
+
+// ValidExtensionID checks if the given extension identifier can be used as the
+// name of its folder
+func ValidExtensionID(id string) bool {
+	return id != "." && id != ".." && extensionIDRegexp.MatchString(id)
+}
+
+// ExtensionManifest holds the contents of an extension manifest file
+type ExtensionManifest struct {
+	ManifestVersion int                              `json:"manifestVersion"`
+	ID              string                           `json:"id"`
+	Name            string                           `json:"name"`
+	Author          string                           `json:"author"`
+	Version         string                           `json:"version"`
+	Description     string                           `json:"description"`
+	Type            string                           `json:"type"`
+	URL             string                           `json:"url"`
+	Castro          string                           `json:"castro"`
+	Dependencies    map[string]string                `json:"dependencies"`
+	Scripts         ExtensionScripts                 `json:"scripts"`
+	Hooks           map[string]ExtensionManifestHook `json:"hooks"`
+	TemplateHooks   map[string]string                `json:"templateHooks"`
+	Permissions     map[string]string                `json:"permissions"`
+	Capabilities    []string                         `json:"capabilities"`
+	Config          []ExtensionConfigField           `json:"config"`
+}
+
+// ExtensionScripts holds the lifecycle script file names of an extension
+type ExtensionScripts struct {
+	Install   string `json:"install"`
+	Upgrade   string `json:"upgrade"`
+	Uninstall string `json:"uninstall"`
+}
+
+// ExtensionManifestHook holds a manifest hook. Hooks can be written as the
+// script name or as an object with the script and its priority
+type ExtensionManifestHook struct {
+	Script   string `json:"script"`
+	Priority int    `json:"priority"`
+}
+
+// UnmarshalJSON reads a hook written as a string or as an object
+func (h *ExtensionManifestHook) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &h.Script); err == nil {
+		return nil
+	}
+
+	type hook ExtensionManifestHook
+	return json.Unmarshal(data, (*hook)(h))
+}
+
+// LoadExtensionManifest reads and validates the manifest of the given extension
+func LoadExtensionManifest(id string) (*ExtensionManifest, error) {
+	if !ValidExtensionID(id) {
+		return nil, fmt.Errorf("Invalid extension id %q", id)
+	}
+
+	// Read manifest file
+	data, err := ioutil.ReadFile(filepath.Join("extensions", id, ExtensionManifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseExtensionManifest(data, id)
+}
+
+// ParseExtensionManifest parses and validates the manifest of the given extension
+// folder. An empty folder name accepts the id of the manifest
+func ParseExtensionManifest(data []byte, dir string) (*ExtensionManifest, error) {
+	manifest := &ExtensionManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("Cannot parse extension manifest: %v", err)
+	}
+
+	if dir == "" {
+		dir = manifest.ID
+	}
+
+	// Apply defaults
+	if manifest.ManifestVersion == 0 {
+		manifest.ManifestVersion = 1
+	}
+	if manifest.Author == "" {
+		manifest.Author = "Anonymous"
+	}
+	if manifest.Description == "" {
+		manifest.Description = "-"
+	}
+	if manifest.Scripts.Install == "" {
+		manifest.Scripts.Install = "install.lua"
+	}
+	if manifest.Scripts.Upgrade == "" {
+		manifest.Scripts.Upgrade = "upgrade.lua"
+	}
+	if manifest.Scripts.Uninstall == "" {
+		manifest.Scripts.Uninstall = "uninstall.lua"
+	}
+
+	if problems := manifest.Validate(dir); len(problems) > 0 {
+		return nil, fmt.Errorf("Invalid %v manifest: %v", dir, problems[0])
+	}
+
+	return manifest, nil
+}
+
+// Validate returns a list of problems of the manifest of the given extension folder
+func (m *ExtensionManifest) Validate(dir string) []string {
+	problems := []string{}
+
+	if m.ManifestVersion < 1 || m.ManifestVersion > 2 {
+		problems = append(problems, fmt.Sprintf("manifestVersion %d is not supported", m.ManifestVersion))
+	}
+
+	if !ValidExtensionID(m.ID) {
+		problems = append(problems, fmt.Sprintf("invalid id %q", m.ID))
+	} else if m.ID != dir {
+		problems = append(problems, fmt.Sprintf("id %q must match the extension folder %q", m.ID, dir))
+	}
+
+	if m.Name == "" {
+		problems = append(problems, "name is required")
+	}
+
+	if _, err := ParseVersion(m.Version); err != nil {
+		problems = append(problems, fmt.Sprintf("version: %v", err))
+	}
+
+	if _, err := ParseVersionConstraint(m.Castro); err != nil {
+		problems = append(problems, fmt.Sprintf("castro: %v", err))
+	}
+
+	for _, id := range m.DependencyList() {
+		if id == m.ID {
+			problems = append(problems, "an extension can not depend on itself")
+		}
+
+		if !ValidExtensionID(id) {
+			problems = append(problems, fmt.Sprintf("invalid dependency id %q", id))
+		}
+
+		if _, err := ParseVersionConstraint(m.Dependencies[id]); err != nil {
+			problems = append(problems, fmt.Sprintf("dependency %v: %v", id, err))
+		}
+	}
+
+	// Permissions are removed from every role on uninstall so extensions can
+	// only declare their own
+	for _, permission := range m.PermissionList() {
+		switch {
+		case permission == models.AllPermissions || models.IsCorePermission(permission):
+			problems = append(problems, fmt.Sprintf("permission %v is reserved", permission))
+		case !strings.HasPrefix(permission, m.ID+".") || len(permission) == len(m.ID)+1:
+			problems = append(problems, fmt.Sprintf("permission %v must start with %v.", permission, m.ID))
+		}
+	}
+
+	if _, err := ParseExtensionCapabilities(m.Capabilities); err != nil {
+		problems = append(problems, fmt.Sprintf("capabilities: %v", err))
+	}
+
+	fields := map[string]bool{}
+	for i := range m.Config {
+		field := &m.Config[i]
+
+		if fields[field.Name] {
+			problems = append(problems, fmt.Sprintf("config field %v is declared twice", field.Name))
+		}
+
+		fields[field.Name] = true
+		problems = append(problems, field.validateSchema()...)
+	}
+
+	for name, hook := range m.Hooks {
+		if hook.Script == "" {
+			problems = append(problems, fmt.Sprintf("hook %v has no script", name))
+		}
+	}
+
+	return problems
+}
+
+// SemanticVersion returns the parsed extension version
+func (m *ExtensionManifest) SemanticVersion() Version {
+	v, _ := ParseVersion(m.Version)
+	return v
+}
+
+// CapabilityGrant returns the capability list saved when the extension is
+// installed. A missing list grants no capabilities. Extensions the administrator
+// installs with unrestricted access are saved as NULL
+func (m *ExtensionManifest) CapabilityGrant(unrestricted bool) sql.NullString {
+	if unrestricted {
+		return sql.NullString{}
+	}
+
+	capabilities := m.Capabilities
+	if capabilities == nil {
+		capabilities = []string{}
+	}
+
+	data, _ := json.Marshal(capabilities)
+
+	return sql.NullString{
+		String: string(data),
+		Valid:  true,
+	}
+}
+
+// PermissionList returns the declared permission names sorted by name
+func (m *ExtensionManifest) PermissionList() []string {
+	list := []string{}
+	for name := range m.Permissions {
+		list = append(list, name)
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// DependencyList returns the dependency identifiers sorted by name
+func (m *ExtensionManifest) DependencyList() []string {
+	list := []string{}
+	for id := range m.Dependencies {
+		list = append(list, id)
+	}
+
+	sort.Strings(list)
+
+	return list
+}
+
+// CheckCastroVersion checks if the extension supports the running castro version.
+// Builds without a semantic version are considered compatible
+func (m *ExtensionManifest) CheckCastroVersion() error {
+	current, err := ParseVersion(VERSION)
+	if err != nil {
+		return nil
+	}
+
+	c, err := ParseVersionConstraint(m.Castro)
+	if err != nil {
+		return err
+	}
+
+	if !c.Check(current) {
+		return fmt.Errorf("%v requires castro %v, running %v", m.ID, m.Castro, current.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidExtensionID(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{id: "news", valid: true},
+		{id: "my-extension_2.0", valid: true},
+		{id: "0day", valid: true},
+		{id: "", valid: false},
+		{id: ".", valid: false},
+		{id: "..", valid: false},
+		{id: ".hidden", valid: false},
+		{id: "-flag", valid: false},
+		{id: "a/b", valid: false},
+		{id: "a\\b", valid: false},
+		{id: "a b", valid: false},
+	}
+
+	for _, test := range tests {
+		if valid := ValidExtensionID(test.id); valid != test.valid {
+			t.Errorf("ValidExtensionID(%q) = %v, expected %v", test.id, valid, test.valid)
+		}
+	}
+}
+
+func TestExtensionManifestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(m *ExtensionManifest)
+		problem string
+	}{
+		{name: "valid", change: func(m *ExtensionManifest) {}},
+		{name: "manifest version", change: func(m *ExtensionManifest) { m.ManifestVersion = 3 }, problem: "manifestVersion"},
+		{name: "invalid id", change: func(m *ExtensionManifest) { m.ID = ".." }, problem: "invalid id"},
+		{name: "folder", change: func(m *ExtensionManifest) { m.ID = "other" }, problem: "must match the extension folder"},
+		{name: "name", change: func(m *ExtensionManifest) { m.Name = "" }, problem: "name is required"},
+		{name: "version", change: func(m *ExtensionManifest) { m.Version = "one" }, problem: "version"},
+		{name: "castro", change: func(m *ExtensionManifest) { m.Castro = "=>1" }, problem: "castro"},
+		{name: "self dependency", change: func(m *ExtensionManifest) { m.Dependencies = map[string]string{"news": "*"} }, problem: "depend on itself"},
+		{name: "dependency id", change: func(m *ExtensionManifest) { m.Dependencies = map[string]string{"../x": "*"} }, problem: "invalid dependency id"},
+		{name: "dependency range", change: func(m *ExtensionManifest) { m.Dependencies = map[string]string{"base": "~x"} }, problem: "dependency base"},
+		{name: "all permissions", change: func(m *ExtensionManifest) { m.Permissions = map[string]string{"*": ""} }, problem: "reserved"},
+		{name: "core permission", change: func(m *ExtensionManifest) { m.Permissions = map[string]string{"extensions.install": ""} }, problem: "reserved"},
+		{name: "permission prefix", change: func(m *ExtensionManifest) { m.Permissions = map[string]string{"forum.post": ""} }, problem: "must start with news."},
+		{name: "empty permission", change: func(m *ExtensionManifest) { m.Permissions = map[string]string{"news.": ""} }, problem: "must start with news."},
+		{name: "capabilities", change: func(m *ExtensionManifest) { m.Capabilities = []string{"file:../"} }, problem: "capabilities"},
+		{name: "config type", change: func(m *ExtensionManifest) { m.Config = []ExtensionConfigField{{Name: "a", Type: "color"}} }, problem: "unknown type"},
+		{name: "config twice", change: func(m *ExtensionManifest) {
+			m.Config = []ExtensionConfigField{{Name: "a", Type: ExtensionConfigString}, {Name: "a", Type: ExtensionConfigString}}
+		}, problem: "declared twice"},
+		{name: "hook script", change: func(m *ExtensionManifest) { m.Hooks = map[string]ExtensionManifestHook{"onLogin": {}} }, problem: "has no script"},
+	}
+
+	for _, test := range tests {
+		m := &ExtensionManifest{
+			ManifestVersion: 2,
+			ID:              "news",
+			Name:            "News",
+			Version:         "1.0.0",
+			Castro:          ">=1.0",
+			Dependencies:    map[string]string{"base": "^1.0"},
+			Permissions:     map[string]string{"news.post": "Post news"},
+			Capabilities:    []string{"db.read", "db.write:news"},
+			Config:          []ExtensionConfigField{{Name: "title", Type: ExtensionConfigString}},
+			Hooks:           map[string]ExtensionManifestHook{"onLogin": {Script: "login.lua"}},
+		}
+		test.change(m)
+
+		problems := m.Validate("news")
+
+		if test.problem == "" {
+			if len(problems) > 0 {
+				t.Errorf("%v: unexpected problems %v", test.name, problems)
+			}
+			continue
+		}
+
+		if len(problems) == 0 || !strings.Contains(problems[0], test.problem) {
+			t.Errorf("%v: expected a %q problem, got %v", test.name, test.problem, problems)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// ExtensionRegistryIndex name of the index file of a local extension registry
+	ExtensionRegistryIndex = "index.json"
+
+	// defaultMaxArchiveSize archive size limit used when Plugin.MaxArchiveSize is not set
+	defaultMaxArchiveSize = 20 << 20
+
+	// extensionUnpackRatio limit of the uncompressed archive size as a multiple of the archive size limit
+	extensionUnpackRatio = 10
+)
+
+// ExtensionRegistryEntry holds an extension of the local registry index. The
+// archive is a path relative to the registry folder or an http(s) URL
+type ExtensionRegistryEntry struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Version     string `json:"version"`
+	Description string `json:"description"`
+	Archive     string `json:"archive"`
+	SHA256      string `json:"sha256"`
+	Signature   string `json:"signature"`
+}
+
+// ParseTrustedKeys decodes a list of base64 encoded ed25519 public keys
+func ParseTrustedKeys(keys []string) ([]ed25519.PublicKey, error) {
+	list := []ed25519.PublicKey{}
+
+	for _, k := range keys {
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(k))
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Plugin.TrustedKeys entry %q is not a base64 ed25519 public key", k)
+		}
+
+		list = append(list, ed25519.PublicKey(key))
+	}
+
+	return list, nil
+}
+
+// MaxExtensionArchiveSize returns the archive size limit in bytes
+func MaxExtensionArchiveSize() int64 {
+	if size := Config.Configuration.Plugin.MaxArchiveSize; size > 0 {
+		return size
+	}
+
+	return defaultMaxArchiveSize
+}
+
+// VerifyExtensionArchive checks the archive against the given hex encoded SHA-256
+// checksum and the base64 encoded ed25519 signature. The signature is optional
+// unless Plugin.RequireSignature is enabled, but when present it must be valid
+// for one of the trusted keys
+func VerifyExtensionArchive(data []byte, checksum, signature string) error {
+	expected, err := hex.DecodeString(strings.TrimSpace(checksum))
+	if err != nil || len(expected) != sha256.Size {
+		return errors.New("a hex encoded SHA-256 checksum is required")
+	}
+
+	sum := sha256.Sum256(data)
+	if subtle.ConstantTimeCompare(sum[:], expected) != 1 {
+		return fmt.Errorf("checksum mismatch, archive SHA-256 is %x", sum)
+	}
+
+	signature = strings.TrimSpace(signature)
+
+	if signature == "" {
+		if Config.Configuration.Plugin.RequireSignature {
+			return errors.New("a signature is required")
+		}
+		return nil
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return errors.New("the signature is not a base64 ed25519 signature")
+	}
+
+	keys, err := ParseTrustedKeys(Config.Configuration.Plugin.TrustedKeys)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if ed25519.Verify(key, data, sig) {
+			return nil
+		}
+	}
+
+	return errors.New("the signature does not match any trusted key")
+}
+
+// ExtractExtensionArchive unpacks the given zip archive into the destination
+// folder and returns its manifest. The extension files can be at the root of the
+// archive or inside a single top folder
+func ExtractExtensionArchive(data []byte, dest string) (*ExtensionManifest, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open archive: %v", err)
+	}
+
+	prefix, err := extensionArchivePrefix(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := UnzipReader(r, prefix, dest, MaxExtensionArchiveSize()*extensionUnpackRatio); err != nil {
+		return nil, err
+	}
+
+	manifestData, err := ioutil.ReadFile(filepath.Join(dest, ExtensionManifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseExtensionManifest(manifestData, "")
+}
+
+// ReadExtensionArchiveManifest returns the manifest of the given zip archive
+// without unpacking it
+func ReadExtensionArchiveManifest(data []byte) (*ExtensionManifest, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("cannot open archive: %v", err)
+	}
+
+	prefix, err := extensionArchivePrefix(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range r.File {
+		if strings.Replace(f.Name, "\\", "/", -1) != prefix+ExtensionManifestName {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+
+		manifestData, err := ioutil.ReadAll(io.LimitReader(rc, MaxExtensionArchiveSize()))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		return ParseExtensionManifest(manifestData, "")
+	}
+
+	return nil, fmt.Errorf("archive has no %v at its root", ExtensionManifestName)
+}
+
+// extensionArchivePrefix returns the folder of the archive that holds the manifest
+func extensionArchivePrefix(r *zip.Reader) (string, error) {
+	top := map[string]bool{}
+	manifests := map[string]bool{}
+
+	for _, f := range r.File {
+		name := strings.Replace(f.Name, "\\", "/", -1)
+
+		if i := strings.Index(name, "/"); i != -1 {
+			top[name[:i+1]] = true
+		} else {
+			top[""] = true
+		}
+
+		if path.Base(name) == ExtensionManifestName {
+			manifests[path.Dir(name)] = true
+		}
+	}
+
+	if manifests["."] {
+		return "", nil
+	}
+
+	if len(top) == 1 {
+		for prefix := range top {
+			if prefix != "" && manifests[strings.TrimSuffix(prefix, "/")] {
+				return prefix, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("archive has no %v at its root", ExtensionManifestName)
+}
+
+// ReadExtensionArchive reads the given archive file applying the size limit
+func ReadExtensionArchive(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	return readLimitedArchive(f)
+}
+
+// DownloadExtensionArchive downloads the given archive applying the size limit
+func DownloadExtensionArchive(url string) ([]byte, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("invalid archive URL %q", url)
+	}
+
+	client := &http.Client{
+		Timeout: time.Minute,
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot download archive: %v", resp.Status)
+	}
+
+	return readLimitedArchive(resp.Body)
+}
+
+// readLimitedArchive reads an archive failing when it exceeds the size limit
+func readLimitedArchive(r io.Reader) ([]byte, error) {
+	max := MaxExtensionArchiveSize()
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("archive is bigger than %d bytes", max)
+	}
+
+	return data, nil
+}
+
+// LoadExtensionRegistry reads the index of the local extension registry
+func LoadExtensionRegistry() ([]ExtensionRegistryEntry, error) {
+	dir := Config.Configuration.Plugin.Registry
+	if dir == "" {
+		return nil, errors.New("Plugin.Registry is not configured")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, ExtensionRegistryIndex))
+	if err != nil {
+		return nil, err
+	}
+
+	index := struct {
+		Extensions []ExtensionRegistryEntry `json:"extensions"`
+	}{}
+
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("cannot parse registry index: %v", err)
+	}
+
+	return index.Extensions, nil
+}
+
+// FindExtensionRegistryEntry returns the registry entry of the given extension
+func FindExtensionRegistryEntry(id string) (*ExtensionRegistryEntry, error) {
+	entries, err := LoadExtensionRegistry()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		if entries[i].ID == id {
+			return &entries[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("%v is not in the registry", id)
+}
+
+// ReadArchive reads the archive of the registry entry. Local archives must be
+// inside the registry folder
+func (e *ExtensionRegistryEntry) ReadArchive() ([]byte, error) {
+	if strings.HasPrefix(e.Archive, "http://") || strings.HasPrefix(e.Archive, "https://") {
+		return DownloadExtensionArchive(e.Archive)
+	}
+
+	dir := filepath.Clean(Config.Configuration.Plugin.Registry)
+	file := filepath.Join(dir, filepath.FromSlash(e.Archive))
+
+	if e.Archive == "" || filepath.IsAbs(e.Archive) || !strings.HasPrefix(file, dir+string(os.PathSeparator)) {
+		return nil, fmt.Errorf("invalid archive path %q", e.Archive)
+	}
+
+	return ReadExtensionArchive(file)
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// testArchive creates the contents of an archive with the given entries
+func testArchive(t *testing.T, entries ...testZipEntry) []byte {
+	buff := &bytes.Buffer{}
+	w := zip.NewWriter(buff)
+
+	for _, entry := range entries {
+		f, err := w.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buff.Bytes()
+}
+
+func TestReadExtensionArchiveManifest(t *testing.T) {
+	Config = &ConfigurationFile{Configuration: &Configuration{}}
+
+	manifest := `{"manifestVersion": 2, "id": "news", "name": "News", "version": "1.0.0"}`
+
+	tests := []struct {
+		name    string
+		entries []testZipEntry
+		id      string
+		fail    string
+	}{
+		{name: "root", entries: []testZipEntry{{name: ExtensionManifestName, body: manifest}, {name: "pages/index.lua"}}, id: "news"},
+		{name: "folder", entries: []testZipEntry{{name: "news/" + ExtensionManifestName, body: manifest}, {name: "news/pages/index.lua"}}, id: "news"},
+		{name: "nested", entries: []testZipEntry{{name: "a/b/" + ExtensionManifestName, body: manifest}}, fail: "has no"},
+		{name: "missing", entries: []testZipEntry{{name: "pages/index.lua"}}, fail: "has no"},
+		{name: "invalid", entries: []testZipEntry{{name: ExtensionManifestName, body: `{"id": "../news"}`}}, fail: "Invalid"},
+	}
+
+	for _, test := range tests {
+		m, err := ReadExtensionArchiveManifest(testArchive(t, test.entries...))
+
+		if test.fail != "" {
+			if err == nil || !strings.Contains(err.Error(), test.fail) {
+				t.Errorf("%v: expected a %q error, got %v", test.name, test.fail, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+		} else if m.ID != test.id {
+			t.Errorf("%v: manifest id %q, expected %q", test.name, m.ID, test.id)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ExtensionConfigString single line text field
+	ExtensionConfigString = "string"
+
+	// ExtensionConfigText multi line text field
+	ExtensionConfigText = "text"
+
+	// ExtensionConfigNumber decimal number field
+	ExtensionConfigNumber = "number"
+
+	// ExtensionConfigInteger integer number field
+	ExtensionConfigInteger = "integer"
+
+	// ExtensionConfigBoolean checkbox field
+	ExtensionConfigBoolean = "boolean"
+
+	// ExtensionConfigSelect field that only accepts one of its options
+	ExtensionConfigSelect = "select"
+)
+
+// extensionConfigNameRegexp valid config field names
+var extensionConfigNameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// ExtensionConfigField holds a field of the config schema of an extension
+type ExtensionConfigField struct {
+	Name        string      `json:"name"`
+	Label       string      `json:"label"`
+	Description string      `json:"description"`
+	Type        string      `json:"type"`
+	Default     interface{} `json:"default"`
+	Required    bool        `json:"required"`
+	Secret      bool        `json:"secret"`
+	Min         *float64    `json:"min"`
+	Max         *float64    `json:"max"`
+	MinLength   int         `json:"minLength"`
+	MaxLength   int         `json:"maxLength"`
+	Pattern     string      `json:"pattern"`
+	Options     []string    `json:"options"`
+}
+
+// validateSchema returns a list of problems of the field definition
+func (f *ExtensionConfigField) validateSchema() []string {
+	problems := []string{}
+
+	if !extensionConfigNameRegexp.MatchString(f.Name) {
+		return append(problems, fmt.Sprintf("config field name %q is not valid", f.Name))
+	}
+
+	switch f.Type {
+	case ExtensionConfigString, ExtensionConfigText, ExtensionConfigNumber, ExtensionConfigInteger, ExtensionConfigBoolean:
+	case ExtensionConfigSelect:
+		if len(f.Options) == 0 {
+			problems = append(problems, fmt.Sprintf("config field %v needs a list of options", f.Name))
+		}
+	default:
+		return append(problems, fmt.Sprintf("config field %v has an unknown type %q", f.Name, f.Type))
+	}
+
+	if f.Pattern != "" {
+		if _, err := regexp.Compile(f.Pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("config field %v pattern: %v", f.Name, err))
+		}
+	}
+
+	if f.Min != nil && f.Max != nil && *f.Min > *f.Max {
+		problems = append(problems, fmt.Sprintf("config field %v min is greater than max", f.Name))
+	}
+
+	if f.MinLength < 0 || f.MaxLength < 0 || (f.MaxLength > 0 && f.MinLength > f.MaxLength) {
+		problems = append(problems, fmt.Sprintf("config field %v has an invalid length range", f.Name))
+	}
+
+	if f.Default != nil {
+		if _, err := f.Check(f.Default); err != nil {
+			problems = append(problems, fmt.Sprintf("config field %v default: %v", f.Name, err))
+		}
+	}
+
+	return problems
+}
+
+// DefaultValue returns the default value of the field or the zero value of its type
+func (f *ExtensionConfigField) DefaultValue() interface{} {
+	if f.Default != nil {
+		if v, err := f.Check(f.Default); err == nil {
+			return v
+		}
+	}
+
+	switch f.Type {
+	case ExtensionConfigNumber:
+		return float64(0)
+	case ExtensionConfigInteger:
+		return int64(0)
+	case ExtensionConfigBoolean:
+		return false
+	}
+
+	return ""
+}
+
+// Parse converts a form value to the field type and validates it
+func (f *ExtensionConfigField) Parse(raw string) (interface{}, error) {
+	switch f.Type {
+	case ExtensionConfigNumber:
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			return f.Check(nil)
+		}
+
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, errors.New("must be a number")
+		}
+
+		return f.Check(v)
+	case ExtensionConfigInteger:
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			return f.Check(nil)
+		}
+
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, errors.New("must be an integer")
+		}
+
+		return f.Check(v)
+	case ExtensionConfigBoolean:
+		switch strings.ToLower(strings.TrimSpace(raw)) {
+		case "", "0", "false", "off":
+			return f.Check(false)
+		case "1", "true", "on":
+			return f.Check(true)
+		}
+
+		return nil, errors.New("must be true or false")
+	}
+
+	return f.Check(raw)
+}
+
+// Check validates the given value and converts it to the field type. Numbers
+// can be given as any Go numeric type
+func (f *ExtensionConfigField) Check(value interface{}) (interface{}, error) {
+	switch f.Type {
+	case ExtensionConfigNumber, ExtensionConfigInteger:
+		if value == nil {
+			if f.Required {
+				return nil, errors.New("is required")
+			}
+			return f.DefaultValue(), nil
+		}
+
+		n, ok := configNumber(value)
+		if !ok {
+			return nil, errors.New("must be a number")
+		}
+
+		if f.Type == ExtensionConfigInteger && n != math.Trunc(n) {
+			return nil, errors.New("must be an integer")
+		}
+
+		if f.Min != nil && n < *f.Min {
+			return nil, fmt.Errorf("must be at least %v", *f.Min)
+		}
+
+		if f.Max != nil && n > *f.Max {
+			return nil, fmt.Errorf("must be at most %v", *f.Max)
+		}
+
+		if f.Type == ExtensionConfigInteger {
+			return int64(n), nil
+		}
+
+		return n, nil
+	case ExtensionConfigBoolean:
+		if value == nil {
+			return false, nil
+		}
+
+		b, ok := value.(bool)
+		if !ok {
+			return nil, errors.New("must be true or false")
+		}
+
+		return b, nil
+	}
+
+	if value == nil {
+		value = ""
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return nil, errors.New("must be a text")
+	}
+
+	if s == "" {
+		if f.Required {
+			return nil, errors.New("is required")
+		}
+		return s, nil
+	}
+
+	if f.Type == ExtensionConfigString && strings.ContainsAny(s, "\r\n") {
+		return nil, errors.New("must be a single line")
+	}
+
+	if f.Type == ExtensionConfigSelect {
+		for _, option := range f.Options {
+			if s == option {
+				return s, nil
+			}
+		}
+
+		return nil, fmt.Errorf("must be one of %v", strings.Join(f.Options, ", "))
+	}
+
+	if length := len([]rune(s)); length < f.MinLength {
+		return nil, fmt.Errorf("must be at least %d characters long", f.MinLength)
+	} else if f.MaxLength > 0 && length > f.MaxLength {
+		return nil, fmt.Errorf("must be at most %d characters long", f.MaxLength)
+	}
+
+	if f.Pattern != "" {
+		if re, err := regexp.Compile(f.Pattern); err != nil || !re.MatchString(s) {
+			return nil, fmt.Errorf("must match %v", f.Pattern)
+		}
+	}
+
+	return s, nil
+}
+
+// configNumber converts the given numeric value to float64
+func configNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	}
+
+	return 0, false
+}
+
+// ConfigField returns the config field of the manifest with the given name
+func (m *ExtensionManifest) ConfigField(name string) (*ExtensionConfigField, bool) {
+	for i := range m.Config {
+		if m.Config[i].Name == name {
+			return &m.Config[i], true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,115 @@
+package util
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses the given list of trusted proxy networks. Plain
+// addresses are treated as single host networks
+func ParseTrustedProxies(list []string) ([]*net.IPNet, error) {
+	networks := []*net.IPNet{}
+
+	for _, p := range list {
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid trusted proxy address %q", p)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+
+			networks = append(networks, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy network %q", p)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// defaultTrustedProxies networks trusted when SSL.Proxy is enabled without a
+// TrustedProxies list, so proxies running on the same machine keep working
+var defaultTrustedProxies = []string{"127.0.0.0/8", "::1"}
+
+// trustedProxyNetworks returns the trusted proxy networks of the given SSL
+// configuration
+func trustedProxyNetworks(c SSLConfig) ([]*net.IPNet, error) {
+	if len(c.TrustedProxies) == 0 && c.Proxy {
+		return ParseTrustedProxies(defaultTrustedProxies)
+	}
+
+	return ParseTrustedProxies(c.TrustedProxies)
+}
+
+// isTrustedProxy checks if the given address belongs to a trusted proxy network
+func isTrustedProxy(networks []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ClientIP returns the address of the client that sent the request. The
+// X-Forwarded-For header is only used when the request comes from a trusted
+// proxy, its addresses are read from right to left skipping the trusted proxies
+func ClientIP(req *http.Request) string {
+	return clientIP(req, Config.TrustedProxies())
+}
+
+// clientIP returns the address of the client using the given trusted proxy networks
+func clientIP(req *http.Request, networks []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	if !isTrustedProxy(networks, host) {
+		return host
+	}
+
+	// Get forwarded addresses
+	forwarded := []string{}
+	for _, header := range req.Header["X-Forwarded-For"] {
+		for _, addr := range strings.Split(header, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(addr))
+		}
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if net.ParseIP(forwarded[i]) == nil {
+			break
+		}
+
+		if !isTrustedProxy(networks, forwarded[i]) {
+			return forwarded[i]
+		}
+
+		host = forwarded[i]
+	}
+
+	return host
+}
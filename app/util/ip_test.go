@@ -0,0 +1,56 @@
+package util
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	networks, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remote    string
+		forwarded []string
+		expected  string
+	}{
+		{remote: "1.2.3.4:5000", expected: "1.2.3.4"},
+		{remote: "1.2.3.4:5000", forwarded: []string{"5.6.7.8"}, expected: "1.2.3.4"},
+		{remote: "10.0.0.1:5000", forwarded: []string{"5.6.7.8"}, expected: "5.6.7.8"},
+		{remote: "10.0.0.1:5000", forwarded: []string{"9.9.9.9, 5.6.7.8"}, expected: "5.6.7.8"},
+		{remote: "10.0.0.1:5000", forwarded: []string{"5.6.7.8, 192.168.1.1"}, expected: "5.6.7.8"},
+		{remote: "10.0.0.1:5000", forwarded: []string{"5.6.7.8", "10.0.0.2"}, expected: "5.6.7.8"},
+		{remote: "10.0.0.1:5000", forwarded: []string{"garbage, 10.0.0.2"}, expected: "10.0.0.2"},
+		{remote: "10.0.0.1:5000", expected: "10.0.0.1"},
+		{remote: "[::1]:5000", forwarded: []string{"5.6.7.8"}, expected: "::1"},
+	}
+
+	for _, test := range tests {
+		req := &http.Request{RemoteAddr: test.remote, Header: http.Header{}}
+		for _, f := range test.forwarded {
+			req.Header.Add("X-Forwarded-For", f)
+		}
+
+		if ip := clientIP(req, networks); ip != test.expected {
+			t.Errorf("clientIP(%v, %v) = %v, expected %v", test.remote, test.forwarded, ip, test.expected)
+		}
+	}
+}
+
+func TestTrustedProxyNetworks(t *testing.T) {
+	if _, err := ParseTrustedProxies([]string{"not an address"}); err == nil {
+		t.Error("expected an error for an invalid proxy")
+	}
+
+	networks, err := trustedProxyNetworks(SSLConfig{Proxy: true})
+	if err != nil || !isTrustedProxy(networks, "127.0.0.1") || isTrustedProxy(networks, "1.2.3.4") {
+		t.Errorf("unexpected default proxies %v %v", networks, err)
+	}
+
+	networks, err = trustedProxyNetworks(SSLConfig{})
+	if err != nil || len(networks) != 0 {
+		t.Errorf("unexpected proxies without SSL.Proxy %v %v", networks, err)
+	}
+}
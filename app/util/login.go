@@ -0,0 +1,429 @@
+package util
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/raggaer/castro/app/database"
+)
+
+// loginMutex serializes the failed login updates
+var loginMutex sync.Mutex
+
+// LoginStatus holds the failed login state of an account and client address
+type LoginStatus struct {
+	Failures    int
+	Captcha     bool
+	Locked      bool
+	LockedUntil time.Time
+	RetryAfter  time.Duration
+
+	// Allowed is only set by AttemptLogin when the attempt was counted and the
+	// credentials can be checked
+	Allowed bool
+
+	// UnlockToken is only set by FailLogin and AttemptLogin when the failure
+	// locked the account
+	UnlockToken string
+}
+
+// loginAttempt holds a castro_login_attempts row
+type loginAttempt struct {
+	ID           string
+	Failures     int
+	Last_failure int64
+	Locked_until int64
+	Unlock_token string
+
+	// stored holds the row as it was read. Nil if there is no row
+	stored *loginAttempt
+}
+
+// loginConfig returns the failed login options with the defaults applied
+func loginConfig() LoginConfig {
+	c := Config.Configuration.Login
+
+	if c.CaptchaAfter == 0 {
+		c.CaptchaAfter = 3
+	}
+	if c.LockAfter == 0 {
+		c.LockAfter = 10
+	}
+	if c.Backoff.Duration <= 0 {
+		c.Backoff = StringDuration{String: "1s", Duration: time.Second}
+	}
+	if c.MaxBackoff.Duration <= 0 {
+		c.MaxBackoff = StringDuration{String: "5m", Duration: time.Minute * 5}
+	}
+	if c.Lockout.Duration <= 0 {
+		c.Lockout = StringDuration{String: "30m", Duration: time.Minute * 30}
+	}
+	if c.Window.Duration <= 0 {
+		c.Window = StringDuration{String: "1h", Duration: time.Hour}
+	}
+
+	return c
+}
+
+// CreateLoginTable creates the failed login table if it does not exist
+func CreateLoginTable() error {
+	return database.InstallTable(database.DB, "castro_login_attempts")
+}
+
+// loginKeys returns the tracker keys of the given account name and address
+func loginKeys(account, ip string) (string, string) {
+	accountKey, ipKey := "", ""
+
+	if account != "" {
+		accountKey = "account:" + strings.ToLower(account)
+	}
+	if ip != "" {
+		ipKey = "ip:" + ip
+	}
+
+	return accountKey, ipKey
+}
+
+// getLoginAttempt returns the attempt row of the given key. Rows whose last
+// failure is older than the window are returned empty
+func getLoginAttempt(key string, c LoginConfig) (*loginAttempt, error) {
+	attempt := &loginAttempt{ID: key}
+
+	if key == "" {
+		return attempt, nil
+	}
+
+	if err := database.DB.Get(attempt, "SELECT id, failures, last_failure, locked_until, unlock_token FROM castro_login_attempts WHERE id = ?", key); err != nil {
+		if err == sql.ErrNoRows {
+			return attempt, nil
+		}
+		return nil, err
+	}
+
+	stored := *attempt
+	attempt.stored = &stored
+
+	// Forget old failures unless the account is still locked
+	if time.Since(time.Unix(attempt.Last_failure, 0)) > c.Window.Duration && time.Now().Unix() >= attempt.Locked_until {
+		return &loginAttempt{ID: key, stored: &stored}, nil
+	}
+
+	return attempt, nil
+}
+
+// hashUnlockToken returns the stored form of the given unlock token
+func hashUnlockToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// retryAfter returns the time left until the next attempt is allowed. The first
+// failure is free, every other failure doubles the wait
+func (a *loginAttempt) retryAfter(c LoginConfig) time.Duration {
+	if a.Failures < 2 {
+		return 0
+	}
+
+	wait := c.MaxBackoff.Duration
+	if a.Failures-2 < 32 {
+		if backoff := c.Backoff.Duration << uint(a.Failures-2); backoff > 0 && backoff < wait {
+			wait = backoff
+		}
+	}
+
+	left := time.Until(time.Unix(a.Last_failure, 0).Add(wait))
+	if left < 0 {
+		return 0
+	}
+
+	return left
+}
+
+// loginStatus builds the status of the given account and address attempts
+func loginStatus(accountAttempt, ipAttempt *loginAttempt, c LoginConfig) *LoginStatus {
+	status := &LoginStatus{
+		Failures: accountAttempt.Failures,
+	}
+
+	if ipAttempt.Failures > status.Failures {
+		status.Failures = ipAttempt.Failures
+	}
+
+	status.Captcha = status.Failures >= c.CaptchaAfter
+
+	if accountAttempt.Locked_until > time.Now().Unix() {
+		status.Locked = true
+		status.LockedUntil = time.Unix(accountAttempt.Locked_until, 0)
+	}
+
+	status.RetryAfter = accountAttempt.retryAfter(c)
+	if wait := ipAttempt.retryAfter(c); wait > status.RetryAfter {
+		status.RetryAfter = wait
+	}
+
+	return status
+}
+
+// CheckLogin returns the failed login status of the given account name and
+// client address. Any of them can be empty
+func CheckLogin(account, ip string) (*LoginStatus, error) {
+	c := loginConfig()
+	accountKey, ipKey := loginKeys(account, ip)
+
+	accountAttempt, err := getLoginAttempt(accountKey, c)
+	if err != nil {
+		return nil, err
+	}
+
+	ipAttempt, err := getLoginAttempt(ipKey, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return loginStatus(accountAttempt, ipAttempt, c), nil
+}
+
+// saveLoginAttempt saves the given attempt row
+func saveLoginAttempt(a *loginAttempt) error {
+	if a.ID == "" {
+		return nil
+	}
+
+	_, err := database.DB.Exec(
+		"REPLACE INTO castro_login_attempts (id, failures, last_failure, locked_until, unlock_token) VALUES (?, ?, ?, ?, ?)",
+		a.ID,
+		a.Failures,
+		a.Last_failure,
+		a.Locked_until,
+		a.Unlock_token,
+	)
+
+	return err
+}
+
+// claimLoginAttempt saves the given attempt row only if nobody changed it since
+// it was read. Returns false when another request changed the row first
+func claimLoginAttempt(tx *sqlx.Tx, a *loginAttempt) (bool, error) {
+	if a.ID == "" {
+		return true, nil
+	}
+
+	var (
+		result sql.Result
+		err    error
+	)
+
+	if a.stored == nil {
+		result, err = tx.Exec(
+			database.Statement(tx, "INSERT IGNORE INTO castro_login_attempts (id, failures, last_failure, locked_until, unlock_token) VALUES (?, ?, ?, ?, ?)"),
+			a.ID,
+			a.Failures,
+			a.Last_failure,
+			a.Locked_until,
+			a.Unlock_token,
+		)
+	} else {
+		result, err = tx.Exec(
+			"UPDATE castro_login_attempts SET failures = ?, last_failure = ?, locked_until = ?, unlock_token = ? WHERE id = ? AND failures = ? AND last_failure = ? AND locked_until = ?",
+			a.Failures,
+			a.Last_failure,
+			a.Locked_until,
+			a.Unlock_token,
+			a.ID,
+			a.stored.Failures,
+			a.stored.Last_failure,
+			a.stored.Locked_until,
+		)
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// lockLoginAttempt locks the given account attempt if it reached the configured
+// number of failures. Returns the unlock token or an empty string
+func lockLoginAttempt(a *loginAttempt, c LoginConfig, now int64) (string, error) {
+	if a.ID == "" || a.Failures < c.LockAfter || a.Locked_until > now {
+		return "", nil
+	}
+
+	token, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	a.Locked_until = time.Now().Add(c.Lockout.Duration).Unix()
+	a.Unlock_token = hashUnlockToken(token)
+
+	return token, nil
+}
+
+// AttemptLogin checks the failed login status of the given account name and
+// client address and counts the attempt as a failure in the same step, so
+// concurrent requests can not pass the same check. The returned status is the
+// one before the attempt. ResetLogin clears the attempt after a successful login
+func AttemptLogin(account, ip string) (*LoginStatus, error) {
+	loginMutex.Lock()
+	defer loginMutex.Unlock()
+
+	c := loginConfig()
+	accountKey, ipKey := loginKeys(account, ip)
+	now := time.Now().Unix()
+
+	accountAttempt, err := getLoginAttempt(accountKey, c)
+	if err != nil {
+		return nil, err
+	}
+
+	ipAttempt, err := getLoginAttempt(ipKey, c)
+	if err != nil {
+		return nil, err
+	}
+
+	status := loginStatus(accountAttempt, ipAttempt, c)
+	if status.Locked || status.RetryAfter > 0 {
+		return status, nil
+	}
+
+	for _, a := range []*loginAttempt{accountAttempt, ipAttempt} {
+		a.Failures++
+		a.Last_failure = now
+	}
+
+	// Lock the account in advance. The lock is removed if the login succeeds
+	token, err := lockLoginAttempt(accountAttempt, c, now)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range []*loginAttempt{accountAttempt, ipAttempt} {
+		claimed, err := claimLoginAttempt(tx, a)
+		if err != nil || !claimed {
+			tx.Rollback()
+			return status, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	status.Allowed = true
+	status.UnlockToken = token
+
+	return status, nil
+}
+
+// FailLogin records a failed login of the given account name and client address.
+// The account is locked when it reaches the configured number of failures
+func FailLogin(account, ip string) (*LoginStatus, error) {
+	loginMutex.Lock()
+	defer loginMutex.Unlock()
+
+	c := loginConfig()
+	accountKey, ipKey := loginKeys(account, ip)
+	now := time.Now().Unix()
+
+	accountAttempt, err := getLoginAttempt(accountKey, c)
+	if err != nil {
+		return nil, err
+	}
+
+	ipAttempt, err := getLoginAttempt(ipKey, c)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range []*loginAttempt{accountAttempt, ipAttempt} {
+		a.Failures++
+		a.Last_failure = now
+	}
+
+	// Lock account
+	token, err := lockLoginAttempt(accountAttempt, c, now)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := saveLoginAttempt(accountAttempt); err != nil {
+		return nil, err
+	}
+
+	if err := saveLoginAttempt(ipAttempt); err != nil {
+		return nil, err
+	}
+
+	status := loginStatus(accountAttempt, ipAttempt, c)
+	status.UnlockToken = token
+
+	return status, nil
+}
+
+// ResetLogin removes the failed logins of the given account name. Used after a
+// successful login. The failures of the client address are kept so logging in
+// to one account does not reset the attempts made against others
+func ResetLogin(account string) error {
+	accountKey, _ := loginKeys(account, "")
+	if accountKey == "" {
+		return nil
+	}
+
+	_, err := database.DB.Exec("DELETE FROM castro_login_attempts WHERE id = ?", accountKey)
+	return err
+}
+
+// UnlockLogin unlocks the account of the given unlock token. Returns the account
+// name or an empty string if the token is not valid
+func UnlockLogin(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+
+	loginMutex.Lock()
+	defer loginMutex.Unlock()
+
+	key := ""
+	if err := database.DB.Get(&key, "SELECT id FROM castro_login_attempts WHERE unlock_token = ?", hashUnlockToken(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+
+	if _, err := database.DB.Exec("DELETE FROM castro_login_attempts WHERE id = ?", key); err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(key, "account:"), nil
+}
+
+// PurgeLoginAttempts removes the old failed logins every given interval
+func PurgeLoginAttempts(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		before := time.Now().Add(-loginConfig().Window.Duration).Unix()
+
+		if _, err := database.DB.Exec("DELETE FROM castro_login_attempts WHERE last_failure < ? AND locked_until < ?", before, time.Now().Unix()); err != nil {
+			Logger.Logger.Errorf("Cannot purge failed logins: %v", err)
+		}
+	}
+}
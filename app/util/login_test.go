@@ -0,0 +1,69 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raggaer/castro/app/database"
+)
+
+func TestResetLogin(t *testing.T) {
+	Config = &ConfigurationFile{Configuration: &Configuration{}}
+
+	dir, err := ioutil.TempDir("", "castro-login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := database.OpenSQLite(filepath.Join(dir, "castro.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	previous := database.DB
+	database.DB = db
+	defer func() { database.DB = previous }()
+
+	schema, err := ioutil.ReadFile(filepath.Join("..", "..", database.InstallDirectory(database.SQLite), "castro_login_attempts.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := AttemptLogin("Admin", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ResetLogin("admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key    string
+		exists bool
+	}{
+		{key: "account:admin", exists: false},
+		{key: "ip:10.0.0.1", exists: true},
+	}
+
+	for _, test := range tests {
+		exists := false
+		if err := db.Get(&exists, "SELECT EXISTS (SELECT 1 FROM castro_login_attempts WHERE id = ?)", test.key); err != nil {
+			t.Fatal(err)
+		}
+		if exists != test.exists {
+			t.Errorf("%v exists = %v, expected %v", test.key, exists, test.exists)
+		}
+	}
+
+	// Resetting without an account name changes nothing
+	if err := ResetLogin(""); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
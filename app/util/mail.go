@@ -0,0 +1,423 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/raggaer/castro/app/database"
+	"gopkg.in/gomail.v2"
+)
+
+const (
+	// MailTransportSMTP sends the mails using the configured SMTP server
+	MailTransportSMTP = "smtp"
+
+	// MailTransportFile writes the mails to a maildir directory
+	MailTransportFile = "file"
+)
+
+// Mail queue status values
+const (
+	MailPending = iota
+	MailFailed
+)
+
+var (
+	// MailTemplate holds the html and plain text mail templates
+	MailTemplate = &MailTemplates{
+		rw: &sync.RWMutex{},
+	}
+
+	// mailWake wakes the mail dispatcher when a mail is queued
+	mailWake = make(chan struct{}, 1)
+)
+
+// MailTemplates holds the mail templates of the current template. The html
+// parts use the application template wrapper and the plain text parts use
+// text/template so they are not escaped
+type MailTemplates struct {
+	rw   *sync.RWMutex
+	HTML Tmpl
+	Text *texttemplate.Template
+}
+
+// QueuedMail holds a mail waiting on the queue
+type QueuedMail struct {
+	ID           int64
+	Recipient    string
+	Subject      string
+	HTML         string
+	Text         string
+	Attempts     int
+	Next_attempt int64
+}
+
+// MailTransport sends a mail message
+type MailTransport interface {
+	Send(m *gomail.Message) error
+}
+
+// smtpTransport sends the messages using a SMTP server
+type smtpTransport struct {
+	dialer *gomail.Dialer
+}
+
+// Send dials the SMTP server and sends the given message
+func (t *smtpTransport) Send(m *gomail.Message) error {
+	return t.dialer.DialAndSend(m)
+}
+
+// fileTransport writes the messages to a maildir directory
+type fileTransport struct {
+	dir string
+}
+
+// Send writes the given message to the tmp directory and moves it to new
+// once it is complete, following the maildir delivery rules
+func (t *fileTransport) Send(m *gomail.Message) error {
+	for _, d := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(t.dir, d), 0755); err != nil {
+			return err
+		}
+	}
+
+	// Create unique file name
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.%s.castro.eml", time.Now().UnixNano(), id[:16])
+
+	buff := &bytes.Buffer{}
+	if _, err := m.WriteTo(buff); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(t.dir, "tmp", name), buff.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(filepath.Join(t.dir, "tmp", name), filepath.Join(t.dir, "new", name))
+}
+
+// mailConfig returns the mail options with the defaults applied
+func mailConfig() MailConfig {
+	c := Config.Configuration.Mail
+
+	if c.Transport == "" {
+		c.Transport = MailTransportSMTP
+	}
+	if c.Directory == "" {
+		c.Directory = "mails"
+	}
+	if c.From == "" {
+		c.From = c.Username
+	}
+	if c.Workers == 0 {
+		c.Workers = 2
+	}
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = 5
+	}
+	if c.RetryDelay.Duration == 0 {
+		c.RetryDelay = StringDuration{String: "1m", Duration: time.Minute}
+	}
+
+	return c
+}
+
+// NewMailTransport returns the transport of the configured mail options
+func NewMailTransport() MailTransport {
+	c := mailConfig()
+
+	if c.Transport == MailTransportFile {
+		return &fileTransport{
+			dir: c.Directory,
+		}
+	}
+
+	return &smtpTransport{
+		dialer: gomail.NewPlainDialer(c.Server, c.Port, c.Username, c.Password),
+	}
+}
+
+// CreateMailQueueTable creates the mail queue table if it does not exist
+func CreateMailQueueTable() error {
+	return database.InstallTable(database.DB, "castro_mail_queue")
+}
+
+// Load parses all the mail templates of the given directory. Files with the
+// .html extension are html parts and files with the .txt extension are plain
+// text parts
+func (m *MailTemplates) Load(dir string) error {
+	// Lock mutex
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	html := NewTemplate("mail")
+	html.Tmpl.Funcs(FuncMap)
+
+	text := texttemplate.New("mail").Funcs(texttemplate.FuncMap(FuncMap))
+
+	// Mail templates are optional
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		m.HTML = html
+		m.Text = text
+		return nil
+	}
+
+	if err := html.LoadTemplates(dir); err != nil {
+		return err
+	}
+
+	// Walk over the mail directory
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+
+		// Check for walking error
+		if err != nil {
+			return err
+		}
+
+		// Check if file has .txt extension
+		if strings.HasSuffix(info.Name(), ".txt") {
+			if text, err = text.ParseFiles(path); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	m.HTML = html
+	m.Text = text
+
+	return nil
+}
+
+// Render executes the html and plain text parts of the given mail template.
+// At least one of the parts must exist. If the app is running on dev mode
+// all the mail templates are reloaded
+func (m *MailTemplates) Render(name string, args map[string]interface{}) (string, string, error) {
+	// Check if app is running on dev mode
+	if Config.Configuration.IsDev() {
+		if err := m.Load(filepath.Join(Config.Configuration.Template, "mail")); err != nil {
+			return "", "", err
+		}
+	}
+
+	// Lock mutex
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+
+	if m.HTML.Tmpl == nil {
+		return "", "", errors.New("Mail templates are not loaded")
+	}
+
+	htmlBuff := &bytes.Buffer{}
+	textBuff := &bytes.Buffer{}
+
+	if t := m.HTML.Tmpl.Lookup(name + ".html"); t != nil {
+		if err := t.Execute(htmlBuff, args); err != nil {
+			return "", "", err
+		}
+	}
+
+	if t := m.Text.Lookup(name + ".txt"); t != nil {
+		if err := t.Execute(textBuff, args); err != nil {
+			return "", "", err
+		}
+	}
+
+	if htmlBuff.Len() == 0 && textBuff.Len() == 0 {
+		return "", "", fmt.Errorf("Mail template %v not found", name)
+	}
+
+	return htmlBuff.String(), textBuff.String(), nil
+}
+
+// RenderMail executes the given mail template in the given language. The
+// language is available to the templates as the lang value
+func RenderMail(name, lang string, args map[string]interface{}) (string, string, error) {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+
+	args["lang"] = lang
+
+	return MailTemplate.Render(name, args)
+}
+
+// MailSubject returns the subject of the given mail template from the
+// mail_<name>_subject language index
+func MailSubject(name, lang string) (string, bool) {
+	index := "mail_" + name + "_subject"
+
+	for _, l := range []string{lang, "default"} {
+		if language, ok := LanguageFiles.Get(l); ok {
+			if subject, ok := language.Data[index]; ok {
+				return subject, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// QueueMail saves the given mail on the queue. The html or the text part can
+// be empty but not both
+func QueueMail(to, subject, html, text string) error {
+	if html == "" && text == "" {
+		return errors.New("Cannot queue a mail without body")
+	}
+
+	_, err := database.DB.Exec(
+		"INSERT INTO castro_mail_queue (recipient, subject, html, text, last_error, created_at) VALUES (?, ?, ?, ?, '', ?)",
+		to,
+		subject,
+		html,
+		text,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// Wake the dispatcher without blocking
+	select {
+	case mailWake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// newMailMessage creates the message of the given queued mail
+func newMailMessage(from string, q *QueuedMail) *gomail.Message {
+	m := gomail.NewMessage()
+
+	// Set headers
+	m.SetHeader("From", from)
+	m.SetHeader("To", q.Recipient)
+	m.SetHeader("Subject", q.Subject)
+
+	// Set plain text body and the html alternative
+	switch {
+	case q.Text != "" && q.HTML != "":
+		m.SetBody("text/plain", q.Text)
+		m.AddAlternative("text/html", q.HTML)
+	case q.Text != "":
+		m.SetBody("text/plain", q.Text)
+	default:
+		m.SetBody("text/html", q.HTML)
+	}
+
+	return m
+}
+
+// deliverMail sends the given queued mail and updates the queue. Failed mails
+// are retried with an exponential backoff until the max attempts are reached
+func deliverMail(transport MailTransport, c MailConfig, q *QueuedMail) {
+	err := transport.Send(newMailMessage(c.From, q))
+
+	// Remove mail from the queue
+	if err == nil {
+		if _, err := database.DB.Exec("DELETE FROM castro_mail_queue WHERE id = ?", q.ID); err != nil {
+			Logger.Logger.Errorf("Cannot remove sent mail %v from the queue: %v", q.ID, err)
+		}
+		return
+	}
+
+	attempts := q.Attempts + 1
+
+	if attempts >= c.MaxAttempts {
+		Logger.Logger.Errorf("Cannot send mail %v to %v after %v attempts: %v", q.ID, q.Recipient, attempts, err)
+
+		if _, dbErr := database.DB.Exec(
+			"UPDATE castro_mail_queue SET status = ?, attempts = ?, last_error = ? WHERE id = ?",
+			MailFailed,
+			attempts,
+			err.Error(),
+			q.ID,
+		); dbErr != nil {
+			Logger.Logger.Errorf("Cannot update mail %v: %v", q.ID, dbErr)
+		}
+		return
+	}
+
+	// Double the delay on every attempt
+	delay := c.RetryDelay.Duration << uint(attempts-1)
+
+	Logger.Logger.Warningf("Cannot send mail %v to %v, retrying in %v: %v", q.ID, q.Recipient, delay, err)
+
+	if _, dbErr := database.DB.Exec(
+		"UPDATE castro_mail_queue SET attempts = ?, next_attempt = ?, last_error = ? WHERE id = ?",
+		attempts,
+		time.Now().Add(delay).Unix(),
+		err.Error(),
+		q.ID,
+	); dbErr != nil {
+		Logger.Logger.Errorf("Cannot update mail %v: %v", q.ID, dbErr)
+	}
+}
+
+// RunMailQueue sends the queued mails using the configured number of
+// workers. The queue is checked every given interval or when a mail is queued
+func RunMailQueue(interval time.Duration) {
+	c := mailConfig()
+	transport := NewMailTransport()
+
+	jobs := make(chan *QueuedMail)
+	wg := &sync.WaitGroup{}
+
+	// Start workers
+	for i := 0; i < c.Workers; i++ {
+		go func() {
+			for q := range jobs {
+				deliverMail(transport, c, q)
+				wg.Done()
+			}
+		}()
+	}
+
+	for {
+		mails := []*QueuedMail{}
+
+		if err := database.DB.Select(
+			&mails,
+			"SELECT id, recipient, subject, html, text, attempts, next_attempt FROM castro_mail_queue WHERE status = ? AND next_attempt <= ? ORDER BY id LIMIT 50",
+			MailPending,
+			time.Now().Unix(),
+		); err != nil {
+			Logger.Logger.Errorf("Cannot load mail queue: %v", err)
+		}
+
+		// Wait for the batch so a mail is never sent twice
+		for _, q := range mails {
+			wg.Add(1)
+			jobs <- q
+		}
+		wg.Wait()
+
+		// Keep going while there are due mails
+		if len(mails) == 50 {
+			continue
+		}
+
+		select {
+		case <-mailWake:
+		case <-time.After(interval):
+		}
+	}
+}
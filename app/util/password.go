@@ -0,0 +1,236 @@
+package util
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/raggaer/castro/app/database"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// PasswordSHA1 unsalted sha1 hex hashes used by TFS
+	PasswordSHA1 = "sha1"
+
+	// PasswordBcrypt bcrypt hashes
+	PasswordBcrypt = "bcrypt"
+
+	// PasswordArgon2id argon2id hashes in the PHC string format
+	PasswordArgon2id = "argon2id"
+
+	// argon2KeyLength length of the argon2id derived key
+	argon2KeyLength = 32
+
+	// argon2SaltLength length of the argon2id salt
+	argon2SaltLength = 16
+)
+
+// passwordColumnShort is set when the accounts password column can not hold
+// the hashes of the configured scheme. New hashes use sha1 meanwhile
+var passwordColumnShort bool
+
+// passwordConfig returns the password hashing options with the defaults applied
+func passwordConfig() PasswordConfig {
+	c := Config.Configuration.Password
+
+	if c.Scheme == "" || passwordColumnShort {
+		c.Scheme = PasswordSHA1
+	}
+	if c.BcryptCost == 0 {
+		c.BcryptCost = bcrypt.DefaultCost
+	}
+	if c.Argon2Time == 0 {
+		c.Argon2Time = 1
+	}
+	if c.Argon2Memory == 0 {
+		c.Argon2Memory = 64 * 1024
+	}
+	if c.Argon2Threads == 0 {
+		c.Argon2Threads = 4
+	}
+
+	return c
+}
+
+// PasswordScheme returns the scheme of the given stored hash or an empty
+// string if the format is unknown
+func PasswordScheme(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return PasswordBcrypt
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return PasswordArgon2id
+	case len(hash) == sha1.Size*2:
+		if _, err := hex.DecodeString(hash); err == nil {
+			return PasswordSHA1
+		}
+	}
+
+	return ""
+}
+
+// HashPassword hashes the given password with the configured scheme
+func HashPassword(password string) (string, error) {
+	c := passwordConfig()
+
+	switch c.Scheme {
+	case PasswordBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), c.BcryptCost)
+		return string(hash), err
+	case PasswordArgon2id:
+
+		// Create random salt
+		salt := make([]byte, argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+
+		key := argon2.IDKey([]byte(password), salt, c.Argon2Time, c.Argon2Memory, c.Argon2Threads, argon2KeyLength)
+
+		return fmt.Sprintf(
+			"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version,
+			c.Argon2Memory,
+			c.Argon2Time,
+			c.Argon2Threads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	case PasswordSHA1:
+		hash := sha1.Sum([]byte(password))
+		return hex.EncodeToString(hash[:]), nil
+	}
+
+	return "", fmt.Errorf("Unknown password scheme %q", c.Scheme)
+}
+
+// VerifyPassword checks the given password against a stored hash of any
+// known scheme. Rehash is true when the hash does not use the configured
+// scheme or options and should be replaced after a successful login
+func VerifyPassword(password, hash string) (valid bool, rehash bool) {
+	c := passwordConfig()
+
+	switch PasswordScheme(hash) {
+	case PasswordSHA1:
+		sum := sha1.Sum([]byte(password))
+		valid = subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(hash))) == 1
+		rehash = c.Scheme != PasswordSHA1
+	case PasswordBcrypt:
+		valid = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+		cost, err := bcrypt.Cost([]byte(hash))
+		rehash = c.Scheme != PasswordBcrypt || err != nil || cost != c.BcryptCost
+	case PasswordArgon2id:
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, false
+		}
+		other := argon2.IDKey([]byte(password), salt, params.Argon2Time, params.Argon2Memory, params.Argon2Threads, uint32(len(key)))
+		valid = subtle.ConstantTimeCompare(key, other) == 1
+		rehash = c.Scheme != PasswordArgon2id ||
+			params.Argon2Time != c.Argon2Time ||
+			params.Argon2Memory != c.Argon2Memory ||
+			params.Argon2Threads != c.Argon2Threads
+	}
+
+	return valid, valid && rehash
+}
+
+// decodeArgon2id decodes an argon2id PHC string
+func decodeArgon2id(hash string) (PasswordConfig, []byte, []byte, error) {
+	params := PasswordConfig{
+		Scheme: PasswordArgon2id,
+	}
+
+	// $argon2id$v=19$m=65536,t=1,p=4$salt$key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, errors.New("Invalid argon2id hash")
+	}
+
+	version := 0
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, err
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("Unsupported argon2id version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Argon2Memory, &params.Argon2Time, &params.Argon2Threads); err != nil {
+		return params, nil, nil, err
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, err
+	}
+
+	return params, salt, key, nil
+}
+
+// CheckPasswordColumn checks if the accounts password column can hold the
+// hashes of the configured scheme. TFS creates it as CHAR(40) for sha1. The
+// game server schema is never changed here, see WidenPasswordColumn. New
+// passwords are hashed with sha1 until the check passes
+func CheckPasswordColumn() error {
+	passwordColumnShort = false
+
+	scheme := passwordConfig().Scheme
+	if scheme == PasswordSHA1 || database.Dialect(database.DB) == database.SQLite {
+		return nil
+	}
+
+	length, err := passwordColumnLength()
+	if err != nil {
+		passwordColumnShort = true
+		return err
+	}
+
+	if length < 255 {
+		passwordColumnShort = true
+		return fmt.Errorf("accounts.password holds %d characters but %v hashes need 255. Run castro password widen", length, scheme)
+	}
+
+	return nil
+}
+
+// WidenPasswordColumn changes the accounts password column to VARCHAR(255) so
+// it can hold bcrypt and argon2id hashes. Returns false if it was already wide enough
+func WidenPasswordColumn() (bool, error) {
+	if database.Dialect(database.DB) == database.SQLite {
+		return false, nil
+	}
+
+	length, err := passwordColumnLength()
+	if err != nil {
+		return false, err
+	}
+
+	if length >= 255 {
+		return false, nil
+	}
+
+	_, err = database.DB.Exec("ALTER TABLE accounts MODIFY password VARCHAR(255) NOT NULL")
+	return err == nil, err
+}
+
+// passwordColumnLength returns the length of the accounts password column
+func passwordColumnLength() (int, error) {
+	length := 0
+	err := database.DB.Get(
+		&length,
+		"SELECT CHARACTER_MAXIMUM_LENGTH FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name = 'accounts' AND column_name = 'password'",
+	)
+	return length, err
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setPasswordConfig sets cheap password hashing options of the given scheme
+func setPasswordConfig(scheme string) {
+	Config = &ConfigurationFile{Configuration: &Configuration{}}
+	Config.Configuration.Password = PasswordConfig{
+		Scheme:        scheme,
+		BcryptCost:    bcrypt.MinCost,
+		Argon2Time:    1,
+		Argon2Memory:  1024,
+		Argon2Threads: 1,
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	for _, scheme := range []string{PasswordSHA1, PasswordBcrypt, PasswordArgon2id} {
+		setPasswordConfig(scheme)
+
+		hash, err := HashPassword("secret")
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", scheme, err)
+			continue
+		}
+
+		if s := PasswordScheme(hash); s != scheme {
+			t.Errorf("%v: hash %q detected as %q", scheme, hash, s)
+		}
+
+		if valid, rehash := VerifyPassword("secret", hash); !valid || rehash {
+			t.Errorf("%v: VerifyPassword = %v %v, expected true false", scheme, valid, rehash)
+		}
+
+		if valid, _ := VerifyPassword("Secret", hash); valid {
+			t.Errorf("%v: wrong password accepted", scheme)
+		}
+	}
+
+	// New hashes use sha1 while the password column is too short
+	setPasswordConfig(PasswordBcrypt)
+	passwordColumnShort = true
+	hash, err := HashPassword("secret")
+	passwordColumnShort = false
+	if err != nil || PasswordScheme(hash) != PasswordSHA1 {
+		t.Errorf("expected a sha1 hash with a short password column, got %q %v", hash, err)
+	}
+
+	setPasswordConfig("md5")
+	if _, err := HashPassword("secret"); err == nil {
+		t.Error("expected an error for an unknown scheme")
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	setPasswordConfig(PasswordSHA1)
+	sha1Hash, _ := HashPassword("secret")
+
+	setPasswordConfig(PasswordBcrypt)
+	bcryptHash, _ := HashPassword("secret")
+
+	setPasswordConfig(PasswordArgon2id)
+	argon2Hash, _ := HashPassword("secret")
+
+	tests := []struct {
+		scheme   string
+		password string
+		hash     string
+		valid    bool
+		rehash   bool
+	}{
+		{scheme: PasswordSHA1, password: "secret", hash: sha1Hash, valid: true},
+		{scheme: PasswordSHA1, password: "secret", hash: "E5E9FA1BA31ECD1AE84F75CAAA474F3A663F05F4", valid: true},
+		{scheme: PasswordBcrypt, password: "secret", hash: sha1Hash, valid: true, rehash: true},
+		{scheme: PasswordBcrypt, password: "wrong", hash: sha1Hash, valid: false},
+		{scheme: PasswordArgon2id, password: "secret", hash: bcryptHash, valid: true, rehash: true},
+		{scheme: PasswordArgon2id, password: "secret", hash: argon2Hash, valid: true},
+		{scheme: PasswordSHA1, password: "secret", hash: argon2Hash, valid: true, rehash: true},
+		{scheme: PasswordArgon2id, password: "secret", hash: "$argon2id$v=19$m=1024,t=1,p=1$bad", valid: false},
+		{scheme: PasswordSHA1, password: "secret", hash: "plain", valid: false},
+		{scheme: PasswordSHA1, password: "", hash: "", valid: false},
+	}
+
+	for _, test := range tests {
+		setPasswordConfig(test.scheme)
+
+		valid, rehash := VerifyPassword(test.password, test.hash)
+		if valid != test.valid || rehash != test.rehash {
+			t.Errorf("VerifyPassword(%q, %q) with %v = %v %v, expected %v %v", test.password, test.hash, test.scheme, valid, rehash, test.valid, test.rehash)
+		}
+	}
+
+	// Hashes with other options are replaced
+	setPasswordConfig(PasswordBcrypt)
+	Config.Configuration.Password.BcryptCost = bcrypt.MinCost + 1
+	if valid, rehash := VerifyPassword("secret", bcryptHash); !valid || !rehash {
+		t.Errorf("expected a rehash for another bcrypt cost, got %v %v", valid, rehash)
+	}
+}
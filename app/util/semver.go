@@ -0,0 +1,192 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version holds a semantic version. Missing minor and patch numbers are zero
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+}
+
+// VersionConstraint holds a version range. The range matches when every
+// comparator of any of its sets matches
+type VersionConstraint struct {
+	raw  string
+	sets [][]versionComparator
+}
+
+// versionComparator compares a version against a fixed version
+type versionComparator struct {
+	op      string
+	version Version
+}
+
+// ParseVersion parses versions such as 1, 1.2, 1.2.3 or v1.2.3-beta
+func ParseVersion(s string) (Version, error) {
+	v := Version{}
+
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+
+	// Remove build metadata
+	if i := strings.Index(s, "+"); i != -1 {
+		s = s[:i]
+	}
+
+	// Get prerelease
+	if i := strings.Index(s, "-"); i != -1 {
+		v.Prerelease = s[i+1:]
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 || s == "" {
+		return v, fmt.Errorf("Invalid version %q", s)
+	}
+
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("Invalid version %q", s)
+		}
+		*numbers[i] = n
+	}
+
+	return v, nil
+}
+
+// String returns the version as major.minor.patch
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare returns -1, 0 or 1 if the version is lower, equal or greater than the given version.
+// Prerelease versions are lower than their release
+func (v Version) Compare(o Version) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+
+	switch {
+	case v.Prerelease == o.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case o.Prerelease == "":
+		return -1
+	case v.Prerelease < o.Prerelease:
+		return -1
+	}
+
+	return 1
+}
+
+// ParseVersionConstraint parses version ranges. Comparators are separated by spaces and
+// sets by ||, for example ">=1.2 <2 || ^3.0.0". Supported operators are =, !=, >, >=, <,
+// <=, ^ (same major version) and ~ (same minor version). An empty range or * matches
+// every version
+func ParseVersionConstraint(s string) (*VersionConstraint, error) {
+	c := &VersionConstraint{
+		raw: strings.TrimSpace(s),
+	}
+
+	for _, set := range strings.Split(s, "||") {
+		comparators := []versionComparator{}
+
+		for _, field := range strings.Fields(set) {
+			if field == "*" || field == "x" {
+				continue
+			}
+
+			// Get operator
+			op := strings.TrimRight(field, "0123456789.v-+abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
+
+			switch op {
+			case "", "=", "!=", ">", ">=", "<", "<=", "^", "~":
+			default:
+				return nil, fmt.Errorf("Invalid version range %q: unknown operator %q", s, op)
+			}
+
+			v, err := ParseVersion(field[len(op):])
+			if err != nil {
+				return nil, fmt.Errorf("Invalid version range %q: %v", s, err)
+			}
+
+			if op == "" {
+				op = "="
+			}
+
+			comparators = append(comparators, versionComparator{op: op, version: v})
+		}
+
+		c.sets = append(c.sets, comparators)
+	}
+
+	return c, nil
+}
+
+// String returns the range as written
+func (c *VersionConstraint) String() string {
+	return c.raw
+}
+
+// Check checks if the given version is inside the range
+func (c *VersionConstraint) Check(v Version) bool {
+	for _, set := range c.sets {
+		ok := true
+
+		for _, comparator := range set {
+			if !comparator.check(v) {
+				ok = false
+				break
+			}
+		}
+
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check compares the given version
+func (c versionComparator) check(v Version) bool {
+	cmp := v.Compare(c.version)
+
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "^":
+		return cmp >= 0 && v.Major == c.version.Major
+	case "~":
+		return cmp >= 0 && v.Major == c.version.Major && v.Minor == c.version.Minor
+	}
+
+	return false
+}
@@ -0,0 +1,124 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected Version
+		fail     bool
+	}{
+		{version: "1", expected: Version{Major: 1}},
+		{version: "1.2", expected: Version{Major: 1, Minor: 2}},
+		{version: "1.2.3", expected: Version{Major: 1, Minor: 2, Patch: 3}},
+		{version: "v1.2.3", expected: Version{Major: 1, Minor: 2, Patch: 3}},
+		{version: " 1.2.3 ", expected: Version{Major: 1, Minor: 2, Patch: 3}},
+		{version: "1.2.3-beta", expected: Version{Major: 1, Minor: 2, Patch: 3, Prerelease: "beta"}},
+		{version: "1.2.3+build.5", expected: Version{Major: 1, Minor: 2, Patch: 3}},
+		{version: "1.2.3-rc.1+build", expected: Version{Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1"}},
+		{version: "", fail: true},
+		{version: "1.2.3.4", fail: true},
+		{version: "1.x", fail: true},
+		{version: "1.-2", fail: true},
+		{version: "a.b.c", fail: true},
+	}
+
+	for _, test := range tests {
+		v, err := ParseVersion(test.version)
+		if test.fail {
+			if err == nil {
+				t.Errorf("ParseVersion(%q) expected an error, got %v", test.version, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVersion(%q) unexpected error: %v", test.version, err)
+			continue
+		}
+		if v != test.expected {
+			t.Errorf("ParseVersion(%q) = %+v, expected %+v", test.version, v, test.expected)
+		}
+	}
+}
+
+func TestVersionCompare(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{a: "1.0.0", b: "1.0.0", expected: 0},
+		{a: "1", b: "1.0.0", expected: 0},
+		{a: "1.0.1", b: "1.0.0", expected: 1},
+		{a: "1.9.0", b: "1.10.0", expected: -1},
+		{a: "2.0.0", b: "1.99.99", expected: 1},
+		{a: "1.0.0-beta", b: "1.0.0", expected: -1},
+		{a: "1.0.0", b: "1.0.0-beta", expected: 1},
+		{a: "1.0.0-alpha", b: "1.0.0-beta", expected: -1},
+	}
+
+	for _, test := range tests {
+		a, _ := ParseVersion(test.a)
+		b, _ := ParseVersion(test.b)
+
+		if cmp := a.Compare(b); cmp != test.expected {
+			t.Errorf("%v.Compare(%v) = %d, expected %d", test.a, test.b, cmp, test.expected)
+		}
+	}
+}
+
+func TestParseVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		match      bool
+		fail       bool
+	}{
+		{constraint: "", version: "0.1.0", match: true},
+		{constraint: "*", version: "9.9.9", match: true},
+		{constraint: "1.2.3", version: "1.2.3", match: true},
+		{constraint: "=1.2.3", version: "1.2.4", match: false},
+		{constraint: "!=1.2.3", version: "1.2.4", match: true},
+		{constraint: ">1.2", version: "1.2.0", match: false},
+		{constraint: ">=1.2", version: "1.2.0", match: true},
+		{constraint: "<2", version: "1.99.0", match: true},
+		{constraint: "<2", version: "2.0.0-beta", match: true},
+		{constraint: "<=2", version: "2.0.1", match: false},
+		{constraint: ">=1.2 <2", version: "1.5.0", match: true},
+		{constraint: ">=1.2 <2", version: "2.0.0", match: false},
+		{constraint: "^1.2.0", version: "1.9.0", match: true},
+		{constraint: "^1.2.0", version: "2.0.0", match: false},
+		{constraint: "^1.2.0", version: "1.1.0", match: false},
+		{constraint: "~1.2.0", version: "1.2.9", match: true},
+		{constraint: "~1.2.0", version: "1.3.0", match: false},
+		{constraint: "<1 || ^3.0.0", version: "3.1.0", match: true},
+		{constraint: "<1 || ^3.0.0", version: "2.0.0", match: false},
+		{constraint: "=>1.0", fail: true},
+		{constraint: ">=1.x", fail: true},
+		{constraint: "1.2.3.4", fail: true},
+	}
+
+	for _, test := range tests {
+		c, err := ParseVersionConstraint(test.constraint)
+		if test.fail {
+			if err == nil {
+				t.Errorf("ParseVersionConstraint(%q) expected an error", test.constraint)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVersionConstraint(%q) unexpected error: %v", test.constraint, err)
+			continue
+		}
+
+		v, err := ParseVersion(test.version)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if match := c.Check(v); match != test.match {
+			t.Errorf("%q.Check(%v) = %v, expected %v", test.constraint, test.version, match, test.match)
+		}
+	}
+}
@@ -1,13 +1,114 @@
 package util
 
 import (
-	"github.com/gorilla/securecookie"
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
 	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/raggaer/castro/app/database"
+)
+
+const (
+	// DatabaseSessionStore name of the database session store
+	DatabaseSessionStore = "database"
+
+	// MemorySessionStore name of the in-memory session store
+	MemorySessionStore = "memory"
+
+	// sessionTouchInterval minimum time between last seen updates
+	sessionTouchInterval = time.Minute
+
+	// sessionIdleLifetime time after which an unseen session is removed when the
+	// session cookie has no max age
+	sessionIdleLifetime = time.Hour * 24
 )
 
-// SessionStore main application session storage
+// sessionBaseKeys session values that do not need the session to be stored
+var sessionBaseKeys = map[string]bool{
+	"issuer":     true,
+	"csrf-token": true,
+}
+
+// SessionStore main application session storage. Used to sign the session cookie
 var SessionStore *securecookie.SecureCookie
 
+// Sessions holds the server-side session storage
+var Sessions SessionStorage
+
+// Session holds a server-side session
+type Session struct {
+	ID        string
+	Account   string
+	IP        string
+	UserAgent string
+	CreatedAt time.Time
+	LastSeen  time.Time
+	Data      map[string]interface{}
+
+	// stored is true once the session is in the session storage
+	stored bool
+}
+
+// sessionCookieValue holds the encoded value of the session cookie. Sessions
+// that are not stored keep their data in the cookie
+type sessionCookieValue struct {
+	ID        string
+	CreatedAt int64
+	Data      map[string]interface{}
+}
+
+// SessionStorage is implemented by the server-side session backends
+type SessionStorage interface {
+	// Get returns the session with the given id or nil if it does not exist
+	Get(id string) (*Session, error)
+
+	// Save creates or updates the given session
+	Save(s *Session) error
+
+	// Delete removes the session with the given id
+	Delete(id string) error
+
+	// List returns all the sessions of the given account
+	List(account string) ([]*Session, error)
+
+	// Purge removes the sessions not seen since the given time
+	Purge(before time.Time) error
+}
+
+// memorySession holds an encoded in-memory session
+type memorySession struct {
+	Session
+	Data []byte
+}
+
+// memorySessionStorage stores sessions in memory. Sessions are lost on restart
+type memorySessionStorage struct {
+	rw       sync.RWMutex
+	sessions map[string]*memorySession
+}
+
+// databaseSessionStorage stores sessions in the castro_sessions table
+type databaseSessionStorage struct{}
+
+// sessionRow holds a castro_sessions row
+type sessionRow struct {
+	ID         string
+	Account    string
+	IP         string
+	User_agent string
+	Data       []byte
+	Created_at int64
+	Last_seen  int64
+}
+
 // SessionCookie returns a session cookie pointer
 func SessionCookie(v string) *http.Cookie {
 	return &http.Cookie{
@@ -17,5 +118,414 @@ func SessionCookie(v string) *http.Cookie {
 		Secure:   Config.Configuration.IsSSL(),
 		MaxAge:   Config.Configuration.Cookies.MaxAge,
 		HttpOnly: true,
+		SameSite: Config.Configuration.Cookies.SameSiteMode(),
+	}
+}
+
+// NewSessionStorage creates the session storage of the given name
+func NewSessionStorage(name string) (SessionStorage, error) {
+	switch name {
+	case MemorySessionStore:
+		return &memorySessionStorage{
+			sessions: make(map[string]*memorySession),
+		}, nil
+	case "", DatabaseSessionStore:
+
+		// Create sessions table
+		if err := database.InstallTable(database.DB, "castro_sessions"); err != nil {
+			return nil, err
+		}
+
+		return &databaseSessionStorage{}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown session store %v", name)
+}
+
+// NewSession creates a new session with a random id
+func NewSession(req *http.Request) (*Session, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{
+		ID:        id,
+		IP:        ClientIP(req),
+		UserAgent: req.UserAgent(),
+		CreatedAt: time.Now(),
+		Data: map[string]interface{}{
+			"issuer": "Castro",
+		},
+	}, nil
+}
+
+// newSessionID returns a random opaque session id
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// LoggedAccount returns the name of the account logged in the session
+func (s *Session) LoggedAccount() string {
+	if logged, ok := s.Data["logged"].(bool); !ok || !logged {
+		return ""
+	}
+
+	name, _ := s.Data["loggedAccount"].(string)
+
+	return name
+}
+
+// Stored checks if the session is kept in the session storage. New sessions are
+// only stored once they hold more than the base values, until then their data
+// is kept in the session cookie
+func (s *Session) Stored() bool {
+	return s.stored
+}
+
+// needsStorage checks if the session holds values other than the base ones
+func (s *Session) needsStorage() bool {
+	for key := range s.Data {
+		if !sessionBaseKeys[key] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Cookie encodes the session id, and the data of sessions that are not stored,
+// and returns the session cookie
+func (s *Session) Cookie() (*http.Cookie, error) {
+	value := &sessionCookieValue{
+		ID:        s.ID,
+		CreatedAt: s.CreatedAt.Unix(),
+	}
+
+	if !s.stored {
+		value.Data = s.Data
+	}
+
+	encoded, err := SessionStore.Encode(Config.Configuration.Cookies.Name, value)
+	if err != nil {
+		return nil, err
+	}
+
+	return SessionCookie(encoded), nil
+}
+
+// LoadSession decodes the given session cookie value and returns its session.
+// Returns nil if the cookie is not valid or the session does not exist
+func LoadSession(cookie string) (*Session, error) {
+	value := &sessionCookieValue{}
+
+	// Invalid or old cookies start a new session
+	if err := SessionStore.Decode(Config.Configuration.Cookies.Name, cookie, value); err != nil {
+		return nil, nil
+	}
+
+	if value.Data == nil {
+		return Sessions.Get(value.ID)
+	}
+
+	return &Session{
+		ID:        value.ID,
+		CreatedAt: time.Unix(value.CreatedAt, 0),
+		Data:      value.Data,
+	}, nil
+}
+
+// Save stores the session data. A new id is given to the session when a different
+// account logs in so a session id known before the login cannot be used. Sessions
+// that only hold the base values are not stored, their data is kept in the cookie
+func (s *Session) Save() error {
+	account := s.LoggedAccount()
+
+	if account != "" && account != s.Account {
+
+		// Remove old session
+		if err := Sessions.Delete(s.ID); err != nil {
+			return err
+		}
+
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+
+		s.ID = id
+	}
+
+	s.Account = account
+	s.LastSeen = time.Now()
+
+	if !s.stored && !s.needsStorage() {
+		return nil
+	}
+
+	if err := Sessions.Save(s); err != nil {
+		return err
+	}
+
+	s.stored = true
+
+	return nil
+}
+
+// Touch updates the session client information. The session is only saved if the
+// client changed or if it was not seen for a while
+func (s *Session) Touch(req *http.Request) (bool, error) {
+	ip := ClientIP(req)
+
+	if s.IP == ip && s.UserAgent == req.UserAgent() && time.Since(s.LastSeen) < sessionTouchInterval {
+		return false, nil
+	}
+
+	s.IP = ip
+	s.UserAgent = req.UserAgent()
+
+	return true, s.Save()
+}
+
+// Regenerate removes the session from the storage and gives it a new id. The
+// session needs to be saved again
+func (s *Session) Regenerate() error {
+	if err := Sessions.Delete(s.ID); err != nil {
+		return err
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return err
+	}
+
+	s.ID = id
+	s.CreatedAt = time.Now()
+	s.stored = false
+
+	return nil
+}
+
+// encodeSessionData encodes the session data map
+func encodeSessionData(data map[string]interface{}) ([]byte, error) {
+	buff := &bytes.Buffer{}
+
+	if err := gob.NewEncoder(buff).Encode(data); err != nil {
+		return nil, err
+	}
+
+	return buff.Bytes(), nil
+}
+
+// decodeSessionData decodes the session data map
+func decodeSessionData(b []byte) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+
+	if err := gob.NewDecoder(bytes.NewBuffer(b)).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// sortSessions sorts the given sessions by last seen time, newest first
+func sortSessions(list []*Session) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastSeen.After(list[j].LastSeen)
+	})
+}
+
+func (m *memorySessionStorage) Get(id string) (*Session, error) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+
+	stored, ok := m.sessions[id]
+	if !ok {
+		return nil, nil
+	}
+
+	// Decode a copy of the session data
+	data, err := decodeSessionData(stored.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	s := stored.Session
+	s.Data = data
+	s.stored = true
+
+	return &s, nil
+}
+
+func (m *memorySessionStorage) Save(s *Session) error {
+	data, err := encodeSessionData(s.Data)
+	if err != nil {
+		return err
+	}
+
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	stored := &memorySession{
+		Session: *s,
+		Data:    data,
+	}
+	stored.Session.Data = nil
+
+	m.sessions[s.ID] = stored
+
+	return nil
+}
+
+func (m *memorySessionStorage) Delete(id string) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	delete(m.sessions, id)
+
+	return nil
+}
+
+func (m *memorySessionStorage) List(account string) ([]*Session, error) {
+	m.rw.RLock()
+	defer m.rw.RUnlock()
+
+	list := []*Session{}
+
+	for _, stored := range m.sessions {
+		if stored.Account != account {
+			continue
+		}
+
+		s := stored.Session
+		list = append(list, &s)
+	}
+
+	sortSessions(list)
+
+	return list, nil
+}
+
+func (m *memorySessionStorage) Purge(before time.Time) error {
+	m.rw.Lock()
+	defer m.rw.Unlock()
+
+	for id, stored := range m.sessions {
+		if stored.LastSeen.Before(before) {
+			delete(m.sessions, id)
+		}
+	}
+
+	return nil
+}
+
+func (d *databaseSessionStorage) Get(id string) (*Session, error) {
+	row := sessionRow{}
+
+	// Get session row
+	if err := database.DB.Get(&row, "SELECT id, account, ip, user_agent, data, created_at, last_seen FROM castro_sessions WHERE id = ?", id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// Decode session data
+	data, err := decodeSessionData(row.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	s := row.session()
+	s.Data = data
+	s.stored = true
+
+	return s, nil
+}
+
+func (d *databaseSessionStorage) Save(s *Session) error {
+	data, err := encodeSessionData(s.Data)
+	if err != nil {
+		return err
+	}
+
+	_, err = database.DB.Exec(
+		"REPLACE INTO castro_sessions (id, account, ip, user_agent, data, created_at, last_seen) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		s.ID,
+		s.Account,
+		s.IP,
+		s.UserAgent,
+		data,
+		s.CreatedAt.Unix(),
+		s.LastSeen.Unix(),
+	)
+
+	return err
+}
+
+func (d *databaseSessionStorage) Delete(id string) error {
+	_, err := database.DB.Exec("DELETE FROM castro_sessions WHERE id = ?", id)
+	return err
+}
+
+func (d *databaseSessionStorage) List(account string) ([]*Session, error) {
+	rows := []sessionRow{}
+
+	// Get account sessions without their data
+	if err := database.DB.Select(&rows, "SELECT id, account, ip, user_agent, created_at, last_seen FROM castro_sessions WHERE account = ?", account); err != nil {
+		return nil, err
+	}
+
+	list := []*Session{}
+
+	for _, row := range rows {
+		list = append(list, row.session())
+	}
+
+	sortSessions(list)
+
+	return list, nil
+}
+
+func (d *databaseSessionStorage) Purge(before time.Time) error {
+	_, err := database.DB.Exec("DELETE FROM castro_sessions WHERE last_seen < ?", before.Unix())
+	return err
+}
+
+// session converts the row to a session without data
+func (r *sessionRow) session() *Session {
+	return &Session{
+		ID:        r.ID,
+		Account:   r.Account,
+		IP:        r.IP,
+		UserAgent: r.User_agent,
+		CreatedAt: time.Unix(r.Created_at, 0),
+		LastSeen:  time.Unix(r.Last_seen, 0),
+	}
+}
+
+// PurgeSessions removes the expired sessions every given interval
+func PurgeSessions(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		// Sessions expire with the session cookie. Cookies without max age last
+		// until the browser is closed so idle sessions are kept for a day
+		lifetime := time.Duration(Config.Configuration.Cookies.MaxAge) * time.Second
+		if lifetime <= 0 {
+			lifetime = sessionIdleLifetime
+		}
+
+		before := time.Now().Add(-lifetime)
+
+		if err := Sessions.Purge(before); err != nil {
+			Logger.Logger.Errorf("Cannot purge expired sessions: %v", err)
+		}
 	}
 }
@@ -185,15 +185,15 @@ func (t Tmpl) RenderWidget(req *http.Request, name string, args map[string]inter
 		return nil, errors.New("Cannot get nonce value")
 	}
 
-	// Get session map
-	session, ok := req.Context().Value("session").(map[string]interface{})
+	// Get session
+	session, ok := req.Context().Value("session").(*Session)
 
 	if !ok {
 		return nil, errors.New("Cannot get session value")
 	}
 
 	// Set session value
-	args["session"] = session
+	args["session"] = session.Data
 
 	// Set nonce value
 	args["nonce"] = nonce
@@ -279,17 +279,17 @@ func (t Tmpl) RenderTemplate(w http.ResponseWriter, req *http.Request, name stri
 		return
 	}
 
-	// Get session map
-	session, ok := req.Context().Value("session").(map[string]interface{})
+	// Get session
+	session, ok := req.Context().Value("session").(*Session)
 
 	if !ok {
 		w.WriteHeader(500)
-		w.Write([]byte("Cannot read session map"))
+		w.Write([]byte("Cannot read session"))
 		return
 	}
 
 	// Set session map
-	args["session"] = session
+	args["session"] = session.Data
 
 	// Set nonce value
 	args["nonce"] = nonce
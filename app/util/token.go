@@ -0,0 +1,176 @@
+package util
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/raggaer/castro/app/database"
+)
+
+// ErrInvalidToken returned when a token is malformed, expired, used or issued
+// for another purpose
+var ErrInvalidToken = errors.New("Invalid or expired token")
+
+// Token holds the signed values of an issued token
+type Token struct {
+	ID        string `json:"n"`
+	Purpose   string `json:"p"`
+	Subject   string `json:"s"`
+	Data      string `json:"d,omitempty"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// CreateTokenTable creates the issued tokens table if it does not exist
+func CreateTokenTable() error {
+	return database.InstallTable(database.DB, "castro_tokens")
+}
+
+// tokenKey returns the key used to sign the tokens. It is derived from the
+// cookie hash key so no extra secret is needed
+func tokenKey() []byte {
+	mac := hmac.New(sha256.New, []byte(Config.Configuration.Cookies.HashKey))
+	mac.Write([]byte("castro-tokens"))
+
+	return mac.Sum(nil)
+}
+
+// signToken returns the signature of the given payload
+func signToken(payload string) []byte {
+	mac := hmac.New(sha256.New, tokenKey())
+	mac.Write([]byte(payload))
+
+	return mac.Sum(nil)
+}
+
+// IssueToken creates a signed single-use token for the given purpose and
+// subject. Data is signed with the token so it can not be changed by the client
+func IssueToken(purpose, subject, data string, ttl time.Duration) (string, error) {
+	// Create random id
+	id, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	t := Token{
+		ID:        id,
+		Purpose:   purpose,
+		Subject:   subject,
+		Data:      data,
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}
+
+	b, err := json.Marshal(&t)
+	if err != nil {
+		return "", err
+	}
+
+	// Save token so it can only be used once
+	if _, err := database.DB.Exec(
+		"INSERT INTO castro_tokens (id, purpose, subject, expires_at) VALUES (?, ?, ?, ?)",
+		t.ID,
+		t.Purpose,
+		t.Subject,
+		t.ExpiresAt,
+	); err != nil {
+		return "", err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signToken(payload)), nil
+}
+
+// decodeToken checks the signature, purpose and expiry of the given token
+func decodeToken(value, purpose string) (*Token, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+
+	// Check signature
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, signToken(parts[0])) {
+		return nil, ErrInvalidToken
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	t := &Token{}
+	if err := json.Unmarshal(b, t); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if t.Purpose != purpose || time.Now().Unix() > t.ExpiresAt {
+		return nil, ErrInvalidToken
+	}
+
+	return t, nil
+}
+
+// VerifyToken checks the given token without using it
+func VerifyToken(value, purpose string) (*Token, error) {
+	t, err := decodeToken(value, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	exists := false
+	if err := database.DB.Get(&exists, "SELECT EXISTS (SELECT 1 FROM castro_tokens WHERE id = ? AND purpose = ?)", t.ID, t.Purpose); err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, ErrInvalidToken
+	}
+
+	return t, nil
+}
+
+// ConsumeToken checks the given token and marks it as used
+func ConsumeToken(value, purpose string) (*Token, error) {
+	t, err := decodeToken(value, purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := database.DB.Exec("DELETE FROM castro_tokens WHERE id = ? AND purpose = ?", t.ID, t.Purpose)
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if n != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	return t, nil
+}
+
+// RevokeTokens removes every unused token of the given purpose and subject
+func RevokeTokens(purpose, subject string) error {
+	_, err := database.DB.Exec("DELETE FROM castro_tokens WHERE purpose = ? AND subject = ?", purpose, subject)
+	return err
+}
+
+// PurgeTokens removes the expired tokens every given interval
+func PurgeTokens(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		if _, err := database.DB.Exec("DELETE FROM castro_tokens WHERE expires_at < ?", time.Now().Unix()); err != nil {
+			Logger.Logger.Errorf("Cannot purge expired tokens: %v", err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testToken encodes and signs the given token without saving it
+func testToken(t *testing.T, token Token) string {
+	b, err := json.Marshal(&token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signToken(payload))
+}
+
+func TestDecodeToken(t *testing.T) {
+	Config = &ConfigurationFile{Configuration: &Configuration{}}
+	Config.Configuration.Cookies.HashKey = "token-test-key"
+
+	valid := testToken(t, Token{ID: "a", Purpose: "verify", Subject: "1", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	expired := testToken(t, Token{ID: "b", Purpose: "verify", Subject: "1", ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	parts := strings.Split(valid, ".")
+	other := base64.RawURLEncoding.EncodeToString([]byte(`{"n":"a","p":"verify","s":"2","e":9999999999}`))
+
+	tests := []struct {
+		name    string
+		value   string
+		purpose string
+		valid   bool
+	}{
+		{name: "valid", value: valid, purpose: "verify", valid: true},
+		{name: "purpose", value: valid, purpose: "reset", valid: false},
+		{name: "expired", value: expired, purpose: "verify", valid: false},
+		{name: "changed payload", value: other + "." + parts[1], purpose: "verify", valid: false},
+		{name: "changed signature", value: parts[0] + "." + parts[1][1:], purpose: "verify", valid: false},
+		{name: "no signature", value: parts[0], purpose: "verify", valid: false},
+		{name: "extra part", value: valid + ".x", purpose: "verify", valid: false},
+		{name: "empty", value: "", purpose: "verify", valid: false},
+	}
+
+	for _, test := range tests {
+		token, err := decodeToken(test.value, test.purpose)
+
+		if !test.valid {
+			if err != ErrInvalidToken {
+				t.Errorf("%v: expected ErrInvalidToken, got %v %v", test.name, token, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+			continue
+		}
+
+		if token.ID != "a" || token.Subject != "1" {
+			t.Errorf("%v: unexpected token %+v", test.name, token)
+		}
+	}
+
+	// Tokens signed with another key are rejected
+	Config.Configuration.Cookies.HashKey = "other-key"
+	if _, err := decodeToken(valid, "verify"); err != ErrInvalidToken {
+		t.Errorf("expected ErrInvalidToken with another key, got %v", err)
+	}
+}
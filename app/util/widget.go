@@ -1,6 +1,7 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"html/template"
 	"io/ioutil"
@@ -30,9 +31,10 @@ type WidgetList struct {
 
 // Widget is an application sidebar content
 type Widget struct {
-	rw     *sync.RWMutex
-	Name   string
-	Result template.HTML
+	rw        *sync.RWMutex
+	Name      string
+	Extension string
+	Result    template.HTML
 }
 
 // Load loads all the widgets from the given directory
@@ -131,8 +133,9 @@ func (w *WidgetList) LoadExtensions() error {
 
 			// Append widget
 			w.List = append(w.List, &Widget{
-				Name: file.Name(),
-				rw:   &sync.RWMutex{},
+				Name:      file.Name(),
+				Extension: extensionID,
+				rw:        &sync.RWMutex{},
 			})
 		}
 	}
@@ -169,12 +172,15 @@ func (w *Widget) IsCached() (template.HTML, bool) {
 	return buff.(template.HTML), true
 }
 
-// Execute gets the result of the given widget
-func (w *Widget) Execute(luaState *glua.LState) error {
+// Execute gets the result of the given widget. The execution is interrupted once the given context is done
+func (w *Widget) Execute(ctx context.Context, luaState *glua.LState) error {
 	// Lock mutex
 	w.rw.RLock()
 	defer w.rw.RUnlock()
 
+	// Set state execution context
+	luaState.SetContext(ctx)
+
 	// Execute widget function
 	if err := luaState.CallByParam(glua.P{
 		Fn:      luaState.GetGlobal("widget"),
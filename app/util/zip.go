@@ -0,0 +1,159 @@
+package util
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// MaxUnzipSize total uncompressed size limit of the archives extracted with Unzip
+	MaxUnzipSize = 1 << 30
+
+	// MaxUnzipEntries entry limit of the extracted archives
+	MaxUnzipEntries = 10000
+)
+
+// errUnzipSize error returned when an archive exceeds its uncompressed size limit
+var errUnzipSize = errors.New("Archive is bigger than the uncompressed size limit")
+
+// Unzip decompresses the given zip archive into the destination folder.
+// Returns the list of extracted paths
+func Unzip(src, dest string) ([]string, error) {
+	// Open zip archive
+	r, err := zip.OpenReader(src)
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return UnzipReader(&r.Reader, "", dest, MaxUnzipSize)
+}
+
+// UnzipReader decompresses the entries of the given archive that are inside
+// prefix into the destination folder, removing the prefix from their names.
+// Absolute paths, entries that escape the destination folder, symbolic links
+// and archives with more than MaxUnzipEntries entries are rejected before
+// anything is written. Extraction stops once maxSize uncompressed bytes are written
+func UnzipReader(r *zip.Reader, prefix, dest string, maxSize int64) ([]string, error) {
+	dest = filepath.Clean(dest)
+	files := []*zip.File{}
+
+	if len(r.File) > MaxUnzipEntries {
+		return nil, fmt.Errorf("Archive has more than %d entries", MaxUnzipEntries)
+	}
+
+	// Check every entry. https://snyk.io/research/zip-slip-vulnerability#go
+	for _, f := range r.File {
+		name := strings.Replace(f.Name, "\\", "/", -1)
+
+		if strings.HasPrefix(name, "/") || filepath.IsAbs(f.Name) || filepath.VolumeName(f.Name) != "" {
+			return nil, fmt.Errorf("Illegal file path %q", f.Name)
+		}
+
+		for _, part := range strings.Split(name, "/") {
+			if part == ".." {
+				return nil, fmt.Errorf("Illegal file path %q", f.Name)
+			}
+		}
+
+		if f.Mode()&os.ModeSymlink != 0 {
+			return nil, fmt.Errorf("Illegal symbolic link %q", f.Name)
+		}
+
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		files = append(files, f)
+	}
+
+	// Check the declared sizes first, the written bytes are limited too since
+	// the headers can lie
+	declared := uint64(0)
+	for _, f := range files {
+		declared += f.UncompressedSize64
+		if declared > uint64(maxSize) {
+			return nil, errUnzipSize
+		}
+	}
+
+	filenames := []string{}
+	remaining := maxSize
+
+	for _, f := range files {
+		name := path.Clean(strings.TrimPrefix(strings.Replace(f.Name, "\\", "/", -1), prefix))
+		if name == "." {
+			continue
+		}
+
+		// Store filename/path
+		fpath := filepath.Join(dest, filepath.FromSlash(name))
+
+		if !strings.HasPrefix(fpath, dest+string(os.PathSeparator)) {
+			return filenames, fmt.Errorf("Illegal file path %q", f.Name)
+		}
+
+		filenames = append(filenames, fpath)
+
+		if f.FileInfo().IsDir() {
+			// Create folder
+			if err := os.MkdirAll(fpath, os.ModePerm); err != nil {
+				return filenames, err
+			}
+			continue
+		}
+
+		written, err := unzipFile(f, fpath, remaining)
+		if err != nil {
+			return filenames, err
+		}
+
+		remaining -= written
+	}
+
+	return filenames, nil
+}
+
+// unzipFile writes the given archive entry to the given path. Returns the
+// written bytes or an error if the entry is bigger than max bytes
+func unzipFile(f *zip.File, fpath string, max int64) (int64, error) {
+	// Create directories
+	if err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
+		return 0, err
+	}
+
+	// Open output file in write mode
+	outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode().Perm()|0600)
+	if err != nil {
+		return 0, err
+	}
+
+	defer outFile.Close()
+
+	// Open source file
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+
+	defer rc.Close()
+
+	// Copy file contents reading one byte past the limit to detect bigger entries
+	written, err := io.Copy(outFile, io.LimitReader(rc, max+1))
+	if err != nil {
+		return written, err
+	}
+
+	if written > max {
+		return written, errUnzipSize
+	}
+
+	return written, nil
+}
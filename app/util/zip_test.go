@@ -0,0 +1,168 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testZipEntry holds an entry of a test archive
+type testZipEntry struct {
+	name string
+	body string
+	mode os.FileMode
+}
+
+// testZip creates an in-memory archive with the given entries
+func testZip(t *testing.T, entries ...testZipEntry) *zip.Reader {
+	buff := &bytes.Buffer{}
+	w := zip.NewWriter(buff)
+
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.name, Method: zip.Deflate}
+		if entry.mode != 0 {
+			header.SetMode(entry.mode)
+		}
+
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(entry.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buff.Bytes()), int64(buff.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestUnzipReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []testZipEntry
+		prefix  string
+		maxSize int64
+		files   []string
+		fail    string
+	}{
+		{
+			name:    "valid",
+			entries: []testZipEntry{{name: "a.txt", body: "a"}, {name: "dir/", mode: os.ModeDir | 0755}, {name: "dir/b.txt", body: "b"}},
+			maxSize: 10,
+			files:   []string{"a.txt", "dir", "dir/b.txt"},
+		},
+		{
+			name:    "prefix",
+			entries: []testZipEntry{{name: "root/a.txt", body: "a"}, {name: "other/b.txt", body: "b"}},
+			prefix:  "root/",
+			maxSize: 10,
+			files:   []string{"a.txt"},
+		},
+		{name: "traversal", entries: []testZipEntry{{name: "../evil.txt", body: "x"}}, maxSize: 10, fail: "Illegal file path"},
+		{name: "nested traversal", entries: []testZipEntry{{name: "a/../../evil.txt", body: "x"}}, maxSize: 10, fail: "Illegal file path"},
+		{name: "backslash traversal", entries: []testZipEntry{{name: "a\\..\\..\\evil.txt", body: "x"}}, maxSize: 10, fail: "Illegal file path"},
+		{name: "absolute", entries: []testZipEntry{{name: "/etc/evil", body: "x"}}, maxSize: 10, fail: "Illegal file path"},
+		{name: "symlink", entries: []testZipEntry{{name: "link", body: "/etc/passwd", mode: os.ModeSymlink | 0777}}, maxSize: 100, fail: "symbolic link"},
+		{name: "size", entries: []testZipEntry{{name: "a.txt", body: "123456"}, {name: "b.txt", body: "123456"}}, maxSize: 10, fail: errUnzipSize.Error()},
+		{name: "ignored traversal", entries: []testZipEntry{{name: "root/a.txt", body: "a"}, {name: "../evil.txt", body: "x"}}, prefix: "root/", maxSize: 10, fail: "Illegal file path"},
+	}
+
+	for _, test := range tests {
+		dest, err := ioutil.TempDir("", "castro-unzip")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		files, err := UnzipReader(testZip(t, test.entries...), test.prefix, dest, test.maxSize)
+
+		if test.fail != "" {
+			if err == nil || !strings.Contains(err.Error(), test.fail) {
+				t.Errorf("%v: expected a %q error, got %v", test.name, test.fail, err)
+			}
+
+			// Nothing is written when an entry is rejected
+			if written, _ := ioutil.ReadDir(dest); len(written) > 0 {
+				t.Errorf("%v: files written for a rejected archive", test.name)
+			}
+		} else if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.name, err)
+		} else if len(files) != len(test.files) {
+			t.Errorf("%v: extracted %v, expected %v", test.name, files, test.files)
+		} else {
+			for i, name := range test.files {
+				if files[i] != filepath.Join(dest, filepath.FromSlash(name)) {
+					t.Errorf("%v: extracted %v, expected %v", test.name, files[i], name)
+				}
+				if _, err := os.Stat(files[i]); err != nil {
+					t.Errorf("%v: %v", test.name, err)
+				}
+			}
+		}
+
+		os.RemoveAll(dest)
+	}
+}
+
+func TestUnzipReaderLyingSize(t *testing.T) {
+	body := bytes.Repeat([]byte("a"), 100)
+
+	// Store an entry whose header declares less bytes than it holds
+	buff := &bytes.Buffer{}
+	w := zip.NewWriter(buff)
+	f, err := w.CreateRaw(&zip.FileHeader{
+		Name:               "big.txt",
+		Method:             zip.Store,
+		CRC32:              crc32.ChecksumIEEE(body),
+		CompressedSize64:   uint64(len(body)),
+		UncompressedSize64: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(body)
+	w.Close()
+
+	r, err := zip.NewReader(bytes.NewReader(buff.Bytes()), int64(buff.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest, err := ioutil.TempDir("", "castro-unzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	if _, err := UnzipReader(r, "", dest, 10); err == nil {
+		t.Fatal("expected an error for an entry bigger than its header")
+	}
+
+	if info, err := os.Stat(filepath.Join(dest, "big.txt")); err == nil && info.Size() > 11 {
+		t.Errorf("wrote %d bytes past the limit", info.Size())
+	}
+}
+
+func TestUnzipReaderEntries(t *testing.T) {
+	entries := make([]testZipEntry, MaxUnzipEntries+1)
+	for i := range entries {
+		entries[i] = testZipEntry{name: "dir/"}
+	}
+
+	if _, err := UnzipReader(testZip(t, entries...), "", os.TempDir(), MaxUnzipSize); err == nil {
+		t.Error("expected an error for too many entries")
+	}
+}
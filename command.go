@@ -0,0 +1,625 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/raggaer/castro/app"
+	"github.com/raggaer/castro/app/database"
+	"github.com/raggaer/castro/app/lua"
+	"github.com/raggaer/castro/app/models"
+	"github.com/raggaer/castro/app/util"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// command struct used for the command-line subcommands
+type command struct {
+	Name        string
+	Usage       string
+	Description string
+	Setup       bool
+	Run         func(args []string) error
+}
+
+// extensionTypes list of tables that hold the extension enabled state
+var extensionTypes = []string{"pages", "widgets", "hooks", "templatehooks"}
+
+// commands holds the list of command-line subcommands
+var commands []command
+
+func init() {
+	commands = []command{
+		{
+			Name:        "migrate status",
+			Usage:       "migrate status",
+			Description: "Shows the state of the core and extension migrations",
+			Setup:       true,
+			Run:         migrateStatusCommand,
+		},
+		{
+			Name:        "migrate apply",
+			Usage:       "migrate apply",
+			Description: "Applies all pending migrations",
+			Setup:       true,
+			Run:         migrateApplyCommand,
+		},
+		{
+			Name:        "migrate rollback",
+			Usage:       "migrate rollback [-extension id] [-steps n]",
+			Description: "Reverts the last applied migrations. Use -steps 0 to revert all of them",
+			Setup:       true,
+			Run:         migrateRollbackCommand,
+		},
+		{
+			Name:        "map encode",
+			Usage:       "map encode",
+			Description: "Encodes the server map file and saves it on the database",
+			Setup:       true,
+			Run:         mapEncodeCommand,
+		},
+		{
+			Name:        "admin create",
+			Usage:       "admin create <name> <email>",
+			Description: "Creates a new admin account",
+			Setup:       true,
+			Run:         adminCreateCommand,
+		},
+		{
+			Name:        "admin promote",
+			Usage:       "admin promote <name>",
+			Description: "Gives the administrator role to an existing account",
+			Setup:       true,
+			Run:         adminPromoteCommand,
+		},
+		{
+			Name:        "extension list",
+			Usage:       "extension list",
+			Description: "Lists the installed extensions",
+			Setup:       true,
+			Run:         extensionListCommand,
+		},
+		{
+			Name:        "extension enable",
+			Usage:       "extension enable <id> [-type pages|widgets|hooks|templatehooks]",
+			Description: "Enables an installed extension",
+			Setup:       true,
+			Run: func(args []string) error {
+				return extensionToggleCommand(args, true)
+			},
+		},
+		{
+			Name:        "extension disable",
+			Usage:       "extension disable <id> [-type pages|widgets|hooks|templatehooks]",
+			Description: "Disables an installed extension",
+			Setup:       true,
+			Run: func(args []string) error {
+				return extensionToggleCommand(args, false)
+			},
+		},
+		{
+			Name:        "password widen",
+			Usage:       "password widen",
+			Description: "Widens the accounts password column to hold bcrypt and argon2id hashes",
+			Setup:       true,
+			Run:         passwordWidenCommand,
+		},
+		{
+			Name:        "config validate",
+			Usage:       "config validate [file]",
+			Description: "Checks the config file for errors",
+			Run:         configValidateCommand,
+		},
+	}
+}
+
+// runCommand executes the subcommand that matches the given arguments
+func runCommand(args []string) error {
+	// Loop subcommands
+	for _, c := range commands {
+
+		// Check if the arguments start with the command name
+		name := strings.Fields(c.Name)
+		if len(args) < len(name) || strings.Join(args[:len(name)], " ") != c.Name {
+			continue
+		}
+
+		// Check if application is installed
+		if c.Setup {
+			if !isInstalled() {
+				return errors.New("Castro is not installed. Run castro without arguments to start the installer")
+			}
+
+			// Load config files and connect to the database
+			app.Setup()
+		}
+
+		return c.Run(args[len(name):])
+	}
+
+	// Show command list
+	commandUsage()
+
+	if len(args) > 0 && args[0] != "help" {
+		return fmt.Errorf("Unknown command %v", strings.Join(args, " "))
+	}
+
+	return nil
+}
+
+// commandUsage shows the list of subcommands
+func commandUsage() {
+	fmt.Println("Usage: castro [command]")
+	fmt.Println()
+	fmt.Println("Running castro without a command starts the web server. Available commands:")
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %v\t%v\n", c.Usage, c.Description)
+	}
+	w.Flush()
+}
+
+// parseCommandFlags parses the given flag set allowing flags after positional arguments
+func parseCommandFlags(set *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+
+	for {
+		if err := set.Parse(args); err != nil {
+			return nil, err
+		}
+
+		args = set.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func migrateStatusCommand(args []string) error {
+	// Get migration directories
+	sources, err := lua.MigrationSources()
+	if err != nil {
+		return err
+	}
+
+	// Get migration list
+	list, err := lua.MigrationStatusList(sources)
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		fmt.Println("There are no migrations")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tSTATUS\tAPPLIED AT")
+
+	for _, status := range list {
+		state := "pending"
+		appliedAt := "-"
+
+		if status.Applied {
+			state = "applied"
+			appliedAt = status.AppliedAt.Format(time.RFC3339)
+		}
+		if status.Modified {
+			state = "modified"
+		}
+		if status.Missing {
+			state = "missing"
+		}
+
+		fmt.Fprintf(w, "%v\t%v\t%v\n", status.String(), state, appliedAt)
+	}
+
+	return w.Flush()
+}
+
+func migrateApplyCommand(args []string) error {
+	// Get migration directories
+	sources, err := lua.MigrationSources()
+	if err != nil {
+		return err
+	}
+
+	// Apply pending migrations
+	applied, err := lua.ApplyMigrations(sources)
+	for _, m := range applied {
+		fmt.Printf("Applied %v\n", m)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%v migrations applied\n", len(applied))
+
+	return nil
+}
+
+func migrateRollbackCommand(args []string) error {
+	set := flag.NewFlagSet("migrate rollback", flag.ContinueOnError)
+	extension := set.String("extension", "", "Extension id. Core migrations are reverted if empty")
+	steps := set.Int("steps", 1, "Number of migrations to revert")
+
+	if _, err := parseCommandFlags(set, args); err != nil {
+		return err
+	}
+
+	// Get migration directory
+	dir := "migrations"
+	if *extension != "" {
+		dir = filepath.Join("extensions", *extension, "migrations")
+	}
+
+	// Revert migrations
+	reverted, err := lua.RollbackMigrations(*extension, dir, *steps)
+	for _, m := range reverted {
+		fmt.Printf("Reverted %v\n", m)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%v migrations reverted\n", len(reverted))
+
+	return nil
+}
+
+func mapEncodeCommand(args []string) error {
+	fmt.Println(">> Encoding map. This process can take several minutes")
+
+	// Encode map
+	m, err := app.EncodeMap()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Map %v saved to database (%v bytes)\n", m.Name, len(m.Data))
+
+	return nil
+}
+
+func adminCreateCommand(args []string) error {
+	if len(args) != 2 {
+		return errors.New("Usage: castro admin create <name> <email>")
+	}
+
+	name, email := args[0], args[1]
+
+	// Read the password so it does not end on the shell history
+	password, err := readAdminPassword()
+	if err != nil {
+		return err
+	}
+
+	// Check account name
+	exists := false
+	if err := database.DB.Get(&exists, "SELECT EXISTS (SELECT 1 FROM accounts WHERE name = ?)", name); err != nil {
+		return err
+	}
+
+	if exists {
+		return fmt.Errorf("Account %v already exists, use admin promote instead", name)
+	}
+
+	// Hash password the same way the register page does
+	hash, err := util.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	// Begin transaction
+	tx, err := database.DB.Beginx()
+	if err != nil {
+		return err
+	}
+
+	// Create account
+	result, err := tx.Exec(
+		"INSERT INTO accounts (name, password, premium_ends_at, email, creation) VALUES (?, ?, ?, ?, ?)",
+		name,
+		hash,
+		0,
+		email,
+		time.Now().Unix(),
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Create castro account
+	if _, err := tx.Exec("INSERT INTO castro_accounts (account_id) VALUES (?)", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Give administrator role
+	if err := assignAdministratorRole(id, name); err != nil {
+		return err
+	}
+
+	fmt.Printf("Admin account %v created\n", name)
+
+	return nil
+}
+
+// readAdminPassword returns the password of the CASTRO_ADMIN_PASSWORD
+// environment variable or asks for it on the standard input
+func readAdminPassword() (string, error) {
+	if password := os.Getenv("CASTRO_ADMIN_PASSWORD"); password != "" {
+		return password, nil
+	}
+
+	// Read a line when the input is not a terminal
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+
+		password := strings.TrimRight(line, "\r\n")
+		if password == "" {
+			return "", errors.New("The password can not be empty")
+		}
+
+		return password, nil
+	}
+
+	fmt.Print("Password: ")
+	password, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Print("Repeat password: ")
+	repeat, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+
+	if len(password) == 0 {
+		return "", errors.New("The password can not be empty")
+	}
+
+	if string(password) != string(repeat) {
+		return "", errors.New("The passwords do not match")
+	}
+
+	return string(password), nil
+}
+
+func adminPromoteCommand(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: castro admin promote <name>")
+	}
+
+	// Get account id
+	var id int64
+	if err := database.DB.Get(&id, "SELECT id FROM accounts WHERE name = ?", args[0]); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("Account %v not found", args[0])
+		}
+		return err
+	}
+
+	// Check if castro account exists
+	exists := false
+	if err := database.DB.Get(&exists, "SELECT EXISTS (SELECT 1 FROM castro_accounts WHERE account_id = ?)", id); err != nil {
+		return err
+	}
+
+	if !exists {
+		if _, err := database.DB.Exec("INSERT INTO castro_accounts (account_id) VALUES (?)", id); err != nil {
+			return err
+		}
+	}
+
+	// Give administrator role
+	if err := assignAdministratorRole(id, args[0]); err != nil {
+		return err
+	}
+
+	fmt.Printf("Account %v is now an admin\n", args[0])
+
+	return nil
+}
+
+// assignAdministratorRole gives the administrator role to the given account. Core
+// migrations are applied first since they create the role tables
+func assignAdministratorRole(id int64, name string) error {
+	if _, err := lua.ApplyMigrations(map[string]string{"": "migrations"}); err != nil {
+		return err
+	}
+
+	if err := models.AssignRole(id, models.AdministratorRole); err != nil {
+		return err
+	}
+
+	// Record the role change. Commands have no logged account
+	return models.WriteAuditEntry(0, "console", "", "roles.assign", name, nil, map[string]string{
+		"role": models.AdministratorRole,
+	})
+}
+
+func extensionListCommand(args []string) error {
+	// Extension rows holder
+	extensions := []struct {
+		ID        string
+		Name      string
+		Version   string
+		Installed bool
+	}{}
+
+	if err := database.DB.Select(&extensions, "SELECT id, name, version, installed = 1 AS installed FROM castro_extensions ORDER BY id"); err != nil {
+		return err
+	}
+
+	if len(extensions) == 0 {
+		fmt.Println("There are no installed extensions")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tVERSION\tINSTALLED\t"+strings.ToUpper(strings.Join(extensionTypes, "\t")))
+
+	for _, extension := range extensions {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v", extension.ID, extension.Name, extension.Version, extension.Installed)
+
+		// Get extension state of each type
+		for _, extType := range extensionTypes {
+			enabled := []bool{}
+
+			if err := database.DB.Select(&enabled, "SELECT enabled = 1 FROM castro_extension_"+extType+" WHERE extension_id = ?", extension.ID); err != nil {
+				return err
+			}
+
+			state := "-"
+			if len(enabled) > 0 {
+				state = "disabled"
+				for _, e := range enabled {
+					if e {
+						state = "enabled"
+					}
+				}
+			}
+
+			fmt.Fprintf(w, "\t%v", state)
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	return w.Flush()
+}
+
+func extensionToggleCommand(args []string, enabled bool) error {
+	set := flag.NewFlagSet("extension", flag.ContinueOnError)
+	extType := set.String("type", "", "Only change the given extension type")
+
+	args, err := parseCommandFlags(set, args)
+	if err != nil {
+		return err
+	}
+
+	if len(args) != 1 {
+		return errors.New("Usage: castro extension enable|disable <id> [-type pages|widgets|hooks|templatehooks]")
+	}
+
+	// Check if extension is installed
+	exists := false
+	if err := database.DB.Get(&exists, "SELECT EXISTS (SELECT 1 FROM castro_extensions WHERE id = ?)", args[0]); err != nil {
+		return err
+	}
+
+	if !exists {
+		return fmt.Errorf("Extension %v is not installed", args[0])
+	}
+
+	// Get the types to change
+	types := extensionTypes
+	if *extType != "" {
+		types = nil
+		for _, t := range extensionTypes {
+			if t == *extType {
+				types = []string{t}
+			}
+		}
+
+		if types == nil {
+			return fmt.Errorf("Unknown extension type %v", *extType)
+		}
+	}
+
+	// Update extension tables
+	for _, t := range types {
+		if _, err := database.DB.Exec("UPDATE castro_extension_"+t+" SET enabled = ? WHERE extension_id = ?", enabled, args[0]); err != nil {
+			return err
+		}
+	}
+
+	if enabled {
+		fmt.Printf("Extension %v enabled\n", args[0])
+	} else {
+		fmt.Printf("Extension %v disabled\n", args[0])
+	}
+
+	return nil
+}
+
+func passwordWidenCommand(args []string) error {
+	// Change the game server accounts table
+	changed, err := util.WidenPasswordColumn()
+	if err != nil {
+		return fmt.Errorf("Cannot widen accounts.password: %v", err)
+	}
+
+	if !changed {
+		fmt.Println("accounts.password can already hold every password scheme")
+		return nil
+	}
+
+	fmt.Println("accounts.password changed to VARCHAR(255)")
+
+	return nil
+}
+
+func configValidateCommand(args []string) error {
+	set := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	file := set.String("file", configFileName, "Config file to validate")
+
+	args, err := parseCommandFlags(set, args)
+	if err != nil {
+		return err
+	}
+
+	// Allow the file as a positional argument
+	if len(args) > 0 {
+		*file = args[0]
+	}
+
+	// Validate config file
+	problems, err := util.ValidateConfig(*file)
+	if err != nil {
+		return fmt.Errorf("Cannot parse %v: %v", *file, err)
+	}
+
+	for _, problem := range problems {
+		fmt.Println("  - " + problem)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%v has %v problems", *file, len(problems))
+	}
+
+	fmt.Printf("%v is valid\n", *file)
+
+	return nil
+}
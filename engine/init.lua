@@ -1,7 +1,5 @@
 -- This file will be executed at start-up
 
-require "extensionhooks"
-
 if app.Mode == "dev" then
     print(">> Running on development mode. Never have development mode open to the public")
 end
@@ -20,18 +18,19 @@ if app.Custom.OnlineChart.Enabled then
 
                sleep(app.Custom.OnlineChart.Interval)
            end
-       end
+       end,
+       "0s"
     )
 end
 
 -- Run extensions onStartup event
-executeHook("onStartup")
+hooks:dispatch("onStartup")
 
 if app.CheckUpdates and app.Version ~= "" then
     local commitData = json:unmarshal(http:get("https://api.github.com/repos/Raggaer/castro/commits"))
     local outdated = 0
 
-    for k, v in pairs(commitData.object) do
+    for k, v in ipairs(commitData) do
         if tostring(v.sha) == app.Version then
             break
         else
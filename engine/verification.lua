@@ -0,0 +1,52 @@
+-- Returns an absolute link to the given subtopic with a token
+local function tokenLink(path, value)
+    local scheme = (app.SSL.Enabled or app.SSL.Proxy) and "https" or "http"
+    return string.format("%s://%s%s?token=%s", scheme, app.URL, path, value)
+end
+
+-- Queues the given mail template with a link
+local function sendMail(to, template, link)
+    local m = {}
+    m.to = to
+    m.template = template
+    m.lang = session:get("lang")
+    m.data = {link = link}
+    mail:send(m)
+end
+
+-- Returns the lifetime of the email verification links
+local function verificationExpire()
+    if app.EmailVerification.Expire ~= nil and app.EmailVerification.Expire ~= "" then
+        return app.EmailVerification.Expire
+    end
+    return "24h"
+end
+
+-- Sends a link to verify the email address of the given account. Older links stop working
+function sendEmailVerification(id, email)
+    token:revoke("verify-email", id)
+    local link = tokenLink("/subtopic/account/verify", token:issue("verify-email", id, verificationExpire(), email))
+    sendMail(email, "verify_email", link)
+end
+
+-- Sends a link to the new email address of the given account to confirm the change
+function sendEmailChange(id, email)
+    token:revoke("change-email", id)
+    local link = tokenLink("/subtopic/account/changemail/confirm", token:issue("change-email", id, verificationExpire(), email))
+    sendMail(email, "confirm_email", link)
+end
+
+-- Sends a password reset link to the given account
+function sendPasswordReset(id, email)
+    token:revoke("reset-password", id)
+    local link = tokenLink("/subtopic/account/recover/password/reset", token:issue("reset-password", id, "1h", email))
+    sendMail(email, "password_reset", link)
+end
+
+-- Checks if the given logged account table can create characters
+function emailVerified(account)
+    if not app.EmailVerification.Enabled or not app.EmailVerification.RequireForCharacters then
+        return true
+    end
+    return account.castro.Email_verified
+end
@@ -14,12 +14,10 @@ import (
 	"github.com/urfave/negroni"
 
 	"fmt"
-	"io/ioutil"
 	"path/filepath"
 	"strings"
 
 	"github.com/BurntSushi/toml"
-	"github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/raggaer/castro/app/database"
 	"github.com/raggaer/castro/app/lua"
@@ -165,11 +163,10 @@ var (
 			},
 			Post: func(res http.ResponseWriter, req *http.Request, s installationStep) error {
 				// Update fields
-				installationConfigFile.Captcha = util.CaptchaConfig{
-					Public:  req.FormValue("public"),
-					Secret:  req.FormValue("private"),
-					Enabled: true,
-				}
+				installationConfigFile.Captcha.Provider = util.CaptchaProviderRecaptcha
+				installationConfigFile.Captcha.Public = req.FormValue("public")
+				installationConfigFile.Captcha.Secret = req.FormValue("private")
+				installationConfigFile.Captcha.Enabled = true
 
 				return nil
 			},
@@ -217,12 +214,11 @@ var (
 				}
 
 				// Update fields
-				installationConfigFile.Mail = util.MailConfig{
-					Server:   req.FormValue("server"),
-					Port:     port,
-					Username: req.FormValue("username"),
-					Password: req.FormValue("password"),
-				}
+				installationConfigFile.Mail.Transport = util.MailTransportSMTP
+				installationConfigFile.Mail.Server = req.FormValue("server")
+				installationConfigFile.Mail.Port = port
+				installationConfigFile.Mail.Username = req.FormValue("username")
+				installationConfigFile.Mail.Password = req.FormValue("password")
 
 				return nil
 			},
@@ -258,6 +254,11 @@ var (
 			MaxAge:   1000000,
 			HashKey:  uniuri.NewLen(32),
 			BlockKey: uniuri.NewLen(32),
+			SameSite: "lax",
+		},
+		Session: util.SessionConfig{
+			Store: util.DatabaseSessionStore,
+			Purge: util.NewStringDuration("30m"),
 		},
 		Cache: util.CacheConfig{
 			Default: util.NewStringDuration("5m"),
@@ -267,6 +268,62 @@ var (
 			Number:  100,
 			Enabled: false,
 			Time:    util.NewStringDuration("1m"),
+			Routes: []util.RateLimitRoute{
+				{Path: "/subtopic/login", Methods: []string{"POST"}, Number: 10, Time: util.NewStringDuration("5m")},
+				{Path: "/subtopic/register", Methods: []string{"POST"}, Number: 5, Time: util.NewStringDuration("10m")},
+				{Path: "/subtopic/account/recover", Methods: []string{"POST"}, Number: 5, Time: util.NewStringDuration("10m")},
+			},
+		},
+		Login: util.LoginConfig{
+			CaptchaAfter: 3,
+			LockAfter:    10,
+			Backoff:      util.NewStringDuration("1s"),
+			MaxBackoff:   util.NewStringDuration("5m"),
+			Lockout:      util.NewStringDuration("30m"),
+			Window:       util.NewStringDuration("1h"),
+			UnlockMail:   true,
+		},
+		Password: util.PasswordConfig{
+			Scheme:        util.PasswordSHA1,
+			BcryptCost:    10,
+			Argon2Time:    1,
+			Argon2Memory:  65536,
+			Argon2Threads: 4,
+		},
+		TwoFactor: util.TwoFactorConfig{
+			RequireStaff:  true,
+			RecoveryCodes: 10,
+		},
+		Captcha: util.CaptchaConfig{
+			Enabled:  false,
+			Provider: util.CaptchaProviderImage,
+			Length:   5,
+			Expire:   util.NewStringDuration("10m"),
+		},
+		Mail: util.MailConfig{
+			Transport:   util.MailTransportSMTP,
+			Directory:   "mails",
+			Workers:     2,
+			MaxAttempts: 5,
+			RetryDelay:  util.NewStringDuration("1m"),
+		},
+		EmailVerification: util.EmailVerificationConfig{
+			Enabled:              false,
+			RequireForCharacters: true,
+			Expire:               util.NewStringDuration("24h"),
+		},
+		Database: util.DatabaseConfig{
+			Driver: database.MySQL,
+		},
+		Lua: util.LuaConfig{
+			PoolSize:            100,
+			CallStackSize:       256,
+			RegistrySize:        5120,
+			RegistryMaxSize:     81920,
+			MinimizeStackMemory: true,
+			Timeout:             util.NewStringDuration("8s"),
+			EventTimeout:        util.NewStringDuration("0s"),
+			Timeouts:            map[string]util.StringDuration{},
 		},
 		Security: util.SecurityConfig{
 			NonceEnabled:      true,
@@ -276,6 +333,7 @@ var (
 			ContentType:       "nosniff",
 			ReferrerPolicy:    "origin",
 			CrossDomainPolicy: "none",
+			CSRFExpire:        util.NewStringDuration("1h"),
 			CSP: util.ContentSecurityPolicyConfig{
 				Default: []string{"none"},
 				Frame: util.ContentSecurityPolicyType{
@@ -363,22 +421,7 @@ func isInstalled() bool {
 
 // isZnoteInstalled checks if znote_aac is already installed
 func isZnoteInstalled(db *sqlx.Tx) (bool, error) {
-	// Check if table exists
-	if _, err := db.Exec("DESCRIBE " + znoteTableName); err != nil {
-
-		// Convert error to MySQL error type
-		mErr, ok := err.(*mysql.MySQLError)
-
-		// Check if table is installed
-		if ok && mErr.Number == 1146 {
-
-			return false, nil
-		}
-
-		return false, err
-	}
-
-	return true, nil
+	return database.TableExists(db, znoteTableName)
 }
 
 func accountExists(id int64, db *sqlx.Tx) bool {
@@ -575,47 +618,12 @@ func installApplication(location string) error {
 		return err
 	}
 
-	// Get all sql files
-	tables, err := ioutil.ReadDir(filepath.Join("install"))
-
-	if err != nil {
+	// Create castro tables
+	if _, err := database.InstallTables(db, database.InstallDirectory(database.MySQL)); err != nil {
 		db.Rollback()
 		return err
 	}
 
-	// Loop files
-	for _, table := range tables {
-
-		// Check if table exists
-		if _, err := db.Exec("DESCRIBE " + strings.TrimSuffix(table.Name(), ".sql")); err != nil {
-
-			// Convert error to MySQL error type
-			mErr, ok := err.(*mysql.MySQLError)
-
-			// Check if table is installed
-			if ok && mErr.Number == 1146 {
-
-				// Read file
-				buff, err := ioutil.ReadFile(filepath.Join("install", table.Name()))
-
-				if err != nil {
-					db.Rollback()
-					return err
-				}
-
-				// Execute query
-				if _, err := db.Exec(string(buff)); err != nil {
-					db.Rollback()
-					return err
-				}
-
-				continue
-			}
-
-			return err
-		}
-	}
-
 	// Check if znote is installed
 	z, err := isZnoteInstalled(db)
 
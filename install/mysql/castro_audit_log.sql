@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS `castro_audit_log` (
+  `id` INT(11) NOT NULL AUTO_INCREMENT,
+  `account_id` INT(11) NOT NULL DEFAULT 0,
+  `account` VARCHAR(255) NOT NULL DEFAULT '',
+  `ip` VARCHAR(45) NOT NULL DEFAULT '',
+  `action` VARCHAR(128) NOT NULL,
+  `target` VARCHAR(255) NOT NULL DEFAULT '',
+  `old_value` TEXT NOT NULL,
+  `new_value` TEXT NOT NULL,
+  `created_at` BIGINT(20) NOT NULL,
+  KEY (`account`),
+  KEY (`action`),
+  KEY (`created_at`),
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
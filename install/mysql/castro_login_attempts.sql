@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `castro_login_attempts` (
+  `id` VARCHAR(255) NOT NULL,
+  `failures` INT(11) NOT NULL DEFAULT 0,
+  `last_failure` BIGINT(20) NOT NULL,
+  `locked_until` BIGINT(20) NOT NULL DEFAULT 0,
+  `unlock_token` VARCHAR(64) NOT NULL DEFAULT '',
+  KEY (`unlock_token`),
+  KEY (`last_failure`),
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `castro_mail_queue` (
+  `id` INT(11) NOT NULL AUTO_INCREMENT,
+  `recipient` VARCHAR(255) NOT NULL,
+  `subject` VARCHAR(255) NOT NULL,
+  `html` MEDIUMTEXT NOT NULL,
+  `text` MEDIUMTEXT NOT NULL,
+  `status` TINYINT(4) NOT NULL DEFAULT 0,
+  `attempts` INT(11) NOT NULL DEFAULT 0,
+  `next_attempt` BIGINT(20) NOT NULL DEFAULT 0,
+  `last_error` TEXT NOT NULL,
+  `created_at` BIGINT(20) NOT NULL,
+  KEY (`status`, `next_attempt`),
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
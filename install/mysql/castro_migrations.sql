@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS `castro_migrations` (
+  `id` INT NOT NULL AUTO_INCREMENT,
+  `extension_id` VARCHAR(45) NOT NULL DEFAULT '',
+  `version` BIGINT(20) NOT NULL,
+  `name` VARCHAR(255) NOT NULL,
+  `checksum` CHAR(64) NOT NULL,
+  `applied_at` BIGINT(20) NOT NULL,
+  UNIQUE KEY (`extension_id`, `version`),
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
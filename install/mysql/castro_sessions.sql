@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS `castro_sessions` (
+  `id` CHAR(64) NOT NULL,
+  `account` VARCHAR(255) NOT NULL DEFAULT '',
+  `ip` VARCHAR(45) NOT NULL DEFAULT '',
+  `user_agent` VARCHAR(255) NOT NULL DEFAULT '',
+  `data` BLOB NOT NULL,
+  `created_at` BIGINT(20) NOT NULL,
+  `last_seen` BIGINT(20) NOT NULL,
+  KEY (`account`),
+  KEY (`last_seen`),
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
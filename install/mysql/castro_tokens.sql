@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `castro_tokens` (
+  `id` VARCHAR(64) NOT NULL,
+  `purpose` VARCHAR(64) NOT NULL,
+  `subject` VARCHAR(255) NOT NULL,
+  `expires_at` BIGINT(20) NOT NULL,
+  KEY (`purpose`, `subject`),
+  KEY (`expires_at`),
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,6 @@
+CREATE TABLE `castro_accounts` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `account_id` INT NOT NULL,
+  `points` INT DEFAULT 0,
+  `admin` TINYINT DEFAULT 0
+);
@@ -0,0 +1,7 @@
+CREATE TABLE `castro_articles` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `title` varchar(255) DEFAULT NULL,
+  `text` TEXT,
+  `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
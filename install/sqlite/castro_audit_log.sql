@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS `castro_audit_log` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `account_id` INTEGER NOT NULL DEFAULT 0,
+  `account` VARCHAR(255) NOT NULL DEFAULT '',
+  `ip` VARCHAR(45) NOT NULL DEFAULT '',
+  `action` VARCHAR(128) NOT NULL,
+  `target` VARCHAR(255) NOT NULL DEFAULT '',
+  `old_value` TEXT NOT NULL,
+  `new_value` TEXT NOT NULL,
+  `created_at` BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS `castro_audit_log_created_at` ON `castro_audit_log` (`created_at`);
@@ -0,0 +1,45 @@
+CREATE TABLE `castro_extensions` (
+  `uid` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `name` VARCHAR(45) DEFAULT NULL,
+  `id` VARCHAR(45) DEFAULT NULL,
+  `author` VARCHAR(45) DEFAULT NULL,
+  `type` VARCHAR(45) DEFAULT NULL,
+  `version` VARCHAR(45) DEFAULT NULL,
+  `description` TEXT,
+  `installed` BIT NOT NULL DEFAULT 1,
+  `created_at` BIGINT NOT NULL,
+  `updated_at` BIGINT NOT NULL,
+  UNIQUE (`id`)
+);
+
+CREATE TABLE `castro_extension_hooks` (
+  `uid` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `extension_id` VARCHAR(45) DEFAULT NULL,
+  `type` VARCHAR(45) DEFAULT NULL,
+  `script` VARCHAR(45) DEFAULT NULL,
+  `enabled` BIT NOT NULL DEFAULT 1,
+  FOREIGN KEY (`extension_id`) REFERENCES `castro_extensions` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `castro_extension_pages` (
+  `uid` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `extension_id` VARCHAR(45) DEFAULT NULL,
+  `enabled` BIT NOT NULL DEFAULT 1,
+  FOREIGN KEY (`extension_id`) REFERENCES `castro_extensions` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `castro_extension_widgets` (
+  `uid` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `extension_id` VARCHAR(45) DEFAULT NULL,
+  `enabled` BIT NOT NULL DEFAULT 1,
+  FOREIGN KEY (`extension_id`) REFERENCES `castro_extensions` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `castro_extension_templatehooks` (
+  `uid` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `extension_id` VARCHAR(45) DEFAULT NULL,
+  `type` VARCHAR(45) DEFAULT NULL,
+  `template` VARCHAR(45) DEFAULT NULL,
+  `enabled` BIT NOT NULL DEFAULT 1,
+  FOREIGN KEY (`extension_id`) REFERENCES `castro_extensions` (`id`) ON DELETE CASCADE
+);
@@ -0,0 +1,11 @@
+CREATE TABLE `castro_fortumo_payments` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `account` VARCHAR(45) NULL,
+  `points` INT NULL,
+  `price` INT NULL,
+  `currency` VARCHAR(45) NULL,
+  `sender` VARCHAR(75) NULL,
+  `operator` VARCHAR(45) NULL,
+  `payment_id` VARCHAR(45) NULL,
+  `created_at` INT NULL
+);
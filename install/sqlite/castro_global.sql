@@ -0,0 +1,5 @@
+CREATE TABLE `castro_global` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `key` varchar(75) DEFAULT NULL,
+  `value` blob
+);
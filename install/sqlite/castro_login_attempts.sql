@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `castro_login_attempts` (
+  `id` VARCHAR(255) NOT NULL PRIMARY KEY,
+  `failures` INTEGER NOT NULL DEFAULT 0,
+  `last_failure` BIGINT NOT NULL,
+  `locked_until` BIGINT NOT NULL DEFAULT 0,
+  `unlock_token` VARCHAR(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS `castro_login_attempts_unlock_token` ON `castro_login_attempts` (`unlock_token`);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS `castro_mail_queue` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `recipient` VARCHAR(255) NOT NULL,
+  `subject` VARCHAR(255) NOT NULL,
+  `html` TEXT NOT NULL,
+  `text` TEXT NOT NULL,
+  `status` INTEGER NOT NULL DEFAULT 0,
+  `attempts` INTEGER NOT NULL DEFAULT 0,
+  `next_attempt` BIGINT NOT NULL DEFAULT 0,
+  `last_error` TEXT NOT NULL,
+  `created_at` BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS `castro_mail_queue_status` ON `castro_mail_queue` (`status`, `next_attempt`);
@@ -0,0 +1,8 @@
+CREATE TABLE `castro_map` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `name` varchar(75) DEFAULT NULL,
+  `data` blob,
+  `created_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  `updated_at` TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+  `last_modtime` TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS `castro_migrations` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `extension_id` VARCHAR(45) NOT NULL DEFAULT '',
+  `version` BIGINT NOT NULL,
+  `name` VARCHAR(255) NOT NULL,
+  `checksum` CHAR(64) NOT NULL,
+  `applied_at` BIGINT NOT NULL,
+  UNIQUE (`extension_id`, `version`)
+);
@@ -0,0 +1,4 @@
+CREATE TABLE `castro_onlinechart` (
+  `count` INT NOT NULL,
+  `time` BIGINT NOT NULL PRIMARY KEY
+);
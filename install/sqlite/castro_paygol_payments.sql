@@ -0,0 +1,8 @@
+CREATE TABLE `castro_paygol_payments` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `transaction_id` VARCHAR(45) NULL,
+  `custom` VARCHAR(45) NULL,
+  `price` INT NULL,
+  `points` INT NULL,
+  `created_at` INT NULL
+);
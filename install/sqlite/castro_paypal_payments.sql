@@ -0,0 +1,9 @@
+CREATE TABLE `castro_paypal_payments` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `payment_id` VARCHAR(45) NULL,
+  `payer_id` VARCHAR(45) NULL,
+  `custom` VARCHAR(45) NULL,
+  `package_name` VARCHAR(45) NULL,
+  `state` VARCHAR(45) NULL,
+  `created_at` INT NULL
+);
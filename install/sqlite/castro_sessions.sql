@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS `castro_sessions` (
+  `id` CHAR(64) NOT NULL PRIMARY KEY,
+  `account` VARCHAR(255) NOT NULL DEFAULT '',
+  `ip` VARCHAR(45) NOT NULL DEFAULT '',
+  `user_agent` VARCHAR(255) NOT NULL DEFAULT '',
+  `data` BLOB NOT NULL,
+  `created_at` BIGINT NOT NULL,
+  `last_seen` BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS `castro_sessions_account` ON `castro_sessions` (`account`);
@@ -0,0 +1,7 @@
+CREATE TABLE `castro_shop_categories` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `name` VARCHAR(45) NULL,
+  `description` VARCHAR(255) NULL,
+  `created_at` INT NULL,
+  `updated_at` INT NULL
+);
@@ -0,0 +1,7 @@
+CREATE TABLE `castro_shop_checkout` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `offer` VARCHAR(255) NULL,
+  `amount` VARCHAR(255) NULL,
+  `player` VARCHAR(70) DEFAULT '',
+  `given` INT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE `castro_shop_discounts` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `code` VARCHAR(45) NULL,
+  `created_at` INT NULL,
+  `valid_till` INT NULL,
+  `discount` INT NULL,
+  `uses` INT NULL,
+  `unlimited` INT NULL
+);
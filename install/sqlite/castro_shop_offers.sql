@@ -0,0 +1,16 @@
+CREATE TABLE `castro_shop_offers` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `name` varchar(45) DEFAULT NULL,
+  `description` varchar(255) DEFAULT NULL,
+  `created_at` INT DEFAULT NULL,
+  `updated_at` INT DEFAULT NULL,
+  `category_id` INT DEFAULT NULL,
+  `price` INT DEFAULT NULL,
+  `image` varchar(255) DEFAULT NULL,
+  `give_item` INT DEFAULT 0,
+  `give_item_amount` INT DEFAULT 0,
+  `charges` INT DEFAULT 1,
+  `container_give_item` varchar(255) DEFAULT '',
+  `container_give_amount` varchar(255) DEFAULT '',
+  `container_give_charges` varchar(255) DEFAULT ''
+);
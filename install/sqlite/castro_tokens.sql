@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS `castro_tokens` (
+  `id` VARCHAR(64) NOT NULL PRIMARY KEY,
+  `purpose` VARCHAR(64) NOT NULL,
+  `subject` VARCHAR(255) NOT NULL,
+  `expires_at` BIGINT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS `castro_tokens_purpose_subject` ON `castro_tokens` (`purpose`, `subject`);
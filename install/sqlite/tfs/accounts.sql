@@ -0,0 +1,229 @@
+CREATE TABLE `accounts` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `name` VARCHAR(32) NOT NULL UNIQUE,
+  `password` CHAR(40) NOT NULL,
+  `secret` CHAR(16) DEFAULT NULL,
+  `type` INT NOT NULL DEFAULT 1,
+  `premium_ends_at` INT NOT NULL DEFAULT 0,
+  `email` VARCHAR(255) NOT NULL DEFAULT '',
+  `creation` INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE `players` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `name` VARCHAR(255) NOT NULL UNIQUE,
+  `group_id` INT NOT NULL DEFAULT 1,
+  `account_id` INT NOT NULL DEFAULT 0,
+  `level` INT NOT NULL DEFAULT 1,
+  `vocation` INT NOT NULL DEFAULT 0,
+  `health` INT NOT NULL DEFAULT 150,
+  `healthmax` INT NOT NULL DEFAULT 150,
+  `experience` BIGINT NOT NULL DEFAULT 0,
+  `lookbody` INT NOT NULL DEFAULT 0,
+  `lookfeet` INT NOT NULL DEFAULT 0,
+  `lookhead` INT NOT NULL DEFAULT 0,
+  `looklegs` INT NOT NULL DEFAULT 0,
+  `looktype` INT NOT NULL DEFAULT 136,
+  `lookaddons` INT NOT NULL DEFAULT 0,
+  `maglevel` INT NOT NULL DEFAULT 0,
+  `mana` INT NOT NULL DEFAULT 0,
+  `manamax` INT NOT NULL DEFAULT 0,
+  `manaspent` INT NOT NULL DEFAULT 0,
+  `soul` INT NOT NULL DEFAULT 0,
+  `town_id` INT NOT NULL DEFAULT 0,
+  `posx` INT NOT NULL DEFAULT 0,
+  `posy` INT NOT NULL DEFAULT 0,
+  `posz` INT NOT NULL DEFAULT 0,
+  `conditions` BLOB NOT NULL DEFAULT '',
+  `cap` INT NOT NULL DEFAULT 400,
+  `sex` INT NOT NULL DEFAULT 0,
+  `lastlogin` BIGINT NOT NULL DEFAULT 0,
+  `lastip` INT NOT NULL DEFAULT 0,
+  `save` TINYINT NOT NULL DEFAULT 1,
+  `skull` TINYINT NOT NULL DEFAULT 0,
+  `skulltime` INT NOT NULL DEFAULT 0,
+  `lastlogout` BIGINT NOT NULL DEFAULT 0,
+  `blessings` TINYINT NOT NULL DEFAULT 0,
+  `onlinetime` INT NOT NULL DEFAULT 0,
+  `deletion` BIGINT NOT NULL DEFAULT 0,
+  `balance` BIGINT NOT NULL DEFAULT 0,
+  `offlinetraining_time` SMALLINT NOT NULL DEFAULT 43200,
+  `offlinetraining_skill` INT NOT NULL DEFAULT -1,
+  `stamina` SMALLINT NOT NULL DEFAULT 2520,
+  `skill_fist` INT NOT NULL DEFAULT 10,
+  `skill_fist_tries` BIGINT NOT NULL DEFAULT 0,
+  `skill_club` INT NOT NULL DEFAULT 10,
+  `skill_club_tries` BIGINT NOT NULL DEFAULT 0,
+  `skill_sword` INT NOT NULL DEFAULT 10,
+  `skill_sword_tries` BIGINT NOT NULL DEFAULT 0,
+  `skill_axe` INT NOT NULL DEFAULT 10,
+  `skill_axe_tries` BIGINT NOT NULL DEFAULT 0,
+  `skill_dist` INT NOT NULL DEFAULT 10,
+  `skill_dist_tries` BIGINT NOT NULL DEFAULT 0,
+  `skill_shielding` INT NOT NULL DEFAULT 10,
+  `skill_shielding_tries` BIGINT NOT NULL DEFAULT 0,
+  `skill_fishing` INT NOT NULL DEFAULT 10,
+  `skill_fishing_tries` BIGINT NOT NULL DEFAULT 0,
+  FOREIGN KEY (`account_id`) REFERENCES `accounts` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `account_bans` (
+  `account_id` INT NOT NULL PRIMARY KEY,
+  `reason` VARCHAR(255) NOT NULL,
+  `banned_at` BIGINT NOT NULL,
+  `expires_at` BIGINT NOT NULL,
+  `banned_by` INT NOT NULL,
+  FOREIGN KEY (`account_id`) REFERENCES `accounts` (`id`) ON DELETE CASCADE,
+  FOREIGN KEY (`banned_by`) REFERENCES `players` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `account_ban_history` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `account_id` INT NOT NULL,
+  `reason` VARCHAR(255) NOT NULL,
+  `banned_at` BIGINT NOT NULL,
+  `expired_at` BIGINT NOT NULL,
+  `banned_by` INT NOT NULL,
+  FOREIGN KEY (`account_id`) REFERENCES `accounts` (`id`) ON DELETE CASCADE,
+  FOREIGN KEY (`banned_by`) REFERENCES `players` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `ip_bans` (
+  `ip` INT NOT NULL PRIMARY KEY,
+  `reason` VARCHAR(255) NOT NULL,
+  `banned_at` BIGINT NOT NULL,
+  `expires_at` BIGINT NOT NULL,
+  `banned_by` INT NOT NULL,
+  FOREIGN KEY (`banned_by`) REFERENCES `players` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `player_namelocks` (
+  `player_id` INT NOT NULL PRIMARY KEY,
+  `reason` VARCHAR(255) NOT NULL,
+  `namelocked_at` BIGINT NOT NULL,
+  `namelocked_by` INT NOT NULL,
+  FOREIGN KEY (`player_id`) REFERENCES `players` (`id`) ON DELETE CASCADE,
+  FOREIGN KEY (`namelocked_by`) REFERENCES `players` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `guilds` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `name` VARCHAR(255) NOT NULL UNIQUE,
+  `ownerid` INT NOT NULL UNIQUE,
+  `creationdata` INT NOT NULL,
+  `motd` VARCHAR(255) NOT NULL DEFAULT '',
+  FOREIGN KEY (`ownerid`) REFERENCES `players` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `guild_ranks` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `guild_id` INT NOT NULL,
+  `name` VARCHAR(255) NOT NULL,
+  `level` INT NOT NULL,
+  FOREIGN KEY (`guild_id`) REFERENCES `guilds` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `guild_membership` (
+  `player_id` INT NOT NULL PRIMARY KEY,
+  `guild_id` INT NOT NULL,
+  `rank_id` INT NOT NULL,
+  `nick` VARCHAR(15) NOT NULL DEFAULT '',
+  FOREIGN KEY (`player_id`) REFERENCES `players` (`id`) ON DELETE CASCADE,
+  FOREIGN KEY (`guild_id`) REFERENCES `guilds` (`id`) ON DELETE CASCADE,
+  FOREIGN KEY (`rank_id`) REFERENCES `guild_ranks` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `guild_invites` (
+  `player_id` INT NOT NULL DEFAULT 0,
+  `guild_id` INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (`player_id`, `guild_id`),
+  FOREIGN KEY (`player_id`) REFERENCES `players` (`id`) ON DELETE CASCADE,
+  FOREIGN KEY (`guild_id`) REFERENCES `guilds` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `guild_wars` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `guild1` INT NOT NULL DEFAULT 0,
+  `guild2` INT NOT NULL DEFAULT 0,
+  `name1` VARCHAR(255) NOT NULL,
+  `name2` VARCHAR(255) NOT NULL,
+  `status` TINYINT NOT NULL DEFAULT 0,
+  `started` BIGINT NOT NULL DEFAULT 0,
+  `ended` BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE `guildwar_kills` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `killer` VARCHAR(50) NOT NULL,
+  `target` VARCHAR(50) NOT NULL,
+  `killerguild` INT NOT NULL DEFAULT 0,
+  `targetguild` INT NOT NULL DEFAULT 0,
+  `warid` INT NOT NULL DEFAULT 0,
+  `time` BIGINT NOT NULL,
+  FOREIGN KEY (`warid`) REFERENCES `guild_wars` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `houses` (
+  `id` INTEGER PRIMARY KEY AUTOINCREMENT,
+  `owner` INT NOT NULL,
+  `paid` INT NOT NULL DEFAULT 0,
+  `warnings` INT NOT NULL DEFAULT 0,
+  `name` VARCHAR(255) NOT NULL,
+  `rent` INT NOT NULL DEFAULT 0,
+  `town_id` INT NOT NULL DEFAULT 0,
+  `bid` INT NOT NULL DEFAULT 0,
+  `bid_end` INT NOT NULL DEFAULT 0,
+  `last_bid` INT NOT NULL DEFAULT 0,
+  `highest_bidder` INT NOT NULL DEFAULT 0,
+  `size` INT NOT NULL DEFAULT 0,
+  `beds` INT NOT NULL DEFAULT 0
+);
+
+CREATE TABLE `house_lists` (
+  `house_id` INT NOT NULL,
+  `listid` INT NOT NULL,
+  `list` TEXT NOT NULL,
+  FOREIGN KEY (`house_id`) REFERENCES `houses` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `players_online` (
+  `player_id` INT NOT NULL PRIMARY KEY
+);
+
+CREATE TABLE `player_deaths` (
+  `player_id` INT NOT NULL,
+  `time` BIGINT NOT NULL DEFAULT 0,
+  `level` INT NOT NULL DEFAULT 1,
+  `killed_by` VARCHAR(255) NOT NULL,
+  `is_player` TINYINT NOT NULL DEFAULT 1,
+  `mostdamage_by` VARCHAR(100) NOT NULL,
+  `mostdamage_is_player` TINYINT NOT NULL DEFAULT 0,
+  `unjustified` TINYINT NOT NULL DEFAULT 0,
+  `mostdamage_unjustified` TINYINT NOT NULL DEFAULT 0,
+  FOREIGN KEY (`player_id`) REFERENCES `players` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `player_storage` (
+  `player_id` INT NOT NULL DEFAULT 0,
+  `key` INT NOT NULL DEFAULT 0,
+  `value` INT NOT NULL DEFAULT 0,
+  PRIMARY KEY (`player_id`, `key`),
+  FOREIGN KEY (`player_id`) REFERENCES `players` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `player_items` (
+  `player_id` INT NOT NULL DEFAULT 0,
+  `pid` INT NOT NULL DEFAULT 0,
+  `sid` INT NOT NULL DEFAULT 0,
+  `itemtype` SMALLINT NOT NULL DEFAULT 0,
+  `count` SMALLINT NOT NULL DEFAULT 0,
+  `attributes` BLOB NOT NULL DEFAULT '',
+  FOREIGN KEY (`player_id`) REFERENCES `players` (`id`) ON DELETE CASCADE
+);
+
+CREATE TABLE `server_config` (
+  `config` VARCHAR(50) NOT NULL PRIMARY KEY,
+  `value` VARCHAR(256) NOT NULL DEFAULT ''
+);
+
+INSERT INTO `server_config` (`config`, `value`) VALUES ('db_version', '0'), ('motd_hash', ''), ('motd_num', '0'), ('players_record', '0');
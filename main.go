@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http/pprof"
 	_ "net/http/pprof"
+	"os"
 	"strings"
 	"time"
 
@@ -18,7 +19,6 @@ import (
 	"github.com/raggaer/castro/app/controllers"
 	"github.com/raggaer/castro/app/models"
 	"github.com/raggaer/castro/app/util"
-	"github.com/ulule/limiter"
 	"github.com/ulule/limiter/drivers/store/memory"
 	"github.com/urfave/negroni"
 	lua "github.com/yuin/gopher-lua"
@@ -33,6 +33,16 @@ func main() {
 	gob.Register(map[string]interface{}{})
 	gob.Register([]interface{}{})
 
+	// Run command-line subcommand
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	// Show credits and application name
 	fmt.Printf(`
 Castro - High performance content management system for Open Tibia servers
@@ -56,18 +66,9 @@ Compiled at: %v
 	// Run main app entry point
 	app.Start()
 
-	// Create rate-limiter instance
-	rate := limiter.Rate{
-		Period: util.Config.Configuration.RateLimit.Time.Duration,
-		Limit:  util.Config.Configuration.RateLimit.Number,
-	}
-
 	// Create rate-limiter storage
 	store := memory.NewStore()
 
-	// Create rate-limiter
-	limiter := limiter.New(store, rate)
-
 	// Declare our new http router
 	router := httprouter.New()
 
@@ -77,6 +78,7 @@ Compiled at: %v
 	router.POST("/subtopic/*filepath", controllers.LuaPage)
 	router.GET("/subtopic/*filepath", controllers.LuaPage)
 	router.GET("/extensions/:id/static/*filepath", controllers.ExtensionStatic)
+	router.GET("/captcha/:id", controllers.CaptchaImage)
 	router.POST("/nocsrf/*filepath", controllers.LuaPage)
 	router.NotFound = http.HandlerFunc(PageNotFound)
 
@@ -93,7 +95,7 @@ Compiled at: %v
 
 	// Create the middleware negroni instance with some application middleware
 	n := negroni.New(
-		newRateLimitHandler(limiter),
+		newRateLimitHandler(store),
 		newSecurityHandler(),
 		newSessionHandler(),
 		newMicrotimeHandler(),
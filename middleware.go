@@ -2,13 +2,15 @@ package main
 
 import (
 	"net/http"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/dchest/uniuri"
 	"github.com/raggaer/castro/app/models"
 	"github.com/raggaer/castro/app/util"
 	"github.com/ulule/limiter"
+	"github.com/urfave/negroni"
 	"golang.org/x/net/context"
 )
 
@@ -27,6 +29,7 @@ type securityHandler struct{}
 // rateLimitHandler used for rate-limiting
 type rateLimitHandler struct {
 	Limiter *limiter.Limiter
+	Routes  []*routeLimiter
 }
 
 // i18nHandler used to detect user language
@@ -47,33 +50,102 @@ func (i *i18nHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next h
 	next(w, req.WithContext(ctx))
 }
 
-// newRateLimitHandler creates and returns a new rateLimitHandler instance
-func newRateLimitHandler(limiter *limiter.Limiter) *rateLimitHandler {
-	return &rateLimitHandler{limiter}
+// routeLimiter holds the rate-limiter of a route rule
+type routeLimiter struct {
+	Rule    util.RateLimitRoute
+	Limiter *limiter.Limiter
 }
 
-func (r *rateLimitHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
-	// Check if rate-limit is not enabled
-	if !util.Config.Configuration.RateLimit.Enabled {
-		next(w, req)
-		return
+// newRateLimitHandler creates and returns a new rateLimitHandler instance. Every
+// route rule gets its own limiter on the given store
+func newRateLimitHandler(store limiter.Store) *rateLimitHandler {
+	handler := &rateLimitHandler{
+		Limiter: limiter.New(store, limiter.Rate{
+			Period: util.Config.Configuration.RateLimit.Time.Duration,
+			Limit:  util.Config.Configuration.RateLimit.Number,
+		}),
+	}
+
+	for _, rule := range util.Config.Configuration.RateLimit.Routes {
+		handler.Routes = append(handler.Routes, &routeLimiter{
+			Rule: rule,
+			Limiter: limiter.New(store, limiter.Rate{
+				Period: rule.Time.Duration,
+				Limit:  rule.Number,
+			}),
+		})
+	}
+
+	return handler
+}
+
+// route returns the most specific route rule that matches the request
+func (r *rateLimitHandler) route(req *http.Request) *routeLimiter {
+	var match *routeLimiter
+
+	// Clean the path so other spellings of a route match its rule
+	p := strings.ToLower(path.Clean("/" + req.URL.Path))
+
+	for _, route := range r.Routes {
+		if !strings.HasPrefix(p, strings.ToLower(route.Rule.Path)) {
+			continue
+		}
+
+		// Check rule methods
+		if len(route.Rule.Methods) > 0 {
+			allowed := false
+			for _, m := range route.Rule.Methods {
+				if strings.EqualFold(m, req.Method) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				continue
+			}
+		}
+
+		if match == nil || len(route.Rule.Path) > len(match.Rule.Path) {
+			match = route
+		}
 	}
 
-	// IP data holder
-	ip := ""
+	return match
+}
+
+// limitReached sets the rate-limit headers and checks if the limit was reached
+func limitReached(w http.ResponseWriter, ctx limiter.Context) bool {
+	// Set rate-limit headers
+	w.Header().Set("X-RateLimit-Limit", strconv.FormatInt(ctx.Limit, 10))
+	w.Header().Set("X-RateLimit-Remaining", strconv.FormatInt(ctx.Remaining, 10))
+	w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(ctx.Reset, 10))
 
-	// Check if behind proxy
-	if util.Config.Configuration.SSL.Proxy {
+	if !ctx.Reached {
+		return false
+	}
+
+	// Tell the client when to retry
+	retry := ctx.Reset - time.Now().Unix()
+	if retry < 1 {
+		retry = 1
+	}
 
-		// Get address from X-Forwarded-For
-		ip = req.Header.Get("X-Forwarded-For")
+	w.Header().Set("Retry-After", strconv.FormatInt(retry, 10))
+	http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
 
-	} else {
+	return true
+}
 
-		// Get address from RemoteAddress
-		ip = req.RemoteAddr
+func (r *rateLimitHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+	// Check if rate-limit is not enabled
+	if !util.Config.Configuration.RateLimit.Enabled {
+		next(w, req)
+		return
 	}
 
+	// Get client address
+	ip := util.ClientIP(req)
+
 	// Get rate-limit context
 	ctx, err := r.Limiter.Get(req.Context(), ip)
 
@@ -83,11 +155,24 @@ func (r *rateLimitHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, n
 	}
 
 	// Check for limit
-	if ctx.Reached {
-		http.Error(w, "Rate-limit reached", 500)
+	if limitReached(w, ctx) {
 		return
 	}
 
+	// Check route rule limit
+	if route := r.route(req); route != nil {
+		ctx, err := route.Limiter.Get(req.Context(), route.Rule.Path+"|"+strings.ToUpper(req.Method)+"|"+ip)
+
+		if err != nil {
+			http.Error(w, "Cannot get rate-limit instance", 500)
+			return
+		}
+
+		if limitReached(w, ctx) {
+			return
+		}
+	}
+
 	// Execute next handler
 	next(w, req)
 }
@@ -98,23 +183,18 @@ func newSecurityHandler() *securityHandler {
 }
 
 func (s *securityHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
-	// Retrieve nonce value from cache
-	nonce, ok := util.Cache.Get("nonce")
-
-	if !ok {
+	// Create response policy with a new nonce
+	csp, err := util.NewContentSecurityPolicy(util.Config.Configuration.Security)
 
-		// Create new nonce value
-		nonce = uniuri.NewLen(3)
-
-		// Save new nonce to cache
-		util.Cache.Set("nonce", nonce, time.Minute*20)
+	if err != nil {
+		util.Logger.Logger.Errorf("Cannot create content security policy: %v", err)
+		http.Error(w, "Cannot create content security policy", 500)
+		return
 	}
 
-	// Set nonce header value
-	util.Config.Configuration.Security.CSP.Script.Default = append(util.Config.Configuration.Security.CSP.Script.Default, "nonce-"+nonce.(string))
-
-	// Create new context with cookie value
-	ctx := context.WithValue(req.Context(), "nonce", nonce)
+	// Create new context with policy and nonce values
+	ctx := context.WithValue(req.Context(), "csp", csp)
+	ctx = context.WithValue(ctx, "nonce", csp.Nonce)
 
 	// Set Strict-Transport-Security header if SSL
 	if util.Config.Configuration.IsSSL() {
@@ -142,11 +222,15 @@ func (s *securityHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, ne
 	w.Header().Set("X-Permitted-Cross-Domain-Policies", util.Config.Configuration.Security.CrossDomainPolicy)
 
 	if util.Config.Configuration.Security.CSP.Enabled {
-		// Set Content-Security-Policy header
-		w.Header().Set(
-			"Content-Security-Policy",
-			util.Config.Configuration.CSP(),
-		)
+
+		// Set Content-Security-Policy header once the page is done adding sources
+		if rw, ok := w.(negroni.ResponseWriter); ok {
+			rw.Before(func(negroni.ResponseWriter) {
+				w.Header().Set("Content-Security-Policy", csp.String())
+			})
+		} else {
+			w.Header().Set("Content-Security-Policy", csp.String())
+		}
 	}
 
 	// Run next handler
@@ -159,77 +243,75 @@ func newSessionHandler() *sessionHandler {
 }
 
 func (s *sessionHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
-	// Get application cookie
-	cookie, err := req.Cookie(util.Config.Configuration.Cookies.Name)
+	// Load session from the application cookie
+	session, err := loadSession(req)
 
 	if err != nil {
+		util.Logger.Logger.Errorf("Cannot load session: %v", err)
+		http.Error(w, "Cannot load session", 500)
+		return
+	}
 
-		// Cookie data
-		v := make(map[string]interface{})
-
-		// Set issuer field
-		v["issuer"] = "Castro"
+	if session == nil {
 
-		// Encode cookie value
-		encoded, err := util.SessionStore.Encode(util.Config.Configuration.Cookies.Name, v)
+		// Create new session
+		session, err = util.NewSession(req)
 
 		if err != nil {
-			util.Logger.Logger.Errorf("Cannot encode cookie value: %v", err)
+			util.Logger.Logger.Errorf("Cannot create session: %v", err)
+			http.Error(w, "Cannot create session", 500)
 			return
 		}
 
-		// Create cookie
-		c := &http.Cookie{
-			Name:     util.Config.Configuration.Cookies.Name,
-			Value:    encoded,
-			Path:     "/",
-			MaxAge:   util.Config.Configuration.Cookies.MaxAge,
-			Secure:   util.Config.Configuration.IsSSL(),
-			HttpOnly: true,
-		}
+		// The session cookie is set once the session holds any data
 
-		// Set cookie
-		http.SetCookie(w, c)
+	} else if _, err := session.Touch(req); err != nil {
+		util.Logger.Logger.Errorf("Cannot update session: %v", err)
+	}
 
-		// Create new context with cookie value
-		ctx := context.WithValue(req.Context(), "session", v)
+	// Create new context with session value
+	ctx := context.WithValue(req.Context(), "session", session)
 
-		// Run next handler
-		next(w, req.WithContext(ctx))
+	// Run next handler
+	next(w, req.WithContext(ctx))
+}
 
-		return
+// loadSession decodes the application cookie and loads its session. Returns nil
+// if there is no valid session
+func loadSession(req *http.Request) (*util.Session, error) {
+	// Get application cookie
+	cookie, err := req.Cookie(util.Config.Configuration.Cookies.Name)
+
+	if err != nil {
+		return nil, nil
 	}
 
-	// Cookie data holder
-	v := make(map[string]interface{})
+	return util.LoadSession(cookie.Value)
+}
+
+// setSessionCookie sets the cookie that holds the session id
+func setSessionCookie(w http.ResponseWriter, session *util.Session) error {
+	// Create cookie
+	c, err := session.Cookie()
 
-	// Decode cookie
-	if err := util.SessionStore.Decode(
-		util.Config.Configuration.Cookies.Name,
-		cookie.Value,
-		&v,
-	); err != nil {
-		util.Logger.Logger.Errorf("Cannot decode cookie value: %v", err)
-		return
+	if err != nil {
+		return err
 	}
 
-	// Get issuer
-	issuer, ok := v["issuer"].(string)
+	// Set cookie
+	http.SetCookie(w, c)
 
-	if !ok {
-		return
-	}
+	return nil
+}
 
-	// Check issuer
-	if issuer != "Castro" {
-		return
+// saveSession saves the session and sets the session cookie. Sessions that are
+// not stored keep their data in the cookie
+func saveSession(w http.ResponseWriter, session *util.Session) error {
+	if err := session.Save(); err != nil {
+		return err
 	}
 
-	// Create new context with cookie value
-	ctx := context.WithValue(req.Context(), "session", v)
-
-	// Run next handler
-	next(w, req.WithContext(ctx))
+	return setSessionCookie(w, session)
 }
 
 // newCsrfHandler creates and returns a new csrfHandler instance
@@ -247,91 +329,100 @@ func (c *csrfHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, next h
 	}
 
 	// Get session
-	session, ok := req.Context().Value("session").(map[string]interface{})
+	session, ok := req.Context().Value("session").(*util.Session)
 
 	if !ok {
+		http.Error(w, "Cannot read session", 500)
 		return
 	}
 
 	// Get token
-	token, ok := session["csrf-token"].(*models.CsrfToken)
+	token, ok := session.Data["csrf-token"].(*models.CsrfToken)
 
 	if !ok {
 
-		// Check if request is valid
-		if req.Method == http.MethodPost {
-			return
-		}
-
 		// Create token
-		tkn := models.CsrfToken{
-			Token: uniuri.New(),
-			At:    time.Now(),
-		}
+		token = models.NewCsrfToken()
 
 		// Set session value
-		session["csrf-token"] = &tkn
-
-		// Encode session
-		encoded, err := util.SessionStore.Encode(util.Config.Configuration.Cookies.Name, session)
-
-		if err != nil {
-			util.Logger.Logger.Errorf("Cannot encode session: %v", err)
-		}
+		session.Data["csrf-token"] = token
 
-		// Create cookie
-		c := &http.Cookie{
-			Name:     util.Config.Configuration.Cookies.Name,
-			Value:    encoded,
-			Path:     "/",
-			MaxAge:   util.Config.Configuration.Cookies.MaxAge,
-			Secure:   util.Config.Configuration.IsSSL(),
-			HttpOnly: true,
+		// Save session
+		if err := saveSession(w, session); err != nil {
+			util.Logger.Logger.Errorf("Cannot save session: %v", err)
 		}
-
-		// Set cookie
-		http.SetCookie(w, c)
-
-		// Create context
-		ctx := context.WithValue(req.Context(), "csrf-token", &tkn)
-
-		// Run next handler
-		next(w, req.WithContext(ctx))
-
-		return
 	}
 
+	// Create context
+	req = req.WithContext(context.WithValue(req.Context(), "csrf-token", token))
+
 	// Check if valid token
-	if req.Method == http.MethodPost && (req.FormValue("_csrf") != token.Token && req.URL.Query().Get("_csrf") != token.Token) {
+	if csrfProtected(req.Method) && !token.Valid(csrfRequestToken(req)) {
+		csrfFailure(w, req)
 		return
 	}
 
-	// Check if token  is old
-	if time.Now().Before(token.At) {
+	// Check if token is old
+	if token.Expired(util.Config.Configuration.Security.CSRFExpire.Duration) {
 
 		// Create new token
-		token.Token = uniuri.New()
-		token.At = time.Now()
-
-		// Encode session
-		encoded, err := util.SessionStore.Encode(util.Config.Configuration.Cookies.Name, session)
+		token.Rotate()
 
-		if err != nil {
-			util.Logger.Logger.Errorf("Cannot encode session: %v", err)
+		// Save session
+		if err := saveSession(w, session); err != nil {
+			util.Logger.Logger.Errorf("Cannot save session: %v", err)
 		}
+	}
 
-		// Create cookie
-		c := util.SessionCookie(encoded)
+	// Run next handler
+	next(w, req)
+}
 
-		// Set cookie
-		http.SetCookie(w, c)
+// csrfProtected checks if the given method changes state and needs a token
+func csrfProtected(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
 	}
+	return true
+}
 
-	// Create context
-	ctx := context.WithValue(req.Context(), "csrf-token", token)
+// csrfRequestToken returns the token sent with the request. The X-CSRF-Token
+// header is checked first so AJAX and JSON requests do not need a form field
+func csrfRequestToken(req *http.Request) string {
+	if token := req.Header.Get("X-CSRF-Token"); token != "" {
+		return token
+	}
+	if token := req.FormValue("_csrf"); token != "" {
+		return token
+	}
+	return req.URL.Query().Get("_csrf")
+}
 
-	// Run next handler
-	next(w, req.WithContext(ctx))
+// wantsJSON checks if the request expects a JSON response
+func wantsJSON(req *http.Request) bool {
+	return req.Header.Get("X-CSRF-Token") != "" ||
+		req.Header.Get("X-Requested-With") == "XMLHttpRequest" ||
+		strings.Contains(req.Header.Get("Accept"), "application/json") ||
+		strings.HasPrefix(req.Header.Get("Content-Type"), "application/json")
+}
+
+// csrfFailure writes the 403 response of a request with a missing or invalid token
+func csrfFailure(w http.ResponseWriter, req *http.Request) {
+	util.Logger.Logger.Warnf("Invalid CSRF token from %v on %v %v", util.ClientIP(req), req.Method, req.URL.Path)
+
+	// Send JSON error to AJAX requests
+	if wantsJSON(req) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":"Invalid CSRF token"}`))
+		return
+	}
+
+	// Render error template
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusForbidden)
+	util.Template.RenderTemplate(w, req, "csrf.html", nil)
 }
 
 // newMicrotimeHandler creates and returns a new microtimeHandler instance
@@ -0,0 +1,24 @@
+-- Replaces the castro_accounts admin flag with roles and permissions
+function up()
+    db:execute("CREATE TABLE castro_roles (name VARCHAR(64) NOT NULL, description VARCHAR(255) NOT NULL DEFAULT '', PRIMARY KEY (name))")
+    db:execute("CREATE TABLE castro_role_permissions (role VARCHAR(64) NOT NULL, permission VARCHAR(128) NOT NULL, PRIMARY KEY (role, permission))")
+    db:execute("CREATE TABLE castro_account_roles (account_id INT NOT NULL, role VARCHAR(64) NOT NULL, PRIMARY KEY (account_id, role))")
+    db:execute("CREATE TABLE castro_permissions (name VARCHAR(128) NOT NULL, description VARCHAR(255) NOT NULL DEFAULT '', extension_id VARCHAR(45) NOT NULL DEFAULT '', PRIMARY KEY (name))")
+
+    db:execute("INSERT INTO castro_roles (name, description) VALUES ('administrator', 'Full access to the admin panel')")
+    db:execute("INSERT INTO castro_role_permissions (role, permission) VALUES ('administrator', '*')")
+    db:execute("INSERT INTO castro_roles (name, description) VALUES ('writer', 'Writes and edits articles')")
+    db:execute("INSERT INTO castro_role_permissions (role, permission) VALUES ('writer', 'articles.edit')")
+
+    -- Current admins become administrators
+    db:execute("INSERT INTO castro_account_roles (account_id, role) SELECT account_id, 'administrator' FROM castro_accounts WHERE admin = 1")
+end
+
+function down()
+    db:execute("UPDATE castro_accounts SET admin = 0")
+    db:execute("UPDATE castro_accounts SET admin = 1 WHERE account_id IN (SELECT a.account_id FROM castro_account_roles a INNER JOIN castro_role_permissions p ON p.role = a.role WHERE p.permission = '*')")
+    db:execute("DROP TABLE castro_permissions")
+    db:execute("DROP TABLE castro_account_roles")
+    db:execute("DROP TABLE castro_role_permissions")
+    db:execute("DROP TABLE castro_roles")
+end
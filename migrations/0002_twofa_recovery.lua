@@ -0,0 +1,8 @@
+-- Stores the hashed two-factor recovery codes of the accounts
+function up()
+    db:execute("CREATE TABLE castro_twofa_recovery (account_id INT NOT NULL, code VARCHAR(64) NOT NULL, used_at BIGINT NOT NULL DEFAULT 0, PRIMARY KEY (account_id, code))")
+end
+
+function down()
+    db:execute("DROP TABLE castro_twofa_recovery")
+end
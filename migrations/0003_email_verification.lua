@@ -0,0 +1,8 @@
+-- Tracks if the account email address was verified
+function up()
+    db:execute("ALTER TABLE castro_accounts ADD COLUMN email_verified INT NOT NULL DEFAULT 0")
+end
+
+function down()
+    db:dropColumn("castro_accounts", "email_verified")
+end
@@ -0,0 +1,8 @@
+-- Sets the order in which extension hook handlers run
+function up()
+    db:execute("ALTER TABLE castro_extension_hooks ADD COLUMN priority INT NOT NULL DEFAULT 0")
+end
+
+function down()
+    db:dropColumn("castro_extension_hooks", "priority")
+end
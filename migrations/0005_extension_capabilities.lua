@@ -0,0 +1,8 @@
+-- Stores the capabilities granted to sandboxed extensions. NULL means the extension runs unrestricted
+function up()
+    db:execute("ALTER TABLE castro_extensions ADD COLUMN capabilities TEXT")
+end
+
+function down()
+    db:dropColumn("castro_extensions", "capabilities")
+end
@@ -0,0 +1,8 @@
+-- Stores the settings of every extension as JSON encoded values
+function up()
+    db:execute("CREATE TABLE castro_extension_config (extension_id VARCHAR(45) NOT NULL, name VARCHAR(64) NOT NULL, value TEXT NOT NULL, updated_at BIGINT NOT NULL DEFAULT 0, PRIMARY KEY (extension_id, name))")
+end
+
+function down()
+    db:execute("DROP TABLE castro_extension_config")
+end
@@ -0,0 +1,24 @@
+function get()
+    local t = token:consume(http.getValues.token or "", "change-email")
+
+    if t == nil then
+        session:setFlash("validationError", "Invalid or expired confirmation link")
+        http:redirect(session:isLogged() and "/subtopic/account/dashboard" or "/subtopic/login")
+        return
+    end
+
+    if db:singleQuery("SELECT id FROM accounts WHERE email = ?", t.data) ~= nil then
+        session:setFlash("validationError", "Email already in use by another user")
+        http:redirect(session:isLogged() and "/subtopic/account/dashboard" or "/subtopic/login")
+        return
+    end
+
+    db:execute("UPDATE accounts SET email = ? WHERE id = ?", t.data, t.subject)
+    db:execute("UPDATE castro_accounts SET email_verified = 1 WHERE account_id = ?", t.subject)
+
+    -- Links sent to the old address are no longer valid
+    token:revoke("verify-email", t.subject)
+
+    session:setFlash("success", "Your email address has been changed")
+    http:redirect(session:isLogged() and "/subtopic/account/dashboard" or "/subtopic/login")
+end
@@ -1,3 +1,5 @@
+require "verification"
+
 function post()
 
 if not session:isLogged() then
@@ -6,6 +8,7 @@ if not session:isLogged() then
 end
 
 local account = session:loggedAccount()
+local email = http.postValues["new-email"] or ""
 
 if http.postValues["account-email"] ~= account.Email then
     session:setFlash("validationError", "Wrong account email")
@@ -13,8 +16,28 @@ if http.postValues["account-email"] ~= account.Email then
     return
 end
 
-db:execute("UPDATE accounts SET email = ? WHERE id = ?", http.postValues["new-email"], account.ID)
+if not validator:validate("IsEmail", email) then
+    session:setFlash("validationError", "Invalid email format")
+    http:redirect("/subtopic/account/changemail")
+    return
+end
+
+if db:singleQuery("SELECT id FROM accounts WHERE email = ?", email) ~= nil then
+    session:setFlash("validationError", "Email already in use by another user")
+    http:redirect("/subtopic/account/changemail")
+    return
+end
+
+if app.EmailVerification.Enabled then
+    sendEmailChange(account.ID, email)
+    session:setFlash("success", "We sent a confirmation link to " .. email .. ". Your email will change once you visit it")
+    http:redirect("/subtopic/account/dashboard")
+    return
+end
+
+db:execute("UPDATE accounts SET email = ? WHERE id = ?", email, account.ID)
+db:execute("UPDATE castro_accounts SET email_verified = 0 WHERE account_id = ?", account.ID)
 session:setFlash("success", "Email changed")
 http:redirect("/subtopic/account/dashboard")
 
-end
\ No newline at end of file
+end
@@ -7,13 +7,14 @@ end
 
 local account = session:loggedAccount()
 
-if crypto:sha1(http.postValues["account-password"]) ~= account.Password then
+if not crypto:verifyPassword(http.postValues["account-password"] or "", account.Password) then
     session:setFlash("validationError", "Wrong account password")
     http:redirect("/subtopic/account/changepassword")
     return
 end
 
-db:execute("UPDATE accounts SET password = ? WHERE id = ?", crypto:sha1(http.postValues["new-password"]), account.ID)
+db:execute("UPDATE accounts SET password = ? WHERE id = ?", crypto:hashPassword(http.postValues["new-password"]), account.ID)
+session:revokeOthers()
 session:setFlash("success", "Password changed")
 http:redirect("/subtopic/account/dashboard")
 
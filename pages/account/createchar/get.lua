@@ -1,9 +1,17 @@
+require "verification"
+
 function get()
     if not session:isLogged() then
         http:redirect("/")
         return
     end
 
+    if not emailVerified(session:loggedAccount()) then
+        session:setFlash("validationError", "You need to verify your email address before creating characters")
+        http:redirect("/subtopic/account/dashboard")
+        return
+    end
+
     local data = {}
 
     data.success = session:getFlash("success")
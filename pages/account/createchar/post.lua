@@ -1,3 +1,5 @@
+require "verification"
+
 local function fetchValues(voc)
     local v = app.Custom.NewCharacterValues[voc]
     if v.inherit_from ~= nil then
@@ -12,6 +14,12 @@ function post()
         return
     end
 
+    if not emailVerified(session:loggedAccount()) then
+        session:setFlash("validationError", "You need to verify your email address before creating characters")
+        http:redirect("/subtopic/account/dashboard")
+        return
+    end
+
     if http.postValues["character-name"]:len() < 5 or http.postValues["character-name"]:len() > 12 then
         session:setFlash("validation-error", "Invalid character name. Names can only have 5 to 12 characters")
         http:redirect()
@@ -84,6 +92,13 @@ function post()
         ncv.soul
     )
 
+    hooks:dispatch("onCharacterCreate", {
+        account = account,
+        name = http.postValues["character-name"],
+        vocation = http.postValues["character-vocation"],
+        town = http.postValues["character-town"]
+    })
+
     session:setFlash("success", "Character created")
     http:redirect("/subtopic/account/dashboard")
 end
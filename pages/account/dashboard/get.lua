@@ -31,8 +31,11 @@ function get()
         data.twofa = false
     else
         data.twofa = true
+        data.recoveryCodes = twofa:remainingCodes(account.ID)
     end
 
+    data.verification = app.EmailVerification.Enabled
+
     if data.account.PremiumDays > 0 then
         data.account.IsPremium = true
     end
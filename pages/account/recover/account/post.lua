@@ -5,35 +5,46 @@ function post()
     end
 
     if app.Captcha.Enabled then
-        if not captcha:verify(http.postValues["g-recaptcha-response"]) then
+        if not captcha:verify(http.postValues) then
             session:setFlash("validationError", "Invalid captcha answer")
             http:redirect("/subtopic/account/recover/account")
             return
         end
     end
 
+    -- Recovery forms share the failed login limits of the client address
+    local status = login:attempt()
+
+    if status.retryAfter > 0 then
+        session:setFlash("validationError", string.format("Too many failed attempts. Please wait %d seconds and try again", status.retryAfter))
+        http:redirect("/subtopic/account/recover/account")
+        return
+    end
+
+    if not status.allowed then
+        session:setFlash("validationError", "Too many attempts. Please try again")
+        http:redirect("/subtopic/account/recover/account")
+        return
+    end
+
     local account = db:singleQuery(
-        "SELECT email, name FROM accounts WHERE email = ? AND password = ?",
-        http.postValues["email"],
-        crypto:sha1(http.postValues["password"])
+        "SELECT email, name, password FROM accounts WHERE email = ?",
+        http.postValues["email"]
     )
 
-    if account == nil then
+    if account == nil or not crypto:verifyPassword(http.postValues["password"] or "", account.password) then
         session:setFlash("validationError", "Wrong email or password")
         http:redirect("/subtopic/account/recover/account")
         return
     end
 
     if app.Mail.Enabled then
-        events:new(
-            function()
-                local m = {}
-                m.to = account.email
-                m.subject = "Account name recovery"
-                m.body = "Your account name is: <b>" .. account.name .. "</b>"
-                mail:send(m)
-            end
-        )
+        local m = {}
+        m.to = account.email
+        m.template = "account_name"
+        m.lang = session:get("lang")
+        m.data = {name = account.name}
+        mail:send(m)
         session:setFlash("success", "Account found. You will get an email with your username soon")
         http:redirect("/subtopic/account/recover/account")
         return
@@ -1,3 +1,5 @@
+require "verification"
+
 function post()
     if session:isLogged() then
         http:redirect("/")
@@ -5,13 +7,28 @@ function post()
     end
 
     if app.Captcha.Enabled then
-        if not captcha:verify(http.postValues["g-recaptcha-response"]) then
+        if not captcha:verify(http.postValues) then
             session:setFlash("validationError", "Invalid captcha answer")
             http:redirect("/subtopic/account/recover/password")
             return
         end
     end
 
+    -- Recovery forms share the failed login limits of the client address
+    local status = login:attempt()
+
+    if status.retryAfter > 0 then
+        session:setFlash("validationError", string.format("Too many failed attempts. Please wait %d seconds and try again", status.retryAfter))
+        http:redirect("/subtopic/account/recover/password")
+        return
+    end
+
+    if not status.allowed then
+        session:setFlash("validationError", "Too many attempts. Please try again")
+        http:redirect("/subtopic/account/recover/password")
+        return
+    end
+
     local account = db:singleQuery(
         "SELECT id, name, email FROM accounts WHERE email = ? AND name = ?",
         http.postValues["email"],
@@ -24,25 +41,13 @@ function post()
         return
     end
 
-    local pp = crypto:randomString(8)
-
-    db:execute("UPDATE accounts SET password = ?  WHERE id = ?", crypto:sha1(pp), account.id)
-
-    if app.Mail.Enabled then
-        events:new(
-            function()
-                local m = {}
-                m.to = account.email
-                m.subject = "Account password recovery"
-                m.body = "Your new account password is: <b>" .. pp .. "</b>"
-                mail:send(m)
-            end
-        )
-        session:setFlash("success", "Account found. You will get an email with your password soon")
+    if not app.Mail.Enabled then
+        session:setFlash("validationError", "Password recovery is not available. Please contact an administrator")
         http:redirect("/subtopic/account/recover/password")
         return
     end
 
-    session:setFlash("success", "Account found. Your new password is: " .. pp)
+    sendPasswordReset(account.id, account.email)
+    session:setFlash("success", "Account found. You will get an email with a link to choose a new password soon")
     http:redirect("/subtopic/account/recover/password")
 end
\ No newline at end of file
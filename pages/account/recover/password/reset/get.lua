@@ -0,0 +1,20 @@
+function get()
+    if session:isLogged() then
+        http:redirect("/")
+        return
+    end
+
+    local data = {}
+
+    data.token = http.getValues.token or ""
+
+    if token:verify(data.token, "reset-password") == nil then
+        session:setFlash("validationError", "Invalid or expired password recovery link")
+        http:redirect("/subtopic/account/recover/password")
+        return
+    end
+
+    data.validationError = session:getFlash("validationError")
+
+    http:render("reset_password.html", data)
+end
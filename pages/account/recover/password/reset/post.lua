@@ -0,0 +1,40 @@
+function post()
+    if session:isLogged() then
+        http:redirect("/")
+        return
+    end
+
+    local value = http.postValues.token or ""
+    local password = http.postValues.password or ""
+
+    if string.len(password) > 32 or string.len(password) < 8 then
+        session:setFlash("validationError", "Invalid password length. Password must be 8 - 32 characters long")
+        http:redirect("/subtopic/account/recover/password/reset?token=" .. value)
+        return
+    end
+
+    local t = token:consume(value, "reset-password")
+    local account = nil
+
+    if t ~= nil then
+        account = db:singleQuery("SELECT id, name, email FROM accounts WHERE id = ?", t.subject)
+    end
+
+    if account == nil then
+        session:setFlash("validationError", "Invalid or expired password recovery link")
+        http:redirect("/subtopic/account/recover/password")
+        return
+    end
+
+    db:execute("UPDATE accounts SET password = ? WHERE id = ?", crypto:hashPassword(password), account.id)
+
+    -- The link was sent to the account address so it is verified
+    if account.email == t.data then
+        db:execute("UPDATE castro_accounts SET email_verified = 1 WHERE account_id = ?", account.id)
+    end
+
+    session:revokeAccount(account.name)
+    login:reset(account.name)
+    session:setFlash("success", "Your password has been changed. You can now sign in")
+    http:redirect("/subtopic/login")
+end
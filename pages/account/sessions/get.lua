@@ -0,0 +1,19 @@
+function get()
+    if not session:isLogged() then
+        http:redirect("/subtopic/login")
+        return
+    end
+
+    local data = {}
+
+    data.validationError = session:getFlash("validationError")
+    data.success = session:getFlash("success")
+    data.list = session:list()
+
+    for _, s in ipairs(data.list) do
+        s.lastSeen = time:parseUnix(s.lastSeen)
+        s.createdAt = time:parseUnix(s.createdAt)
+    end
+
+    http:render("sessions.html", data)
+end
@@ -0,0 +1,22 @@
+function post()
+    if not session:isLogged() then
+        http:redirect("/subtopic/login")
+        return
+    end
+
+    if http.postValues.session == "others" then
+        local revoked = session:revokeOthers()
+        session:setFlash("success", "Logged out from " .. revoked .. " other sessions")
+        http:redirect("/subtopic/account/sessions")
+        return
+    end
+
+    if not session:revoke(http.postValues.session) then
+        session:setFlash("validationError", "Session not found")
+        http:redirect("/subtopic/account/sessions")
+        return
+    end
+
+    session:setFlash("success", "Session logged out")
+    http:redirect("/subtopic/account/sessions")
+end
@@ -0,0 +1,20 @@
+function get()
+    if not session:isLogged() then
+        http:redirect("/subtopic/login")
+        return
+    end
+
+    local account = session:loggedAccount()
+
+    if account.Secret == nil then
+        http:redirect("/subtopic/account/dashboard")
+        return
+    end
+
+    local data = {}
+
+    data.validationError = session:getFlash("validationError")
+    data.staff = app.TwoFactor.RequireStaff and #session:permissions() > 0
+
+    http:render("disabletwofa.html", data)
+end
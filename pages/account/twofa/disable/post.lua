@@ -0,0 +1,24 @@
+function post()
+    if not session:isLogged() then
+        http:redirect("/subtopic/login")
+        return
+    end
+
+    local account = session:loggedAccount()
+
+    if account.Secret == nil then
+        http:redirect("/subtopic/account/dashboard")
+        return
+    end
+
+    if not crypto:verifyPassword(http.postValues["account-password"] or "", account.Password) then
+        session:setFlash("validationError", "Wrong account password")
+        http:redirect("/subtopic/account/twofa/disable")
+        return
+    end
+
+    twofa:disable(account.ID)
+    session:revokeOthers()
+    session:setFlash("success", "Two-factor authentication disabled")
+    http:redirect("/subtopic/account/dashboard")
+end
@@ -24,8 +24,13 @@ function post()
         return
     end
 
-    session:destroy()
     db:execute("UPDATE accounts SET secret = ? WHERE id = ?", secret, account.ID)
-    session:setFlash("success", "Two-factor authentication enabled. Please log-in")
-    http:redirect("/subtopic/login")
+
+    local data = {}
+
+    data.codes = twofa:generateCodes(account.ID)
+    data.enabled = true
+
+    session:destroy()
+    http:render("twofacodes.html", data)
 end
@@ -0,0 +1,12 @@
+function get()
+    local account = login:unlock(http.getValues.token or "")
+
+    if account == nil then
+        session:setFlash("validationError", "Invalid or expired unlock link")
+        http:redirect("/subtopic/login")
+        return
+    end
+
+    session:setFlash("success", "Your account has been unlocked. You can log in again")
+    http:redirect("/subtopic/login")
+end
\ No newline at end of file
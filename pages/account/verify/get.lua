@@ -0,0 +1,18 @@
+function get()
+    local t = token:consume(http.getValues.token or "", "verify-email")
+    local account = nil
+
+    if t ~= nil then
+        account = db:singleQuery("SELECT id FROM accounts WHERE id = ? AND email = ?", t.subject, t.data)
+    end
+
+    if account == nil then
+        session:setFlash("validationError", "Invalid or expired verification link")
+        http:redirect(session:isLogged() and "/subtopic/account/dashboard" or "/subtopic/login")
+        return
+    end
+
+    db:execute("UPDATE castro_accounts SET email_verified = 1 WHERE account_id = ?", account.id)
+    session:setFlash("success", "Your email address has been verified")
+    http:redirect(session:isLogged() and "/subtopic/account/dashboard" or "/subtopic/login")
+end
@@ -0,0 +1,19 @@
+require "verification"
+
+function post()
+    if not session:isLogged() then
+        http:redirect("/subtopic/login")
+        return
+    end
+
+    local account = session:loggedAccount()
+
+    if not app.EmailVerification.Enabled or account.castro.Email_verified then
+        http:redirect("/subtopic/account/dashboard")
+        return
+    end
+
+    sendEmailVerification(account.ID, account.Email)
+    session:setFlash("success", "We sent a new verification link to " .. account.Email)
+    http:redirect("/subtopic/account/dashboard")
+end
@@ -1,13 +1,15 @@
 function post()
 	-- Block access for anyone who is not admin
-	if not session:isLogged() or not session:isAdmin() then
+	if not session:isLogged() or not session:can("articles.edit") then
 		http:redirect("/")
 		return
 	end
 
 	local removeArticle = tonumber(http.postValues["delete"])
 	if removeArticle then
+		local article = db:singleQuery("SELECT id, title, text FROM castro_articles WHERE id = ?", removeArticle)
 		db:execute("DELETE from castro_articles WHERE id = ?", removeArticle)
+		audit:log("articles.delete", removeArticle, article, nil)
 		session:setFlash("success", "Article was successfully removed.")
 	end
 
@@ -0,0 +1,65 @@
+require "paginator"
+
+function get()
+    if not session:isLogged() or not session:can("audit.view") then
+        http:redirect("/")
+        return
+    end
+
+    local data = {}
+    local filter = {}
+    local query = {}
+
+    for _, key in ipairs({"account", "action", "target", "from", "to"}) do
+        local value = http.getValues[key]
+        if value ~= nil and value ~= "" then
+            data[key] = value
+            table.insert(query, key .. "=" .. url:encode(value))
+        end
+    end
+
+    filter.account = data.account
+    filter.action = data.action
+    filter.target = data.target
+
+    if data.from then
+        filter.from = time:parseDate(data.from, "2006-01-02")
+    end
+
+    if data.to then
+        -- Include the whole day
+        filter.to = time:parseDate(data.to, "2006-01-02") + 86399
+    end
+
+    if http.getValues.export == "csv" or http.getValues.export == "json" then
+        local format = http.getValues.export
+        http:setHeader("Content-Type", format == "csv" and "text/csv" or "application/json")
+        http:setHeader("Content-Disposition", "attachment; filename=audit-log." .. format)
+        http:write(audit:export(filter, format))
+        return
+    end
+
+    local page = 0
+
+    if http.getValues.page ~= nil then
+        page = math.max(math.floor((tonumber(http.getValues.page) or 0) + 0.5), 0)
+    end
+
+    local pg = paginator(page, 30, audit:count(filter))
+
+    filter.limit = pg.limit
+    filter.offset = pg.offset
+
+    data.list = audit:list(filter)
+
+    for _, entry in ipairs(data.list) do
+        entry.date = time:parseUnix(entry.Created_at).Result
+        entry.before = entry.Old_value
+        entry.after = entry.New_value
+    end
+
+    data.paginator = pg
+    data.query = table.concat(query, "&")
+
+    http:render("audit.html", data)
+end
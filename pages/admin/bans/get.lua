@@ -1,7 +1,7 @@
 local config = require("bans")
 
 function get()
-    if not session:isLogged() or not session:isAdmin() then
+    if not session:isLogged() or not session:can("bans.manage") then
         http:redirect("/")
         return
     end
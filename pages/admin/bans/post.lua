@@ -1,7 +1,7 @@
 local config = require("bans")
 
 function post()
-    if not session:isLogged() or not session:isAdmin() then
+    if not session:isLogged() or not session:can("bans.manage") then
         http:redirect("/")
         return
     end
@@ -49,6 +49,7 @@ function post()
         -- Ban account if not already banned
         if not db:singleQuery("SELECT 1 FROM account_bans WHERE account_id = ?", ban.account_id, false) then
             db:execute("INSERT INTO account_bans (account_id, reason, banned_at, expires_at, banned_by) VALUES (?, ?, ?, ?, ?)", ban.account_id, ban.reason, os.time(), os.time() + ban.duration, ban.banned_by)
+            audit:log("bans.account", player.name, nil, {account_id = ban.account_id, reason = ban.reason, expires_at = os.time() + ban.duration})
             session:setFlash("success", string.format("The account of %s have been banned for %s hours.", player.name, math.floor(ban.duration / 60 / 60)))
             -- Clear cache
             cache:delete("SELECT * FROM account_bans")
@@ -59,6 +60,7 @@ function post()
         -- Ban IP if not already banned
         if not db:singleQuery("SELECT 1 FROM ip_bans WHERE account_id = ?", player.lastip, false) then
             db:execute("INSERT INTO ip_bans (ip, reason, banned_at, expires_at, banned_by) VALUES (?, ?, ?, ?, ?)", player.lastip, ban.reason, os.time(), os.time() + ban.duration, ban.banned_by)
+            audit:log("bans.ip", player.name, nil, {ip = player.lastip, reason = ban.reason, expires_at = os.time() + ban.duration})
             session:setFlash("success", string.format("The ip address of %s have been banned for %s hours.", player.name, math.floor(ban.duration / 60 / 60)))
             -- Clear cache
             cache:delete("SELECT * FROM ip_bans")
@@ -69,6 +71,7 @@ function post()
         -- Namelock player if not already namelocked
         if not db:singleQuery("SELECT 1 FROM player_namelocks WHERE player_id = ?", ban.player_id, false) then
             db:execute("INSERT INTO player_namelocks (player_id, reason, namelocked_at, namelocked_by) VALUES (?, ?, ?, ?)", ban.player_id, ban.reason, os.time(), ban.banned_by)
+            audit:log("bans.namelock", player.name, nil, {player_id = ban.player_id, reason = ban.reason})
             session:setFlash("success", string.format("%s have been namelocked.", player.name))
             -- Clear cache
             cache:delete("SELECT * FROM player_namelocks")
@@ -81,6 +84,7 @@ function post()
         -- Account ban
         if not db:singleQuery("SELECT 1 FROM account_bans WHERE account_id = ?", ban.account_id, false) then
             db:execute("INSERT INTO account_bans (account_id, reason, banned_at, expires_at, banned_by) VALUES (?, ?, ?, ?, ?)", ban.account_id, ban.reason, os.time(), os.time() + ban.duration, ban.banned_by)
+            audit:log("bans.account", player.name, nil, {account_id = ban.account_id, reason = ban.reason, expires_at = os.time() + ban.duration})
             success = true
             -- Clear cache
             cache:delete("SELECT * FROM account_bans")
@@ -89,6 +93,7 @@ function post()
         -- IP ban
         if not db:singleQuery("SELECT 1 FROM ip_bans WHERE ip = ?", player.lastip, false) then
             db:execute("INSERT INTO ip_bans (ip, reason, banned_at, expires_at, banned_by) VALUES (?, ?, ?, ?, ?)", player.lastip, ban.reason, os.time(), os.time() + ban.duration, ban.banned_by)
+            audit:log("bans.ip", player.name, nil, {ip = player.lastip, reason = ban.reason, expires_at = os.time() + ban.duration})
             success = true
             -- Clear cache
             cache:delete("SELECT * FROM ip_bans")
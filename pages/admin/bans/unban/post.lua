@@ -1,21 +1,27 @@
 function post()
-    if not session:isLogged() or not session:isAdmin() then
+    if not session:isLogged() or not session:can("bans.manage") then
         http:redirect("/")
         return
     end
 
     if http.postValues.lift_namelock then
+        local namelock = db:singleQuery("SELECT player_id, reason, namelocked_at FROM player_namelocks WHERE player_id = ?", http.postValues.lift_namelock)
         db:execute("DELETE FROM player_namelocks WHERE player_id = ?", http.postValues.lift_namelock)
+        audit:log("bans.namelock.lift", http.postValues.lift_namelock, namelock, nil)
         session:setFlash("success", "Namelock has been removed.")
         -- Clear cache
         cache:delete("SELECT * FROM player_namelocks")
     elseif http.postValues.lift_account_ban then
+        local accountBan = db:singleQuery("SELECT account_id, reason, banned_at, expires_at FROM account_bans WHERE account_id = ?", http.postValues.lift_account_ban)
         db:execute("DELETE FROM account_bans WHERE account_id = ?", http.postValues.lift_account_ban)
+        audit:log("bans.account.lift", http.postValues.lift_account_ban, accountBan, nil)
         session:setFlash("success", "Account has been unbanned.")
         -- Clear cache
         cache:delete("SELECT * FROM account_bans")
     elseif http.postValues.lift_ip_ban then
+        local ipBan = db:singleQuery("SELECT ip, reason, banned_at, expires_at FROM ip_bans WHERE ip = ?", http.postValues.lift_ip_ban)
         db:execute("DELETE FROM ip_bans WHERE ip = ?", http.postValues.lift_ip_ban)
+        audit:log("bans.ip.lift", http.postValues.lift_ip_ban, ipBan, nil)
         session:setFlash("success", "IP has been unbanned.")
         -- Clear cache
         cache:delete("SELECT * FROM ip_bans")
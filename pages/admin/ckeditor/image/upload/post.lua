@@ -1,5 +1,5 @@
 function post()
-    if not session:isAdmin() then
+    if not session:can("articles.edit") then
         return
     end
 
@@ -10,6 +10,7 @@ function post()
     end
 
     file:saveFile("public/ckeditor/images/" .. file.name)
+    audit:log("articles.upload", file.name, nil, nil)
 
     local data = {}
     data.uploaded = 1
@@ -1,6 +1,6 @@
 function get()
     -- Block access for anyone who is not admin
-    if not session:isLogged() or not session:isAdmin() then
+    if not session:isLogged() or not session:can("extensions.install") then
         http:redirect("/")
         return
     end
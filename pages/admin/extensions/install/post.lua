@@ -1,113 +1,160 @@
-function post()
-    -- Block access for anyone who is not admin
-    if not session:isLogged() or not session:isAdmin() then
-        http:redirect("/")
-        return
-    end
-
-    -- Install extension
-    if http.postValues.install_extension then
-        local extension = json:unmarshalFile(string.format("extensions/%s/extension.json", http.postValues.install_extension))
-        local successMessage = extension.id .. " has been installed."
-
-        if extension.author == nil then
-            extension.author = "Anonymous"
-        end
-
-        if extension.description == nil then
-            extension.description = "-"
-        end
-
-        -- Run install script
-        if file:exists(string.format("extensions/%s/install.lua", extension.id)) then
-            local success = false
-            try(
-                function ()
-                    -- Load file
-                    dofile(string.format("extensions/%s/install.lua", extension.id))
-
-                    success, message = install()
-                    if success == false then
-                        error(message or "install function explicitly returned false but did not provide any message.")
-                        return
-                    end
-
-                    successMessage = string.format("Installed %s: %s", extension.id, message)
-                end,
-                -- Error function
-                function (err)
-                    session:setFlash("validationError", string.format("Failed to install %s: %s", extension.id, err))
-                end
-            )
-
-            -- Abort if install.lua failed
-            if not success then
-                http:redirect("/subtopic/admin/extensions/install")
-                return
-            end
-        end
-
-        -- Install extension base
-        db:execute("INSERT INTO castro_extensions (name, id, version, description, author, type, installed, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, b'1', ?, ?)", extension.name, extension.id, extension.version, extension.description, extension.author, extension.type, os.time(), os.time())
-
-        -- Install Lua hooks
-        if extension.hooks then
-            for hook, script in pairs(extension.hooks) do
-                db:execute("INSERT INTO castro_extension_hooks (extension_id, type, script, enabled) VALUES (?, ?, ?, b'1')", extension.id, hook, script)
-            end
-        end
-
-        -- Install template hooks
-        if extension.templateHooks then
-            for hook, template in pairs(extension.templateHooks) do
-                db:execute("INSERT INtO castro_extension_templatehooks (extension_id, type, template, enabled) VALUES (?, ?, ?, b'1')", extension.id, hook, template)
-            end
-        end
-
-        -- Install pages
-        if file:exists(string.format("extensions/%s/pages", extension.id)) then
-            db:execute("INSERT INTO castro_extension_pages (extension_id, enabled) VALUES (?, b'1')", extension.id)
-        end
-
-        -- Install widgets
-        if file:exists(string.format("extensions/%s/widgets", extension.id)) then
-            db:execute("INSERT INTO castro_extension_widgets (extension_id, enabled) VALUES (?, b'1')", extension.id)
-        end
-
-        session:setFlash("success", successMessage)
-        http:redirect("/subtopic/admin/extensions/install")
-        return
-    end
-
-    -- Uninstall extension
-    if http.postValues.uninstall_extension then
-        local id = http.postValues.uninstall_extension
-
-        -- Run uninstall.lua
-        if file:exists(string.format("extensions/%s/uninstall.lua", id)) then
-            try(
-                function ()
-                    -- Load file
-                    dofile(string.format("extensions/%s/uninstall.lua", id))
-
-                    local success, message = uninstall()
-                    if success == false then
-                        error(message or "uninstall function explicitly returned false but did not provide any message.")
-                        return
-                    end
-                    session:setFlash("success", string.format("Uninstalled %s: %s", id, message or ""))
-                end,
-                -- Error function
-                function (err)
-                    session:setFlash("validationError", string.format("Failed to execute uninstall script for %s: %s. The extension have been removed anyway.", id, err))
-                end
-            )
-        end
-
-        -- Remove extension
-        db:execute("DELETE FROM castro_extensions WHERE id = ?", id)
-
-        http:redirect("/subtopic/admin/extensions/install")
-        return
-    end
-end
+-- logArchiveInstall audits an archive install and sets the result message
+local function logArchiveInstall(result, message)
+    local text
+
+    if result.from then
+        audit:log("extensions.upgrade", result.id, {version = result.from}, {version = result.version})
+        text = string.format("Upgraded %s from %s to %s", result.id, result.from, result.version)
+    else
+        for _, ext in ipairs(result.installed) do
+            audit:log("extensions.install", ext.id, nil, {version = ext.version})
+        end
+        text = string.format("Installed %s %s", result.id, result.version)
+    end
+
+    if message ~= "" then
+        session:setFlash("success", text .. ": " .. message)
+    else
+        session:setFlash("success", text .. ".")
+    end
+end
+
+function post()
+    -- Block access for anyone who is not admin
+    if not session:isLogged() or not session:can("extensions.install") then
+        http:redirect("/")
+        return
+    end
+
+    -- Install extension from an uploaded archive or URL
+    if http.postValues.install_archive then
+        http:parseMultiPartForm()
+
+        local source = http:formFile("archive")
+        if source == nil then
+            source = http.postValues.archive_url
+        end
+
+        if source == nil or source == "" then
+            session:setFlash("validationError", "Upload an archive or enter its URL")
+            http:redirect("/subtopic/admin/extensions/install")
+            return
+        end
+
+        try(
+            function ()
+                local result, message = extension:installArchive(source, http.postValues.sha256, http.postValues.signature)
+                logArchiveInstall(result, message)
+            end,
+            -- Error function
+            function (err)
+                session:setFlash("validationError", "Failed to install archive: " .. err)
+            end
+        )
+
+        http:redirect("/subtopic/admin/extensions/install")
+        return
+    end
+
+    -- Install extension from the local registry
+    if http.postValues.install_registry then
+        local id = http.postValues.install_registry
+
+        try(
+            function ()
+                local result, message = extension:installRegistry(id)
+                logArchiveInstall(result, message)
+            end,
+            -- Error function
+            function (err)
+                session:setFlash("validationError", string.format("Failed to install %s: %s", id, err))
+            end
+        )
+
+        http:redirect("/subtopic/admin/extensions/install")
+        return
+    end
+
+    -- Install extension and its missing dependencies. Only the administrator
+    -- can grant unrestricted access
+    if http.postValues.install_extension or http.postValues.install_unrestricted then
+        local id = http.postValues.install_extension or http.postValues.install_unrestricted
+        local unrestricted = http.postValues.install_unrestricted ~= nil
+
+        try(
+            function ()
+                local installed, message = extension:install(id, unrestricted)
+
+                for _, ext in ipairs(installed) do
+                    audit:log("extensions.install", ext.id, nil, {version = ext.version, unrestricted = unrestricted and ext.id == id})
+                end
+
+                if message ~= "" then
+                    session:setFlash("success", string.format("Installed %s: %s", id, message))
+                else
+                    session:setFlash("success", id .. " has been installed.")
+                end
+            end,
+            -- Error function
+            function (err)
+                session:setFlash("validationError", string.format("Failed to install %s: %s", id, err))
+            end
+        )
+
+        http:redirect("/subtopic/admin/extensions/install")
+        return
+    end
+
+    -- Upgrade extension
+    if http.postValues.upgrade_extension then
+        local id = http.postValues.upgrade_extension
+
+        try(
+            function ()
+                local from, to, message = extension:upgrade(id)
+
+                audit:log("extensions.upgrade", id, {version = from}, {version = to})
+
+                if message ~= "" then
+                    session:setFlash("success", string.format("Upgraded %s from %s to %s: %s", id, from, to, message))
+                else
+                    session:setFlash("success", string.format("Upgraded %s from %s to %s.", id, from, to))
+                end
+            end,
+            -- Error function
+            function (err)
+                session:setFlash("validationError", string.format("Failed to upgrade %s: %s", id, err))
+            end
+        )
+
+        http:redirect("/subtopic/admin/extensions/install")
+        return
+    end
+
+    -- Uninstall extension
+    if http.postValues.uninstall_extension then
+        local id = http.postValues.uninstall_extension
+        local installed = db:singleQuery("SELECT id, version FROM castro_extensions WHERE id = ?", id)
+
+        try(
+            function ()
+                local message = extension:uninstall(id)
+
+                audit:log("extensions.uninstall", id, installed, nil)
+
+                if message ~= "" then
+                    session:setFlash("success", string.format("Uninstalled %s: %s", id, message))
+                else
+                    session:setFlash("success", id .. " has been uninstalled.")
+                end
+            end,
+            -- Error function
+            function (err)
+                session:setFlash("validationError", string.format("Failed to uninstall %s: %s", id, err))
+            end
+        )
+
+        http:redirect("/subtopic/admin/extensions/install")
+        return
+    end
+end
@@ -3,11 +3,30 @@ require "util"
 
 function get()
     -- Block access for anyone who is not admin
-    if not session:isLogged() or not session:isAdmin() then
+    if not session:isLogged() or not session:can("extensions.install") then
         http:redirect("/")
         return
     end
 
+    -- Settings of an installed extension
+    if http.getValues.extension ~= nil then
+        local data = {}
+
+        data.manifest = extension:manifest(http.getValues.extension)
+
+        if data.manifest == nil or not data.manifest.installed then
+            session:setFlash("validationError", "Extension is not installed")
+            http:redirect("/subtopic/admin/extensions/install")
+            return
+        end
+
+        data.success = session:getFlash("success")
+        data.settings = extension:settings(data.manifest.id)
+
+        http:render("extensionsettings.html", data)
+        return
+    end
+
     if not app.Plugin.Enabled then
         http:redirect("/")
         return
@@ -0,0 +1,45 @@
+function post()
+    -- Block access for anyone who is not admin
+    if not session:isLogged() or not session:can("extensions.install") then
+        http:redirect("/")
+        return
+    end
+
+    local id = http.postValues.extension
+    local manifest = extension:manifest(id or "")
+
+    if manifest == nil or not manifest.installed then
+        session:setFlash("validationError", "Extension is not installed")
+        http:redirect("/subtopic/admin/extensions/install")
+        return
+    end
+
+    local changed, problems = extension:saveSettings(id, http.postValues)
+
+    if changed == nil then
+        local data = {}
+
+        data.manifest = manifest
+        data.validationError = "Some settings are not valid"
+        data.settings = extension:settings(id)
+
+        -- Show the submitted values with their errors
+        for _, field in ipairs(data.settings) do
+            field.error = problems[field.name]
+
+            if not field.secret then
+                field.value = http.postValues[field.name]
+            end
+        end
+
+        http:render("extensionsettings.html", data)
+        return
+    end
+
+    if #changed > 0 then
+        audit:log("extensions.configure", id, nil, {fields = table.concat(changed, ", ")})
+    end
+
+    session:setFlash("success", "Settings saved")
+    http:redirect("/subtopic/admin/extensions/view?extension=" .. url:encode(id))
+end
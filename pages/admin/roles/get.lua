@@ -0,0 +1,24 @@
+function get()
+    if not session:isLogged() or not session:can("roles.manage") then
+        http:redirect("/")
+        return
+    end
+
+    local data = {}
+
+    data.success = session:getFlash("success")
+    data.validationError = session:getFlash("validationError")
+    data.permissions = roles:permissions()
+    data.roles = roles:list()
+
+    for _, role in ipairs(data.roles) do
+        role.granted = {}
+        for _, permission in ipairs(role.Permissions) do
+            role.granted[permission] = true
+        end
+    end
+
+    data.assignments = db:query("SELECT a.account_id, a.role, b.name FROM castro_account_roles a INNER JOIN accounts b ON b.id = a.account_id ORDER BY b.name, a.role")
+
+    http:render("roles.html", data)
+end
@@ -0,0 +1,130 @@
+-- Returns the first of the given permissions the logged account does not hold
+local function ungranted(permissions)
+    for _, permission in ipairs(permissions) do
+        if not session:can(permission) then
+            return permission
+        end
+    end
+    return nil
+end
+
+-- Returns the permissions needed to give or take the given role
+local function rolePermissions(name)
+    local permissions = {}
+
+    for _, role in ipairs(roles:list()) do
+        if role.Name == name then
+            permissions = role.Permissions
+        end
+    end
+
+    if name == "administrator" then
+        table.insert(permissions, "*")
+    end
+
+    return permissions
+end
+
+function post()
+    if not session:isLogged() or not session:can("roles.manage") then
+        http:redirect("/")
+        return
+    end
+
+    local action = http.postValues.action
+
+    if action == "save" then
+        local name = http.postValues.name
+
+        if name == nil or name == "" then
+            session:setFlash("validationError", "Role name is required")
+            http:redirect("/subtopic/admin/roles")
+            return
+        end
+
+        local permissions = {}
+
+        for _, permission in ipairs(roles:permissions()) do
+            if http.postValues["permission:" .. permission.Name] then
+                table.insert(permissions, permission.Name)
+            end
+        end
+
+        -- The administrator role always keeps every permission
+        if name == "administrator" then
+            permissions = {"*"}
+        end
+
+        local missing = ungranted(permissions)
+
+        if missing ~= nil then
+            session:setFlash("validationError", "You cannot give the " .. missing .. " permission")
+            http:redirect("/subtopic/admin/roles")
+            return
+        end
+
+        local old = nil
+
+        for _, role in ipairs(roles:list()) do
+            if role.Name == name then
+                old = {description = role.Description, permissions = role.Permissions}
+            end
+        end
+
+        roles:save(name, http.postValues.description or "", permissions)
+        audit:log("roles.save", name, old, {description = http.postValues.description or "", permissions = permissions})
+        session:setFlash("success", "Role " .. name .. " saved")
+        http:redirect("/subtopic/admin/roles")
+        return
+    end
+
+    if action == "delete" then
+        if http.postValues.name == "administrator" then
+            session:setFlash("validationError", "The administrator role cannot be removed")
+            http:redirect("/subtopic/admin/roles")
+            return
+        end
+
+        roles:delete(http.postValues.name)
+        audit:log("roles.delete", http.postValues.name, nil, nil)
+        session:setFlash("success", "Role " .. http.postValues.name .. " removed")
+        http:redirect("/subtopic/admin/roles")
+        return
+    end
+
+    local account = db:singleQuery("SELECT id FROM accounts WHERE name = ?", http.postValues.account)
+
+    if account == nil then
+        session:setFlash("validationError", "Account not found")
+        http:redirect("/subtopic/admin/roles")
+        return
+    end
+
+    if action == "assign" or action == "remove" then
+        local missing = ungranted(rolePermissions(http.postValues.role))
+
+        if missing ~= nil then
+            session:setFlash("validationError", "You need the " .. missing .. " permission to change the " .. http.postValues.role .. " role")
+            http:redirect("/subtopic/admin/roles")
+            return
+        end
+    end
+
+    if action == "assign" then
+        roles:assign(account.id, http.postValues.role)
+        audit:log("roles.assign", http.postValues.account, nil, {role = http.postValues.role})
+        session:setFlash("success", "Role " .. http.postValues.role .. " given to " .. http.postValues.account)
+    elseif action == "remove" then
+        if http.postValues.role == "administrator" and account.id == session:loggedAccount().ID then
+            session:setFlash("validationError", "You cannot remove the administrator role from your own account")
+            http:redirect("/subtopic/admin/roles")
+            return
+        end
+
+        roles:remove(account.id, http.postValues.role)
+        audit:log("roles.remove", http.postValues.account, {role = http.postValues.role}, nil)
+        session:setFlash("success", "Role " .. http.postValues.role .. " taken from " .. http.postValues.account)
+    end
+
+    http:redirect("/subtopic/admin/roles")
+end
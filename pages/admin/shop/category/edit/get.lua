@@ -6,7 +6,7 @@ function get()
         return
     end
 
-    if not session:isAdmin() then
+    if not session:can("shop.manage") then
         http:redirect("/")
         return
     end
@@ -6,7 +6,7 @@ function post()
         return
     end
 
-    if not session:isAdmin() then
+    if not session:can("shop.manage") then
         http:redirect("/")
         return
     end
@@ -28,7 +28,9 @@ function post()
         return
     end
 
+    local old = db:singleQuery("SELECT name, description FROM castro_shop_categories WHERE id = ?", http.postValues.id)
     db:execute("UPDATE castro_shop_categories SET name = ?, description = ?, updated_at = ? WHERE id = ?", http.postValues.title, http.postValues.text, os.time(), http.postValues.id)
+    audit:log("shop.category.update", http.postValues.id, old, {name = http.postValues.title, description = http.postValues.text})
     session:setFlash("success", "Category updated")
     http:redirect("/subtopic/admin/shop")
 end
\ No newline at end of file
@@ -4,7 +4,7 @@ function get()
         return
     end
 
-    if not session:isAdmin() then
+    if not session:can("shop.manage") then
         http:redirect("/")
         return
     end
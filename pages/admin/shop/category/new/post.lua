@@ -6,7 +6,7 @@ function post()
         return
     end
 
-    if not session:isAdmin() then
+    if not session:can("shop.manage") then
         http:redirect("/")
         return
     end
@@ -29,6 +29,7 @@ function post()
     end
 
     db:execute("INSERT INTO castro_shop_categories (name, description, created_at, updated_at) VALUES (?, ?, ?, ?)", http.postValues.title, http.postValues.text, os.time(), os.time())
+    audit:log("shop.category.create", http.postValues.title, nil, {name = http.postValues.title, description = http.postValues.text})
     session:setFlash("success", "Category created")
     http:redirect("/subtopic/admin/shop")
 end
\ No newline at end of file
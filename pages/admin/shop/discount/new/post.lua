@@ -4,7 +4,7 @@ function post()
         return
     end
 
-    if not session:isAdmin() then
+    if not session:can("shop.manage") then
         http:redirect("/")
         return
     end
@@ -40,6 +40,14 @@ function post()
         http.postValues.unlimited
     )
 
+    audit:log("shop.discount.create", http.postValues.code, nil, {
+        code = http.postValues.code,
+        discount = http.postValues.amount,
+        uses = http.postValues["code-uses"],
+        unlimited = http.postValues.unlimited,
+        valid_till = validUntil
+    })
+
     session:setFlash("success", "Discount code created")
     http:redirect("/subtopic/admin/shop")
 end
\ No newline at end of file
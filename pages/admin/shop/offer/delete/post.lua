@@ -4,7 +4,7 @@ function post()
         return
     end
 
-    if not session:isAdmin() then
+    if not session:can("shop.manage") then
         http:redirect("/")
         return
     end
@@ -19,6 +19,7 @@ function post()
     end
 
     db:execute("DELETE FROM castro_shop_offers WHERE id = ?", data.offer.id)
+    audit:log("shop.offer.delete", data.offer.id, data.offer, nil)
 
     session:setFlash("success", "Shop offer deleted")
     http:redirect("/subtopic/admin/shop/category?id=" .. data.offer.category_id)
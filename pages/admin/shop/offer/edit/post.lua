@@ -6,7 +6,7 @@ function post()
         return
     end
 
-    if not session:isAdmin() then
+    if not session:can("shop.manage") then
         http:redirect("/")
         return
     end
@@ -90,6 +90,13 @@ function post()
         data.offer.id
     )
 
+    audit:log("shop.offer.update", data.offer.id, data.offer, {
+        category_id = data.offer.category_id,
+        price = http.postValues["offer-price"],
+        description = http.postValues["offer-description"],
+        name = http.postValues["offer-name"]
+    })
+
     session:setFlash("success", "Shop offer edited")
     http:redirect("/subtopic/admin/shop/category?id=" .. data.category.id)
 end
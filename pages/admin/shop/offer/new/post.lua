@@ -7,7 +7,7 @@ function post()
         return
     end
 
-    if not session:isAdmin() then
+    if not session:can("shop.manage") then
         http:redirect("/")
         return
     end
@@ -90,6 +90,13 @@ function post()
         ternary(http.postValues["container-item-charges[]"] == "", "", table.concat(explode(",", http.postValues["container-item-charges[]"]), ","))
     )
 
+    audit:log("shop.offer.create", http.postValues["offer-name"], nil, {
+        category_id = data.category.id,
+        price = http.postValues["offer-price"],
+        description = http.postValues["offer-description"],
+        name = http.postValues["offer-name"]
+    })
+
     session:setFlash("success", "Shop offer created")
     http:redirect("/subtopic/admin/shop/category?id=" .. data.category.id)
 end
@@ -4,7 +4,7 @@ function post()
         return
     end
 
-    if not session:isAdmin() then
+    if not session:can("shop.manage") then
         http:redirect("/")
         return
     end
@@ -14,7 +14,9 @@ function post()
         return
     end
 
+    local old = db:singleQuery("SELECT id, name, description FROM castro_shop_categories WHERE id = ?", http.postValues.id)
     db:execute("DELETE FROM castro_shop_categories WHERE id = ?", http.postValues.id)
+    audit:log("shop.category.delete", http.postValues.id, old, nil)
     session:setFlash("success", "Category removed")
     http:redirect("/subtopic/admin/shop")
 end
\ No newline at end of file
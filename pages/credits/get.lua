@@ -11,12 +11,12 @@ function get()
     end
 
     if data.contributors then
-        data.contributors = json:unmarshal(data.contributors).object
+        data.contributors = json:unmarshal(data.contributors)
     end
 
     if data.commits then
-        data.commits = json:unmarshal(data.commits).object
-        for i, commit in pairs(data.commits) do
+        data.commits = json:unmarshal(data.commits)
+        for i, commit in ipairs(data.commits) do
             if commit.commit.message:len() > 45 then
                 data.commits[i].commit.message = commit.commit.message:sub(0, 45) .. "..."
             end
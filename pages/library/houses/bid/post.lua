@@ -1,3 +1,15 @@
+-- Lets extensions cancel the bid. Returns true if the bid was cancelled
+local function cancelBid(account, house)
+    local event = hooks:dispatch("onHouseBid", {account = account, character = url:decode(http.postValues.character), house = tonumber(house.id), bid = tonumber(http.postValues.bid)})
+
+    if event.cancelled then
+        session:setFlash("error", event.reason ~= "" and event.reason or "Bid cancelled")
+        http:redirect("/subtopic/library/houses/view?id=" .. house.id)
+    end
+
+    return event.cancelled
+end
+
 function post()
     if not session:isLogged() then
         http:redirect("/")
@@ -50,6 +62,10 @@ function post()
 
     -- There is no bid for the house
     if house.bidname == nil then
+        if cancelBid(account, house) then
+            return
+        end
+
         db:execute(
             "UPDATE houses SET bid = ?, bid_end = ?, last_bid = ?, highest_bidder = ? WHERE id = ?",
             http.postValues.bid,
@@ -77,6 +93,10 @@ function post()
         return
     end
 
+    if cancelBid(account, house) then
+        return
+    end
+
     db:execute(
         "UPDATE houses SET bid = bid + ?, last_bid = ?, highest_bidder = ? WHERE id = ?",
         http.postValues.bid,
@@ -8,6 +8,7 @@ function get()
 
     data["validationError"] = session:getFlash("validationError")
     data["success"] = session:getFlash("success")
+    data.captcha = app.Captcha.Enabled and login:check().captcha
 
     http:render("login.html", data)
 end
\ No newline at end of file
@@ -1,4 +1,28 @@
-require "extensionhooks"
+-- Reports a failed login and sends the unlock email when the attempt locked the
+-- account. The attempt was already counted by login:attempt
+local function failLogin(name, status, message)
+    if status.unlockToken ~= nil and app.Mail.Enabled and app.Login.UnlockMail then
+        local account = db:singleQuery("SELECT email FROM accounts WHERE name = ?", name)
+
+        if account ~= nil then
+            local scheme = (app.SSL.Enabled or app.SSL.Proxy) and "https" or "http"
+            local link = string.format("%s://%s/subtopic/account/unlock?token=%s", scheme, app.URL, status.unlockToken)
+            local m = {}
+            m.to = account.email
+            m.template = "unlock_account"
+            m.lang = session:get("lang")
+            m.data = {link = link}
+            mail:send(m)
+        end
+    end
+
+    if status.unlockToken ~= nil then
+        message = "Too many failed login attempts. Your account is temporarily locked"
+    end
+
+    session:setFlash("validationError", message)
+    http:redirect("/subtopic/login")
+end
 
 function post()
     if session:isLogged() then
@@ -6,31 +30,84 @@ function post()
         return
     end
 
-    local account = db:singleQuery("SELECT name, secret, email FROM accounts WHERE name = ? AND password = ?", http.postValues["account-name"], crypto:sha1(http.postValues.password))
+    local name = http.postValues["account-name"] or ""
+    local status = login:attempt(name)
+
+    if status.locked then
+        session:setFlash("validationError", string.format("Too many failed login attempts. Your account is locked until %s", time:parseUnix(status.lockedUntil).Result))
+        http:redirect("/subtopic/login")
+        return
+    end
+
+    if status.retryAfter > 0 then
+        session:setFlash("validationError", string.format("Too many failed login attempts. Please wait %d seconds and try again", status.retryAfter))
+        http:redirect("/subtopic/login")
+        return
+    end
 
-    if account == nil then
-        session:setFlash("validationError", "Wrong account name or password")
+    -- Another attempt of the same account or address is being checked
+    if not status.allowed then
+        session:setFlash("validationError", "Too many login attempts. Please try again")
         http:redirect("/subtopic/login")
         return
     end
 
+    if status.captcha and app.Captcha.Enabled then
+        if not captcha:verify(http.postValues) then
+            failLogin(name, status, "Invalid captcha answer")
+            return
+        end
+    end
+
+    local account = db:singleQuery("SELECT id, name, password, secret, email FROM accounts WHERE name = ?", name)
+    local valid, rehash = false, false
+
+    if account ~= nil then
+        valid, rehash = crypto:verifyPassword(http.postValues.password or "", account.password)
+    end
+
+    if not valid then
+        failLogin(name, status, "Wrong account name or password")
+        return
+    end
+
+    local recoveryUsed = false
+
     if account.secret ~= nil then
-        if not validator:validQRToken(http.postValues.token, account.secret) then
-            session:setFlash("validationError", "Invalid two-factor token. Please try again")
-            http:redirect()
+        local token = http.postValues.token or ""
+
+        -- Authenticator tokens are 6 digits, anything else is tried as a recovery code
+        if token:match("^%d%d%d%d%d%d$") then
+            if not validator:validQRToken(token, account.secret) then
+                failLogin(name, status, "Invalid two-factor token. Please try again")
+                return
+            end
+        elseif token == "" or not twofa:useCode(account.id, token) then
+            failLogin(name, status, "Invalid two-factor token. Please try again")
             return
+        else
+            recoveryUsed = true
         end
     end
 
+    login:reset(account.name)
+
+    -- Upgrade the stored hash to the configured scheme
+    if rehash then
+        db:execute("UPDATE accounts SET password = ? WHERE id = ?", crypto:hashPassword(http.postValues.password), account.id)
+    end
+
     session:set("logged", true)
     session:set("loggedAccount", account.name)
     session:set("admin", session:isAdmin())
 
-    -- Extension hook
-    local status = {continue = true}
-    executeHook("onLogin", session:loggedAccount(), status)
-    -- Allow extensions to take over
-    if not status.continue then
+    if recoveryUsed then
+        session:setFlash("validationError", string.format("You logged in with a recovery code. You have %d recovery codes left", twofa:remainingCodes(account.id)))
+    end
+
+    -- Extension hook. Extensions cancel the event to take over the response
+    local event = hooks:dispatch("onLogin", {account = session:loggedAccount()})
+    if event.cancelled then
         return
     end
 
@@ -1,5 +1,3 @@
-require "extensionhooks"
-
 function post()
     if not session:isLogged() then
         http:redirect("/subtopic/login")
@@ -7,7 +5,7 @@ function post()
     end
 
     -- Extension hook
-    executeHook("onLogout", session:loggedAccount())
+    hooks:dispatch("onLogout", {account = session:loggedAccount()})
 
     session:destroy()
     session:setFlash("success", "Logged out")
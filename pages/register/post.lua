@@ -1,3 +1,5 @@
+require "verification"
+
 function post()
     if session:isLogged() then
         http:redirect("/")
@@ -5,7 +7,7 @@ function post()
     end
 
     if app.Captcha.Enabled then
-        if not captcha:verify(http.postValues["g-recaptcha-response"]) then
+        if not captcha:verify(http.postValues) then
             session:setFlash("validationError", "Invalid captcha answer")
             http:redirect("/subtopic/register")
             return
@@ -51,13 +53,23 @@ function post()
     local id = db:execute(
         "INSERT INTO accounts (name, password, premium_ends_at, email, creation) VALUES (?, ?, ?, ?, ?)",
         http.postValues["account-name"],
-        crypto:sha1(http.postValues["password"]),
+        crypto:hashPassword(http.postValues["password"]),
         os.time() + (10 * 86400),
         http.postValues["email"],
         os.time()
     )
 
     db:execute("INSERT INTO castro_accounts (account_id) VALUES (?)", id)
+
+    hooks:dispatch("onRegister", {id = id, name = http.postValues["account-name"], email = http.postValues["email"]})
+
+    if app.EmailVerification.Enabled then
+        sendEmailVerification(id, http.postValues["email"])
+        session:setFlash("success", "Account created. We sent you an email to verify your address, you can sign in meanwhile")
+        http:redirect("/subtopic/login")
+        return
+    end
+
     session:setFlash("success", "Account created. You can now sign in")
     http:redirect("/subtopic/login")
 end
\ No newline at end of file
@@ -43,11 +43,13 @@ function post()
 
     local discount = db:singleQuery("SELECT id, valid_till, discount, uses, unlimited FROM castro_shop_discounts WHERE code = ?", http.postValues.discount)
 
+    local discountApplied = false
+
     if discount ~= nil then
         if os.time() < tonumber(discount.valid_till) then
             if discount.unlimited or tonumber(discount.uses) > 0 then
                 totalprice = totalprice - ((tonumber(discount.discount) * totalprice) / 100)
-                db:execute("UPDATE castro_shop_discounts SET uses = uses - 1 WHERE id = ?", discount.id)
+                discountApplied = true
             end
         end
     end
@@ -58,8 +60,15 @@ function post()
         return
     end
 
-    db:execute("UPDATE castro_accounts SET points = points - ? WHERE account_id = ?", totalprice, account.ID)
-    
+    -- Extensions can cancel the checkout
+    local event = hooks:dispatch("onCheckout", {account = account, character = character.name, offers = cart, price = totalprice})
+
+    if event.cancelled then
+        session:setFlash("error", event.reason ~= "" and event.reason or "Checkout cancelled")
+        http:redirect("/subtopic/shop/view")
+        return
+    end
+
     local offers = ""
     local amount = ""
     for name, offer in pairs(cartdata) do
@@ -68,7 +77,32 @@ function post()
     end
     offers = string.sub(offers, 1, -2)
     amount = string.sub(amount, 1, -2)
-    db:execute("INSERT INTO castro_shop_checkout (offer, amount, player, given) VALUES (?, ?, ?, 0)", offers, amount, character.name)
+
+    -- Charge the account and save the checkout as a single unit
+    local paid = db:transaction(
+        function()
+            local points = db:singleQuery("SELECT points FROM castro_accounts WHERE account_id = ? FOR UPDATE", account.ID)
+
+            if points == nil or tonumber(points.points) < totalprice then
+                return false
+            end
+
+            if discountApplied then
+                db:execute("UPDATE castro_shop_discounts SET uses = uses - 1 WHERE id = ?", discount.id)
+            end
+
+            db:execute("UPDATE castro_accounts SET points = points - ? WHERE account_id = ?", totalprice, account.ID)
+            db:execute("INSERT INTO castro_shop_checkout (offer, amount, player, given) VALUES (?, ?, ?, 0)", offers, amount, character.name)
+
+            return true
+        end
+    )
+
+    if not paid then
+        session:setFlash("error", "You need more points")
+        http:redirect("/subtopic/shop/view")
+        return
+    end
 
     session:set("shop-cart", {})
     session:setFlash("success", "You paid " .. totalprice .. " for all your cart items. You will get your items in-game")
@@ -43,4 +43,6 @@ function get()
         http.getValues.payment_id,
         os.time()
     )
+
+    hooks:dispatch("onPaymentReceived", {provider = "fortumo", account = http.getValues.cuid, points = tonumber(http.getValues.amount), reference = http.getValues.payment_id})
 end
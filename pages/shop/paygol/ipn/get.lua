@@ -23,4 +23,6 @@ function get()
 
     db:execute("UPDATE castro_accounts a, accounts b SET a.points = points + ? WHERE a.account_id = b.id AND b.name = ?", points, custom)
     db:execute("INSERT INTO castro_paygol_payments (transaction_id, custom, price, points, created_at) VALUES (?, ?, ?, ?, ?)", transaction_id, custom, price, points, os.time())
+
+    hooks:dispatch("onPaymentReceived", {provider = "paygol", account = custom, points = tonumber(points), reference = transaction_id})
 end
@@ -54,6 +54,8 @@ function post()
     db:execute("UPDATE castro_accounts a, accounts b SET a.points = points + ? WHERE a.account_id = b.id AND b.name = ?", pkg.points, payment.custom)
     db:execute("UPDATE castro_paypal_payments SET state = ? WHERE id = ?", "executed", http.postValues["id"])
 
+    hooks:dispatch("onPaymentReceived", {provider = "paypal", account = payment.custom, points = tonumber(pkg.points), reference = payment.payment_id})
+
     session:setFlash("success", "Package " .. pkg.name .. " purchased. " .. pkg.points .. " points given")
 
     http:redirect("/subtopic/shop/paypal")
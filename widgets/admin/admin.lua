@@ -7,7 +7,13 @@ function widget()
 
     local data = {}
 
-    data.admin = session:loggedAccount().castro.Admin
+    data.shop = session:can("shop.manage")
+    data.articles = session:can("articles.edit")
+    data.extensions = session:can("extensions.install")
+    data.bans = session:can("bans.manage")
+    data.roles = session:can("roles.manage")
+    data.audit = session:can("audit.view")
+    data.admin = data.shop or data.articles or data.extensions or data.bans or data.roles or data.audit
     data.pluginEnabled = app.Plugin.Enabled
 
     widgets:render("admin.html", data)